@@ -82,7 +82,11 @@ func (c *client) OnResourceSubscribedChanged(uri string) {
 }
 
 func (c *client) OnProgress(params mcp.ProgressParams) {
-	fmt.Printf("Progress: %f/%f\n", params.Progress, params.Total)
+	if params.Total == nil {
+		fmt.Printf("Progress: %f\n", params.Progress)
+		return
+	}
+	fmt.Printf("Progress: %f/%f\n", params.Progress, *params.Total)
 }
 
 func (c *client) OnLog(params mcp.LogParams) {
@@ -208,15 +212,17 @@ func (c *client) runPrompts() bool {
 	for _, msg := range pr.Messages {
 		fmt.Println("---")
 		fmt.Printf("Role: %s\n", msg.Role)
-		switch msg.Content.Type {
-		case mcp.ContentTypeText:
-			fmt.Printf("Message: %s\n", msg.Content.Text)
-		case mcp.ContentTypeImage:
-			// Truncate the image data, as the terminal can't display it anyway.
-			data := msg.Content.Data[0:50]
-			fmt.Printf("Truncated image data: %s...\n", data)
-		case mcp.ContentTypeResource:
-			fmt.Printf("Message: Resource\n")
+		for _, content := range msg.Content {
+			switch content.Type {
+			case mcp.ContentTypeText:
+				fmt.Printf("Message: %s\n", content.Text)
+			case mcp.ContentTypeImage:
+				// Truncate the image data, as the terminal can't display it anyway.
+				data := content.Data[0:50]
+				fmt.Printf("Truncated image data: %s...\n", data)
+			case mcp.ContentTypeResource:
+				fmt.Printf("Message: Resource\n")
+			}
 		}
 		fmt.Println("---")
 	}
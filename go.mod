@@ -4,8 +4,7 @@ go 1.23.3
 
 require (
 	github.com/google/uuid v1.6.0
+	github.com/qri-io/jsonpointer v0.1.1
 	github.com/qri-io/jsonschema v0.2.1
 	github.com/tmaxmax/go-sse v0.10.0
 )
-
-require github.com/qri-io/jsonpointer v0.1.1 // indirect
@@ -0,0 +1,73 @@
+package mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// argumentsFrame is a container of the JSON scanned by checkJSONLimits. key is set while the next
+// token of an object is one of its keys.
+type argumentsFrame struct {
+	object, key bool
+}
+
+// checkToolArgumentLimits checks the arguments of the params of a tools/call request against the
+// limits set with WithToolArgumentLimits, without decoding them, so oversized arguments are turned
+// down before they take up any memory.
+func (s server) checkToolArgumentLimits(params json.RawMessage) error {
+	if s.maxArgumentKeys == 0 && s.maxArgumentDepth == 0 {
+		return nil
+	}
+
+	var raw struct {
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &raw); err != nil {
+		return err
+	}
+	return checkJSONLimits(raw.Arguments, s.maxArgumentKeys, s.maxArgumentDepth)
+}
+
+// checkJSONLimits scans data token by token, failing as soon as it holds more than maxKeys keys,
+// counted across all its objects, or its containers are nested deeper than maxDepth, data itself
+// being at depth 1. A zero limit isn't checked.
+func checkJSONLimits(data []byte, maxKeys, maxDepth int) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var stack []argumentsFrame
+	keys := 0
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		delim, isDelim := tok.(json.Delim)
+		if isDelim && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if n := len(stack); n > 0 && stack[n-1].object {
+			if stack[n-1].key {
+				keys++
+				if maxKeys > 0 && keys > maxKeys {
+					return fmt.Errorf("arguments have more than %d keys", maxKeys)
+				}
+				stack[n-1].key = false
+				continue
+			}
+			stack[n-1].key = true
+		}
+		if isDelim {
+			stack = append(stack, argumentsFrame{object: delim == '{', key: delim == '{'})
+			if maxDepth > 0 && len(stack) > maxDepth {
+				return fmt.Errorf("arguments are nested deeper than %d levels", maxDepth)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// BatchBuilder accumulates requests of a Client, such as many resources/read requests, to send them
+// all at once with Flush, for clients loading many things at once. Create one with Client.Batch.
+//
+// The transports carry a single message at a time, and the servers of this package don't accept
+// JSON-RPC batches, so Flush doesn't send the requests as one JSON-RPC array. It falls back to
+// sending them as separate requests, all at once, without waiting for the response of one before
+// sending the next, which still takes a single round trip instead of one per request. The responses
+// are correlated to the requests by ID, whatever order they arrive in.
+//
+// A BatchBuilder isn't safe for concurrent use.
+type BatchBuilder struct {
+	client   *Client
+	ctx      context.Context
+	requests []JSONRPCMessage
+	err      error
+}
+
+// BatchResponse is the response to a request of a batch. Err is set if the request failed, or the
+// server answered it with an error, in which case Result is empty.
+type BatchResponse struct {
+	Result json.RawMessage
+	Err    error
+}
+
+// Batch starts a batch of requests, which are sent with ctx, see BatchBuilder.
+func (c *Client) Batch(ctx context.Context) *BatchBuilder {
+	return &BatchBuilder{
+		client: c,
+		ctx:    ctx,
+	}
+}
+
+// Add adds a request with the given method and params to the batch, and returns the index of its
+// response in the responses returned by Flush. If params can't be marshaled, Flush returns the
+// error without sending any request.
+func (b *BatchBuilder) Add(method string, params any) int {
+	paramsBs, err := json.Marshal(params)
+	if err != nil && b.err == nil {
+		b.err = fmt.Errorf("failed to marshal params of %s request %d: %w", method, len(b.requests), err)
+	}
+
+	b.requests = append(b.requests, JSONRPCMessage{
+		JSONRPC: JSONRPCVersion,
+		Method:  method,
+		Params:  paramsBs,
+	})
+	return len(b.requests) - 1
+}
+
+// ReadResource adds a resources/read request to the batch, like Add. The result of its response is
+// decoded into a ReadResourceResult with BatchResponse.Decode.
+func (b *BatchBuilder) ReadResource(params ReadResourceParams) int {
+	return b.Add(MethodResourcesRead, params)
+}
+
+// Flush sends the requests of the batch, and waits for all their responses, which are returned in the
+// order the requests were added. The failure of a request doesn't fail the others: it's reported in
+// the Err of its response. The batch is empty afterwards, so it can be reused.
+func (b *BatchBuilder) Flush() ([]BatchResponse, error) {
+	requests, err := b.requests, b.err
+	b.requests, b.err = nil, nil
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]BatchResponse, len(requests))
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res, err := b.client.sendRequest(b.ctx, req)
+			switch {
+			case err != nil:
+				responses[i].Err = err
+			case res.Error != nil:
+				responses[i].Err = fmt.Errorf("result error: %w", res.Error)
+			default:
+				responses[i].Result = res.Result
+			}
+		}()
+	}
+	wg.Wait()
+
+	return responses, nil
+}
+
+// Decode decodes the result of the response into v, or returns the error of the response, if any.
+func (r BatchResponse) Decode(v any) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	if err := json.Unmarshal(r.Result, v); err != nil {
+		return fmt.Errorf("failed to unmarshal result: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package mcp_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+)
+
+func TestClientBatch(t *testing.T) {
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("first")},
+		"b.txt": {Data: []byte("second")},
+	}
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error),
+		mcp.WithResourceServer(mcp.NewFSResourceServer(fsys)))
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ResourceServer: true,
+	})
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	batch := cli.Batch(ctx)
+	uris := []string{"file:///a.txt", "file:///missing.txt", "file:///b.txt"}
+	for i, uri := range uris {
+		if index := batch.ReadResource(mcp.ReadResourceParams{URI: uri}); index != i {
+			t.Errorf("expected request %s to have index %d, got %d", uri, i, index)
+		}
+	}
+
+	responses, err := batch.Flush()
+	if err != nil {
+		t.Fatalf("failed to flush batch: %v", err)
+	}
+	if len(responses) != len(uris) {
+		t.Fatalf("expected %d responses, got %d", len(uris), len(responses))
+	}
+
+	for i, want := range map[int]string{0: "first", 2: "second"} {
+		var result mcp.ReadResourceResult
+		if err := responses[i].Decode(&result); err != nil {
+			t.Errorf("unexpected error reading %s: %v", uris[i], err)
+			continue
+		}
+		if len(result.Contents) != 1 || result.Contents[0].Text != want {
+			t.Errorf("expected %s to read %q, got %+v", uris[i], want, result)
+		}
+	}
+	if err := responses[1].Err; err == nil || !strings.Contains(err.Error(), "result error") {
+		t.Errorf("expected result error for the missing resource, got %v", err)
+	}
+
+	if responses, err := batch.Flush(); err != nil || len(responses) != 0 {
+		t.Errorf("expected the flushed batch to be empty, got %v, err %v", responses, err)
+	}
+
+	batch.Add(mcp.MethodToolsList, func() {})
+	if _, err := batch.Flush(); err == nil {
+		t.Error("expected error for params that can't be marshaled")
+	}
+}
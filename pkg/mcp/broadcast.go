@@ -0,0 +1,123 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Broadcaster sends custom notifications, such as experimental or maintenance notifications, to
+// every client connected to the server it's attached to with WithBroadcaster. A Broadcaster can be
+// attached to a single server, and is usable from the moment Serve starts until it returns.
+//
+// Broadcaster is safe for concurrent use.
+type Broadcaster struct {
+	lock sync.RWMutex
+	// sessions is the session map of the attached server, which is shared by every copy of the
+	// server, or nil if the broadcaster isn't attached.
+	sessions *sync.Map
+}
+
+// NewBroadcaster creates a Broadcaster, to be attached to a server with WithBroadcaster.
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{}
+}
+
+// WithBroadcaster attaches the broadcaster to the server, so it sends its notifications to the
+// sessions of the server.
+func WithBroadcaster(broadcaster *Broadcaster) ServerOption {
+	return func(s *server) {
+		s.broadcaster = broadcaster
+	}
+}
+
+// Broadcast sends the notification with the given method and params to every session of the server.
+// Sessions that haven't completed the initialization handshake, or that are ending, are skipped.
+// The notification is sent to all sessions concurrently, and each send is bounded by the write
+// timeout of the server, as well as by ctx.
+//
+// It returns an error if params can't be marshaled, the broadcaster isn't attached to a running
+// server, or sending to some of the sessions fails, in which case the notification is still sent
+// to the other sessions.
+func (b *Broadcaster) Broadcast(ctx context.Context, method string, params any) error {
+	if method == "" {
+		return fmt.Errorf("method is required")
+	}
+	paramsBs, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal params: %w", err)
+	}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	if b.sessions == nil {
+		return fmt.Errorf("broadcaster isn't attached to a running server")
+	}
+
+	var wg sync.WaitGroup
+	var errsLock sync.Mutex
+	var errs []error
+
+	b.sessions.Range(func(_, value any) bool {
+		sess, _ := value.(*session)
+		if !sess.isInitialized() || sess.ctx.Err() != nil {
+			return true
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := sess.broadcastNotification(ctx, method, paramsBs); err != nil {
+				errsLock.Lock()
+				errs = append(errs, fmt.Errorf("session %s: %w", sess.id, err))
+				errsLock.Unlock()
+			}
+		}()
+		return true
+	})
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (b *Broadcaster) attach(sessions *sync.Map) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.sessions = sessions
+}
+
+func (b *Broadcaster) detach() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.sessions = nil
+}
+
+// broadcastNotification sends the notification to the session. The send is bounded by the write
+// timeout, whose expiry is subject to the write timeout policy, and is also cancelled with ctx.
+func (s *session) broadcastNotification(ctx context.Context, method string, params json.RawMessage) error {
+	sCtx, sCancel := contextWithTimeout(s.ctx, s.clock, s.writeTimeout)
+	defer sCancel()
+	stop := context.AfterFunc(ctx, sCancel)
+	defer stop()
+
+	err := s.transport.Send(sCtx, SessionMsg{
+		SessionID: s.id,
+		Msg: JSONRPCMessage{
+			JSONRPC: JSONRPCVersion,
+			Method:  method,
+			Params:  params,
+		},
+	})
+	if err != nil {
+		s.handleWriteTimeout(sCtx)
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+
+	return nil
+}
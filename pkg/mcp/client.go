@@ -2,13 +2,17 @@ package mcp
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/qri-io/jsonschema"
 )
 
 // ClientOption is a function that configures a client.
@@ -21,6 +25,19 @@ type ServerRequirement struct {
 	ToolServer     bool
 }
 
+// ServerSessionInfo describes the server, and the session of the client with it, that sent a request
+// the client is handling, such as a sampling request, see ServerSession. ID is the session ID
+// given by the transport, and the rest is what the server reported during initialization.
+type ServerSessionInfo struct {
+	ID                 string
+	ProtocolVersion    string
+	ServerInfo         Info
+	ServerCapabilities ServerCapabilities
+}
+
+// serverSessionKey is the context key of the ServerSessionInfo of the server requests.
+type serverSessionKey struct{}
+
 // Client implements a Model Context Protocol (MCP) client that enables communication
 // between LLM applications and external data sources and tools. It manages the
 // connection lifecycle, handles protocol messages, and provides access to MCP
@@ -51,16 +68,33 @@ type Client struct {
 	transport                  ClientTransport
 
 	sessionID string
-	// clientRequests is a map of requestID to chan JSONRPCMessage, used for mapping the result to the original request
-	clientRequests sync.Map
+	// clientRequests tracks the requests sent to the server, used for mapping the result to the original request
+	clientRequests *pendingRequests
 	// serverRequests is a map of requestID to request, used for cancelling requests
 	serverRequests sync.Map
+	// toolOutputSchemas is a map of tool name to the *jsonschema.Schema of its structured results,
+	// learned from ListTools and used for validating the results of CallTool
+	toolOutputSchemas sync.Map
+	// resourceStreams is a map of requestID to *resourceStreamSink, used for writing the chunks of
+	// streamed resource reads
+	resourceStreams sync.Map
+	// resourceCache caches the results of ReadResource, if enabled with WithResourceCache
+	resourceCache *resourceCache
+	// toolListCache, promptListCache and resourceListCache hold the full lists of the server, if
+	// enabled with the WithAutoRefresh options
+	toolListCache     *listCache[Tool]
+	promptListCache   *listCache[Prompt]
+	resourceListCache *listCache[Resource]
 
 	rootsListHandler RootsListHandler
 	rootsListUpdater RootsListUpdater
 
 	samplingHandler SamplingHandler
 
+	// serverRequestHandlers is a map of method to the ServerRequestHandler of the requests of the
+	// server, see WithServerRequestHandler
+	serverRequestHandlers map[string]ServerRequestHandler
+
 	promptListWatcher PromptListWatcher
 
 	resourceListWatcher       ResourceListWatcher
@@ -75,12 +109,35 @@ type Client struct {
 	readTimeout  time.Duration
 	pingInterval time.Duration
 
+	requestIDGenerator func() string
+	clock              Clock
+
+	experimentalCapabilities map[string]any
+	capabilitiesOverride     *ClientCapabilities
+	resourceCompression      bool
+	serverCapabilities       ServerCapabilities
+	protocolVersion          string
+	// serverSession is the ServerSessionInfo passed to the handlers of the server requests, set once
+	// the session is initialized
+	serverSession atomic.Pointer[ServerSessionInfo]
+
 	initialized bool
 
 	errsChan  chan error
 	closeChan chan struct{}
 }
 
+// requestMetaKey is the context key of the metadata set with ContextWithRequestMeta.
+type requestMetaKey struct{}
+
+type resourceStreamSink struct {
+	lock   sync.Mutex
+	writer io.Writer
+	err    error
+	// cancel stops the read once writing to writer failed.
+	cancel context.CancelFunc
+}
+
 var (
 	defaultClientWriteTimeout = 30 * time.Second
 	defaultClientReadTimeout  = 30 * time.Second
@@ -101,13 +158,29 @@ func WithRootsListUpdater(updater RootsListUpdater) ClientOption {
 	}
 }
 
-// WithSamplingHandler sets the sampling handler for the client.
+// WithSamplingHandler sets the sampling handler for the client. The context passed to the handler
+// identifies the server that requested the sampling, see ServerSession.
 func WithSamplingHandler(handler SamplingHandler) ClientOption {
 	return func(c *Client) {
 		c.samplingHandler = handler
 	}
 }
 
+// WithServerRequestHandler registers handler for the requests of the server with the given method,
+// such as elicitation/create, which the client answers with the result of handler. A handler
+// registered for ping, roots/list or sampling/createMessage takes the place of the one the client
+// has for them, although the roots and sampling capabilities are still only advertised with
+// WithRootsListHandler and WithSamplingHandler. Requests of a method without a handler are answered
+// with a method not found error.
+func WithServerRequestHandler(method string, handler ServerRequestHandler) ClientOption {
+	return func(c *Client) {
+		if c.serverRequestHandlers == nil {
+			c.serverRequestHandlers = make(map[string]ServerRequestHandler)
+		}
+		c.serverRequestHandlers[method] = handler
+	}
+}
+
 // WithPromptListWatcher sets the prompt list watcher for the client.
 func WithPromptListWatcher(watcher PromptListWatcher) ClientOption {
 	return func(c *Client) {
@@ -171,6 +244,116 @@ func WithClientPingInterval(interval time.Duration) ClientOption {
 	}
 }
 
+// WithClientRequestIDGenerator sets the function used to generate the IDs of requests the client
+// sends to the server. The generated IDs must be unique within the session. By default, random
+// UUIDs are used.
+func WithClientRequestIDGenerator(generator func() string) ClientOption {
+	return func(c *Client) {
+		c.requestIDGenerator = generator
+	}
+}
+
+// WithClientClock sets the clock the client measures its ping interval, and read and write timeouts
+// with, so tests can control time with a fake clock. By default, the real clock is used.
+func WithClientClock(clock Clock) ClientOption {
+	return func(c *Client) {
+		c.clock = clock
+	}
+}
+
+// WithClientExperimentalCapability advertises a non-standard capability with the given name and
+// value in the experimental field of the client capabilities. Setting the same name twice
+// overrides the previous value.
+func WithClientExperimentalCapability(name string, value any) ClientOption {
+	return func(c *Client) {
+		if c.experimentalCapabilities == nil {
+			c.experimentalCapabilities = make(map[string]any)
+		}
+		c.experimentalCapabilities[name] = value
+	}
+}
+
+// WithClientResourceCompression makes the client advertise support for compressed resources/read
+// results in its experimental capabilities, so servers set up with WithResourceCompression compress
+// the large ones. The results are decompressed transparently, before they're returned by
+// ReadResource and the other methods reading resources. A result that decompresses to more than 10
+// MiB fails its read, so a server can't exhaust the memory of the client with a small result.
+func WithClientResourceCompression() ClientOption {
+	return func(c *Client) {
+		c.resourceCompression = true
+	}
+}
+
+// WithClientCapabilities sets the capabilities the client advertises to the server during
+// initialization, for advanced cases such as advertising a capability handled out-of-band, or
+// suppressing one. The given capabilities take precedence over the ones derived from the handlers
+// set with WithRootsListHandler, WithRootsListUpdater and WithSamplingHandler, and over
+// WithClientExperimentalCapability: they're advertised as they are. Note that the client only
+// answers the server requests it has handlers for, whatever it advertises.
+func WithClientCapabilities(capabilities ClientCapabilities) ClientOption {
+	return func(c *Client) {
+		c.capabilitiesOverride = &capabilities
+	}
+}
+
+// WithResourceCache makes the client cache the results of ReadResource for up to size resources,
+// evicting the least recently read ones first. A read served from the cache doesn't reach the server.
+//
+// Only the resources the client is subscribed to with SubscribeResource are cached, as the server
+// notifies the client when they change, and the cached result is then dropped. The result of a read
+// that was in flight while the resource changed isn't cached. Unsubscribing from a resource drops it
+// from the cache. Resources the client isn't subscribed to are always read from the server, so the
+// cache never serves a result the server is known to have superseded, although a result may be
+// served in the short window between a change on the server and the arrival of its notification.
+// If the server sends the new contents with the notification, see WithInlineResourceUpdates, they
+// replace the cached result instead, so the next read doesn't reach the server either.
+func WithResourceCache(size int) ClientOption {
+	return func(c *Client) {
+		c.resourceCache = newResourceCache(size)
+	}
+}
+
+// WithAutoRefreshToolList makes the client keep the full tool list of the server, as returned by
+// CachedTools. The list is fetched on Connect, following every page, and fetched again in the
+// background whenever the server notifies the client that it changed. The ToolListWatcher, if set,
+// is then called once the new list is cached, instead of on the arrival of the notification, so it
+// can read the new list with CachedTools.
+//
+// Changes notified while the list is being fetched are coalesced into a single fetch after it. A
+// failed fetch keeps the previous list, and the error is reported on Errors.
+func WithAutoRefreshToolList(enabled bool) ClientOption {
+	return func(c *Client) {
+		c.toolListCache = nil
+		if enabled {
+			c.toolListCache = newListCache(c.listAllTools)
+		}
+	}
+}
+
+// WithAutoRefreshPromptList makes the client keep the full prompt list of the server, as returned
+// by CachedPrompts, refreshed like with WithAutoRefreshToolList. The PromptListWatcher, if set, is
+// called once the new list is cached.
+func WithAutoRefreshPromptList(enabled bool) ClientOption {
+	return func(c *Client) {
+		c.promptListCache = nil
+		if enabled {
+			c.promptListCache = newListCache(c.listAllPrompts)
+		}
+	}
+}
+
+// WithAutoRefreshResourceList makes the client keep the full resource list of the server, as
+// returned by CachedResources, refreshed like with WithAutoRefreshToolList. The ResourceListWatcher,
+// if set, is called once the new list is cached.
+func WithAutoRefreshResourceList(enabled bool) ClientOption {
+	return func(c *Client) {
+		c.resourceListCache = nil
+		if enabled {
+			c.resourceListCache = newListCache(c.listAllResources)
+		}
+	}
+}
+
 // NewClient creates a new Model Context Protocol (MCP) client with the specified configuration.
 // It establishes a client that can communicate with MCP servers according to the protocol
 // specification at https://spec.modelcontextprotocol.io/specification/.
@@ -210,6 +393,14 @@ func NewClient(
 	if c.pingInterval == 0 {
 		c.pingInterval = defaultClientPingInterval
 	}
+	if c.requestIDGenerator == nil {
+		c.requestIDGenerator = newUUID
+	}
+	if c.clock == nil {
+		c.clock = realClock{}
+	}
+	c.registerServerRequestHandlers()
+	c.clientRequests = newPendingRequests(c.readTimeout, c.clock)
 
 	c.capabilities = ClientCapabilities{}
 
@@ -222,6 +413,16 @@ func NewClient(
 	if c.samplingHandler != nil {
 		c.capabilities.Sampling = &SamplingCapability{}
 	}
+	c.capabilities.Experimental = c.experimentalCapabilities
+	if c.resourceCompression {
+		if c.capabilities.Experimental == nil {
+			c.capabilities.Experimental = make(map[string]any)
+		}
+		c.capabilities.Experimental[experimentalResourceCompression] = map[string]any{}
+	}
+	if c.capabilitiesOverride != nil {
+		c.capabilities = *c.capabilitiesOverride
+	}
 
 	c.requiredServerCapabilities = ServerCapabilities{}
 
@@ -262,10 +463,6 @@ func NewClient(
 		c.requiredServerCapabilities.Logging = &LoggingCapability{}
 	}
 
-	if c.rootsListUpdater != nil {
-		go c.listenRootsList()
-	}
-
 	return c
 }
 
@@ -277,7 +474,16 @@ func NewClient(
 //
 // Connect must be called after creating a new client and before making any other client method calls.
 // It returns an error if the session cannot be established or if the initialization fails.
+//
+// Connect is ConnectContext with the background context.
 func (c *Client) Connect() error {
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect, but the initialization, and the loading of the lists kept with the
+// WithAutoRefresh options, give up once ctx is done. Loading the lists is also bounded by the read
+// timeout of the client, so a server that never answers doesn't hold up the connection.
+func (c *Client) ConnectContext(ctx context.Context) error {
 	sessID, err := c.transport.StartSession()
 	if err != nil {
 		return fmt.Errorf("failed to start session: %w", err)
@@ -285,12 +491,23 @@ func (c *Client) Connect() error {
 
 	go c.listenMessages()
 	go c.pings()
+	go c.clientRequests.sweepUntil(c.closeChan)
 
 	c.sessionID = sessID
-	if err := c.initialize(); err != nil {
+	if err := c.initialize(ctx); err != nil {
 		return fmt.Errorf("failed to initialize client: %w", err)
 	}
 
+	if err := c.loadListCaches(ctx); err != nil {
+		return fmt.Errorf("failed to load lists: %w", err)
+	}
+
+	// Roots list changes are only reported once the session is initialized, a change before it is
+	// reported right after.
+	if c.rootsListUpdater != nil {
+		go c.listenRootsList()
+	}
+
 	return nil
 }
 
@@ -439,6 +656,17 @@ func (c *Client) ListResources(ctx context.Context, params ListResourcesParams)
 // See ReadResourceParams for details on available parameters including resource URI
 // and optional progress tracking.
 func (c *Client) ReadResource(ctx context.Context, params ReadResourceParams) (ReadResourceResult, error) {
+	var gen uint64
+	var cacheable bool
+	if c.resourceCache != nil {
+		var result ReadResourceResult
+		var hit bool
+		result, gen, hit, cacheable = c.resourceCache.get(params.URI)
+		if hit {
+			return result, nil
+		}
+	}
+
 	paramsBs, err := json.Marshal(params)
 	if err != nil {
 		return ReadResourceResult{}, fmt.Errorf("failed to marshal params: %w", err)
@@ -461,9 +689,92 @@ func (c *Client) ReadResource(ctx context.Context, params ReadResourceParams) (R
 		return ReadResourceResult{}, err
 	}
 
+	if cacheable {
+		c.resourceCache.put(params.URI, gen, result)
+	}
+
 	return result, nil
 }
 
+// ReadResourceTemplate reads the resource whose URI is the expansion of the URI template with the
+// given variables, as done by ExpandTemplate, for servers that expose their resources as templates.
+// It returns an error, without reaching the server, if the template is malformed or one of its
+// variables is missing from vars. The resource is then read with ReadResource.
+func (c *Client) ReadResourceTemplate(
+	ctx context.Context,
+	uriTemplate string,
+	vars map[string]string,
+) (ReadResourceResult, error) {
+	uri, err := ExpandTemplate(uriTemplate, vars)
+	if err != nil {
+		return ReadResourceResult{}, err
+	}
+	return c.ReadResource(ctx, ReadResourceParams{URI: uri})
+}
+
+// ReadResourceStream reads the resource with the given URI and writes its raw content to w, so
+// resources too large to be held in memory can be read. It returns the metadata of the resource,
+// without its Text and Blob.
+//
+// If the server supports streamed reads (see StreamableResourceServer), the content is written to w
+// in chunks as it arrives, and a slow w slows down the server accordingly. If writing to w fails,
+// the read is cancelled and the write error is returned. If the server doesn't support streamed
+// reads, the resource is read with ReadResource, and its text or decoded blob is written to w.
+//
+// The request can be cancelled via the context. When cancelled, a cancellation
+// request will be sent to the server to stop processing.
+func (c *Client) ReadResourceStream(ctx context.Context, params ReadResourceParams, w io.Writer) (Resource, error) {
+	if _, ok := c.serverCapabilities.Experimental[experimentalResourceStreaming]; !ok {
+		return c.readResourceInto(ctx, params, w)
+	}
+
+	extra := make(map[string]any, len(params.Meta.Extra)+1)
+	for k, v := range params.Meta.Extra {
+		extra[k] = v
+	}
+	extra[metaStream] = true
+	params.Meta.Extra = extra
+
+	paramsBs, err := json.Marshal(params)
+	if err != nil {
+		return Resource{}, fmt.Errorf("failed to marshal params: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reqID := c.requestIDGenerator()
+	sink := &resourceStreamSink{writer: w, cancel: cancel}
+	c.resourceStreams.Store(reqID, sink)
+	defer c.resourceStreams.Delete(reqID)
+
+	res, err := c.sendRequestWithID(ctx, reqID, JSONRPCMessage{
+		JSONRPC: JSONRPCVersion,
+		Method:  MethodResourcesRead,
+		Params:  paramsBs,
+	})
+	if wErr := sink.writeErr(); wErr != nil {
+		return Resource{}, fmt.Errorf("failed to write resource content: %w", wErr)
+	}
+	if err != nil {
+		return Resource{}, err
+	}
+
+	if res.Error != nil {
+		return Resource{}, fmt.Errorf("result error: %w", res.Error)
+	}
+
+	var result ReadResourceResult
+	if err := json.Unmarshal(res.Result, &result); err != nil {
+		return Resource{}, err
+	}
+	if len(result.Contents) == 0 {
+		return Resource{}, fmt.Errorf("resource %s has no contents", params.URI)
+	}
+
+	return result.Contents[0], nil
+}
+
 // ListResourceTemplates retrieves a list of available resource templates from the server.
 // Resource templates allow servers to expose parameterized resources using URI templates.
 //
@@ -561,15 +872,50 @@ func (c *Client) SubscribeResource(ctx context.Context, params SubscribeResource
 		return fmt.Errorf("result error: %w", res.Error)
 	}
 
+	if c.resourceCache != nil {
+		c.resourceCache.subscribe(params.URI)
+	}
+
 	return nil
 }
 
+// Subscriptions returns the URIs of the resources the session is subscribed to, sorted, such as for
+// a client resuming a session to reconcile its state with the server. Subscribing to a resource
+// more than once doesn't list it more than once.
+//
+// Listing subscriptions is an extension of this package, see MethodResourcesSubscriptions, so
+// servers built with other implementations answer it with an error.
+func (c *Client) Subscriptions(ctx context.Context) ([]string, error) {
+	res, err := c.sendRequest(ctx, JSONRPCMessage{
+		JSONRPC: JSONRPCVersion,
+		Method:  MethodResourcesSubscriptions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Error != nil {
+		return nil, fmt.Errorf("result error: %w", res.Error)
+	}
+
+	var result resourcesSubscriptionsResult
+	if err := json.Unmarshal(res.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal subscriptions: %w", err)
+	}
+
+	return result.URIs, nil
+}
+
 // UnsubscribeResource cancels an existing subscription for notifications about changes
 // to a specific resource. After unsubscribing, the client will no longer receive
 // notifications through the ResourceSubscribedWatcher interface for this resource.
 //
 // See UnsubscribeResourceParams for details on available parameters including resource URI.
 func (c *Client) UnsubscribeResource(ctx context.Context, params UnsubscribeResourceParams) error {
+	if c.resourceCache != nil {
+		c.resourceCache.unsubscribe(params.URI)
+	}
+
 	paramsBs, err := json.Marshal(params)
 	if err != nil {
 		return fmt.Errorf("failed to marshal params: %w", err)
@@ -596,6 +942,9 @@ func (c *Client) UnsubscribeResource(ctx context.Context, params UnsubscribeReso
 // The request can be cancelled via the context. When cancelled, a cancellation
 // request will be sent to the server to stop processing.
 //
+// The output schemas of the listed tools are remembered, for validating the structured content of
+// their results in CallTool.
+//
 // See ListToolsParams for details on available parameters including cursor for
 // pagination and optional progress tracking.
 func (c *Client) ListTools(ctx context.Context, params ListToolsParams) (ListToolsResult, error) {
@@ -621,6 +970,14 @@ func (c *Client) ListTools(ctx context.Context, params ListToolsParams) (ListToo
 		return ListToolsResult{}, err
 	}
 
+	for _, tool := range result.Tools {
+		if tool.OutputSchema == nil {
+			c.toolOutputSchemas.Delete(tool.Name)
+			continue
+		}
+		c.toolOutputSchemas.Store(tool.Name, tool.OutputSchema)
+	}
+
 	return result, nil
 }
 
@@ -630,6 +987,10 @@ func (c *Client) ListTools(ctx context.Context, params ListToolsParams) (ListToo
 // The request can be cancelled via the context. When cancelled, a cancellation
 // request will be sent to the server to stop processing.
 //
+// If the tool returned structured content, and its output schema is known from a previous call to
+// ListTools, the structured content is validated against the schema, and an error is returned if
+// it doesn't conform.
+//
 // See CallToolParams for details on available parameters including tool name,
 // arguments, and optional progress tracking.
 func (c *Client) CallTool(ctx context.Context, params CallToolParams) (CallToolResult, error) {
@@ -655,9 +1016,100 @@ func (c *Client) CallTool(ctx context.Context, params CallToolParams) (CallToolR
 		return CallToolResult{}, err
 	}
 
+	if err := c.validateStructuredContent(ctx, params.Name, result); err != nil {
+		return CallToolResult{}, err
+	}
+
 	return result, nil
 }
 
+// CallToolDryRun calls the tool like CallTool, as a dry run: the server checks the arguments of the
+// call, and the tool returns the result it would have without any side effect, see WithToolDryRun.
+// It's meant for confirming a call before running it, such as with destructive tools. It returns an
+// error, without reaching the server, if the server doesn't support dry runs.
+func (c *Client) CallToolDryRun(ctx context.Context, params CallToolParams) (CallToolResult, error) {
+	if _, ok := c.serverCapabilities.Experimental[experimentalToolDryRun]; !ok {
+		return CallToolResult{}, fmt.Errorf("failed to dry run tool %s: the server doesn't support dry runs", params.Name)
+	}
+
+	extra := make(map[string]any, len(params.Meta.Extra)+1)
+	for k, v := range params.Meta.Extra {
+		extra[k] = v
+	}
+	extra[metaDryRun] = true
+	params.Meta.Extra = extra
+
+	return c.CallTool(ctx, params)
+}
+
+func (c *Client) validateStructuredContent(ctx context.Context, toolName string, result CallToolResult) error {
+	if result.StructuredContent == nil {
+		return nil
+	}
+	s, ok := c.toolOutputSchemas.Load(toolName)
+	if !ok {
+		return nil
+	}
+	schema, _ := s.(*jsonschema.Schema)
+
+	return validateToolOutput(ctx, toolName, schema, result.StructuredContent)
+}
+
+// CallToolTyped calls the tool with the given name through cli, and decodes its result into a value
+// of type T. The args are encoded to JSON and must encode to an object, or be nil for a tool
+// without arguments.
+//
+// The structured content of the result is decoded into T if present, otherwise the result must
+// consist of a single text content holding JSON, which is decoded into T. An error is returned if
+// the tool reports an error by setting IsError, with the text of its content, or if the content of
+// the result can't be decoded.
+func CallToolTyped[T any](ctx context.Context, cli *Client, name string, args any) (T, error) {
+	var zero T
+
+	params := CallToolParams{Name: name}
+	if args != nil {
+		argsBs, err := json.Marshal(args)
+		if err != nil {
+			return zero, fmt.Errorf("failed to marshal arguments: %w", err)
+		}
+		if err := unmarshalWithNumbers(argsBs, &params.Arguments); err != nil {
+			return zero, fmt.Errorf("arguments must encode to a JSON object: %w", err)
+		}
+	}
+
+	result, err := cli.CallTool(ctx, params)
+	if err != nil {
+		return zero, err
+	}
+
+	if result.IsError {
+		texts := make([]string, 0, len(result.Content))
+		for _, content := range result.Content {
+			if content.Type == ContentTypeText {
+				texts = append(texts, content.Text)
+			}
+		}
+		return zero, fmt.Errorf("tool %s returned an error: %s", name, strings.Join(texts, "\n"))
+	}
+	if result.StructuredContent != nil {
+		var value T
+		if err := json.Unmarshal(result.StructuredContent, &value); err != nil {
+			return zero, fmt.Errorf("failed to decode tool %s structured content: %w", name, err)
+		}
+		return value, nil
+	}
+	if len(result.Content) != 1 || result.Content[0].Type != ContentTypeText {
+		return zero, fmt.Errorf("tool %s result must be a single text content", name)
+	}
+
+	var value T
+	if err := json.Unmarshal([]byte(result.Content[0].Text), &value); err != nil {
+		return zero, fmt.Errorf("failed to decode tool %s result: %w", name, err)
+	}
+
+	return value, nil
+}
+
 // SetLogLevel configures the logging level for the MCP server.
 // It allows dynamic adjustment of the server's logging verbosity during runtime.
 //
@@ -701,6 +1153,59 @@ func (c *Client) Errors() <-chan error {
 	return c.errsChan
 }
 
+// CachedTools returns the full tool list of the server, kept up to date when enabled with
+// WithAutoRefreshToolList. It returns nil if the option isn't enabled, or the list wasn't fetched
+// yet, like when the server doesn't advertise the tools capability.
+func (c *Client) CachedTools() []Tool {
+	if c.toolListCache == nil {
+		return nil
+	}
+	return c.toolListCache.get()
+}
+
+// CachedPrompts returns the full prompt list of the server, kept up to date when enabled with
+// WithAutoRefreshPromptList. It returns nil if the option isn't enabled, or the list wasn't fetched
+// yet.
+func (c *Client) CachedPrompts() []Prompt {
+	if c.promptListCache == nil {
+		return nil
+	}
+	return c.promptListCache.get()
+}
+
+// CachedResources returns the full resource list of the server, kept up to date when enabled with
+// WithAutoRefreshResourceList. It returns nil if the option isn't enabled, or the list wasn't
+// fetched yet.
+func (c *Client) CachedResources() []Resource {
+	if c.resourceListCache == nil {
+		return nil
+	}
+	return c.resourceListCache.get()
+}
+
+// ServerCapabilities returns the capabilities the server advertised during initialization,
+// including its experimental capabilities. It returns zero capabilities before Connect succeeds.
+func (c *Client) ServerCapabilities() ServerCapabilities {
+	return c.serverCapabilities
+}
+
+// ProtocolVersion returns the version of the protocol the client and the server agreed on during
+// initialization, so callers can tell whether the server supports the features of a newer version.
+// It returns an empty string before Connect succeeds.
+func (c *Client) ProtocolVersion() string {
+	return c.protocolVersion
+}
+
+// ServerSession returns the ServerSessionInfo of the server whose request is being handled with ctx,
+// like the context passed to the SamplingHandler, the RootsListHandler and the handlers set with
+// WithServerRequestHandler, so clients connected to many servers can apply a policy per server, such
+// as the models each server may sample. It reports false if ctx wasn't created for handling a request
+// of a server, or the request came before the session was initialized, such as an early ping.
+func ServerSession(ctx context.Context) (ServerSessionInfo, bool) {
+	info, ok := ctx.Value(serverSessionKey{}).(ServerSessionInfo)
+	return info, ok
+}
+
 // Close terminates the client's connection to the server and releases all associated resources.
 // It closes the error channel, stops all background routines, and terminates the transport connection.
 //
@@ -712,8 +1217,8 @@ func (c *Client) Close() {
 	c.transport.Close()
 }
 
-func (c *Client) initialize() error {
-	sCtx, sCancel := context.WithTimeout(context.Background(), c.writeTimeout)
+func (c *Client) initialize(ctx context.Context) error {
+	sCtx, sCancel := contextWithTimeout(ctx, c.clock, c.writeTimeout)
 	defer sCancel()
 
 	params := initializeParams{
@@ -769,6 +1274,14 @@ func (c *Client) initialize() error {
 		return nErr
 	}
 
+	c.serverCapabilities = result.Capabilities
+	c.protocolVersion = result.ProtocolVersion
+	c.serverSession.Store(&ServerSessionInfo{
+		ID:                 c.sessionID,
+		ProtocolVersion:    result.ProtocolVersion,
+		ServerInfo:         result.ServerInfo,
+		ServerCapabilities: result.Capabilities,
+	})
 	c.initialized = true
 
 	return c.sendNotification(context.Background(), methodNotificationsInitialized, nil)
@@ -830,6 +1343,8 @@ func (c *Client) checkCapabilities(result initializeResult, requiredServerCap Se
 	return nil
 }
 
+// listenRootsList sends a notifications/roots/list_changed notification to the server on every update
+// of the RootsListUpdater, which drives the OnRootsListChanged of its RootsListWatcher.
 func (c *Client) listenRootsList() {
 	lists := c.rootsListUpdater.RootsListUpdates()
 	for {
@@ -857,7 +1372,7 @@ func (c *Client) listenMessages() {
 
 		if msg.SessionID != c.sessionID {
 			msg.Errs <- fmt.Errorf("invalid session ID: %s", msg.SessionID)
-			return
+			continue
 		}
 
 		msg.Errs <- c.handleMsg(msg.Msg)
@@ -865,20 +1380,21 @@ func (c *Client) listenMessages() {
 }
 
 func (c *Client) pings() {
-	pingTicker := time.NewTicker(c.pingInterval)
+	pingTicker := c.clock.NewTicker(c.pingInterval)
+	defer pingTicker.Stop()
 
 	for {
 		select {
 		case <-c.closeChan:
 			return
-		case <-pingTicker.C:
+		case <-pingTicker.C():
 			c.ping()
 		}
 	}
 }
 
 func (c *Client) ping() {
-	wCtx, wCancel := context.WithTimeout(context.Background(), c.writeTimeout)
+	wCtx, wCancel := contextWithTimeout(context.Background(), c.clock, c.writeTimeout)
 	defer wCancel()
 
 	res, err := c.sendRequest(wCtx, JSONRPCMessage{
@@ -895,24 +1411,13 @@ func (c *Client) ping() {
 }
 
 func (c *Client) handleMsg(msg JSONRPCMessage) error {
-	if msg.JSONRPC != JSONRPCVersion {
-		nErr := fmt.Errorf("invalid jsonrpc version: %s", msg.JSONRPC)
-		c.logError(nErr)
-		return nErr
-	}
-
-	// Handle basic protocol messages
-	if err := c.handleBasicMessages(msg); err != nil {
-		return err
-	}
-
-	// Handle root-related messages
-	if err := c.handleRootMessages(msg); err != nil {
+	if err := validateJSONRPCVersion(msg); err != nil {
+		c.logError(err)
 		return err
 	}
 
-	// Handle sampling-related messages
-	if err := c.handleSamplingMessages(msg); err != nil {
+	// Handle requests of the server
+	if err := c.handleRequestMessages(msg); err != nil {
 		return err
 	}
 
@@ -929,52 +1434,74 @@ func (c *Client) handleMsg(msg JSONRPCMessage) error {
 	return nil
 }
 
-func (c *Client) handleBasicMessages(msg JSONRPCMessage) error {
-	if msg.Method != methodPing {
-		return nil
+// registerServerRequestHandlers registers the handlers of the requests of the server the client
+// handles by itself, for the methods no handler was registered for with WithServerRequestHandler.
+func (c *Client) registerServerRequestHandlers() {
+	builtins := map[string]ServerRequestHandler{methodPing: c.handlePing}
+	if c.rootsListHandler != nil {
+		builtins[MethodRootsList] = c.handleRootsList
 	}
-	if err := c.sendResult(context.Background(), msg.ID, nil); err != nil {
-		nErr := fmt.Errorf("failed to handle ping: %w", err)
-		c.logError(nErr)
-		return nErr
+	if c.samplingHandler != nil {
+		builtins[MethodSamplingCreateMessage] = c.handleSamplingCreateMessage
 	}
 
-	return nil
+	if c.serverRequestHandlers == nil {
+		c.serverRequestHandlers = make(map[string]ServerRequestHandler)
+	}
+	for method, handler := range builtins {
+		if _, ok := c.serverRequestHandlers[method]; !ok {
+			c.serverRequestHandlers[method] = handler
+		}
+	}
 }
 
-func (c *Client) handleRootMessages(msg JSONRPCMessage) error {
-	if c.rootsListHandler == nil {
+// handleRequestMessages answers a request of the server with the ServerRequestHandler registered for
+// its method, or with a method not found error if there's none, so the server doesn't wait for a
+// response that never comes. The context of the handler carries the ServerSessionInfo of the server,
+// and is cancelled if the server cancels the request.
+func (c *Client) handleRequestMessages(msg JSONRPCMessage) error {
+	if msg.Method == "" || msg.ID == "" {
 		return nil
 	}
-
-	if msg.Method != MethodRootsList {
-		return nil
+	handler, ok := c.serverRequestHandlers[msg.Method]
+	if !ok {
+		return c.rejectUnsupported(msg)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx := context.Background()
+	if session := c.serverSession.Load(); session != nil {
+		ctx = context.WithValue(ctx, serverSessionKey{}, *session)
+	}
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	c.serverRequests.Store(msg.ID, &request{
 		ctx:    ctx,
 		cancel: cancel,
 	})
+	defer c.serverRequests.Delete(msg.ID)
 
-	rl, err := c.rootsListHandler.RootsList(ctx)
+	result, err := handler(ctx, msg.Params)
 	if err != nil {
-		nErr := fmt.Errorf("failed to list roots: %w", err)
-		if err := c.sendError(ctx, msg.ID, JSONRPCError{
+		nErr := fmt.Errorf("failed to handle %s request: %w", msg.Method, err)
+		jErr := JSONRPCError{
 			Code:    jsonRPCInternalErrorCode,
 			Message: errMsgInternalError,
 			Data:    map[string]any{"error": nErr},
-		}); err != nil {
-			nErr = fmt.Errorf("%w: failed to send error on roots list: %w", nErr, err)
+		}
+		var hErr JSONRPCError
+		if errors.As(err, &hErr) {
+			jErr = hErr
+		}
+		if err := c.sendError(ctx, msg.ID, jErr); err != nil {
+			nErr = fmt.Errorf("%w: failed to send error on %s request: %w", nErr, msg.Method, err)
 		}
 		c.logError(nErr)
 		return nErr
 	}
 
-	if err := c.sendResult(ctx, msg.ID, rl); err != nil {
-		nErr := fmt.Errorf("failed to send result on roots list: %w", err)
+	if err := c.sendResult(ctx, msg.ID, result); err != nil {
+		nErr := fmt.Errorf("failed to send result on %s request: %w", msg.Method, err)
 		c.logError(nErr)
 		return nErr
 	}
@@ -982,50 +1509,70 @@ func (c *Client) handleRootMessages(msg JSONRPCMessage) error {
 	return nil
 }
 
-func (c *Client) handleSamplingMessages(msg JSONRPCMessage) error {
-	if c.samplingHandler == nil {
-		return nil
-	}
+func (c *Client) handlePing(_ context.Context, params json.RawMessage) (any, error) {
+	_, rawMeta := parsePingParams(params)
+	return pingResult{Meta: rawMeta}, nil
+}
 
-	if msg.Method != MethodSamplingCreateMessage {
-		return nil
+func (c *Client) handleRootsList(ctx context.Context, _ json.RawMessage) (any, error) {
+	rl, err := c.rootsListHandler.RootsList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roots: %w", err)
 	}
+	return rl, nil
+}
+
+func (c *Client) handleSamplingCreateMessage(ctx context.Context, rawParams json.RawMessage) (any, error) {
 	var params SamplingParams
-	if err := json.Unmarshal(msg.Params, &params); err != nil {
-		nErr := fmt.Errorf("failed to unmarshal sampling params: %w", err)
-		c.logError(nErr)
-		return nErr
+	if err := json.Unmarshal(rawParams, &params); err != nil {
+		c.logError(fmt.Errorf("failed to unmarshal sampling params: %w", err))
+		return nil, JSONRPCError{
+			Code:    jsonRPCInvalidParamsCode,
+			Message: errMsgInvalidJSON,
+			Data:    map[string]any{"method": MethodSamplingCreateMessage},
+		}
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	c.serverRequests.Store(msg.ID, &request{
-		ctx:    ctx,
-		cancel: cancel,
-	})
-
-	rl, err := c.samplingHandler.CreateSampleMessage(ctx, params)
+	result, err := c.createSampleMessage(ctx, params)
 	if err != nil {
-		nErr := fmt.Errorf("failed to create sample message: %w", err)
-		if err := c.sendError(ctx, msg.ID, JSONRPCError{
-			Code:    jsonRPCInternalErrorCode,
-			Message: errMsgInternalError,
-			Data:    map[string]any{"error": nErr},
-		}); err != nil {
-			nErr = fmt.Errorf("%w: failed to send error on create sample message: %w", nErr, err)
-		}
-		c.logError(nErr)
-		return nErr
+		return nil, fmt.Errorf("failed to create sample message: %w", err)
 	}
+	return result, nil
+}
 
-	if err := c.sendResult(ctx, msg.ID, rl); err != nil {
-		nErr := fmt.Errorf("failed to send result on create sample message: %w", err)
-		c.logError(nErr)
-		return nErr
-	}
+// createSampleMessage generates the sampled message with CreateSampleMessageStream if the sampling
+// handler is a StreamingSamplingHandler and the request has a progress token, sending each piece of
+// text to the server as it's generated, and with CreateSampleMessage otherwise.
+func (c *Client) createSampleMessage(ctx context.Context, params SamplingParams) (SamplingResult, error) {
+	streamer, ok := c.samplingHandler.(StreamingSamplingHandler)
+	if !ok || params.Meta.ProgressToken == "" {
+		return c.samplingHandler.CreateSampleMessage(ctx, params)
+	}
+
+	deltas := make(chan string)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+
+		count := 0
+		for delta := range deltas {
+			count++
+			if err := c.sendNotification(ctx, methodNotificationsProgress, ProgressParams{
+				ProgressToken: params.Meta.ProgressToken,
+				Progress:      float64(count),
+				Delta:         delta,
+			}); err != nil {
+				c.logError(fmt.Errorf("failed to send sampling delta: %w", err))
+			}
+		}
+	}()
 
-	return nil
+	result, err := streamer.CreateSampleMessageStream(ctx, params, deltas)
+	close(deltas)
+	// The pieces must reach the server before the result.
+	<-forwarded
+
+	return result, err
 }
 
 func (c *Client) handleNotificationMessages(msg JSONRPCMessage) error {
@@ -1039,27 +1586,49 @@ func (c *Client) handleNotificationMessages(msg JSONRPCMessage) error {
 		}
 		c.handleNotificationsCancelled(params)
 	case methodNotificationsPromptsListChanged:
-		if c.promptListWatcher != nil {
-			c.promptListWatcher.OnPromptListChanged()
-		}
+		c.promptListCache.refresh(c.closeChan, func(err error) {
+			c.logRefreshError("prompt", err)
+			if c.promptListWatcher != nil {
+				c.promptListWatcher.OnPromptListChanged()
+			}
+		})
 	case methodNotificationsResourcesListChanged:
-		if c.resourceListWatcher != nil {
-			c.resourceListWatcher.OnResourceListChanged()
-		}
+		c.resourceListCache.refresh(c.closeChan, func(err error) {
+			c.logRefreshError("resource", err)
+			if c.resourceListWatcher != nil {
+				c.resourceListWatcher.OnResourceListChanged()
+			}
+		})
 	case methodNotificationsResourcesUpdated:
-		if c.resourceSubscribedWatcher != nil {
-			var params SubscribeResourceParams
+		if c.resourceSubscribedWatcher != nil || c.resourceCache != nil {
+			var params notificationsResourcesUpdatedParams
 			if err := json.Unmarshal(msg.Params, &params); err != nil {
 				nErr := fmt.Errorf("failed to unmarshal resources subscribe params: %w", err)
 				c.logError(nErr)
 				return nErr
 			}
-			c.resourceSubscribedWatcher.OnResourceSubscribedChanged(params.URI)
+			if c.resourceCache != nil {
+				c.resourceCache.invalidate(params.URI, params.Contents)
+			}
+			if c.resourceSubscribedWatcher != nil {
+				c.resourceSubscribedWatcher.OnResourceSubscribedChanged(params.URI)
+			}
 		}
 	case methodNotificationsToolsListChanged:
-		if c.toolListWatcher != nil {
-			c.toolListWatcher.OnToolListChanged()
+		c.toolListCache.refresh(c.closeChan, func(err error) {
+			c.logRefreshError("tool", err)
+			if c.toolListWatcher != nil {
+				c.toolListWatcher.OnToolListChanged()
+			}
+		})
+	case methodNotificationsResourcesChunk:
+		var params notificationsResourcesChunkParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			nErr := fmt.Errorf("failed to unmarshal resources chunk params: %w", err)
+			c.logError(nErr)
+			return nErr
 		}
+		c.handleNotificationsResourcesChunk(params)
 	case methodNotificationsProgress:
 		if c.progressListener == nil {
 			return nil
@@ -1087,59 +1656,129 @@ func (c *Client) handleNotificationMessages(msg JSONRPCMessage) error {
 	return nil
 }
 
+// handleResultMessages delivers the response to the request of the client it answers. A response to a
+// request that isn't pending, because it already timed out or was answered, or was never sent, such
+// as a response with a mismatched ID, is reported on Errors and dropped. The request it was meant
+// for then times out, instead of waiting forever.
 func (c *Client) handleResultMessages(msg JSONRPCMessage) error {
 	if msg.Method != "" {
 		return nil
 	}
-	reqID := string(msg.ID)
-	rc, ok := c.clientRequests.Load(reqID)
-	if !ok {
-		return nil
+	if !c.clientRequests.resolve(string(msg.ID), msg) {
+		c.logError(fmt.Errorf("dropped response to unknown request %q", msg.ID))
 	}
-	resChan, _ := rc.(chan JSONRPCMessage)
-	resChan <- msg
 	return nil
 }
 
+// rejectUnsupported answers a request of the server the client has no handler for, such as listing
+// roots without a RootsListHandler, or a method the client doesn't know of, with a method not found
+// error, so the server doesn't wait for a response that never comes.
+func (c *Client) rejectUnsupported(msg JSONRPCMessage) error {
+	err := c.sendError(context.Background(), msg.ID, JSONRPCError{
+		Code:    jsonRPCMethodNotFoundCode,
+		Message: errMsgMethodNotFound,
+		Data:    map[string]any{"method": msg.Method},
+	})
+	if err != nil {
+		nErr := fmt.Errorf("failed to reject unsupported %s request: %w", msg.Method, err)
+		c.logError(nErr)
+		return nErr
+	}
+
+	return nil
+}
+
+func (c *Client) handleNotificationsResourcesChunk(params notificationsResourcesChunkParams) {
+	s, ok := c.resourceStreams.Load(params.RequestID)
+	if !ok {
+		return
+	}
+	sink, _ := s.(*resourceStreamSink)
+
+	// The read is making progress, so it mustn't time out while its content is still arriving.
+	c.clientRequests.extend(params.RequestID)
+	sink.write(params.Data)
+}
+
 func (c *Client) handleNotificationsCancelled(params notificationsCancelledParams) {
-	r, ok := c.serverRequests.Load(params.RequestID)
+	r, ok := c.serverRequests.Load(MustString(params.RequestID))
 	if !ok {
 		return
 	}
-	req, _ := r.(request)
+	req, _ := r.(*request)
 	req.cancel()
 }
 
-func (c *Client) registerRequest() (string, chan JSONRPCMessage) {
-	reqID := uuid.New().String()
-	resChan := make(chan JSONRPCMessage)
-	c.clientRequests.Store(reqID, resChan)
-	return reqID, resChan
+// ContextWithRequestMeta returns a copy of ctx carrying meta, which the client attaches to the _meta
+// of the params of every request it sends with the returned context, such as CallTool, so
+// request-scoped metadata like trace IDs reaches the server without being set on the params of each
+// call. A field already set in the Meta of the params takes precedence over the one in meta. Servers
+// of this package expose the metadata to their handlers with MetaFromContext.
+func ContextWithRequestMeta(ctx context.Context, meta map[string]any) context.Context {
+	return context.WithValue(ctx, requestMetaKey{}, meta)
+}
+
+// withRequestMeta attaches the metadata ctx carries, if any, to the params of msg, see
+// ContextWithRequestMeta.
+func withRequestMeta(ctx context.Context, msg JSONRPCMessage) (JSONRPCMessage, error) {
+	extra, _ := ctx.Value(requestMetaKey{}).(map[string]any)
+	if len(extra) == 0 {
+		return msg, nil
+	}
+
+	params, err := editParamsMeta(msg.Params, func(meta map[string]json.RawMessage) error {
+		for k, v := range extra {
+			if _, ok := meta[k]; ok {
+				continue
+			}
+			var err error
+			if meta[k], err = json.Marshal(v); err != nil {
+				return fmt.Errorf("failed to marshal meta field %s: %w", k, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return JSONRPCMessage{}, err
+	}
+	msg.Params = params
+
+	return msg, nil
 }
 
 func (c *Client) sendRequest(ctx context.Context, msg JSONRPCMessage) (JSONRPCMessage, error) {
-	reqID, resChan := c.registerRequest()
+	return c.sendRequestWithID(ctx, c.requestIDGenerator(), msg)
+}
+
+// sendRequestWithID sends msg as a request with the given ID, for callers that need to know the ID
+// before the request is sent. The write timeout only bounds the sending of the request. The response
+// is awaited until ctx is done, or the request times out after the read timeout, which responses in
+// progress, like streamed resource reads, push back.
+func (c *Client) sendRequestWithID(ctx context.Context, reqID string, msg JSONRPCMessage) (JSONRPCMessage, error) {
+	msg, err := withRequestMeta(ctx, msg)
+	if err != nil {
+		return JSONRPCMessage{}, err
+	}
+	results := c.clientRequests.add(reqID)
 	msg.ID = MustString(reqID)
 
-	sCtx, sCancel := context.WithTimeout(ctx, c.writeTimeout)
+	sCtx, sCancel := contextWithTimeout(ctx, c.clock, c.writeTimeout)
 	defer sCancel()
 
 	if err := c.transport.Send(sCtx, SessionMsg{
 		SessionID: c.sessionID,
 		Msg:       msg,
 	}); err != nil {
+		c.clientRequests.remove(reqID)
 		return JSONRPCMessage{}, err
 	}
 
-	ticker := time.NewTicker(c.readTimeout)
-
-	var resMsg JSONRPCMessage
+	var res pendingResult
 
 	select {
-	case <-ticker.C:
-		return JSONRPCMessage{}, errors.New("request timeout")
-	case <-sCtx.Done():
-		err := sCtx.Err()
+	case <-ctx.Done():
+		c.clientRequests.remove(reqID)
+		err := ctx.Err()
 		if !errors.Is(err, context.Canceled) {
 			return JSONRPCMessage{}, err
 		}
@@ -1151,10 +1790,13 @@ func (c *Client) sendRequest(ctx context.Context, msg JSONRPCMessage) (JSONRPCMe
 			err = fmt.Errorf("%w: failed to send notification: %w", err, nErr)
 		}
 		return JSONRPCMessage{}, err
-	case resMsg = <-resChan:
+	case res = <-results:
+	}
+	if res.err == nil && msg.Method == MethodResourcesRead {
+		res.msg = c.decompressResult(res.msg)
 	}
 
-	return resMsg, nil
+	return res.msg, res.err
 }
 
 func (c *Client) sendNotification(ctx context.Context, method string, params any) error {
@@ -1169,7 +1811,7 @@ func (c *Client) sendNotification(ctx context.Context, method string, params any
 		Params:  paramsBs,
 	}
 
-	sCtx, sCancel := context.WithTimeout(ctx, c.writeTimeout)
+	sCtx, sCancel := contextWithTimeout(ctx, c.clock, c.writeTimeout)
 	defer sCancel()
 
 	if err := c.transport.Send(sCtx, SessionMsg{
@@ -1194,7 +1836,7 @@ func (c *Client) sendResult(ctx context.Context, id MustString, result any) erro
 		Result:  resBs,
 	}
 
-	sCtx, sCancel := context.WithTimeout(ctx, c.writeTimeout)
+	sCtx, sCancel := contextWithTimeout(ctx, c.clock, c.writeTimeout)
 	defer sCancel()
 
 	if err := c.transport.Send(sCtx, SessionMsg{
@@ -1214,7 +1856,7 @@ func (c *Client) sendError(ctx context.Context, id MustString, err JSONRPCError)
 		Error:   &err,
 	}
 
-	sCtx, sCancel := context.WithTimeout(ctx, c.writeTimeout)
+	sCtx, sCancel := contextWithTimeout(ctx, c.clock, c.writeTimeout)
 	defer sCancel()
 
 	if err := c.transport.Send(sCtx, SessionMsg{
@@ -1227,9 +1869,106 @@ func (c *Client) sendError(ctx context.Context, id MustString, err JSONRPCError)
 	return nil
 }
 
+// loadListCaches fetches the lists kept with the WithAutoRefresh options, for the capabilities the
+// server advertises, within the read timeout.
+func (c *Client) loadListCaches(ctx context.Context) error {
+	ctx, cancel := contextWithTimeout(ctx, c.clock, c.readTimeout)
+	defer cancel()
+	if c.toolListCache != nil && c.serverCapabilities.Tools != nil {
+		if err := c.toolListCache.load(ctx); err != nil {
+			return fmt.Errorf("failed to list tools: %w", err)
+		}
+	}
+	if c.promptListCache != nil && c.serverCapabilities.Prompts != nil {
+		if err := c.promptListCache.load(ctx); err != nil {
+			return fmt.Errorf("failed to list prompts: %w", err)
+		}
+	}
+	if c.resourceListCache != nil && c.serverCapabilities.Resources != nil {
+		if err := c.resourceListCache.load(ctx); err != nil {
+			return fmt.Errorf("failed to list resources: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) listAllTools(ctx context.Context) ([]Tool, error) {
+	return listAll(ctx, func(ctx context.Context, cursor string) ([]Tool, string, error) {
+		result, err := c.ListTools(ctx, ListToolsParams{Cursor: cursor})
+		return result.Tools, result.NextCursor, err
+	})
+}
+
+func (c *Client) listAllPrompts(ctx context.Context) ([]Prompt, error) {
+	return listAll(ctx, func(ctx context.Context, cursor string) ([]Prompt, string, error) {
+		result, err := c.ListPrompts(ctx, ListPromptsParams{Cursor: cursor})
+		return result.Prompts, result.NextCursor, err
+	})
+}
+
+func (c *Client) listAllResources(ctx context.Context) ([]Resource, error) {
+	return listAll(ctx, func(ctx context.Context, cursor string) ([]Resource, string, error) {
+		result, err := c.ListResources(ctx, ListResourcesParams{Cursor: cursor})
+		return result.Resources, result.NextCursor, err
+	})
+}
+
+func (c *Client) logRefreshError(list string, err error) {
+	if err != nil {
+		c.logError(fmt.Errorf("failed to refresh %s list: %w", list, err))
+	}
+}
+
 func (c *Client) logError(err error) {
 	select {
 	case c.errsChan <- err:
 	default:
 	}
 }
+
+// readResourceInto reads the resource with ReadResource, and writes its text or decoded blob to w.
+func (c *Client) readResourceInto(ctx context.Context, params ReadResourceParams, w io.Writer) (Resource, error) {
+	result, err := c.ReadResource(ctx, params)
+	if err != nil {
+		return Resource{}, err
+	}
+	if len(result.Contents) == 0 {
+		return Resource{}, fmt.Errorf("resource %s has no contents", params.URI)
+	}
+
+	resource := result.Contents[0]
+	content := []byte(resource.Text)
+	if resource.Blob != "" {
+		content, err = base64.StdEncoding.DecodeString(resource.Blob)
+		if err != nil {
+			return Resource{}, fmt.Errorf("failed to decode resource blob: %w", err)
+		}
+	}
+	if _, err := w.Write(content); err != nil {
+		return Resource{}, fmt.Errorf("failed to write resource content: %w", err)
+	}
+
+	resource.Text = ""
+	resource.Blob = ""
+	return resource, nil
+}
+
+func (s *resourceStreamSink) write(data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return
+	}
+	if _, err := s.writer.Write(data); err != nil {
+		s.err = err
+		s.cancel()
+	}
+}
+
+func (s *resourceStreamSink) writeErr() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.err
+}
@@ -1,9 +1,23 @@
 package mcp_test
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
 
 	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+	"github.com/MegaGrindStone/go-mcp/pkg/mcptest"
+	"github.com/qri-io/jsonschema"
 )
 
 type mockPromptListWatcher struct{}
@@ -12,18 +26,1073 @@ type mockResourceListWatcher struct{}
 
 type mockResourceSubscribedWatcher struct{}
 
+// mockChangedResourceWatcher reports the URI of every changed resource.
+type mockChangedResourceWatcher struct {
+	uris chan string
+}
+
+// mockCountingResourceServer answers every read with the number of reads so far, followed by padding
+// spaces.
+type mockCountingResourceServer struct {
+	*mockResourceServer
+	reads   *atomic.Int32
+	padding int
+}
+
+// mockBlockingToolServer never answers the listings of its tools, until they're cancelled.
+type mockBlockingToolServer struct {
+	mockArgumentsToolServer
+}
+
 type mockToolListWatcher struct{}
 
+// mockManualToolListUpdater notifies the tool list changes sent on updates.
+type mockManualToolListUpdater struct {
+	updates chan struct{}
+}
+
+// mockCachedToolListWatcher reports the tools cached by cli when the tool list changes.
+type mockCachedToolListWatcher struct {
+	cli   **mcp.Client
+	tools chan []mcp.Tool
+}
+
 type mockRootsListHandler struct{}
 
 type mockRootsListUpdater struct {
 	ch chan struct{}
 }
 
+// mockChangedRootsListWatcher reports every roots list change notification to changed.
+type mockChangedRootsListWatcher struct {
+	changed chan struct{}
+}
+
 type mockSamplingHandler struct{}
 
+// mockStopSequenceSamplingHandler reports the params of every sampling request, and answers it as
+// stopped by the first of its stop sequences.
+type mockStopSequenceSamplingHandler struct {
+	params chan mcp.SamplingParams
+}
+
+// mockSessionSamplingHandler reports the ServerSessionInfo of every sampling request, and answers it
+// only for the servers allowed to sample its model.
+type mockSessionSamplingHandler struct {
+	sessions chan mcp.ServerSessionInfo
+	allowed  map[string]bool
+}
+
+// mockStreamingSamplingHandler streams its response in the given pieces.
+type mockStreamingSamplingHandler struct {
+	mockSamplingHandler
+
+	pieces []string
+}
+
 type mockLogReceiver struct{}
 
+func TestCallToolTyped(t *testing.T) {
+	type sum struct {
+		Total int `json:"total"`
+	}
+
+	registry := mcp.NewToolRegistry()
+	textTool := func(text string, isError bool) mcp.ToolHandler {
+		return func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			return mcp.CallToolResult{
+				Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: text}},
+				IsError: isError,
+			}, nil
+		}
+	}
+	err := registry.AddAll([]mcp.ToolEntry{
+		{
+			Tool: mcp.Tool{Name: "add"},
+			Handler: func(
+				_ context.Context,
+				params mcp.CallToolParams,
+				_ mcp.RequestClientFunc,
+			) (mcp.CallToolResult, error) {
+				a, _ := params.Arguments["a"].(float64)
+				b, _ := params.Arguments["b"].(float64)
+				return mcp.CallToolResult{
+					Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: fmt.Sprintf(`{"total":%d}`, int(a+b))}},
+				}, nil
+			},
+		},
+		{Tool: mcp.Tool{Name: "fail"}, Handler: textTool("something broke", true)},
+		{Tool: mcp.Tool{Name: "plain"}, Handler: textTool("not json", false)},
+	})
+	if err != nil {
+		t.Fatalf("failed to register tools: %v", err)
+	}
+
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithToolServer(registry))
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ToolServer: true,
+	})
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	res, err := mcp.CallToolTyped[sum](ctx, cli, "add", map[string]int{"a": 1, "b": 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Total != 3 {
+		t.Errorf("expected total 3, got %d", res.Total)
+	}
+
+	_, err = mcp.CallToolTyped[sum](ctx, cli, "fail", nil)
+	if err == nil || !strings.Contains(err.Error(), "something broke") {
+		t.Errorf("expected tool error with its message, got %v", err)
+	}
+
+	if _, err := mcp.CallToolTyped[sum](ctx, cli, "plain", nil); err == nil {
+		t.Error("expected error decoding a non-JSON result")
+	}
+
+	if _, err := mcp.CallToolTyped[sum](ctx, cli, "add", []int{1, 2}); err == nil {
+		t.Error("expected error for arguments that aren't an object")
+	}
+}
+
+func TestCallToolStructuredContent(t *testing.T) {
+	type sum struct {
+		Total int `json:"total"`
+	}
+
+	schema := jsonschema.Must(`{"type":"object","properties":{"total":{"type":"integer"}},"required":["total"]}`)
+	structured := func(content string) mcp.ToolHandler {
+		return func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			return mcp.CallToolResult{StructuredContent: json.RawMessage(content)}, nil
+		}
+	}
+
+	registry := mcp.NewToolRegistry()
+	err := registry.AddAll([]mcp.ToolEntry{
+		{Tool: mcp.Tool{Name: "sum", OutputSchema: schema}, Handler: structured(`{"total":3}`)},
+		{Tool: mcp.Tool{Name: "mismatch", OutputSchema: schema}, Handler: structured(`{"total":"three"}`)},
+	})
+	if err != nil {
+		t.Fatalf("failed to register tools: %v", err)
+	}
+
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithToolServer(registry))
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ToolServer: true,
+	})
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	// Before the tools are listed, their output schemas are unknown, so nothing is validated.
+	if _, err := cli.CallTool(ctx, mcp.CallToolParams{Name: "mismatch"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := cli.ListTools(ctx, mcp.ListToolsParams{}); err != nil {
+		t.Fatalf("failed to list tools: %v", err)
+	}
+
+	res, err := cli.CallTool(ctx, mcp.CallToolParams{Name: "sum"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(res.StructuredContent) != `{"total":3}` {
+		t.Errorf("expected structured content {\"total\":3}, got %s", res.StructuredContent)
+	}
+
+	typed, err := mcp.CallToolTyped[sum](ctx, cli, "sum", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if typed.Total != 3 {
+		t.Errorf("expected total 3, got %d", typed.Total)
+	}
+
+	if _, err := cli.CallTool(ctx, mcp.CallToolParams{Name: "mismatch"}); err == nil {
+		t.Error("expected error for structured content not matching the output schema")
+	}
+
+	bs, err := json.Marshal(mcp.CallToolResult{})
+	if err != nil {
+		t.Fatalf("failed to marshal result: %v", err)
+	}
+	if strings.Contains(string(bs), "structuredContent") {
+		t.Errorf("expected structured content to be omitted, got %s", bs)
+	}
+}
+
+func TestCallToolResourceLink(t *testing.T) {
+	link := mcp.Content{
+		Type:        mcp.ContentTypeResourceLink,
+		URI:         "file:///reports/q3.csv",
+		Name:        "q3.csv",
+		Description: "Quarterly report",
+		MimeType:    "text/csv",
+	}
+
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "report"},
+		func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			return mcp.CallToolResult{Content: []mcp.Content{link}}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to register tool: %v", err)
+	}
+
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithToolServer(registry))
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ToolServer: true,
+	})
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	res, err := cli.CallTool(ctx, mcp.CallToolParams{Name: "report"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Content) != 1 || res.Content[0] != link {
+		t.Errorf("expected resource link %+v, got %+v", link, res.Content)
+	}
+
+	bs, err := json.Marshal(link)
+	if err != nil {
+		t.Fatalf("failed to marshal content: %v", err)
+	}
+	if !strings.Contains(string(bs), `"type":"resource_link"`) || strings.Contains(string(bs), `"resource"`) {
+		t.Errorf("expected a resource link without an embedded resource, got %s", bs)
+	}
+}
+
+func TestSamplingStopSequenceAndUsage(t *testing.T) {
+	temperature := 0.2
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "summarize"},
+		func(_ context.Context, _ mcp.CallToolParams, requestClient mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			params, err := json.Marshal(mcp.SamplingParams{
+				Messages: []mcp.SamplingMessage{
+					{Role: mcp.PromptRoleUser, Content: mcp.SamplingContent{Type: "text", Text: "Hi"}},
+				},
+				MaxTokens:     100,
+				Temperature:   &temperature,
+				StopSequences: []string{"END"},
+			})
+			if err != nil {
+				return mcp.CallToolResult{}, err
+			}
+			res, err := requestClient(mcp.JSONRPCMessage{
+				JSONRPC: mcp.JSONRPCVersion,
+				Method:  mcp.MethodSamplingCreateMessage,
+				Params:  params,
+			})
+			if err != nil {
+				return mcp.CallToolResult{}, err
+			}
+			return mcp.CallToolResult{StructuredContent: res.Result}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to register tool: %v", err)
+	}
+
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithToolServer(registry))
+
+	handler := mockStopSequenceSamplingHandler{params: make(chan mcp.SamplingParams, 1)}
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ToolServer: true,
+	}, mcp.WithSamplingHandler(handler))
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	res, err := cli.CallTool(ctx, mcp.CallToolParams{Name: "summarize"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	params := <-handler.params
+	if params.Temperature == nil || *params.Temperature != temperature || len(params.StopSequences) != 1 {
+		t.Errorf("expected temperature and stop sequences to reach the client, got %+v", params)
+	}
+
+	var result mcp.SamplingResult
+	if err := json.Unmarshal(res.StructuredContent, &result); err != nil {
+		t.Fatalf("failed to unmarshal sampling result: %v", err)
+	}
+	if result.StopSequence != "END" {
+		t.Errorf("expected stop sequence END, got %q", result.StopSequence)
+	}
+	if result.Usage == nil || result.Usage.InputTokens != 12 || result.Usage.OutputTokens != 3 {
+		t.Errorf("expected usage of 12 input and 3 output tokens, got %+v", result.Usage)
+	}
+
+	bs, err := json.Marshal(mcp.SamplingResult{})
+	if err != nil {
+		t.Fatalf("failed to marshal result: %v", err)
+	}
+	if strings.Contains(string(bs), "usage") || strings.Contains(string(bs), "stopSequence") {
+		t.Errorf("expected usage and stop sequence to be omitted, got %s", bs)
+	}
+}
+
+func TestStreamSampling(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "write"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			deltas := make(chan string)
+			received := make(chan []string)
+			go func() {
+				var pieces []string
+				for delta := range deltas {
+					pieces = append(pieces, delta)
+				}
+				received <- pieces
+			}()
+
+			result, err := mcp.StreamSampling(ctx, mcp.SamplingParams{MaxTokens: 100}, deltas)
+			if err != nil {
+				return mcp.CallToolResult{}, err
+			}
+			return mcp.CallToolResult{Content: []mcp.Content{
+				{Type: mcp.ContentTypeText, Text: strings.Join(<-received, "|")},
+				{Type: mcp.ContentTypeText, Text: result.Content.Text},
+			}}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to register tool: %v", err)
+	}
+
+	type testCase struct {
+		name       string
+		handler    mcp.SamplingHandler
+		wantPieces string
+		wantText   string
+	}
+
+	testCases := []testCase{
+		{
+			name:       "streaming",
+			handler:    mockStreamingSamplingHandler{pieces: []string{"Hel", "lo", " world"}},
+			wantPieces: "Hel|lo| world",
+			wantText:   "Hello world",
+		},
+		{
+			name:     "not streaming",
+			handler:  mockSamplingHandler{},
+			wantText: "Test response",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			serverTransport, clientTransport := setupStdIO()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithToolServer(registry))
+
+			cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+				ToolServer: true,
+			}, mcp.WithSamplingHandler(tc.handler))
+			defer cli.Close()
+
+			if err := cli.Connect(); err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+
+			res, err := cli.CallTool(ctx, mcp.CallToolParams{Name: "write"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if pieces := res.Content[0].Text; pieces != tc.wantPieces {
+				t.Errorf("expected pieces %q, got %q", tc.wantPieces, pieces)
+			}
+			if text := res.Content[1].Text; text != tc.wantText {
+				t.Errorf("expected result text %q, got %q", tc.wantText, text)
+			}
+		})
+	}
+}
+
+func TestSamplingModelPreferences(t *testing.T) {
+	testCases := []struct {
+		name        string
+		preferences mcp.SamplingModelPreferences
+		wantErr     bool
+	}{
+		{name: "zero"},
+		{
+			name:        "boundaries",
+			preferences: mcp.SamplingModelPreferences{CostPriority: 0, SpeedPriority: 1, IntelligencePriority: 0.5},
+		},
+		{
+			name:        "negative",
+			preferences: mcp.SamplingModelPreferences{CostPriority: -0.1},
+			wantErr:     true,
+		},
+		{
+			name:        "above one",
+			preferences: mcp.SamplingModelPreferences{SpeedPriority: 1.01},
+			wantErr:     true,
+		},
+		{
+			name:        "not a number",
+			preferences: mcp.SamplingModelPreferences{IntelligencePriority: math.NaN()},
+			wantErr:     true,
+		},
+	}
+
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "sample"},
+		func(ctx context.Context, params mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			// The preferences of the test case are looked up by name, as NaN can't be sent as JSON.
+			var preferences mcp.SamplingModelPreferences
+			for _, tc := range testCases {
+				if tc.name == params.Arguments["case"] {
+					preferences = tc.preferences
+				}
+			}
+			_, err := mcp.StreamSampling(ctx, mcp.SamplingParams{
+				MaxTokens:        100,
+				ModelPreferences: preferences,
+				StopSequences:    []string{"."},
+			}, make(chan string))
+			return mcp.CallToolResult{}, err
+		})
+	if err != nil {
+		t.Fatalf("failed to register tool: %v", err)
+	}
+
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithToolServer(registry))
+
+	handler := mockStopSequenceSamplingHandler{params: make(chan mcp.SamplingParams, 1)}
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ToolServer: true,
+	}, mcp.WithSamplingHandler(handler))
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.preferences.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("expected validation error %t, got %v", tc.wantErr, err)
+			}
+
+			_, err := cli.CallTool(ctx, mcp.CallToolParams{Name: "sample", Arguments: map[string]any{"case": tc.name}})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected sampling error %t, got %v", tc.wantErr, err)
+			}
+			select {
+			case params := <-handler.params:
+				if tc.wantErr {
+					t.Errorf("expected invalid preferences not to reach the client, got %+v", params.ModelPreferences)
+				} else if !reflect.DeepEqual(params.ModelPreferences, tc.preferences) {
+					t.Errorf("expected preferences %+v, got %+v", tc.preferences, params.ModelPreferences)
+				}
+			default:
+				if !tc.wantErr {
+					t.Error("expected the sampling request to reach the client")
+				}
+			}
+		})
+	}
+}
+
+func TestSamplingServerSession(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "sample"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			_, err := mcp.StreamSampling(ctx, mcp.SamplingParams{MaxTokens: 100}, make(chan string))
+			return mcp.CallToolResult{}, err
+		})
+	if err != nil {
+		t.Fatalf("failed to register tool: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		allowed map[string]bool
+		wantErr bool
+	}{
+		{name: "allowed", allowed: map[string]bool{"test-server": true}},
+		{name: "denied", allowed: map[string]bool{"other-server": true}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverTransport, clientTransport := setupStdIO()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithToolServer(registry))
+
+			handler := mockSessionSamplingHandler{sessions: make(chan mcp.ServerSessionInfo, 1), allowed: test.allowed}
+			cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+				ToolServer: true,
+			}, mcp.WithSamplingHandler(handler))
+			defer cli.Close()
+
+			if err := cli.Connect(); err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+
+			_, err := cli.CallTool(ctx, mcp.CallToolParams{Name: "sample"})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("expected sampling error %t, got %v", test.wantErr, err)
+			}
+
+			session := <-handler.sessions
+			if session.ServerInfo.Name != "test-server" || session.ProtocolVersion != cli.ProtocolVersion() {
+				t.Errorf("expected the session with test-server, got %+v", session)
+			}
+			if session.ServerCapabilities.Tools == nil {
+				t.Errorf("expected the capabilities of the server, got %+v", session.ServerCapabilities)
+			}
+		})
+	}
+
+	if _, ok := mcp.ServerSession(context.Background()); ok {
+		t.Error("expected no server session outside of a server request")
+	}
+}
+
+func TestReadResourceTemplate(t *testing.T) {
+	srv := &mockResourceServer{}
+
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithResourceServer(srv))
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ResourceServer: true,
+	})
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	_, err := cli.ReadResourceTemplate(ctx, "test://users/{id}/profile", map[string]string{"id": "42"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if srv.readParams.URI != "test://users/42/profile" {
+		t.Errorf("expected read of test://users/42/profile, got %s", srv.readParams.URI)
+	}
+
+	if _, err := cli.ReadResourceTemplate(ctx, "test://users/{id}/profile", nil); err == nil {
+		t.Error("expected error for a missing variable")
+	}
+}
+
+func TestResourceCache(t *testing.T) {
+	srv := mockCountingResourceServer{mockResourceServer: &mockResourceServer{}, reads: new(atomic.Int32)}
+	updater := mcptest.NewManualResourceUpdater()
+	watcher := mockChangedResourceWatcher{uris: make(chan string, 1)}
+
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error),
+		mcp.WithResourceServer(srv), mcp.WithResourceSubscribedUpdater(updater))
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ResourceServer: true,
+	}, mcp.WithResourceCache(10), mcp.WithResourceSubscribedWatcher(watcher))
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	read := func(want string) {
+		t.Helper()
+
+		res, err := cli.ReadResource(ctx, mcp.ReadResourceParams{URI: "test://resource"})
+		if err != nil {
+			t.Fatalf("failed to read resource: %v", err)
+		}
+		if got := res.Contents[0].Text; got != want {
+			t.Errorf("expected read %s, got %s", want, got)
+		}
+	}
+
+	// Resources the client isn't subscribed to aren't cached.
+	read("1")
+	read("2")
+
+	if err := cli.SubscribeResource(ctx, mcp.SubscribeResourceParams{URI: "test://resource"}); err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	read("3")
+	read("3")
+
+	updater.TriggerUpdate("test://resource")
+	<-watcher.uris
+	read("4")
+	read("4")
+
+	if err := cli.UnsubscribeResource(ctx, mcp.UnsubscribeResourceParams{URI: "test://resource"}); err != nil {
+		t.Fatalf("failed to unsubscribe: %v", err)
+	}
+	read("5")
+}
+
+func TestInlineResourceUpdates(t *testing.T) {
+	testCases := []struct {
+		name    string
+		inline  bool
+		padding int
+		// wantUpdateReads is the number of reads once the update arrives, and wantText the text of the
+		// read that follows, along with the number of reads after it.
+		wantUpdateReads int32
+		wantText        string
+		wantReads       int32
+	}{
+		{name: "inline", inline: true, wantUpdateReads: 2, wantText: "2", wantReads: 2},
+		{name: "too large", inline: true, padding: 20 << 10, wantUpdateReads: 2, wantText: "3", wantReads: 3},
+		{name: "disabled", wantUpdateReads: 1, wantText: "2", wantReads: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := mockCountingResourceServer{
+				mockResourceServer: &mockResourceServer{},
+				reads:              new(atomic.Int32),
+				padding:            tc.padding,
+			}
+			updater := mcptest.NewManualResourceUpdater()
+			watcher := mockChangedResourceWatcher{uris: make(chan string, 1)}
+
+			serverTransport, clientTransport := setupStdIO()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithResourceServer(srv),
+				mcp.WithResourceSubscribedUpdater(updater), mcp.WithInlineResourceUpdates(tc.inline))
+
+			cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+				ResourceServer: true,
+			}, mcp.WithResourceCache(10), mcp.WithResourceSubscribedWatcher(watcher))
+			defer cli.Close()
+
+			if err := cli.Connect(); err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			if err := cli.SubscribeResource(ctx, mcp.SubscribeResourceParams{URI: "test://resource"}); err != nil {
+				t.Fatalf("failed to subscribe: %v", err)
+			}
+			if _, err := cli.ReadResource(ctx, mcp.ReadResourceParams{URI: "test://resource"}); err != nil {
+				t.Fatalf("failed to read resource: %v", err)
+			}
+
+			updater.TriggerUpdate("test://resource")
+			<-watcher.uris
+			if reads := srv.reads.Load(); reads != tc.wantUpdateReads {
+				t.Errorf("expected %d reads once updated, got %d", tc.wantUpdateReads, reads)
+			}
+
+			res, err := cli.ReadResource(ctx, mcp.ReadResourceParams{URI: "test://resource"})
+			if err != nil {
+				t.Fatalf("failed to read resource: %v", err)
+			}
+			if got := strings.TrimSpace(res.Contents[0].Text); got != tc.wantText {
+				t.Errorf("expected read %s, got %s", tc.wantText, got)
+			}
+			if reads := srv.reads.Load(); reads != tc.wantReads {
+				t.Errorf("expected %d reads, got %d", tc.wantReads, reads)
+			}
+		})
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error),
+		mcp.WithResourceServer(&mockResourceServer{}), mcp.WithResourceSubscribedUpdater(mockResourceSubscribedUpdater{}))
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ResourceServer: true,
+	})
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	subscriptions := func() []string {
+		t.Helper()
+
+		uris, err := cli.Subscriptions(ctx)
+		if err != nil {
+			t.Fatalf("failed to list subscriptions: %v", err)
+		}
+		return uris
+	}
+
+	if uris := subscriptions(); len(uris) != 0 {
+		t.Errorf("expected no subscriptions, got %v", uris)
+	}
+
+	for _, uri := range []string{"test://b", "test://a", "test://b", "test://c"} {
+		if err := cli.SubscribeResource(ctx, mcp.SubscribeResourceParams{URI: uri}); err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+	}
+	if err := cli.UnsubscribeResource(ctx, mcp.UnsubscribeResourceParams{URI: "test://c"}); err != nil {
+		t.Fatalf("failed to unsubscribe: %v", err)
+	}
+
+	if uris, want := subscriptions(), []string{"test://a", "test://b"}; !slices.Equal(uris, want) {
+		t.Errorf("expected subscriptions %v, got %v", want, uris)
+	}
+}
+
+func TestClientMismatchedResponseID(t *testing.T) {
+	srvReader, cliWriter := io.Pipe()
+	cliReader, srvWriter := io.Pipe()
+	defer srvWriter.Close()
+
+	clientTransport := mcp.NewStdIO(cliReader, cliWriter)
+	go clientTransport.Start()
+
+	// The server answers the initialization properly, and every other request with the wrong ID.
+	go func() {
+		scanner := bufio.NewScanner(srvReader)
+		encoder := json.NewEncoder(srvWriter)
+		for scanner.Scan() {
+			var msg mcp.JSONRPCMessage
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || msg.ID == "" {
+				continue
+			}
+			res := mcp.JSONRPCMessage{JSONRPC: mcp.JSONRPCVersion, ID: "wrong-" + msg.ID, Result: json.RawMessage(`{}`)}
+			if msg.Method == "initialize" {
+				res.ID = msg.ID
+				res.Result = json.RawMessage(`{"protocolVersion":"2024-11-05","capabilities":{},` +
+					`"serverInfo":{"name":"buggy-server","version":"1.0"}}`)
+			}
+			if err := encoder.Encode(res); err != nil {
+				return
+			}
+		}
+	}()
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{},
+		mcp.WithClientReadTimeout(100*time.Millisecond))
+	defer cli.Close()
+
+	errs := make(chan error, 10)
+	go func() {
+		for err := range cli.Errors() {
+			errs <- err
+		}
+	}()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := cli.ListTools(context.Background(), mcp.ListToolsParams{}); err == nil {
+		t.Fatal("expected error for a response with the wrong ID")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the request to time out promptly, took %v", elapsed)
+	}
+
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "dropped response to unknown request") {
+			t.Errorf("expected the mismatched response to be reported, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the mismatched response to be reported")
+	}
+}
+
+func TestClientUnsupportedServerRequests(t *testing.T) {
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithToolServer(newRequestToolRegistry(t)))
+
+	// The client has neither a roots list handler, nor a sampling handler.
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ToolServer: true,
+	})
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	// elicitation/create is a request the client has no handler for.
+	for _, method := range []string{mcp.MethodRootsList, mcp.MethodSamplingCreateMessage, "elicitation/create"} {
+		reqCtx, reqCancel := context.WithTimeout(ctx, time.Second)
+		result, err := cli.CallTool(reqCtx, mcp.CallToolParams{
+			Name:      "request",
+			Arguments: map[string]any{"method": method},
+		})
+		reqCancel()
+		if err != nil {
+			t.Fatalf("failed to call tool for %s: %v", method, err)
+		}
+		if text := result.Content[0].Text; text != "-32601 Method not found" {
+			t.Errorf("expected method not found error for %s, got %q", method, text)
+		}
+	}
+}
+
+func TestServerRequestHandler(t *testing.T) {
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithToolServer(newRequestToolRegistry(t)))
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ToolServer: true,
+	},
+		mcp.WithServerRequestHandler("elicitation/create", func(context.Context, json.RawMessage) (any, error) {
+			return map[string]string{"action": "accept"}, nil
+		}),
+		mcp.WithServerRequestHandler("custom/invalid", func(context.Context, json.RawMessage) (any, error) {
+			return nil, fmt.Errorf("rejected: %w", mcp.JSONRPCError{Code: -32602, Message: "Invalid params"})
+		}),
+		mcp.WithServerRequestHandler("custom/failing", func(context.Context, json.RawMessage) (any, error) {
+			return nil, errors.New("failed")
+		}),
+	)
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	tests := map[string]string{
+		"elicitation/create": `{"action":"accept"}`,
+		"custom/invalid":     "-32602 Invalid params",
+		"custom/failing":     "-32603 Internal error",
+	}
+	for method, want := range tests {
+		reqCtx, reqCancel := context.WithTimeout(ctx, time.Second)
+		result, err := cli.CallTool(reqCtx, mcp.CallToolParams{
+			Name:      "request",
+			Arguments: map[string]any{"method": method},
+		})
+		reqCancel()
+		if err != nil {
+			t.Fatalf("failed to call tool for %s: %v", method, err)
+		}
+		if text := result.Content[0].Text; text != want {
+			t.Errorf("expected %q for %s, got %q", want, method, text)
+		}
+	}
+}
+
+func TestAutoRefreshToolList(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	if err := registry.Add(mcp.Tool{Name: "echo"}, nil); err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+	updater := mockManualToolListUpdater{updates: make(chan struct{})}
+
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error),
+		mcp.WithToolServer(registry), mcp.WithToolListUpdater(updater))
+
+	var cli *mcp.Client
+	watcher := mockCachedToolListWatcher{cli: &cli, tools: make(chan []mcp.Tool, 1)}
+	cli = mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ToolServer: true,
+	}, mcp.WithAutoRefreshToolList(true), mcp.WithToolListWatcher(watcher))
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	if tools := cli.CachedTools(); len(tools) != 1 || tools[0].Name != "echo" {
+		t.Fatalf("expected the tool list to be fetched on connect, got %+v", tools)
+	}
+
+	if err := registry.Add(mcp.Tool{Name: "add"}, nil); err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+	updater.updates <- struct{}{}
+
+	// The watcher is called once the new list is cached.
+	select {
+	case tools := <-watcher.tools:
+		if len(tools) != 2 || tools[1].Name != "add" {
+			t.Errorf("expected the refreshed tool list, got %+v", tools)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the tool list watcher to be called")
+	}
+
+	if prompts := cli.CachedPrompts(); prompts != nil {
+		t.Errorf("expected no cached prompts without auto refresh, got %+v", prompts)
+	}
+}
+
+func TestConnectContextLoadsListsWithinContext(t *testing.T) {
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error),
+		mcp.WithToolServer(mockBlockingToolServer{}))
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ToolServer: true,
+	}, mcp.WithAutoRefreshToolList(true))
+	defer cli.Close()
+
+	connectCtx, connectCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer connectCancel()
+
+	err := cli.ConnectContext(connectCtx)
+	if err == nil || !strings.Contains(err.Error(), "failed to load lists") {
+		t.Errorf("expected the tool list to fail to load within the context, got %v", err)
+	}
+}
+
+func TestClientRequestOutlivesWriteTimeout(t *testing.T) {
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "slow"},
+		func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			time.Sleep(100 * time.Millisecond)
+			return mcp.CallToolResult{Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: "done"}}}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithToolServer(registry))
+
+	// The write timeout only bounds sending the request, and the response comes after it passed.
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ToolServer: true,
+	}, mcp.WithClientWriteTimeout(20*time.Millisecond))
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	res, err := cli.CallTool(ctx, mcp.CallToolParams{Name: "slow"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Content) != 1 || res.Content[0].Text != "done" {
+		t.Errorf("expected the result of the tool, got %+v", res)
+	}
+}
+
+func TestRootsListChanged(t *testing.T) {
+	serverTransport, clientTransport, httpSrv := setupSSE()
+	defer httpSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watcher := mockChangedRootsListWatcher{changed: make(chan struct{}, 1)}
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithRootsListWatcher(watcher))
+
+	// The roots list changes before the client connects, which the server is told about once the
+	// session is initialized.
+	updater := mockRootsListUpdater{ch: make(chan struct{}, 1)}
+	updater.ch <- struct{}{}
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{},
+		mcp.WithRootsListHandler(mockRootsListHandler{}), mcp.WithRootsListUpdater(updater))
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	select {
+	case <-watcher.changed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the roots list watcher to be called for the change before connecting")
+	}
+
+	updater.ch <- struct{}{}
+	select {
+	case <-watcher.changed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the roots list watcher to be called for the change after connecting")
+	}
+}
+
 func (m mockPromptListWatcher) OnPromptListChanged() {
 }
 
@@ -33,9 +1102,41 @@ func (m mockResourceListWatcher) OnResourceListChanged() {
 func (m mockResourceSubscribedWatcher) OnResourceSubscribedChanged(string) {
 }
 
+func (m mockChangedResourceWatcher) OnResourceSubscribedChanged(uri string) {
+	m.uris <- uri
+}
+
+func (m mockCountingResourceServer) ReadResource(
+	_ context.Context,
+	params mcp.ReadResourceParams,
+	_ mcp.RequestClientFunc,
+) (mcp.ReadResourceResult, error) {
+	reads := m.reads.Add(1)
+	return mcp.ReadResourceResult{
+		Contents: []mcp.Resource{{URI: params.URI, Text: fmt.Sprint(reads) + strings.Repeat(" ", m.padding)}},
+	}, nil
+}
+
+func (m mockBlockingToolServer) ListTools(
+	ctx context.Context,
+	_ mcp.ListToolsParams,
+	_ mcp.RequestClientFunc,
+) (mcp.ListToolsResult, error) {
+	<-ctx.Done()
+	return mcp.ListToolsResult{}, ctx.Err()
+}
+
 func (m mockToolListWatcher) OnToolListChanged() {
 }
 
+func (m mockManualToolListUpdater) ToolListUpdates() <-chan struct{} {
+	return m.updates
+}
+
+func (m mockCachedToolListWatcher) OnToolListChanged() {
+	m.tools <- (*m.cli).CachedTools()
+}
+
 func (m mockRootsListHandler) RootsList(context.Context) (mcp.RootList, error) {
 	return mcp.RootList{
 		Roots: []mcp.Root{
@@ -44,6 +1145,10 @@ func (m mockRootsListHandler) RootsList(context.Context) (mcp.RootList, error) {
 	}, nil
 }
 
+func (m mockChangedRootsListWatcher) OnRootsListChanged() {
+	m.changed <- struct{}{}
+}
+
 func (m mockRootsListUpdater) RootsListUpdates() <-chan struct{} {
 	if m.ch == nil {
 		m.ch = make(chan struct{})
@@ -63,9 +1168,87 @@ func (m mockSamplingHandler) CreateSampleMessage(context.Context, mcp.SamplingPa
 	}, nil
 }
 
+func (m mockStopSequenceSamplingHandler) CreateSampleMessage(
+	_ context.Context,
+	params mcp.SamplingParams,
+) (mcp.SamplingResult, error) {
+	m.params <- params
+	return mcp.SamplingResult{
+		Role:         mcp.PromptRoleAssistant,
+		Content:      mcp.SamplingContent{Type: "text", Text: "Hello"},
+		Model:        "test-model",
+		StopReason:   "stopSequence",
+		StopSequence: params.StopSequences[0],
+		Usage:        &mcp.SamplingUsage{InputTokens: 12, OutputTokens: 3},
+	}, nil
+}
+
+func (m mockSessionSamplingHandler) CreateSampleMessage(
+	ctx context.Context,
+	_ mcp.SamplingParams,
+) (mcp.SamplingResult, error) {
+	session, ok := mcp.ServerSession(ctx)
+	if !ok {
+		return mcp.SamplingResult{}, errors.New("no server session")
+	}
+	m.sessions <- session
+	if !m.allowed[session.ServerInfo.Name] {
+		return mcp.SamplingResult{}, fmt.Errorf("server %s isn't allowed to sample test-model", session.ServerInfo.Name)
+	}
+	return mcp.SamplingResult{
+		Role:    mcp.PromptRoleAssistant,
+		Content: mcp.SamplingContent{Type: "text", Text: "Hello"},
+		Model:   "test-model",
+	}, nil
+}
+
+func (m mockStreamingSamplingHandler) CreateSampleMessageStream(
+	ctx context.Context,
+	_ mcp.SamplingParams,
+	deltas chan<- string,
+) (mcp.SamplingResult, error) {
+	for _, piece := range m.pieces {
+		select {
+		case deltas <- piece:
+		case <-ctx.Done():
+			return mcp.SamplingResult{}, ctx.Err()
+		}
+	}
+	return mcp.SamplingResult{
+		Role:       mcp.PromptRoleAssistant,
+		Content:    mcp.SamplingContent{Type: "text", Text: strings.Join(m.pieces, "")},
+		Model:      "test-model",
+		StopReason: "endTurn",
+	}, nil
+}
+
 func (m mockLogReceiver) OnLog(_ mcp.LogParams) {
 }
 
+// newRequestToolRegistry returns a registry with a "request" tool, which sends the client a request
+// with the method in its arguments, and returns the result of the response, or its error code and
+// message.
+func newRequestToolRegistry(t *testing.T) mcp.ToolServer {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "request"},
+		func(_ context.Context, params mcp.CallToolParams, requestClient mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			method, _ := params.Arguments["method"].(string)
+			res, err := requestClient(mcp.JSONRPCMessage{JSONRPC: mcp.JSONRPCVersion, Method: method})
+			if err != nil {
+				return mcp.CallToolResult{}, err
+			}
+			text := string(res.Result)
+			if res.Error != nil {
+				text = fmt.Sprintf("%d %s", res.Error.Code, res.Error.Message)
+			}
+			return mcp.CallToolResult{Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: text}}}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+	return registry
+}
+
 // func TestNewClient(t *testing.T) {
 // 	tests := []struct {
 // 		name     string
@@ -0,0 +1,147 @@
+package mcp
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock tells the time and measures durations for the server and the client: their ping intervals,
+// read timeouts, write timeouts, and the timeouts of requests set with WithMethodTimeout, as well as
+// the keep-alive interval and resume window of SSEServer, and the refills of TokenBucketLimiter. It's
+// set with WithClock, WithClientClock, WithSSEClock and WithTokenBucketClock, and defaults to the real
+// clock, so tests can drive timeouts with a fake clock, such as mcptest.FakeClock, instead of waiting
+// for them.
+//
+// Implementations must be safe for concurrent use.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is the Clock counterpart of time.Timer. C returns the channel the time is sent on once the
+// timer expires.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker is the Clock counterpart of time.Ticker. C returns the channel the ticks are sent on.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+type realTimer struct {
+	*time.Timer
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+// timeoutContext is a context that times out on the time of a Clock other than the real one, and
+// then reports context.DeadlineExceeded, like the contexts of context.WithTimeout do.
+type timeoutContext struct {
+	context.Context
+
+	deadline time.Time
+	timedOut atomic.Bool
+}
+
+// Now implements Clock interface.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer implements Clock interface.
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// NewTicker implements Clock interface.
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// C implements Timer interface.
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// C implements Ticker interface.
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// contextWithTimeout is context.WithTimeout on the time of clock.
+func contextWithTimeout(
+	ctx context.Context,
+	clock Clock,
+	timeout time.Duration,
+) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(realClock); ok {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	inner, cancel := context.WithCancel(ctx)
+	tCtx := &timeoutContext{
+		Context:  inner,
+		deadline: clock.Now().Add(timeout),
+	}
+	timer := clock.NewTimer(timeout)
+	go func() {
+		defer timer.Stop()
+
+		select {
+		case <-timer.C():
+			if inner.Err() == nil {
+				tCtx.timedOut.Store(true)
+			}
+			cancel()
+		case <-inner.Done():
+		}
+	}()
+
+	return tCtx, cancel
+}
+
+func (c *timeoutContext) Deadline() (time.Time, bool) {
+	if deadline, ok := c.Context.Deadline(); ok && deadline.Before(c.deadline) {
+		return deadline, true
+	}
+	return c.deadline, true
+}
+
+func (c *timeoutContext) Err() error {
+	if c.timedOut.Load() {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
+
+// afterFunc is time.AfterFunc on the time of clock: it calls f in its own goroutine once d passed.
+// The returned function stops the timer, after which f isn't called, unless it already was.
+func afterFunc(clock Clock, d time.Duration, f func()) func() {
+	timer := clock.NewTimer(d)
+	stopped := make(chan struct{})
+	go func() {
+		defer timer.Stop()
+
+		select {
+		case <-timer.C():
+			f()
+		case <-stopped:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopped) })
+	}
+}
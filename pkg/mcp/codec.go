@@ -0,0 +1,645 @@
+package mcp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"strconv"
+)
+
+// Codec encodes and decodes the JSON-RPC messages exchanged by a transport. JSONCodec is the codec of
+// every transport by default. SSEServer and SSEClient can negotiate another one, such as MsgpackCodec,
+// with WithSSECodecs and WithSSEClientCodec, and fall back to JSON when the peer doesn't support it.
+// StdIO has no way to negotiate a codec, and always uses JSON.
+//
+// Implementations must be safe for concurrent use.
+type Codec interface {
+	// ContentType returns the media type of the encoded messages, which identifies the codec when
+	// peers negotiate it.
+	ContentType() string
+	Marshal(msg JSONRPCMessage) ([]byte, error)
+	Unmarshal(data []byte, msg *JSONRPCMessage) error
+}
+
+// JSONCodec is the Codec encoding messages as JSON, as the MCP specification defines them.
+type JSONCodec struct{}
+
+// MsgpackCodec is the Codec encoding messages with MessagePack, a binary counterpart of JSON that's
+// smaller and cheaper to parse, for constrained or high-throughput environments.
+//
+// A message is encoded as a map of the fields its JSON encoding has. Its raw params and results, such
+// as the arguments of a tool call, are transcoded from and to JSON value by value, so they pass
+// through unchanged, keeping the order of their object keys. Integers are encoded as MessagePack
+// integers, and other numbers as 64-bit floats.
+//
+// SSE events carry text, so the messages an SSEServer sends on a MessagePack event stream are encoded
+// in base64, which makes them a third larger than their MessagePack encoding, and often larger than
+// their JSON encoding. Only the messages clients post are sent as binary MessagePack.
+type MsgpackCodec struct{}
+
+const (
+	jsonContentType    = "application/json"
+	msgpackContentType = "application/msgpack"
+)
+
+// maxMsgpackDepth caps the nesting of the arrays and maps of a MessagePack message, like
+// encoding/json does for JSON, so a malicious message can't exhaust the stack.
+const maxMsgpackDepth = 10000
+
+var errMsgpackTruncated = errors.New("msgpack: unexpected end of data")
+
+// ContentType implements Codec interface.
+func (JSONCodec) ContentType() string {
+	return jsonContentType
+}
+
+// Marshal implements Codec interface.
+func (JSONCodec) Marshal(msg JSONRPCMessage) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+// Unmarshal implements Codec interface.
+func (JSONCodec) Unmarshal(data []byte, msg *JSONRPCMessage) error {
+	return json.Unmarshal(data, msg)
+}
+
+// ContentType implements Codec interface.
+func (MsgpackCodec) ContentType() string {
+	return msgpackContentType
+}
+
+// Marshal implements Codec interface.
+func (MsgpackCodec) Marshal(msg JSONRPCMessage) ([]byte, error) {
+	// The fields are written as the keys of a map, leaving out the ones JSON omits, so both encodings
+	// carry the same message. Like in JSON, an error response without an ID has a nil one.
+	hasID := msg.ID != "" || msg.Error != nil
+	n := 1
+	for _, present := range []bool{
+		hasID, msg.Method != "", len(msg.Params) > 0, len(msg.Result) > 0, msg.Error != nil,
+	} {
+		if present {
+			n++
+		}
+	}
+
+	var b bytes.Buffer
+	writeMsgpackHeader(&b, n, 0x80, 0xde, 0xdf)
+	writeMsgpackString(&b, "jsonrpc")
+	writeMsgpackString(&b, msg.JSONRPC)
+	if hasID {
+		writeMsgpackString(&b, "id")
+		if msg.ID != "" {
+			writeMsgpackString(&b, string(msg.ID))
+		} else {
+			b.WriteByte(0xc0)
+		}
+	}
+	if msg.Method != "" {
+		writeMsgpackString(&b, "method")
+		writeMsgpackString(&b, msg.Method)
+	}
+	for _, field := range []struct {
+		key   string
+		value json.RawMessage
+	}{{"params", msg.Params}, {"result", msg.Result}} {
+		if len(field.value) == 0 {
+			continue
+		}
+		writeMsgpackString(&b, field.key)
+		if err := writeMsgpackJSON(&b, field.value); err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", field.key, err)
+		}
+	}
+	if msg.Error != nil {
+		// Errors are small and rare, so they're transcoded from their JSON encoding, which takes care
+		// of their unstructured data.
+		data, err := json.Marshal(msg.Error)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode error: %w", err)
+		}
+		writeMsgpackString(&b, "error")
+		if err := writeMsgpackJSON(&b, data); err != nil {
+			return nil, fmt.Errorf("failed to encode error: %w", err)
+		}
+	}
+
+	return b.Bytes(), nil
+}
+
+// Unmarshal implements Codec interface.
+func (MsgpackCodec) Unmarshal(data []byte, msg *JSONRPCMessage) error {
+	r := msgpackReader{data: data}
+	n, err := r.readMapLength()
+	if err != nil {
+		return err
+	}
+
+	var m JSONRPCMessage
+	for range n {
+		key, err := r.readString()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "jsonrpc":
+			m.JSONRPC, err = r.readString()
+		case "method":
+			m.Method, err = r.readString()
+		case "params":
+			m.Params, err = r.readJSON()
+		case "result":
+			m.Result, err = r.readJSON()
+		case "id":
+			// The ID is decoded as it is from JSON, as a string or a number.
+			var id json.RawMessage
+			if id, err = r.readJSON(); err == nil {
+				err = m.ID.UnmarshalJSON(id)
+			}
+		case "error":
+			var data json.RawMessage
+			if data, err = r.readJSON(); err == nil {
+				err = json.Unmarshal(data, &m.Error)
+			}
+		default:
+			// Unknown fields are skipped, as they are in JSON.
+			_, err = r.readJSON()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", key, err)
+		}
+	}
+	if r.pos != len(r.data) {
+		return fmt.Errorf("msgpack: %d bytes after top-level value", len(r.data)-r.pos)
+	}
+
+	*msg = m
+	return nil
+}
+
+// isJSONCodec reports whether codec encodes messages as JSON, which is sent as is in the data of SSE
+// events. The messages of other codecs may be binary, and are sent encoded in base64.
+func isJSONCodec(codec Codec) bool {
+	return codec.ContentType() == jsonContentType
+}
+
+// encodeEventData encodes the message with codec as the data of an SSE event.
+func encodeEventData(codec Codec, msg JSONRPCMessage) ([]byte, error) {
+	data, err := codec.Marshal(msg)
+	if err != nil || isJSONCodec(codec) {
+		return data, err
+	}
+	return base64.StdEncoding.AppendEncode(nil, data), nil
+}
+
+// decodeEventData decodes the message from the data of an SSE event encoded with codec.
+func decodeEventData(codec Codec, data string, msg *JSONRPCMessage) error {
+	if isJSONCodec(codec) {
+		return codec.Unmarshal([]byte(data), msg)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode base64 event data: %w", err)
+	}
+	return codec.Unmarshal(decoded, msg)
+}
+
+// contentMediaType returns the media type of the value of a Content-Type header, which is JSON if the
+// header is missing.
+func contentMediaType(contentType string) string {
+	if contentType == "" {
+		return jsonContentType
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return mediaType
+}
+
+// findCodec returns the codec of codecs whose content type is the media type of contentType, or
+// false if there's none.
+func findCodec(codecs []Codec, contentType string) (Codec, bool) {
+	if contentType == "" {
+		return nil, false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	for _, codec := range codecs {
+		if codec.ContentType() == mediaType {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// writeMsgpackJSON transcodes the JSON value in data to MessagePack, written to b.
+func writeMsgpackJSON(b *bytes.Buffer, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if err := writeMsgpackValue(dec, b); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid character after top-level value")
+	}
+	return nil
+}
+
+// writeMsgpackValue transcodes the next JSON value of dec to MessagePack, written to b. The elements
+// of arrays and objects are transcoded to a buffer of their own first, as MessagePack prefixes them
+// with their count.
+func writeMsgpackValue(dec *json.Decoder, b *bytes.Buffer) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		var elems bytes.Buffer
+		n := 0
+		for ; dec.More(); n++ {
+			if tok == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				keyStr, _ := key.(string)
+				writeMsgpackString(&elems, keyStr)
+			}
+			if err := writeMsgpackValue(dec, &elems); err != nil {
+				return err
+			}
+		}
+		// The closing delimiter.
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		if tok == '{' {
+			writeMsgpackHeader(b, n, 0x80, 0xde, 0xdf)
+		} else {
+			writeMsgpackHeader(b, n, 0x90, 0xdc, 0xdd)
+		}
+		b.Write(elems.Bytes())
+	case string:
+		writeMsgpackString(b, tok)
+	case json.Number:
+		return writeMsgpackNumber(b, tok)
+	case bool:
+		if tok {
+			b.WriteByte(0xc3)
+		} else {
+			b.WriteByte(0xc2)
+		}
+	case nil:
+		b.WriteByte(0xc0)
+	}
+
+	return nil
+}
+
+// writeMsgpackHeader writes the header of an array or a map of n elements, with the fix, 16-bit and
+// 32-bit formats given.
+func writeMsgpackHeader(b *bytes.Buffer, n int, fix, format16, format32 byte) {
+	switch {
+	case n <= 15:
+		b.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(format16)
+		b.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		b.WriteByte(format32)
+		b.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+func writeMsgpackString(b *bytes.Buffer, s string) {
+	switch n := len(s); {
+	case n <= 31:
+		b.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		b.WriteByte(0xd9)
+		b.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(0xda)
+		b.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		b.WriteByte(0xdb)
+		b.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+	b.WriteString(s)
+}
+
+func writeMsgpackNumber(b *bytes.Buffer, num json.Number) error {
+	if i, err := strconv.ParseInt(num.String(), 10, 64); err == nil {
+		writeMsgpackInt(b, i)
+		return nil
+	}
+	if u, err := strconv.ParseUint(num.String(), 10, 64); err == nil {
+		writeMsgpackUint(b, u)
+		return nil
+	}
+	f, err := strconv.ParseFloat(num.String(), 64)
+	if err != nil {
+		return fmt.Errorf("msgpack: number %s out of range", num)
+	}
+	b.WriteByte(0xcb)
+	b.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	return nil
+}
+
+func writeMsgpackInt(b *bytes.Buffer, i int64) {
+	if i >= 0 {
+		writeMsgpackUint(b, uint64(i))
+		return
+	}
+
+	switch {
+	case i >= -32:
+		b.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		b.WriteByte(0xd0)
+		b.WriteByte(byte(i))
+	case i >= math.MinInt16:
+		b.WriteByte(0xd1)
+		b.Write(binary.BigEndian.AppendUint16(nil, uint16(i)))
+	case i >= math.MinInt32:
+		b.WriteByte(0xd2)
+		b.Write(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	default:
+		b.WriteByte(0xd3)
+		b.Write(binary.BigEndian.AppendUint64(nil, uint64(i)))
+	}
+}
+
+func writeMsgpackUint(b *bytes.Buffer, u uint64) {
+	switch {
+	case u <= math.MaxInt8:
+		b.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		b.WriteByte(0xcc)
+		b.WriteByte(byte(u))
+	case u <= math.MaxUint16:
+		b.WriteByte(0xcd)
+		b.Write(binary.BigEndian.AppendUint16(nil, uint16(u)))
+	case u <= math.MaxUint32:
+		b.WriteByte(0xce)
+		b.Write(binary.BigEndian.AppendUint32(nil, uint32(u)))
+	default:
+		b.WriteByte(0xcf)
+		b.Write(binary.BigEndian.AppendUint64(nil, u))
+	}
+}
+
+// msgpackReader transcodes MessagePack values to JSON.
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+// readJSON transcodes the next MessagePack value to JSON. Binary values are transcoded to base64
+// strings, as JSON has no binary type, and extension values are rejected.
+func (r *msgpackReader) readJSON() (json.RawMessage, error) {
+	var b bytes.Buffer
+	if err := r.writeJSON(&b, 1); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// readMapLength reads the header of a map, returning its number of entries.
+func (r *msgpackReader) readMapLength() (int, error) {
+	format, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	switch c := format[0]; {
+	case c&0xf0 == 0x80:
+		return int(c & 0x0f), nil
+	case c == 0xde, c == 0xdf:
+		return r.length(c - 0xde + 1)
+	default:
+		return 0, fmt.Errorf("msgpack: expected a map, got format 0x%02x", c)
+	}
+}
+
+// readString reads a string value.
+func (r *msgpackReader) readString() (string, error) {
+	format, err := r.next(1)
+	if err != nil {
+		return "", err
+	}
+	n, err := r.stringLength(format[0])
+	if err != nil {
+		return "", err
+	}
+	s, err := r.next(n)
+	return string(s), err
+}
+
+func (r *msgpackReader) writeJSON(b *bytes.Buffer, depth int) error {
+	if depth > maxMsgpackDepth {
+		return fmt.Errorf("msgpack: exceeded max depth of %d", maxMsgpackDepth)
+	}
+	format, err := r.next(1)
+	if err != nil {
+		return err
+	}
+
+	switch c := format[0]; {
+	case c <= 0x7f:
+		b.WriteString(strconv.Itoa(int(c)))
+	case c >= 0xe0:
+		b.WriteString(strconv.Itoa(int(int8(c))))
+	case c&0xf0 == 0x80:
+		return r.writeMap(b, int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return r.writeArray(b, int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return r.writeString(b, int(c&0x1f))
+	case c == 0xc0:
+		b.WriteString("null")
+	case c == 0xc2:
+		b.WriteString("false")
+	case c == 0xc3:
+		b.WriteString("true")
+	case c == 0xc4, c == 0xc5, c == 0xc6:
+		n, err := r.length(c - 0xc4)
+		if err != nil {
+			return err
+		}
+		bin, err := r.next(n)
+		if err != nil {
+			return err
+		}
+		return writeJSONValue(b, bin)
+	case c == 0xca:
+		bits, err := r.next(4)
+		if err != nil {
+			return err
+		}
+		return writeJSONValue(b, float64(math.Float32frombits(binary.BigEndian.Uint32(bits))))
+	case c == 0xcb:
+		bits, err := r.next(8)
+		if err != nil {
+			return err
+		}
+		return writeJSONValue(b, math.Float64frombits(binary.BigEndian.Uint64(bits)))
+	case c >= 0xcc && c <= 0xcf:
+		n, err := r.uint(1 << (c - 0xcc))
+		if err != nil {
+			return err
+		}
+		b.WriteString(strconv.FormatUint(n, 10))
+	case c >= 0xd0 && c <= 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := r.uint(size)
+		if err != nil {
+			return err
+		}
+		// Sign-extend the integer from its size.
+		shift := 64 - 8*size
+		b.WriteString(strconv.FormatInt(int64(n<<shift)>>shift, 10))
+	case c >= 0xd9 && c <= 0xdb:
+		n, err := r.length(c - 0xd9)
+		if err != nil {
+			return err
+		}
+		return r.writeString(b, n)
+	case c == 0xdc, c == 0xdd:
+		n, err := r.length(c - 0xdc + 1)
+		if err != nil {
+			return err
+		}
+		return r.writeArray(b, n, depth)
+	case c == 0xde, c == 0xdf:
+		n, err := r.length(c - 0xde + 1)
+		if err != nil {
+			return err
+		}
+		return r.writeMap(b, n, depth)
+	default:
+		return fmt.Errorf("msgpack: unsupported format 0x%02x", c)
+	}
+
+	return nil
+}
+
+func (r *msgpackReader) writeArray(b *bytes.Buffer, n, depth int) error {
+	// Every element takes a byte at least, which bounds the elements of a truncated array.
+	if n > len(r.data)-r.pos {
+		return errMsgpackTruncated
+	}
+	b.WriteByte('[')
+	for i := range n {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if err := r.writeJSON(b, depth+1); err != nil {
+			return err
+		}
+	}
+	b.WriteByte(']')
+	return nil
+}
+
+func (r *msgpackReader) writeMap(b *bytes.Buffer, n, depth int) error {
+	if 2*n > len(r.data)-r.pos {
+		return errMsgpackTruncated
+	}
+	b.WriteByte('{')
+	for i := range n {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if err := r.writeKey(b); err != nil {
+			return err
+		}
+		b.WriteByte(':')
+		if err := r.writeJSON(b, depth+1); err != nil {
+			return err
+		}
+	}
+	b.WriteByte('}')
+	return nil
+}
+
+// writeKey writes the key of a map entry, which must be a string, as JSON objects only have string
+// keys.
+func (r *msgpackReader) writeKey(b *bytes.Buffer) error {
+	format, err := r.next(1)
+	if err != nil {
+		return err
+	}
+	n, err := r.stringLength(format[0])
+	if err != nil {
+		return err
+	}
+	return r.writeString(b, n)
+}
+
+// stringLength returns the length of the string whose header starts with the given format.
+func (r *msgpackReader) stringLength(c byte) (int, error) {
+	switch {
+	case c&0xe0 == 0xa0:
+		return int(c & 0x1f), nil
+	case c >= 0xd9 && c <= 0xdb:
+		return r.length(c - 0xd9)
+	default:
+		return 0, fmt.Errorf("msgpack: expected a string, got format 0x%02x", c)
+	}
+}
+
+func (r *msgpackReader) writeString(b *bytes.Buffer, n int) error {
+	s, err := r.next(n)
+	if err != nil {
+		return err
+	}
+	return writeJSONValue(b, string(s))
+}
+
+// length reads a length of 1, 2 or 4 bytes, for the given exponent of 2.
+func (r *msgpackReader) length(exp byte) (int, error) {
+	n, err := r.uint(1 << exp)
+	return int(n), err
+}
+
+// uint reads a big-endian unsigned integer of the given size in bytes.
+func (r *msgpackReader) uint(size int) (uint64, error) {
+	bs, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range bs {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data)-r.pos {
+		return nil, errMsgpackTruncated
+	}
+	bs := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return bs, nil
+}
+
+func writeJSONValue(b *bytes.Buffer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("msgpack: %w", err)
+	}
+	b.Write(data)
+	return nil
+}
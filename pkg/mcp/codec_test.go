@@ -0,0 +1,257 @@
+package mcp_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+	"github.com/tmaxmax/go-sse"
+)
+
+func TestMsgpackCodec(t *testing.T) {
+	codec := mcp.MsgpackCodec{}
+
+	data, err := codec.Marshal(mcp.JSONRPCMessage{JSONRPC: mcp.JSONRPCVersion, Method: "ping"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []byte("\x82\xa7jsonrpc\xa32.0\xa6method\xa4ping")
+	if !bytes.Equal(data, want) {
+		t.Errorf("expected %x, got %x", want, data)
+	}
+
+	// The raw params pass through unchanged, keys in order and integers exact.
+	params := `{"z":[1,-2,300,-40000,3.5,"text",null,true,false],"a":{"big":9007199254740993,` +
+		`"huge":18446744073709551615,"s":"` + string(bytes.Repeat([]byte("x"), 300)) + `"},"empty":{}}`
+	msg := mcp.JSONRPCMessage{
+		JSONRPC: mcp.JSONRPCVersion,
+		ID:      "1",
+		Method:  mcp.MethodToolsCall,
+		Params:  json.RawMessage(params),
+	}
+	data, err = codec.Marshal(msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got mcp.JSONRPCMessage
+	if err := codec.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ID != msg.ID || got.Method != msg.Method || string(got.Params) != params {
+		t.Errorf("expected %+v, got %+v", msg, got)
+	}
+
+	errMsg := mcp.JSONRPCMessage{
+		JSONRPC: mcp.JSONRPCVersion,
+		ID:      "2",
+		Error:   &mcp.JSONRPCError{Code: -32602, Message: "Invalid params", Data: map[string]any{"field": "name"}},
+	}
+	data, err = codec.Marshal(errMsg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got = mcp.JSONRPCMessage{}
+	if err := codec.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ID != "2" || got.Error == nil || got.Error.Code != -32602 || got.Error.Data["field"] != "name" {
+		t.Errorf("expected %+v, got %+v", errMsg, got)
+	}
+
+	// An error response without an ID has a nil one, as in JSON.
+	data, err = codec.Marshal(mcp.JSONRPCMessage{
+		JSONRPC: mcp.JSONRPCVersion,
+		Error:   &mcp.JSONRPCError{Code: -32700, Message: "Parse error"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Contains(data, []byte("\xa2id\xc0")) {
+		t.Errorf("expected a nil ID, got %x", data)
+	}
+
+	// Unknown fields are skipped, and numeric IDs are read as strings.
+	got = mcp.JSONRPCMessage{}
+	if err := codec.Unmarshal([]byte("\x83\xa7jsonrpc\xa32.0\xa5extra\x91\x01\xa2id\x07"), &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.JSONRPC != mcp.JSONRPCVersion || got.ID != "7" {
+		t.Errorf("expected message with ID 7, got %+v", got)
+	}
+
+	for name, data := range map[string][]byte{
+		"truncated":  []byte("\x82\xa7jsonrpc\xa32.0\xa6method"),
+		"trailing":   []byte("\x80\x80"),
+		"int key":    []byte("\x81\x01\x02"),
+		"not a map":  []byte("\x91\x01"),
+		"bool id":    []byte("\x81\xa2id\xc3"),
+		"extension":  []byte("\xd4\x01\x02"),
+		"long array": []byte("\xdd\xff\xff\xff\xff"),
+	} {
+		if err := codec.Unmarshal(data, &got); err == nil {
+			t.Errorf("expected error for %s message", name)
+		}
+	}
+}
+
+func TestSSECodecNegotiation(t *testing.T) {
+	testCases := []struct {
+		name        string
+		serverOpts  []mcp.SSEServerOption
+		contentType string
+	}{
+		{name: "supported", serverOpts: []mcp.SSEServerOption{mcp.WithSSECodecs(mcp.MsgpackCodec{})},
+			contentType: "application/msgpack"},
+		{name: "unsupported", contentType: "application/json"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := mcp.NewSSEServer(tc.serverOpts...)
+
+			var contentTypesLock sync.Mutex
+			contentTypes := make(map[string]bool)
+
+			mux := http.NewServeMux()
+			httpSrv := httptest.NewServer(mux)
+			defer httpSrv.Close()
+
+			mux.Handle("/sse", srv.HandleSSE(httpSrv.URL+"/message"))
+			handleMessage := srv.HandleMessage()
+			mux.HandleFunc("/message", func(w http.ResponseWriter, r *http.Request) {
+				contentTypesLock.Lock()
+				contentTypes[r.Header.Get("Content-Type")] = true
+				contentTypesLock.Unlock()
+				handleMessage.ServeHTTP(w, r)
+			})
+
+			registry := mcp.NewToolRegistry()
+			err := registry.Add(mcp.Tool{Name: "echo"},
+				func(_ context.Context, params mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+					args, err := json.Marshal(params.Arguments)
+					return mcp.CallToolResult{StructuredContent: args}, err
+				})
+			if err != nil {
+				t.Fatalf("failed to add tool: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go mcp.Serve(ctx, mockServer{}, srv, make(chan error), mcp.WithToolServer(registry))
+
+			clientTransport := mcp.NewSSEClient(fmt.Sprintf("%s/sse", httpSrv.URL), httpSrv.Client(),
+				mcp.WithSSEClientCodec(mcp.MsgpackCodec{}))
+			cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+				ToolServer: true,
+			})
+			defer cli.Close()
+
+			if err := cli.Connect(); err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+
+			result, err := cli.CallTool(ctx, mcp.CallToolParams{
+				Name:      "echo",
+				Arguments: map[string]any{"text": "hello", "count": 3},
+			})
+			if err != nil {
+				t.Fatalf("failed to call tool: %v", err)
+			}
+			if want := `{"count":3,"text":"hello"}`; string(result.StructuredContent) != want {
+				t.Errorf("expected structured content %s, got %s", want, result.StructuredContent)
+			}
+
+			contentTypesLock.Lock()
+			defer contentTypesLock.Unlock()
+			if len(contentTypes) != 1 || !contentTypes[tc.contentType] {
+				t.Errorf("expected messages posted as %s, got %v", tc.contentType, contentTypes)
+			}
+		})
+	}
+}
+
+func TestSSEServerCodecContentType(t *testing.T) {
+	srv := mcp.NewSSEServer(mcp.WithSSECodecs(mcp.MsgpackCodec{}))
+
+	mux := http.NewServeMux()
+	httpSrv := httptest.NewServer(mux)
+	defer httpSrv.Close()
+
+	mux.Handle("/sse", srv.HandleSSE(httpSrv.URL+"/message"))
+	mux.Handle("/message", srv.HandleMessage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpSrv.URL+"/sse", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Mcp-Codec", "application/msgpack")
+	resp, err := httpSrv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	codec := mcp.MsgpackCodec{}
+	for ev, err := range sse.Read(resp.Body, nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+
+		switch ev.Type {
+		case "endpoint":
+			// The session negotiated MessagePack, so JSON bodies are rejected.
+			jsonResp, err := httpSrv.Client().Post(ev.Data, "application/json",
+				strings.NewReader(`{"jsonrpc":"2.0","id":"1","method":"ping"}`))
+			if err != nil {
+				t.Fatalf("failed to send ping: %v", err)
+			}
+			jsonResp.Body.Close()
+			if jsonResp.StatusCode != http.StatusUnsupportedMediaType {
+				t.Errorf("expected status %d, got %d", http.StatusUnsupportedMediaType, jsonResp.StatusCode)
+			}
+
+			ping, err := codec.Marshal(mcp.JSONRPCMessage{JSONRPC: mcp.JSONRPCVersion, ID: "1", Method: "ping"})
+			if err != nil {
+				t.Fatalf("failed to marshal ping: %v", err)
+			}
+			pingResp, err := httpSrv.Client().Post(ev.Data, "application/msgpack", bytes.NewReader(ping))
+			if err != nil {
+				t.Fatalf("failed to send ping: %v", err)
+			}
+			pingResp.Body.Close()
+			if pingResp.StatusCode != http.StatusOK {
+				t.Errorf("expected status %d, got %d", http.StatusOK, pingResp.StatusCode)
+			}
+		case "message":
+			data, err := base64.StdEncoding.DecodeString(ev.Data)
+			if err != nil {
+				t.Fatalf("failed to decode event data: %v", err)
+			}
+			var msg mcp.JSONRPCMessage
+			if err := codec.Unmarshal(data, &msg); err != nil {
+				t.Fatalf("failed to unmarshal message: %v", err)
+			}
+			if msg.ID != "1" || msg.Error != nil {
+				t.Errorf("expected ping response with ID 1, got %+v", msg)
+			}
+			return
+		}
+	}
+
+	t.Fatal("event stream ended before the ping response")
+}
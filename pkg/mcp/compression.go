@@ -0,0 +1,127 @@
+package mcp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// compressedResult is the result of a resources/read request compressed with gzip, sent in place of
+// the ReadResourceResult once both peers negotiated compression, see WithResourceCompression and
+// WithClientResourceCompression. The encoding is named in the _meta field, so the result stays a
+// plain JSON object, and Data holds the compressed JSON of the original result.
+type compressedResult struct {
+	Meta compressedResultMeta `json:"_meta"`
+	Data []byte               `json:"data"`
+}
+
+type compressedResultMeta struct {
+	Compression string `json:"compression"`
+}
+
+const compressionGzip = "gzip"
+
+// compressedResultPrefix starts every compressedResult, as marshaled by the server, so the client
+// spots them without unmarshaling every result it receives.
+var compressedResultPrefix = []byte(`{"_meta":{"compression":`)
+
+// compressResult compresses the marshaled result of the client request with the given ID, if it's a
+// resources/read request, the client negotiated compression, and the result is large enough to be
+// worth it. The result is returned as is otherwise, or if compressing it doesn't make it smaller.
+func (s *session) compressResult(id MustString, result []byte) []byte {
+	if !s.resourceCompression || len(result) < s.resourceCompressionMinSize {
+		return result
+	}
+	if _, info, ok := s.lookupRequest(id); !ok || info.method != MethodResourcesRead {
+		return result
+	}
+	s.initLock.RLock()
+	_, negotiated := s.clientCapabilities.Experimental[experimentalResourceCompression]
+	s.initLock.RUnlock()
+	if !negotiated {
+		return result
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(result); err != nil {
+		s.logRequestError(id, fmt.Errorf("failed to compress result: %w", err))
+		return result
+	}
+	if err := zw.Close(); err != nil {
+		s.logRequestError(id, fmt.Errorf("failed to compress result: %w", err))
+		return result
+	}
+
+	compressed, err := json.Marshal(compressedResult{
+		Meta: compressedResultMeta{Compression: compressionGzip},
+		Data: buf.Bytes(),
+	})
+	if err != nil {
+		s.logRequestError(id, fmt.Errorf("failed to marshal compressed result: %w", err))
+		return result
+	}
+	if len(compressed) >= len(result) {
+		return result
+	}
+
+	return compressed
+}
+
+// decompressResult restores the result of msg, the response to a resources/read request, if the
+// server compressed it. The results of other requests are never compressed, so they're left alone. A
+// result that can't be decompressed is turned into an error response, so the request fails instead
+// of returning garbage.
+func (c *Client) decompressResult(msg JSONRPCMessage) JSONRPCMessage {
+	if !c.resourceCompression || !bytes.HasPrefix(msg.Result, compressedResultPrefix) {
+		return msg
+	}
+
+	result, err := gunzipResult(msg.Result)
+	if err != nil {
+		nErr := fmt.Errorf("failed to decompress result of request %q: %w", msg.ID, err)
+		c.logError(nErr)
+		msg.Result = nil
+		msg.Error = &JSONRPCError{
+			Code:    jsonRPCInternalErrorCode,
+			Message: errMsgInternalError,
+			Data:    map[string]any{"error": nErr},
+		}
+		return msg
+	}
+	msg.Result = result
+
+	return msg
+}
+
+// gunzipResult returns the original result held by a compressedResult. The decompressed result is
+// capped at maxDecompressedMessageSize, so a small compressed result can't exhaust the memory of the
+// client.
+func gunzipResult(result json.RawMessage) (json.RawMessage, error) {
+	var compressed compressedResult
+	if err := json.Unmarshal(result, &compressed); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal compressed result: %w", err)
+	}
+	if compressed.Meta.Compression != compressionGzip {
+		return nil, fmt.Errorf("unsupported compression %q", compressed.Meta.Compression)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compressed result: %w", err)
+	}
+	r := &limitedReadCloser{reader: zr, limit: maxDecompressedMessageSize}
+	defer r.Close()
+
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compressed result: %w", err)
+	}
+	if !json.Valid(decompressed) {
+		return nil, fmt.Errorf("decompressed result isn't valid JSON")
+	}
+
+	return decompressed, nil
+}
@@ -0,0 +1,198 @@
+package mcp_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+
+	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+)
+
+type countingWriter struct {
+	writer io.Writer
+	count  *atomic.Int64
+}
+
+func TestResourceCompression(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog.\n", 1000)
+	blob := bytes.Repeat([]byte{0, 1, 2, 3, 255}, 4000)
+	fsys := fstest.MapFS{
+		"big.txt":  {Data: []byte(text)},
+		"big.bin":  {Data: blob},
+		"tiny.txt": {Data: []byte("tiny")},
+	}
+
+	tests := []struct {
+		name           string
+		clientOptions  []mcp.ClientOption
+		wantCompressed bool
+	}{
+		{
+			name:           "negotiated",
+			clientOptions:  []mcp.ClientOption{mcp.WithClientResourceCompression()},
+			wantCompressed: true,
+		},
+		{
+			name: "client without compression",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Count the bytes the server writes, to tell whether the results were compressed.
+			var written atomic.Int64
+			srvReader, srvWriter := io.Pipe()
+			cliReader, cliWriter := io.Pipe()
+			clientTransport := mcp.NewStdIO(cliReader, srvWriter)
+			serverTransport := mcp.NewStdIO(srvReader, countingWriter{writer: cliWriter, count: &written})
+			go serverTransport.Start()
+			go clientTransport.Start()
+
+			go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error),
+				mcp.WithResourceServer(mcp.NewFSResourceServer(fsys)), mcp.WithResourceCompression(1024))
+
+			cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+				ResourceServer: true,
+			}, test.clientOptions...)
+			defer cli.Close()
+
+			if err := cli.Connect(); err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+			if _, ok := cli.ServerCapabilities().Experimental["resourceCompression"]; !ok {
+				t.Error("expected the server to advertise resource compression")
+			}
+
+			before := written.Load()
+			result, err := cli.ReadResource(ctx, mcp.ReadResourceParams{URI: "file:///big.txt"})
+			if err != nil {
+				t.Fatalf("failed to read resource: %v", err)
+			}
+			if len(result.Contents) != 1 || result.Contents[0].Text != text {
+				t.Errorf("expected the text to round-trip intact, got %d contents", len(result.Contents))
+			}
+			if compressed := written.Load()-before < int64(len(text)); compressed != test.wantCompressed {
+				t.Errorf("expected compressed %t, the server wrote %d bytes for %d bytes of text",
+					test.wantCompressed, written.Load()-before, len(text))
+			}
+
+			var content bytes.Buffer
+			if _, err := cli.ReadResourceStream(ctx, mcp.ReadResourceParams{URI: "file:///big.bin"}, &content); err != nil {
+				t.Fatalf("failed to read resource: %v", err)
+			}
+			if !bytes.Equal(content.Bytes(), blob) {
+				t.Errorf("expected the blob to round-trip intact, got %d bytes", content.Len())
+			}
+
+			result, err = cli.ReadResource(ctx, mcp.ReadResourceParams{URI: "file:///tiny.txt"})
+			if err != nil {
+				t.Fatalf("failed to read resource: %v", err)
+			}
+			if len(result.Contents) != 1 || result.Contents[0].Text != "tiny" {
+				t.Errorf("expected the text below the minimum size to be read, got %+v", result)
+			}
+		})
+	}
+}
+
+func TestClientResourceCompressionLimits(t *testing.T) {
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			t.Fatalf("failed to compress: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("failed to compress: %v", err)
+		}
+		return buf.Bytes()
+	}
+	compressed := func(data []byte) json.RawMessage {
+		bs, err := json.Marshal(map[string]any{
+			"_meta": map[string]any{"compression": "gzip"},
+			"data":  gzipped(data),
+		})
+		if err != nil {
+			t.Fatalf("failed to marshal result: %v", err)
+		}
+		return bs
+	}
+
+	// Expands to 11 MiB, past the cap on decompressed results.
+	bomb := compressed(slices.Concat([]byte(`{"contents":[{"uri":"test://bomb","text":"`),
+		bytes.Repeat([]byte("a"), 11<<20), []byte(`"}]}`)))
+	// A tool list that looks like a compressed result, which the client mustn't decompress.
+	tools := compressed([]byte(`{"tools":[{"name":"hidden","inputSchema":{"type":"object"}}]}`))
+
+	srvReader, cliWriter := io.Pipe()
+	cliReader, srvWriter := io.Pipe()
+	defer srvWriter.Close()
+
+	clientTransport := mcp.NewStdIO(cliReader, cliWriter)
+	go clientTransport.Start()
+
+	go func() {
+		scanner := bufio.NewScanner(srvReader)
+		encoder := json.NewEncoder(srvWriter)
+		for scanner.Scan() {
+			var msg mcp.JSONRPCMessage
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || msg.ID == "" {
+				continue
+			}
+			res := mcp.JSONRPCMessage{JSONRPC: mcp.JSONRPCVersion, ID: msg.ID}
+			switch msg.Method {
+			case "initialize":
+				res.Result = json.RawMessage(`{"protocolVersion":"2024-11-05","capabilities":{` +
+					`"resources":{},"tools":{},"experimental":{"resourceCompression":{}}},` +
+					`"serverInfo":{"name":"malicious-server","version":"1.0"}}`)
+			case mcp.MethodResourcesRead:
+				res.Result = bomb
+			case mcp.MethodToolsList:
+				res.Result = tools
+			}
+			if err := encoder.Encode(res); err != nil {
+				return
+			}
+		}
+	}()
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ResourceServer: true,
+		ToolServer:     true,
+	}, mcp.WithClientResourceCompression())
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := cli.ReadResource(ctx, mcp.ReadResourceParams{URI: "test://bomb"}); err == nil {
+		t.Error("expected the read of a result decompressing past the cap to fail")
+	}
+
+	result, err := cli.ListTools(ctx, mcp.ListToolsParams{})
+	if err != nil {
+		t.Fatalf("failed to list tools: %v", err)
+	}
+	if len(result.Tools) != 0 {
+		t.Errorf("expected the result of tools/list not to be decompressed, got tools %+v", result.Tools)
+	}
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count.Add(int64(n))
+	return n, err
+}
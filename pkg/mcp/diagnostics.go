@@ -0,0 +1,63 @@
+package mcp
+
+import (
+	"maps"
+	"time"
+)
+
+// Diagnostics is a snapshot of what a server supports and how it's configured, as returned by
+// ServerDiagnostics, for debug endpoints and startup logs. It encodes to JSON, with the durations in
+// nanoseconds, as time.Duration does.
+type Diagnostics struct {
+	// Info is the implementation info of the server.
+	Info Info `json:"info"`
+
+	// Capabilities are the capabilities the server advertises to clients: which of prompts,
+	// resources, tools and logging are enabled, with their list changed and subscribe flags, and the
+	// experimental capabilities.
+	Capabilities ServerCapabilities `json:"capabilities"`
+
+	// Completion reports whether the server answers completion requests, which it does for the
+	// arguments of prompts and resource templates once it has a PromptServer or a ResourceServer.
+	Completion bool `json:"completion"`
+
+	// RequiredClientCapabilities are the capabilities clients must have to connect to the server.
+	RequiredClientCapabilities ClientCapabilities `json:"requiredClientCapabilities"`
+
+	// WriteTimeout, ReadTimeout and PingInterval are the timeouts and interval set with
+	// WithServerWriteTimeout, WithServerReadTimeout and WithServerPingInterval, or their defaults.
+	// A zero PingInterval means the server doesn't ping clients.
+	WriteTimeout time.Duration `json:"writeTimeout"`
+	ReadTimeout  time.Duration `json:"readTimeout"`
+	PingInterval time.Duration `json:"pingInterval"`
+
+	// MethodTimeouts are the timeouts set per method with WithMethodTimeout, and
+	// DefaultMethodTimeout the one set with WithDefaultMethodTimeout for the other methods, zero if
+	// they have none.
+	MethodTimeouts       map[string]time.Duration `json:"methodTimeouts,omitempty"`
+	DefaultMethodTimeout time.Duration            `json:"defaultMethodTimeout"`
+}
+
+// ServerDiagnostics returns the Diagnostics of the server that Serve runs with srv and the given
+// options, so servers can report what they support, for example in their startup logs or on a debug
+// endpoint. Like ValidateServer, it only reads the options, so it can be called before or alongside
+// Serve, and concurrently: every call returns a snapshot that shares no state with the others.
+func ServerDiagnostics(srv Server, options ...ServerOption) Diagnostics {
+	var s server
+	for _, opt := range options {
+		opt(&s)
+	}
+	s.setDefaults()
+
+	return Diagnostics{
+		Info:                       srv.Info(),
+		Capabilities:               s.serverCapabilities(),
+		Completion:                 s.promptServer != nil || s.resourceServer != nil,
+		RequiredClientCapabilities: s.clientRequirements(srv),
+		WriteTimeout:               s.writeTimeout,
+		ReadTimeout:                s.readTimeout,
+		PingInterval:               s.pingInterval,
+		MethodTimeouts:             maps.Clone(s.methodTimeouts),
+		DefaultMethodTimeout:       s.defaultMethodTimeout,
+	}
+}
@@ -0,0 +1,255 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// messageHandler handles a message of a client, once dispatched by its method. It validates the
+// params of the message, returning errInvalidJSON if they're malformed, and then hands the work over
+// to the session.
+type messageHandler func(s server, sess *session, msg JSONRPCMessage) error
+
+// dispatchMsg hands msg over to the handler of its method, with a single lookup in the handlers of the
+// server, or to the request waiting for it if it's a response. Messages of a method the server has no
+// handler for, such as the methods of a capability it doesn't have, are ignored.
+func (s server) dispatchMsg(sess *session, msg JSONRPCMessage) error {
+	// We musn't wait for the handlers to finish, as they might be blocking
+	// the client's request, and since these handlers might 'call' the client back,
+	// that would cause a deadlock. So, in each handler, once the params
+	// is proven to be valid, we launch a goroutine to continue the processing.
+
+	if msg.Method == "" {
+		go sess.handleResult(msg)
+		return nil
+	}
+
+	handler, ok := s.handlers[msg.Method]
+	if !ok {
+		return nil
+	}
+	return handler(s, sess, msg)
+}
+
+// messageHandlers returns the handlers of the methods the server handles with its options, keyed by
+// method.
+func (s server) messageHandlers() map[string]messageHandler {
+	handlers := make(map[string]messageHandler)
+
+	addBasicHandlers(handlers)
+	if s.promptServer != nil {
+		addPromptHandlers(handlers)
+	}
+	if s.resourceServer != nil {
+		addResourceHandlers(handlers)
+	}
+	if s.toolServer != nil {
+		addToolHandlers(handlers)
+	}
+	addCompletionHandlers(handlers)
+	addNotificationHandlers(handlers)
+	if s.logHandler != nil {
+		addLoggingHandlers(handlers)
+	}
+
+	return handlers
+}
+
+func addBasicHandlers(handlers map[string]messageHandler) {
+	handlers[methodPing] = func(_ server, sess *session, msg JSONRPCMessage) error {
+		meta, rawMeta := parsePingParams(msg.Params)
+		go sess.handlePing(msg.ID, meta, rawMeta)
+		return nil
+	}
+	handlers[methodInitialize] = func(s server, sess *session, msg JSONRPCMessage) error {
+		var params initializeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		// Each handshake gets its own snapshot of the capabilities, so the session never shares
+		// them with the other sessions, nor with the handlers it passes them to.
+		go sess.handleInitialize(msg.ID, params, s.capabilities.clone(),
+			s.requiredClientCapabilities, s.info)
+		return nil
+	}
+}
+
+func addPromptHandlers(handlers map[string]messageHandler) {
+	handlers[MethodPromptsList] = func(s server, sess *session, msg JSONRPCMessage) error {
+		var params ListPromptsParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		if params.Meta.ProgressToken != "" {
+			s.progresses.Store(params.Meta.ProgressToken, sess.id)
+		}
+		go sess.handlePromptsList(msg.ID, params, s.promptServer)
+		return nil
+	}
+	handlers[MethodPromptsGet] = func(s server, sess *session, msg JSONRPCMessage) error {
+		var params GetPromptParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		if params.Meta.ProgressToken != "" {
+			s.progresses.Store(params.Meta.ProgressToken, sess.id)
+		}
+		go sess.handlePromptsGet(msg.ID, params, s.promptServer)
+		return nil
+	}
+}
+
+func addResourceHandlers(handlers map[string]messageHandler) {
+	handlers[MethodResourcesList] = func(s server, sess *session, msg JSONRPCMessage) error {
+		var params ListResourcesParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		if params.Meta.ProgressToken != "" {
+			s.progresses.Store(params.Meta.ProgressToken, sess.id)
+		}
+		go sess.handleResourcesList(msg.ID, params, s.resourceServer)
+		return nil
+	}
+	handlers[MethodResourcesRead] = func(s server, sess *session, msg JSONRPCMessage) error {
+		var params ReadResourceParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		if params.Meta.ProgressToken != "" {
+			s.progresses.Store(params.Meta.ProgressToken, sess.id)
+		}
+		go sess.handleResourcesRead(msg.ID, params, s.resourceServer)
+		return nil
+	}
+	handlers[MethodResourcesTemplatesList] = func(s server, sess *session, msg JSONRPCMessage) error {
+		var params ListResourceTemplatesParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		if params.Meta.ProgressToken != "" {
+			s.progresses.Store(params.Meta.ProgressToken, sess.id)
+		}
+		go sess.handleResourcesListTemplates(msg.ID, params, s.resourceServer)
+		return nil
+	}
+	handlers[MethodResourcesSubscribe] = func(s server, sess *session, msg JSONRPCMessage) error {
+		var params SubscribeResourceParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		go sess.handleResourcesSubscribe(msg.ID, params, s.resourceServer)
+		return nil
+	}
+	handlers[MethodResourcesUnsubscribe] = func(s server, sess *session, msg JSONRPCMessage) error {
+		var params UnsubscribeResourceParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		go sess.handleResourcesUnsubscribe(msg.ID, params, s.resourceServer)
+		return nil
+	}
+	handlers[MethodResourcesSubscriptions] = func(_ server, sess *session, msg JSONRPCMessage) error {
+		go sess.handleResourcesSubscriptions(msg.ID)
+		return nil
+	}
+}
+
+func addToolHandlers(handlers map[string]messageHandler) {
+	handlers[MethodToolsList] = func(s server, sess *session, msg JSONRPCMessage) error {
+		var params ListToolsParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		if params.Meta.ProgressToken != "" {
+			s.progresses.Store(params.Meta.ProgressToken, sess.id)
+		}
+		go sess.handleToolsList(msg.ID, params, s.toolServer)
+		return nil
+	}
+	handlers[MethodToolsCall] = func(s server, sess *session, msg JSONRPCMessage) error {
+		if err := s.checkToolArgumentLimits(msg.Params); err != nil {
+			return fmt.Errorf("%w: %w", errInvalidJSON, err)
+		}
+		var params CallToolParams
+		unmarshal := json.Unmarshal
+		if s.jsonNumberArguments {
+			unmarshal = unmarshalWithNumbers
+		}
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		if params.Meta.ProgressToken != "" {
+			s.progresses.Store(params.Meta.ProgressToken, sess.id)
+		}
+		go sess.handleToolsCall(msg.ID, params, s.toolServer)
+		return nil
+	}
+}
+
+func addCompletionHandlers(handlers map[string]messageHandler) {
+	handlers[MethodCompletionComplete] = func(s server, sess *session, msg JSONRPCMessage) error {
+		var params CompletesCompletionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+
+		switch params.Ref.Type {
+		case CompletionRefPrompt:
+			go sess.handleCompletePrompt(msg.ID, params, s.promptServer)
+		case CompletionRefResource:
+			go sess.handleCompleteResource(msg.ID, params, s.resourceServer)
+		}
+		return nil
+	}
+}
+
+func addNotificationHandlers(handlers map[string]messageHandler) {
+	handlers[methodNotificationsInitialized] = func(_ server, sess *session, _ JSONRPCMessage) error {
+		// Marked right away, so the requests the client sends right after the notification aren't
+		// handled before it, and dropped as sent to a session that isn't initialized.
+		if sess.markInitialized() {
+			go sess.handleNotificationsInitialized()
+		}
+		return nil
+	}
+	handlers[methodNotificationsCancelled] = func(_ server, sess *session, msg JSONRPCMessage) error {
+		var params notificationsCancelledParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		go sess.handleNotificationsCancelled(params)
+		return nil
+	}
+	handlers[methodNotificationsProgress] = func(_ server, sess *session, msg JSONRPCMessage) error {
+		var params ProgressParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		// Handled right away, so the progress of a request sent to the client is queued in order, and
+		// before the response itself is handled.
+		sess.handleNotificationsProgress(params)
+		return nil
+	}
+	handlers[methodNotificationsRootsListChanged] = func(s server, sess *session, _ JSONRPCMessage) error {
+		if receiver, ok := s.rootsListWatcher.(RootsListReceiver); ok {
+			go sess.handleNotificationsRootsListChanged(receiver)
+			return nil
+		}
+		if s.rootsListWatcher != nil {
+			s.rootsListWatcher.OnRootsListChanged()
+		}
+		return nil
+	}
+}
+
+func addLoggingHandlers(handlers map[string]messageHandler) {
+	handlers[MethodLoggingSetLevel] = func(s server, sess *session, msg JSONRPCMessage) error {
+		var params LogParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return errInvalidJSON
+		}
+		go sess.handleLoggingSetLevel(msg.ID, params, s.logHandler)
+		return nil
+	}
+}
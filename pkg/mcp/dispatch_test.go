@@ -0,0 +1,62 @@
+package mcp
+
+import (
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+)
+
+// benchServer is a Server without requirements on its clients.
+type benchServer struct{}
+
+// BenchmarkDispatchMsg measures the cost of routing a message to its handler, with messages that are
+// turned down before any work is started: a notification without a handler, a method the server
+// doesn't know of, and a tools/call request with malformed params.
+func BenchmarkDispatchMsg(b *testing.B) {
+	s := newServer(benchServer{}, nil, make(chan error),
+		WithToolServer(NewToolRegistry()), WithResourceServer(NewFSResourceServer(fstest.MapFS{})))
+
+	benchmarks := []struct {
+		name string
+		msg  JSONRPCMessage
+	}{
+		{
+			name: "notification",
+			msg:  JSONRPCMessage{JSONRPC: JSONRPCVersion, Method: methodNotificationsRootsListChanged},
+		},
+		{
+			name: "unknown method",
+			msg:  JSONRPCMessage{JSONRPC: JSONRPCVersion, ID: "1", Method: "unknown/method"},
+		},
+		{
+			name: "tools/call",
+			msg: JSONRPCMessage{
+				JSONRPC: JSONRPCVersion,
+				ID:      "1",
+				Method:  MethodToolsCall,
+				Params:  json.RawMessage(`{`),
+			},
+		},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				_ = s.dispatchMsg(nil, bm.msg)
+			}
+		})
+	}
+}
+
+func (benchServer) Info() Info {
+	return Info{Name: "bench-server", Version: "1.0"}
+}
+
+func (benchServer) RequireRootsListClient() bool {
+	return false
+}
+
+func (benchServer) RequireSamplingClient() bool {
+	return false
+}
@@ -29,6 +29,7 @@
 //   - Thread-safe operations using sync.Map
 //   - CORS and custom HTTP client support
 //   - Channel-based message routing
+//   - Optional MessagePack encoding, negotiated with a JSON fallback
 //
 // # Server Components
 //
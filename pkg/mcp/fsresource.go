@@ -0,0 +1,243 @@
+package mcp
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// FSResourceOption represents the options for an FSResourceServer.
+type FSResourceOption func(*FSResourceServer)
+
+// FSResourceServer is a ResourceServer that serves the files of a file system as resources, so
+// servers exposing a directory don't need to implement file serving themselves. To serve a
+// subdirectory only, pass the result of fs.Sub.
+//
+// Every regular file is a resource, whose URI is the URI prefix followed by the slash-separated path
+// of the file in the file system, such as file:///docs/readme.md with the default prefix. Files are
+// listed in lexical order, and are also exposed through the {+path} template, whose path argument
+// can be completed.
+//
+// The MIME type of a file is guessed from its extension, and when that fails, sniffed from its
+// content on read, in which case it's not known when listing. Files whose MIME type is text (see
+// IsTextMime) and whose content is valid UTF-8 are read as Text, and the other files as Blob.
+//
+// FSResourceServer doesn't notify clients about changed files: subscriptions are accepted, but
+// ignored. It's safe for concurrent use if the file system is.
+type FSResourceServer struct {
+	fsys     fs.FS
+	prefix   string
+	ignore   []string
+	template ResourceTemplate
+}
+
+const (
+	defaultFSURIPrefix = "file:///"
+
+	// fsCompletionLimit is the maximum number of paths suggested when completing the path argument.
+	fsCompletionLimit = 100
+)
+
+var _ ResourceServer = (*FSResourceServer)(nil)
+
+// NewFSResourceServer creates an FSResourceServer serving the files of fsys.
+func NewFSResourceServer(fsys fs.FS, options ...FSResourceOption) *FSResourceServer {
+	s := &FSResourceServer{
+		fsys:   fsys,
+		prefix: defaultFSURIPrefix,
+	}
+	for _, opt := range options {
+		opt(s)
+	}
+
+	s.template = ResourceTemplate{
+		URITemplate: s.prefix + "{+path}",
+		Name:        "file",
+		Description: "A file, by its path",
+	}
+
+	return s
+}
+
+// WithFSURIPrefix sets the prefix of the URIs of the files, which is file:/// by default. The
+// prefix is used as is, so it usually ends with a slash.
+func WithFSURIPrefix(prefix string) FSResourceOption {
+	return func(s *FSResourceServer) {
+		s.prefix = prefix
+	}
+}
+
+// WithFSIgnore sets the path.Match patterns of the files that aren't served. A pattern matches
+// either the path of a file, or its base name, so "*.tmp" ignores all temporary files and
+// "build/*" the files directly in the build directory. A pattern matching a directory ignores the
+// whole directory, such as ".git".
+func WithFSIgnore(patterns ...string) FSResourceOption {
+	return func(s *FSResourceServer) {
+		s.ignore = append(s.ignore, patterns...)
+	}
+}
+
+// ListResources implements ResourceServer interface.
+func (s *FSResourceServer) ListResources(
+	ctx context.Context,
+	_ ListResourcesParams,
+	_ RequestClientFunc,
+) (ListResourcesResult, error) {
+	resources := []Resource{}
+	err := s.walk(ctx, func(name string, info fs.FileInfo) {
+		size := info.Size()
+		resources = append(resources, Resource{
+			URI:      s.prefix + name,
+			Name:     path.Base(name),
+			MimeType: mime.TypeByExtension(path.Ext(name)),
+			Size:     &size,
+		})
+	})
+	if err != nil {
+		return ListResourcesResult{}, err
+	}
+
+	return ListResourcesResult{Resources: resources}, nil
+}
+
+// ReadResource implements ResourceServer interface.
+func (s *FSResourceServer) ReadResource(
+	_ context.Context,
+	params ReadResourceParams,
+	_ RequestClientFunc,
+) (ReadResourceResult, error) {
+	name, ok := strings.CutPrefix(params.URI, s.prefix)
+	if !ok || !fs.ValidPath(name) || s.ignored(name) {
+		return ReadResourceResult{}, fmt.Errorf("resource not found: %s", params.URI)
+	}
+
+	data, err := fs.ReadFile(s.fsys, name)
+	if err != nil {
+		return ReadResourceResult{}, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	mimeType := mime.TypeByExtension(path.Ext(name))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+
+	resource := Resource{
+		URI:      params.URI,
+		Name:     path.Base(name),
+		MimeType: mimeType,
+	}
+	if IsTextMime(mimeType) && utf8.Valid(data) {
+		resource.Text = string(data)
+	} else {
+		resource.Blob = base64.StdEncoding.EncodeToString(data)
+	}
+
+	return ReadResourceResult{Contents: []Resource{resource}}, nil
+}
+
+// ListResourceTemplates implements ResourceServer interface.
+func (s *FSResourceServer) ListResourceTemplates(
+	context.Context,
+	ListResourceTemplatesParams,
+	RequestClientFunc,
+) (ListResourceTemplatesResult, error) {
+	return ListResourceTemplatesResult{Templates: []ResourceTemplate{s.template}}, nil
+}
+
+// CompletesResourceTemplate implements ResourceServer interface. It completes the path argument of
+// the file template with the paths of the files starting with its value, and reports their total.
+func (s *FSResourceServer) CompletesResourceTemplate(
+	ctx context.Context,
+	params CompletesCompletionParams,
+	_ RequestClientFunc,
+) (CompletionResult, error) {
+	if params.Ref.URI != s.template.URITemplate || params.Argument.Name != "path" {
+		return CompletionResult{}, fmt.Errorf("unknown template argument %s of %s",
+			params.Argument.Name, params.Ref.URI)
+	}
+
+	var result CompletionResult
+	result.Completion.Values = []string{}
+	total := 0
+	err := s.walk(ctx, func(name string, _ fs.FileInfo) {
+		if !strings.HasPrefix(name, params.Argument.Value) {
+			return
+		}
+		total++
+		if len(result.Completion.Values) == fsCompletionLimit {
+			result.Completion.HasMore = true
+			return
+		}
+		result.Completion.Values = append(result.Completion.Values, name)
+	})
+	if err != nil {
+		return CompletionResult{}, err
+	}
+	result.Completion.Total = &total
+
+	return result, nil
+}
+
+// SubscribeResource implements ResourceServer interface.
+func (s *FSResourceServer) SubscribeResource(SubscribeResourceParams) {}
+
+// UnsubscribeResource implements ResourceServer interface.
+func (s *FSResourceServer) UnsubscribeResource(UnsubscribeResourceParams) {}
+
+// walk calls fn with the path and info of every regular file that isn't ignored, in lexical order.
+func (s *FSResourceServer) walk(ctx context.Context, fn func(name string, info fs.FileInfo)) error {
+	err := fs.WalkDir(s.fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if name == "." {
+			return nil
+		}
+		if s.ignored(name) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		fn(name, info)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+
+	return nil
+}
+
+// ignored reports whether the file or directory with the given path, or any of its parent
+// directories, matches an ignore pattern.
+func (s *FSResourceServer) ignored(name string) bool {
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		for _, pattern := range s.ignore {
+			if ok, _ := path.Match(pattern, dir); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, path.Base(dir)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package mcp_test
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+)
+
+func TestFSResourceServer(t *testing.T) {
+	fsys := fstest.MapFS{
+		"readme.txt":       {Data: []byte("hello")},
+		"data/config.json": {Data: []byte(`{"debug":true}`)},
+		"data/logo.png":    {Data: []byte("\x89PNG\r\n\x1a\n")},
+		"data/cache.tmp":   {Data: []byte("stale")},
+		"raw":              {Data: []byte{0xff, 0x00, 0x01}},
+		".git/config":      {Data: []byte("[core]")},
+	}
+	srv := mcp.NewFSResourceServer(fsys,
+		mcp.WithFSURIPrefix("file:///project/"),
+		mcp.WithFSIgnore("*.tmp", ".git"),
+	)
+	ctx := context.Background()
+
+	list, err := srv.ListResources(ctx, mcp.ListResourcesParams{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var uris []string
+	for _, resource := range list.Resources {
+		uris = append(uris, resource.URI)
+	}
+	want := []string{
+		"file:///project/data/config.json",
+		"file:///project/data/logo.png",
+		"file:///project/raw",
+		"file:///project/readme.txt",
+	}
+	if !reflect.DeepEqual(uris, want) {
+		t.Errorf("expected resources %v, got %v", want, uris)
+	}
+
+	read := func(uri string) mcp.Resource {
+		t.Helper()
+
+		result, err := srv.ReadResource(ctx, mcp.ReadResourceParams{URI: uri}, nil)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", uri, err)
+		}
+		return result.Contents[0]
+	}
+
+	if resource := read("file:///project/data/config.json"); resource.Text != `{"debug":true}` {
+		t.Errorf("expected JSON read as text, got %+v", resource)
+	}
+	if resource := read("file:///project/readme.txt"); resource.Text != "hello" {
+		t.Errorf("expected text file read as text, got %+v", resource)
+	}
+	if resource := read("file:///project/data/logo.png"); resource.MimeType != "image/png" ||
+		resource.Blob != base64.StdEncoding.EncodeToString(fsys["data/logo.png"].Data) {
+		t.Errorf("expected image read as blob, got %+v", resource)
+	}
+	if resource := read("file:///project/raw"); resource.MimeType != "application/octet-stream" || resource.Blob == "" {
+		t.Errorf("expected binary file sniffed and read as blob, got %+v", resource)
+	}
+
+	for _, uri := range []string{"file:///project/data/cache.tmp", "file:///project/.git/config",
+		"file:///project/../secret", "file:///other/readme.txt"} {
+		if _, err := srv.ReadResource(ctx, mcp.ReadResourceParams{URI: uri}, nil); err == nil {
+			t.Errorf("expected error reading %s", uri)
+		}
+	}
+
+	templates, err := srv.ListResourceTemplates(ctx, mcp.ListResourceTemplatesParams{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	template := templates.Templates[0].URITemplate
+	if template != "file:///project/{+path}" {
+		t.Errorf("expected file template, got %s", template)
+	}
+
+	completion, err := srv.CompletesResourceTemplate(ctx, mcp.CompletesCompletionParams{
+		Ref:      mcp.CompletionRef{Type: "ref/resource", URI: template},
+		Argument: mcp.CompletionArgument{Name: "path", Value: "data/"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"data/config.json", "data/logo.png"}; !reflect.DeepEqual(completion.Completion.Values, want) {
+		t.Errorf("expected completions %v, got %v", want, completion.Completion.Values)
+	}
+	if total := completion.Completion.Total; total == nil || *total != 2 {
+		t.Errorf("expected a total of 2 completions, got %v", total)
+	}
+}
@@ -0,0 +1,129 @@
+package mcp
+
+import (
+	"context"
+	"sync"
+)
+
+// listCache holds the full list of the tools, prompts or resources of the server, fetched with
+// fetch, and re-fetched whenever the server notifies the client that the list changed.
+type listCache[T any] struct {
+	fetch func(ctx context.Context) ([]T, error)
+
+	// fetchLock serializes fetches, so the result of an older fetch never overwrites a newer one.
+	fetchLock sync.Mutex
+
+	lock  sync.Mutex
+	items []T
+	// refreshing reports whether a refresh is running, and stale whether the list changed again
+	// since that refresh started, in which case the refresh fetches the list once more.
+	refreshing bool
+	stale      bool
+}
+
+func newListCache[T any](fetch func(ctx context.Context) ([]T, error)) *listCache[T] {
+	return &listCache[T]{fetch: fetch}
+}
+
+// get returns a copy of the cached list, nil if it was never fetched.
+func (l *listCache[T]) get() []T {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.items == nil {
+		return nil
+	}
+	return append([]T{}, l.items...)
+}
+
+// load fetches the list and caches it. On error, the cached list is kept.
+func (l *listCache[T]) load(ctx context.Context) error {
+	l.fetchLock.Lock()
+	defer l.fetchLock.Unlock()
+
+	items, err := l.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	if items == nil {
+		items = []T{}
+	}
+
+	l.lock.Lock()
+	l.items = items
+	l.lock.Unlock()
+
+	return nil
+}
+
+// refresh re-fetches the list in the background, and calls onDone with the error of the fetch once
+// the cache is up to date. Changes that happen while a refresh is running are coalesced into a
+// single fetch after it, and onDone is only called when no change is left. The fetch is cancelled
+// once done is closed, and onDone isn't called then. On a nil cache, onDone is called right away.
+func (l *listCache[T]) refresh(done <-chan struct{}, onDone func(err error)) {
+	if l == nil {
+		onDone(nil)
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.refreshing {
+		l.stale = true
+		return
+	}
+	l.refreshing = true
+
+	go func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		for {
+			err := l.load(ctx)
+
+			l.lock.Lock()
+			if l.stale {
+				l.stale = false
+				l.lock.Unlock()
+				continue
+			}
+			l.refreshing = false
+			l.lock.Unlock()
+
+			select {
+			case <-done:
+			default:
+				onDone(err)
+			}
+			return
+		}
+	}()
+}
+
+// listAll fetches every page of a paginated list, starting from the first one.
+func listAll[T any](
+	ctx context.Context,
+	list func(ctx context.Context, cursor string) ([]T, string, error),
+) ([]T, error) {
+	var items []T
+	cursor := ""
+	for {
+		page, next, err := list(ctx, cursor)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+		if next == "" {
+			return items, nil
+		}
+		cursor = next
+	}
+}
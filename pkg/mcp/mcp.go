@@ -1,10 +1,19 @@
 package mcp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-
+	"io"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/qri-io/jsonpointer"
 	"github.com/qri-io/jsonschema"
 )
 
@@ -18,6 +27,8 @@ type Transport interface {
 	//
 	// The SessionMsg parameter contains both the target session ID and the message payload.
 	// Implementations should maintain session isolation to prevent cross-session interference.
+	// Server transports return an error wrapping ErrSessionBroken when the session can't be written
+	// to anymore, so the server ends it.
 	Send(ctx context.Context, msg SessionMsg) error
 
 	// SessionMessages returns a receive-only channel that emits incoming messages from
@@ -28,7 +39,10 @@ type Transport interface {
 	// must ensure the channel is properly closed when the transport is closed.
 	//
 	// The error channel in SessionMsgWithErrs must be handled by receiving exactly one
-	// error value, even if errors are not relevant to the implementation.
+	// error value, even if errors are not relevant to the implementation. A MessageError
+	// received from it only concerns that single message: implementations should send
+	// the wrapped JSONRPCError back to the peer and keep reading. Any other error is a
+	// transport-level failure for the session.
 	SessionMessages() <-chan SessionMsgWithErrs
 
 	// Close terminates the transport, releasing any held resources and closing
@@ -55,6 +69,14 @@ type ServerTransport interface {
 	Sessions() <-chan SessionCtx
 }
 
+// SessionCloser is an optional interface for server transports that hold resources per session,
+// such as an open connection. When a session ends, the server calls CloseSession, so the transport
+// can flush any buffered data and release the session's connection instead of leaving it open
+// until the peer goes away.
+type SessionCloser interface {
+	CloseSession(sessionID string)
+}
+
 // ClientTransport extends the base Transport interface with client-specific
 // functionality for initiating sessions with servers. It provides the
 // client-side communication layer in the MCP protocol.
@@ -133,13 +155,41 @@ type ResourceServer interface {
 	CompletesResourceTemplate(ctx context.Context, params CompletesCompletionParams,
 		requestClient RequestClientFunc) (CompletionResult, error)
 
-	// SubscribeResource registers interest in a specific resource URI.
+	// SubscribeResource registers interest in a specific resource URI. It's called once per session
+	// and URI: subscribing again to a resource the session is already subscribed to isn't relayed.
 	SubscribeResource(params SubscribeResourceParams)
 
 	// UnsubscribeResource unregisters interest in a specific resource URI.
 	UnsubscribeResource(params UnsubscribeResourceParams)
 }
 
+// StreamableResourceServer is an optional extension of ResourceServer for servers that serve
+// resources too large to be read into memory, such as big files. When the resource server set with
+// WithResourceServer implements it, the server advertises the streaming support to clients, and
+// reads requested with Client.ReadResourceStream are served by ReadResourceStream. Other reads are
+// still served by ReadResource.
+//
+// The content of a streamed resource is sent to the client in chunks, each chunk only after the
+// previous one was written to the transport, so a slow client slows down the reading of the stream
+// instead of having it buffered in memory.
+type StreamableResourceServer interface {
+	ResourceServer
+
+	// ReadResourceStream opens the resource with the given URI for streaming. The server closes the
+	// returned content once it's fully sent, or the request is cancelled. As the context is cancelled
+	// along with the request, reads of the content should be bound to it.
+	ReadResourceStream(ctx context.Context, params ReadResourceParams, requestClient RequestClientFunc) (
+		ResourceStream, error)
+}
+
+// ResourceStream is a resource opened for streaming by a StreamableResourceServer.
+type ResourceStream struct {
+	// Resource holds the metadata of the resource. Its Text and Blob are ignored.
+	Resource Resource
+	// Content is the raw content of the resource.
+	Content io.ReadCloser
+}
+
 // ResourceListUpdater provides an interface for monitoring changes to the available resources list.
 // It maintains a channel that emits notifications whenever resources are added, removed, or modified.
 //
@@ -180,10 +230,38 @@ type ToolServer interface {
 	ListTools(ctx context.Context, params ListToolsParams, requestClient RequestClientFunc) (ListToolsResult, error)
 
 	// CallTool executes a specific tool with the given arguments.
-	// Returns error if tool not found, arguments are invalid, execution fails, or context is cancelled.
+	//
+	// A tool that ran but failed at its task, such as a search with no match or an API call that was
+	// refused, must report it in a result with IsError set, describing the failure in its Content,
+	// so the model calling the tool can see it and react. Such a result is sent as a successful
+	// response. A returned error is a protocol failure instead, such as an unknown tool, invalid
+	// arguments, or a cancelled context: the call is answered with a JSON-RPC error, and the result
+	// is discarded. The error is answered as is if it's or wraps a JSONRPCError, so the tool can
+	// pick the code, such as invalid params, and with an internal error otherwise.
 	CallTool(ctx context.Context, params CallToolParams, requestClient RequestClientFunc) (CallToolResult, error)
 }
 
+// StreamingToolServer is an optional extension of ToolServer for servers whose tools produce their
+// content over time, such as tools tailing a log or generating text. When the tool server set with
+// WithToolServer implements it, calls with a progress token are served by CallToolStream, and every
+// content item is sent to the client as soon as it's produced, in a progress notification carrying
+// the item in its Content. Calls without a progress token are still served by CallTool.
+//
+// The items are delivered in the order they're sent on the channel, each in its own notification,
+// and all of them before the result of the call. Once the channel is closed, the call completes
+// with a result aggregating every item, in the same order, so clients that ignore the progress
+// notifications still get the whole content.
+type StreamingToolServer interface {
+	ToolServer
+
+	// CallToolStream executes a specific tool with the given arguments, sending its content on the
+	// returned channel, which it closes once the tool is done. An error returned here fails the call
+	// before any content is sent; a tool failing after that reports the failure as content. When the
+	// context is cancelled, the server stops receiving from the channel, so sends must be bound to it.
+	CallToolStream(ctx context.Context, params CallToolParams, requestClient RequestClientFunc) (
+		<-chan Content, error)
+}
+
 // ToolListUpdater provides an interface for monitoring changes to the available tools list.
 // It maintains a channel that emits notifications whenever tools are added, removed, or modified.
 //
@@ -220,6 +298,23 @@ type LogHandler interface {
 	SetLogLevel(level LogLevel)
 }
 
+// BatchLogHandler is an optional extension of LogHandler for chatty log sources, which emit their
+// log messages in bursts. When the handler set with WithLogHandler implements it, the server also
+// receives batches of log messages from LogBatches, and queues every batch for each session at once,
+// instead of one message at a time. The messages of a batch are sent in order, and the batches and
+// the messages received from LogStreams are sent in the order the server receives them.
+//
+// A batch counts as a single notification in the buffer set with WithNotificationBuffer, so a batch
+// dropped by the overflow policy drops all its messages. The server doesn't modify the batches, and
+// shares them between the sessions, so the handler mustn't modify a batch once it emitted it.
+type BatchLogHandler interface {
+	LogHandler
+
+	// LogBatches returns a channel that emits batches of log messages.
+	// The channel remains open for the lifetime of the handler and is safe for concurrent receives.
+	LogBatches() <-chan []LogParams
+}
+
 // RootsListWatcher provides an interface for receiving notifications when the client's root list changes.
 // The implementation can use these notifications to update its internal state or perform necessary actions
 // when the client's available roots change.
@@ -228,6 +323,19 @@ type RootsListWatcher interface {
 	OnRootsListChanged()
 }
 
+// RootsListReceiver is an optional extension of RootsListWatcher for watchers that need the new root
+// list itself, for example to diff it against the previous one. When the watcher set with
+// WithRootsListWatcher implements it, the server requests the root list from the client on every
+// change notification, and calls OnRootsListUpdated with it instead of OnRootsListChanged.
+type RootsListReceiver interface {
+	RootsListWatcher
+
+	// OnRootsListUpdated is called with the new root list of the client connected to the session with
+	// the given ID. Fetching the list is skipped, and the call with it, if the client fails to
+	// respond with one.
+	OnRootsListUpdated(sessionID string, roots RootList)
+}
+
 // Client interfaces
 
 // RootsListHandler defines the interface for retrieving the list of root resources in the MCP protocol.
@@ -256,6 +364,32 @@ type SamplingHandler interface {
 	CreateSampleMessage(ctx context.Context, params SamplingParams) (SamplingResult, error)
 }
 
+// StreamingSamplingHandler is an optional extension of SamplingHandler for clients whose model
+// generates its response over time, such as token by token. When the sampling handler set with
+// WithSamplingHandler implements it, sampling requests with a progress token, such as the ones sent
+// with StreamSampling, are served by CreateSampleMessageStream, and every piece of text is sent to
+// the server as soon as it's generated, in a progress notification carrying the piece in its Delta.
+// Requests without a progress token are still served by CreateSampleMessage.
+type StreamingSamplingHandler interface {
+	SamplingHandler
+
+	// CreateSampleMessageStream generates a response message like CreateSampleMessage, sending its
+	// text on deltas as it's generated, in pieces that form the text of the returned result once
+	// concatenated. The pieces are sent to the server before the result, and deltas is closed once
+	// the method returns. Sends must be bound to the context, which is cancelled if the server
+	// cancels the request.
+	CreateSampleMessageStream(ctx context.Context, params SamplingParams, deltas chan<- string) (SamplingResult, error)
+}
+
+// ServerRequestHandler handles the requests of the server with a given method, registered with
+// WithServerRequestHandler, for requests the client doesn't handle by itself, such as elicitation.
+// It receives the raw params of the request, and returns the result sent back to the server, which
+// must be marshalable to JSON. A JSONRPCError returned by the handler, or wrapped in its error, is
+// sent to the server as is, and any other error as an internal error. The context carries the
+// ServerSessionInfo of the server, see ServerSession, and is cancelled if the server cancels the
+// request.
+type ServerRequestHandler func(ctx context.Context, params json.RawMessage) (any, error)
+
 // PromptListWatcher provides an interface for receiving notifications when the server's prompt list changes.
 // Implementations can use these notifications to update their internal state or trigger UI updates when
 // available prompts are added, removed, or modified.
@@ -381,10 +515,25 @@ type SessionMsgWithErrs struct {
 	Msg JSONRPCMessage
 
 	// Errs receives exactly one error value after message processing completes.
-	// A nil error indicates successful processing.
+	// A nil error indicates successful processing. A MessageError indicates that only
+	// this message was rejected and the session remains usable.
 	Errs chan<- error
 }
 
+// MessageError represents a failure to process a single message that doesn't affect the
+// session it came from, such as malformed JSON or invalid params. Transports should report
+// it to the peer as a JSON-RPC error response for ID and continue processing subsequent
+// messages, rather than closing the session.
+type MessageError struct {
+	// ID identifies the rejected message. Empty when it couldn't be determined,
+	// for example when the message itself couldn't be parsed, in which case the response is sent
+	// with a null ID.
+	ID MustString
+
+	// Err is the JSON-RPC error that should be sent back to the peer.
+	Err JSONRPCError
+}
+
 // Info contains metadata about a server or client instance including its name and version.
 type Info struct {
 	Name    string `json:"name"`
@@ -430,34 +579,71 @@ type GetPromptParams struct {
 type ListPromptResult struct {
 	Prompts    []Prompt `json:"prompts"`
 	NextCursor string   `json:"nextCursor,omitempty"`
+
+	// Meta holds optional metadata of the result.
+	Meta map[string]any `json:"_meta,omitempty"`
 }
 
 // Prompt defines a template for generating prompts with optional arguments.
 // It's returned by GetPrompt and contains metadata about the prompt.
+// Title is the human-friendly name shown in UIs, while Name identifies the prompt programmatically.
 type Prompt struct {
 	Name        string           `json:"name"`
+	Title       string           `json:"title,omitempty"`
 	Description string           `json:"description,omitempty"`
 	Arguments   []PromptArgument `json:"arguments,omitempty"`
 }
 
 // PromptArgument defines a single argument that can be passed to a prompt.
 // Required indicates whether the argument must be provided when using the prompt.
+// Choices, when not empty, lists the values the argument is restricted to, so clients can offer
+// them as options.
 type PromptArgument struct {
-	Name        string `json:"name"`
-	Description string `json:"description,omitempty"`
-	Required    bool   `json:"required,omitempty"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Required    bool     `json:"required,omitempty"`
+	Choices     []string `json:"choices,omitempty"`
+}
+
+// CompleteArgument completes the prompt argument from its declared Choices, returning the choices
+// that start with the argument's current value. It reports false if the prompt has no argument
+// with that name, or the argument declares no choices. PromptServer implementations can use it
+// as the default behavior of CompletesPrompt.
+func (p Prompt) CompleteArgument(argument CompletionArgument) (CompletionResult, bool) {
+	for _, arg := range p.Arguments {
+		if arg.Name != argument.Name || len(arg.Choices) == 0 {
+			continue
+		}
+
+		var result CompletionResult
+		result.Completion.Values = []string{}
+		for _, choice := range arg.Choices {
+			if strings.HasPrefix(choice, argument.Value) {
+				result.Completion.Values = append(result.Completion.Values, choice)
+			}
+		}
+		return result, true
+	}
+
+	return CompletionResult{}, false
 }
 
 // GetPromptResult represents the result of a prompt request.
 type GetPromptResult struct {
 	Description string          `json:"description,omitempty"`
 	Messages    []PromptMessage `json:"messages,omitempty"`
+
+	// Meta holds optional metadata of the result.
+	Meta map[string]any `json:"_meta,omitempty"`
 }
 
-// PromptMessage represents a message in a prompt.
+// PromptMessage represents a message in a prompt. A message holds one or more content parts, such as
+// an instruction together with an image. A message with a single part is encoded with a content
+// object, as most clients expect, and with more parts it's encoded with an array of content objects.
+// Both forms are accepted when decoding.
 type PromptMessage struct {
 	Role    PromptRole `json:"role"`
-	Content Content    `json:"content"`
+	Content []Content  `json:"content"`
 }
 
 // PromptRole represents the role in a conversation (user or assistant).
@@ -496,6 +682,9 @@ type SubscribeResourceParams struct {
 	// URI is the unique identifier of the resource to subscribe to.
 	// Must match URI used in ReadResource calls.
 	URI string `json:"uri"`
+
+	// Meta contains optional metadata, see ParamsMeta.
+	Meta ParamsMeta `json:"_meta,omitempty"`
 }
 
 // UnsubscribeResourceParams contains parameters for unsubscribing from a resource.
@@ -503,6 +692,9 @@ type UnsubscribeResourceParams struct {
 	// URI is the unique identifier of the resource to unsubscribe from.
 	// Must match URI used in ReadResource calls.
 	URI string `json:"uri"`
+
+	// Meta contains optional metadata, see ParamsMeta.
+	Meta ParamsMeta `json:"_meta,omitempty"`
 }
 
 // ListResourcesResult represents a paginated list of resources returned by ListResources.
@@ -510,20 +702,30 @@ type UnsubscribeResourceParams struct {
 type ListResourcesResult struct {
 	Resources  []Resource `json:"resources"`
 	NextCursor string     `json:"nextCursor,omitempty"`
+
+	// Meta holds optional metadata of the result.
+	Meta map[string]any `json:"_meta,omitempty"`
 }
 
 // ReadResourceResult represents the result of a read resource request.
 type ReadResourceResult struct {
 	Contents []Resource `json:"contents"`
+
+	// Meta holds optional metadata of the result.
+	Meta map[string]any `json:"_meta,omitempty"`
 }
 
 // Resource represents a content resource in the system with associated metadata.
 // The content can be provided either as Text or Blob, with MimeType indicating the format.
+// Size is the size of the raw resource content in bytes, if known, and is omitted when nil.
+// Title is the human-friendly name shown in UIs, while Name identifies the resource programmatically.
 type Resource struct {
 	URI         string `json:"uri"`
 	Name        string `json:"name,omitempty"`
+	Title       string `json:"title,omitempty"`
 	Description string `json:"description,omitempty"`
 	MimeType    string `json:"mimeType,omitempty"`
+	Size        *int64 `json:"size,omitempty"`
 	Text        string `json:"text,omitempty"`
 	Blob        string `json:"blob,omitempty"`
 }
@@ -531,6 +733,9 @@ type Resource struct {
 // ListResourceTemplatesResult represents the result of a list resource templates request.
 type ListResourceTemplatesResult struct {
 	Templates []ResourceTemplate `json:"resourceTemplates"`
+
+	// Meta holds optional metadata of the result.
+	Meta map[string]any `json:"_meta,omitempty"`
 }
 
 // ResourceTemplate defines a template for generating resource URIs.
@@ -576,21 +781,36 @@ type CallToolParams struct {
 type ListToolsResult struct {
 	Tools      []Tool `json:"tools"`
 	NextCursor string `json:"nextCursor,omitempty"`
+
+	// Meta holds optional metadata of the result.
+	Meta map[string]any `json:"_meta,omitempty"`
 }
 
 // Tool defines a callable tool with its input schema.
 // InputSchema defines the expected format of arguments for CallTool.
+// Title is the human-friendly name shown in UIs, while Name identifies the tool programmatically.
 type Tool struct {
 	Name        string             `json:"name"`
+	Title       string             `json:"title,omitempty"`
 	Description string             `json:"description,omitempty"`
 	InputSchema *jsonschema.Schema `json:"inputSchema,omitempty"`
+	// OutputSchema is the schema of the StructuredContent of the tool results, if the tool returns
+	// structured results.
+	OutputSchema *jsonschema.Schema `json:"outputSchema,omitempty"`
 }
 
 // CallToolResult represents the outcome of a tool invocation via CallTool.
-// IsError indicates whether the operation failed, with details in Content.
+// IsError indicates whether the tool failed at its task, with details in Content. Unlike a JSON-RPC
+// error, which reports a failure to call the tool, it's part of a successful response.
 type CallToolResult struct {
 	Content []Content `json:"content"`
 	IsError bool      `json:"isError"`
+	// StructuredContent is the machine-readable result of a tool that declares an OutputSchema, and
+	// must conform to it. It's omitted when nil.
+	StructuredContent json.RawMessage `json:"structuredContent,omitempty"`
+
+	// Meta holds optional metadata of the result.
+	Meta map[string]any `json:"_meta,omitempty"`
 }
 
 // CompletesCompletionParams contains parameters for requesting completion suggestions.
@@ -601,6 +821,9 @@ type CompletesCompletionParams struct {
 	Ref CompletionRef `json:"ref"`
 	// Argument specifies which argument needs completion suggestions
 	Argument CompletionArgument `json:"argument"`
+
+	// Meta contains optional metadata, see ParamsMeta.
+	Meta ParamsMeta `json:"_meta,omitempty"`
 }
 
 // CompletionRef identifies what is being completed in a completion request.
@@ -627,10 +850,19 @@ type CompletionArgument struct {
 // CompletionResult contains the response data for a completion request, including
 // possible completion values and whether more completions are available.
 type CompletionResult struct {
-	Completion struct {
-		Values  []string `json:"values"`
-		HasMore bool     `json:"hasMore"`
-	} `json:"completion"`
+	Completion Completion `json:"completion"`
+
+	// Meta holds optional metadata of the result.
+	Meta map[string]any `json:"_meta,omitempty"`
+}
+
+// Completion holds the completion values of a CompletionResult.
+type Completion struct {
+	Values  []string `json:"values"`
+	HasMore bool     `json:"hasMore"`
+	// Total is the number of completions there are in all, which can exceed the number of
+	// Values, for clients to show how many were left out. It's omitted when nil.
+	Total *int `json:"total,omitempty"`
 }
 
 // ProgressParams represents the progress status of a long-running operation.
@@ -639,9 +871,18 @@ type ProgressParams struct {
 	ProgressToken MustString `json:"progressToken"`
 	// Progress represents the current progress value
 	Progress float64 `json:"value"`
-	// Total represents the expected final value when known.
-	// When non-zero, completion percentage can be calculated as (Progress/Total)*100
-	Total float64 `json:"total"`
+	// Total represents the expected final value when known, in which case completion percentage can
+	// be calculated as (Progress/Total)*100. It's nil, and omitted, when the total is unknown, such as
+	// for a tool that can't tell upfront how much work it has: Progress then only increases, and
+	// clients should show indeterminate progress, like a spinner, rather than a bar.
+	Total *float64 `json:"total,omitempty"`
+	// Content holds the content item just produced by a tool call streamed by a StreamingToolServer,
+	// in which case Progress is the number of items produced so far.
+	Content []Content `json:"content,omitempty"`
+	// Delta holds the piece of text just generated by a sampling request streamed by a
+	// StreamingSamplingHandler, to be appended to the pieces received before it, in which case
+	// Progress is the number of pieces generated so far.
+	Delta string `json:"delta,omitempty"`
 }
 
 // LogParams represents the parameters for a log message.
@@ -670,6 +911,10 @@ type ParamsMeta struct {
 	// ProgressToken uniquely identifies an operation for progress tracking.
 	// When provided, the server can emit progress updates via ProgressReporter.
 	ProgressToken MustString `json:"progressToken"`
+
+	// Extra holds the other metadata fields, keyed by name, such as vendor extensions or
+	// correlation IDs. They're encoded next to progressToken in the _meta object.
+	Extra map[string]any `json:"-"`
 }
 
 // RootList represents a collection of root resources in the system.
@@ -679,6 +924,9 @@ type ParamsMeta struct {
 //   - Name: A human-readable name for the root
 type RootList struct {
 	Roots []Root `json:"roots"`
+
+	// Meta holds optional metadata of the result.
+	Meta map[string]any `json:"_meta,omitempty"`
 }
 
 // Root represents a top-level resource entry point in the system.
@@ -708,6 +956,15 @@ type SamplingParams struct {
 
 	// MaxTokens specifies the maximum number of tokens allowed in the generated response
 	MaxTokens int `json:"maxTokens"`
+
+	// Temperature controls the randomness of the generated response, left to the client if nil
+	Temperature *float64 `json:"temperature,omitempty"`
+
+	// StopSequences lists the sequences that stop the generation once the model produces them
+	StopSequences []string `json:"stopSequences,omitempty"`
+
+	// Meta contains optional metadata, see ParamsMeta.
+	Meta ParamsMeta `json:"_meta,omitempty"`
 }
 
 // SamplingMessage represents a message in the sampling conversation history. Contains
@@ -733,14 +990,15 @@ type SamplingContent struct {
 
 // SamplingModelPreferences defines preferences for model selection and behavior. Contains
 // hints to guide model selection, and priority values for different aspects (cost,
-// speed, intelligence) that influence the sampling process and model choice.
+// speed, intelligence) that influence the sampling process and model choice. Each priority
+// ranges from 0, not important, to 1, most important, see Validate.
 type SamplingModelPreferences struct {
 	Hints []struct {
 		Name string `json:"name"`
 	} `json:"hints"`
-	CostPriority         int `json:"costPriority"`
-	SpeedPriority        int `json:"speedPriority"`
-	IntelligencePriority int `json:"intelligencePriority"`
+	CostPriority         float64 `json:"costPriority"`
+	SpeedPriority        float64 `json:"speedPriority"`
+	IntelligencePriority float64 `json:"intelligencePriority"`
 }
 
 // SamplingResult represents the output of a sampling operation. Contains the role of
@@ -751,9 +1009,30 @@ type SamplingResult struct {
 	Content    SamplingContent `json:"content"`
 	Model      string          `json:"model"`
 	StopReason string          `json:"stopReason"`
+
+	// StopSequence is the sequence of SamplingParams.StopSequences that stopped the generation, if
+	// any.
+	StopSequence string `json:"stopSequence,omitempty"`
+
+	// Usage holds the token counts of the generation, if the client reports them.
+	Usage *SamplingUsage `json:"usage,omitempty"`
+
+	// Meta holds optional metadata of the result.
+	Meta map[string]any `json:"_meta,omitempty"`
+}
+
+// SamplingUsage reports the tokens the model consumed and produced for a sampling result, for
+// servers that budget their tokens.
+type SamplingUsage struct {
+	InputTokens  int `json:"inputTokens"`
+	OutputTokens int `json:"outputTokens"`
 }
 
 // Content represents a message content with its type.
+//
+// A content of type ContentTypeResourceLink points at a resource by its URI, along with its Name,
+// Description and MimeType, instead of embedding it like a content of type ContentTypeResource does.
+// The client reads the linked resource separately, with ReadResource, if it needs it.
 type Content struct {
 	Type ContentType `json:"type"`
 
@@ -763,6 +1042,10 @@ type Content struct {
 	MimeType string `json:"mimeType,omitempty"`
 
 	Resource *Resource `json:"resource,omitempty"`
+
+	URI         string `json:"uri,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
 }
 
 // ContentType represents the type of content in messages.
@@ -784,20 +1067,67 @@ type ContentType string
 //
 // The implementation must handle timeouts, connection errors, and invalid responses appropriately.
 // It should respect the JSON-RPC 2.0 specification for error handling and message formatting.
+//
+// The function passed to a server method is bound to that method's context. If the context is
+// cancelled while a request is awaiting its response, a notifications/cancelled message is sent
+// to the client for that request and the context's error is returned.
 type RequestClientFunc func(msg JSONRPCMessage) (JSONRPCMessage, error)
 
 // ServerCapabilities represents server capabilities.
+// Experimental holds non-standard capabilities, keyed by name, that vendors can negotiate ahead of
+// standardization.
 type ServerCapabilities struct {
-	Prompts   *PromptsCapability   `json:"prompts,omitempty"`
-	Resources *ResourcesCapability `json:"resources,omitempty"`
-	Tools     *ToolsCapability     `json:"tools,omitempty"`
-	Logging   *LoggingCapability   `json:"logging,omitempty"`
+	Prompts      *PromptsCapability   `json:"prompts,omitempty"`
+	Resources    *ResourcesCapability `json:"resources,omitempty"`
+	Tools        *ToolsCapability     `json:"tools,omitempty"`
+	Logging      *LoggingCapability   `json:"logging,omitempty"`
+	Experimental map[string]any       `json:"experimental,omitempty"`
 }
 
 // ClientCapabilities represents client capabilities.
+// Experimental holds non-standard capabilities, keyed by name, that vendors can negotiate ahead of
+// standardization.
 type ClientCapabilities struct {
-	Roots    *RootsCapability    `json:"roots,omitempty"`
-	Sampling *SamplingCapability `json:"sampling,omitempty"`
+	Roots        *RootsCapability    `json:"roots,omitempty"`
+	Sampling     *SamplingCapability `json:"sampling,omitempty"`
+	Experimental map[string]any      `json:"experimental,omitempty"`
+}
+
+// clone returns a copy of the capabilities that shares no state with c, so the copy can be handed to
+// a session, or a handler, without the changes made to one affecting the other.
+func (c ServerCapabilities) clone() ServerCapabilities {
+	if c.Prompts != nil {
+		prompts := *c.Prompts
+		c.Prompts = &prompts
+	}
+	if c.Resources != nil {
+		resources := *c.Resources
+		c.Resources = &resources
+	}
+	if c.Tools != nil {
+		tools := *c.Tools
+		c.Tools = &tools
+	}
+	if c.Logging != nil {
+		logging := *c.Logging
+		c.Logging = &logging
+	}
+	c.Experimental = maps.Clone(c.Experimental)
+	return c
+}
+
+// clone returns a copy of the capabilities that shares no state with c.
+func (c ClientCapabilities) clone() ClientCapabilities {
+	if c.Roots != nil {
+		roots := *c.Roots
+		c.Roots = &roots
+	}
+	if c.Sampling != nil {
+		sampling := *c.Sampling
+		c.Sampling = &sampling
+	}
+	c.Experimental = maps.Clone(c.Experimental)
+	return c
 }
 
 // PromptsCapability represents prompts-specific capabilities.
@@ -833,6 +1163,17 @@ type initializeParams struct {
 	ClientInfo      Info               `json:"clientInfo"`
 }
 
+// pingParams are the optional params of a ping request. Their _meta, such as a correlation ID for
+// tracking liveness, is echoed back in the pingResult.
+type pingParams struct {
+	Meta json.RawMessage `json:"_meta,omitempty"`
+}
+
+// pingResult is the result of a ping request: an empty object, or one with the _meta of the ping.
+type pingResult struct {
+	Meta json.RawMessage `json:"_meta,omitempty"`
+}
+
 type initializeResult struct {
 	ProtocolVersion string             `json:"protocolVersion"`
 	Capabilities    ServerCapabilities `json:"capabilities"`
@@ -844,8 +1185,20 @@ type notificationsCancelledParams struct {
 	Reason    string `json:"reason"`
 }
 
+type notificationsResourcesChunkParams struct {
+	RequestID string `json:"requestId"`
+	Data      []byte `json:"data"`
+}
+
+type resourcesSubscriptionsResult struct {
+	URIs []string `json:"uris"`
+}
+
 type notificationsResourcesUpdatedParams struct {
 	URI string `json:"uri"`
+	// Contents holds the updated contents of the resource, if sent inline, see
+	// WithInlineResourceUpdates.
+	Contents []Resource `json:"contents,omitempty"`
 }
 
 const (
@@ -867,6 +1220,9 @@ const (
 	MethodResourcesSubscribe = "resources/subscribe"
 	// MethodResourcesUnsubscribe is the method name for unsubscribing from resource updates.
 	MethodResourcesUnsubscribe = "resources/unsubscribe"
+	// MethodResourcesSubscriptions is the method name for listing the resources the session is
+	// subscribed to. It's an extension of this package, not part of the MCP specification.
+	MethodResourcesSubscriptions = "resources/subscriptions"
 
 	// MethodToolsList is the method name for retrieving a list of available tools.
 	MethodToolsList = "tools/list"
@@ -892,11 +1248,16 @@ const (
 	protocolVersion = "2024-11-05"
 
 	errMsgInvalidJSON                    = "Invalid json"
+	errMsgInvalidRequest                 = "Invalid request"
+	errMsgInvalidParams                  = "Invalid params"
+	errMsgMethodNotFound                 = "Method not found"
 	errMsgUnsupportedProtocolVersion     = "Unsupported protocol version"
 	errMsgInsufficientClientCapabilities = "Insufficient client capabilities"
 	errMsgInternalError                  = "Internal error"
 	errMsgWriteTimeout                   = "Write timeout"
 	errMsgReadTimeout                    = "Read timeout"
+	errMsgRateLimited                    = "Rate limit exceeded"
+	errMsgAlreadyInitialized             = "Already initialized"
 
 	methodPing       = "ping"
 	methodInitialize = "initialize"
@@ -906,19 +1267,45 @@ const (
 	methodNotificationsPromptsListChanged   = "notifications/prompts/list_changed"
 	methodNotificationsResourcesListChanged = "notifications/resources/list_changed"
 	methodNotificationsResourcesUpdated     = "notifications/resources/updated"
+	methodNotificationsResourcesChunk       = "notifications/resources/chunk"
 	methodNotificationsToolsListChanged     = "notifications/tools/list_changed"
 	methodNotificationsProgress             = "notifications/progress"
 	methodNotificationsMessage              = "notifications/message"
 
 	methodNotificationsRootsListChanged = "notifications/roots/list_changed"
 
-	userCancelledReason = "User requested cancellation"
+	userCancelledReason   = "User requested cancellation"
+	serverCancelledReason = "Server cancelled the request"
 
 	jsonRPCParseErrorCode     = -32700
 	jsonRPCInvalidRequestCode = -32600
 	jsonRPCMethodNotFoundCode = -32601
 	jsonRPCInvalidParamsCode  = -32602
 	jsonRPCInternalErrorCode  = -32603
+
+	// jsonRPCRateLimitedCode is in the range reserved for implementation-defined server errors.
+	jsonRPCRateLimitedCode = -32000
+
+	// experimentalResourceStreaming is the experimental server capability advertising support for
+	// streamed resource reads, see StreamableResourceServer.
+	experimentalResourceStreaming = "resourceStreaming"
+	// experimentalResourceCompression is the experimental capability advertising support for
+	// compressed resources/read results, see WithResourceCompression.
+	experimentalResourceCompression = "resourceCompression"
+	// experimentalToolDryRun is the experimental server capability advertising support for dry-run
+	// tool calls, see WithToolDryRun.
+	experimentalToolDryRun = "toolDryRun"
+	// metaStream is the _meta field a client sets to true to request a streamed resource read.
+	metaStream = "stream"
+	// metaDryRun is the _meta field a client sets to true to request a dry-run tool call.
+	metaDryRun = "dryRun"
+	// inlineResourceUpdateMaxSize is the maximum size of the encoded contents of a resource sent
+	// inline in its update notification, see WithInlineResourceUpdates.
+	inlineResourceUpdateMaxSize = 16 << 10
+	// resourceStreamChunkSize is the maximum size of the raw content sent in a single chunk. Once
+	// base64 encoded, a chunk stays below the default 64KiB line limit of bufio.Scanner, which
+	// line-based transports like StdIO read messages with.
+	resourceStreamChunkSize = 32 << 10
 )
 
 // PromptRole represents the role in a conversation (user or assistant).
@@ -939,28 +1326,46 @@ const (
 	LogLevelEmergency
 )
 
+// JSON Schema dialects, for WithSchemaDialect.
+const (
+	SchemaDialectDraft07 = "http://json-schema.org/draft-07/schema#"
+	SchemaDialect202012  = "https://json-schema.org/draft/2020-12/schema"
+)
+
 // ContentType represents the type of content in messages.
 const (
-	ContentTypeText     ContentType = "text"
-	ContentTypeImage    ContentType = "image"
-	ContentTypeResource ContentType = "resource"
+	ContentTypeText         ContentType = "text"
+	ContentTypeImage        ContentType = "image"
+	ContentTypeResource     ContentType = "resource"
+	ContentTypeResourceLink ContentType = "resource_link"
 )
 
 // UnmarshalJSON implements json.Unmarshaler to convert JSON data into MustString,
 // handling both string and numeric input formats.
 func (m *MustString) UnmarshalJSON(data []byte) error {
 	var v any
-	if err := json.Unmarshal(data, &v); err != nil {
+	if err := unmarshalWithNumbers(data, &v); err != nil {
 		return err
 	}
 
 	switch v := v.(type) {
+	case nil:
+		// A null ID, such as the one of the response to a message that couldn't be parsed, is left
+		// empty.
+		*m = ""
 	case string:
 		*m = MustString(v)
-	case float64:
-		*m = MustString(fmt.Sprintf("%d", int(v)))
-	case int:
-		*m = MustString(fmt.Sprintf("%d", v))
+	case json.Number:
+		// Integers are kept as written, so IDs beyond the precision of a float64 stay exact.
+		if n, err := v.Int64(); err == nil {
+			*m = MustString(fmt.Sprintf("%d", n))
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return fmt.Errorf("invalid number: %w", err)
+		}
+		*m = MustString(fmt.Sprintf("%d", int(f)))
 	default:
 		return fmt.Errorf("invalid type: %T", v)
 	}
@@ -968,12 +1373,616 @@ func (m *MustString) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// parsePingParams returns the _meta of the params of a ping request, decoded and as the raw JSON to
+// echo back. Pings are answered whatever their params, so a peer is never deemed dead over params it
+// doesn't understand: params that aren't an object, or whose _meta isn't one, are ignored.
+func parsePingParams(data json.RawMessage) (ParamsMeta, json.RawMessage) {
+	var params pingParams
+	if len(data) == 0 || json.Unmarshal(data, &params) != nil {
+		return ParamsMeta{}, nil
+	}
+	var meta ParamsMeta
+	if !bytes.HasPrefix(params.Meta, []byte("{")) || json.Unmarshal(params.Meta, &meta) != nil {
+		return ParamsMeta{}, nil
+	}
+	return meta, params.Meta
+}
+
+// unmarshalWithNumbers is json.Unmarshal, except that the numbers decoded into an interface value
+// are json.Number instead of float64, so large integers keep their exact value.
+func unmarshalWithNumbers(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid character after top-level value")
+	}
+
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler to encode an error response without an ID, such as the
+// response to a message that couldn't be parsed, with a null ID, as JSON-RPC requires every response
+// to have one. Other messages are encoded as they are, without an empty ID.
+func (m JSONRPCMessage) MarshalJSON() ([]byte, error) {
+	type message JSONRPCMessage
+	if m.Error == nil || m.ID != "" {
+		return json.Marshal(message(m))
+	}
+	return json.Marshal(struct {
+		message
+		ID *MustString `json:"id"`
+	}{message: message(m)})
+}
+
 // MarshalJSON implements json.Marshaler to convert MustString into its JSON representation,
 // always encoding as a string value.
 func (m MustString) MarshalJSON() ([]byte, error) {
 	return json.Marshal(string(m))
 }
 
+// Valid reports whether t is one of the content types defined by this package.
+func (t ContentType) Valid() bool {
+	switch t {
+	case ContentTypeText, ContentTypeImage, ContentTypeResource, ContentTypeResourceLink:
+		return true
+	}
+	return false
+}
+
+// UnmarshalJSON implements json.Unmarshaler to reject a content whose type isn't valid, see
+// ContentType.Valid, instead of passing it on to code that doesn't know how to handle it.
+func (c *Content) UnmarshalJSON(data []byte) error {
+	type content Content
+	var v content
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if !v.Type.Valid() {
+		return fmt.Errorf("invalid content type %q", v.Type)
+	}
+
+	*c = Content(v)
+	return nil
+}
+
+// FirstContent returns the first content part of the message, or the zero Content if the message
+// is empty. It's a convenience for the common single-content messages.
+func (p PromptMessage) FirstContent() Content {
+	if len(p.Content) == 0 {
+		return Content{}
+	}
+	return p.Content[0]
+}
+
+// MarshalJSON implements json.Marshaler to encode a single content part as an object, and
+// multiple parts, or none, as an array.
+func (p PromptMessage) MarshalJSON() ([]byte, error) {
+	var content any = p.Content
+	switch len(p.Content) {
+	case 0:
+		content = []Content{}
+	case 1:
+		content = p.Content[0]
+	}
+
+	return json.Marshal(struct {
+		Role    PromptRole `json:"role"`
+		Content any        `json:"content"`
+	}{
+		Role:    p.Role,
+		Content: content,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler to decode the content of the message from either a
+// single object or an array of objects.
+func (p *PromptMessage) UnmarshalJSON(data []byte) error {
+	var msg struct {
+		Role    PromptRole      `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+
+	p.Role = msg.Role
+	p.Content = nil
+
+	content := bytes.TrimSpace(msg.Content)
+	if len(content) == 0 || bytes.Equal(content, []byte("null")) {
+		return nil
+	}
+	if content[0] == '[' {
+		return json.Unmarshal(content, &p.Content)
+	}
+
+	var c Content
+	if err := json.Unmarshal(content, &c); err != nil {
+		return err
+	}
+	p.Content = []Content{c}
+
+	return nil
+}
+
+// Validate returns an error naming the priorities of p that are outside of [0, 1], the range
+// clients expect them in. The server checks the preferences of every sampling request before
+// sending it, so invalid ones fail the request instead of reaching the client.
+func (p SamplingModelPreferences) Validate() error {
+	var errs []error
+	for _, priority := range []struct {
+		name  string
+		value float64
+	}{
+		{"costPriority", p.CostPriority},
+		{"speedPriority", p.SpeedPriority},
+		{"intelligencePriority", p.IntelligencePriority},
+	} {
+		// Written so that NaN, which fails every comparison, is out of range too.
+		if !(priority.value >= 0 && priority.value <= 1) {
+			errs = append(errs, fmt.Errorf("%s must be between 0 and 1, got %v", priority.name, priority.value))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// MarshalJSON implements json.Marshaler to encode the progress token and the extra fields into a
+// single object. The progress token is omitted when empty.
+func (p ParamsMeta) MarshalJSON() ([]byte, error) {
+	meta := make(map[string]any, len(p.Extra)+1)
+	for k, v := range p.Extra {
+		meta[k] = v
+	}
+	if p.ProgressToken != "" {
+		meta["progressToken"] = p.ProgressToken
+	}
+	return json.Marshal(meta)
+}
+
+// UnmarshalJSON implements json.Unmarshaler to decode the progress token, and collect every other
+// field into Extra.
+func (p *ParamsMeta) UnmarshalJSON(data []byte) error {
+	var meta map[string]json.RawMessage
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return err
+	}
+
+	*p = ParamsMeta{}
+	for k, v := range meta {
+		if k == "progressToken" {
+			if err := json.Unmarshal(v, &p.ProgressToken); err != nil {
+				return fmt.Errorf("failed to unmarshal progress token: %w", err)
+			}
+			continue
+		}
+		var value any
+		if err := json.Unmarshal(v, &value); err != nil {
+			return fmt.Errorf("failed to unmarshal meta field %s: %w", k, err)
+		}
+		if p.Extra == nil {
+			p.Extra = make(map[string]any)
+		}
+		p.Extra[k] = value
+	}
+
+	return nil
+}
+
+// ParseLogLevel parses the name of a log level, case-insensitively. Besides the names of the MCP
+// levels, as returned by LogLevel.String, it accepts the syslog keywords "err", "crit" and "emerg",
+// and "warn", the name of the slog warning level.
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "info":
+		return LogLevelInfo, nil
+	case "notice":
+		return LogLevelNotice, nil
+	case "warning", "warn":
+		return LogLevelWarning, nil
+	case "error", "err":
+		return LogLevelError, nil
+	case "critical", "crit":
+		return LogLevelCritical, nil
+	case "alert":
+		return LogLevelAlert, nil
+	case "emergency", "emerg":
+		return LogLevelEmergency, nil
+	default:
+		return 0, fmt.Errorf("unknown log level: %q", name)
+	}
+}
+
+// LogLevelFromSlog converts a slog level to the MCP level it falls in, the reverse of
+// LogLevel.ToSlog: levels between two of the levels ToSlog returns are rounded down, levels below
+// slog.LevelDebug map to LogLevelDebug, and levels from slog.LevelError+12 up map to
+// LogLevelEmergency. So slog.LevelInfo+2 and up to slog.LevelWarn is notice, and the levels above
+// slog.LevelError are critical, alert and emergency, in steps of 4.
+func LogLevelFromSlog(level slog.Level) LogLevel {
+	switch {
+	case level < slog.LevelInfo:
+		return LogLevelDebug
+	case level < slog.LevelInfo+2:
+		return LogLevelInfo
+	case level < slog.LevelWarn:
+		return LogLevelNotice
+	case level < slog.LevelError:
+		return LogLevelWarning
+	case level < slog.LevelError+4:
+		return LogLevelError
+	case level < slog.LevelError+8:
+		return LogLevelCritical
+	case level < slog.LevelError+12:
+		return LogLevelAlert
+	default:
+		return LogLevelEmergency
+	}
+}
+
+// ToSlog converts the level to a slog level. Debug, info, warning and error map to the
+// corresponding slog levels. slog has no levels for the others, so notice maps to
+// slog.LevelInfo+2, halfway between info and warning, and critical, alert and emergency map to
+// slog.LevelError+4, slog.LevelError+8 and slog.LevelError+12, continuing the slog spacing above
+// error. LogLevelFromSlog converts these back to the same level. Levels out of range map to the
+// closest one.
+func (l LogLevel) ToSlog() slog.Level {
+	switch {
+	case l <= LogLevelDebug:
+		return slog.LevelDebug
+	case l == LogLevelInfo:
+		return slog.LevelInfo
+	case l == LogLevelNotice:
+		return slog.LevelInfo + 2
+	case l == LogLevelWarning:
+		return slog.LevelWarn
+	case l == LogLevelError:
+		return slog.LevelError
+	case l == LogLevelCritical:
+		return slog.LevelError + 4
+	case l == LogLevelAlert:
+		return slog.LevelError + 8
+	default:
+		return slog.LevelError + 12
+	}
+}
+
+// String returns the MCP name of the level, such as "warning". Levels out of range are formatted
+// as "LogLevel(n)".
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelNotice:
+		return "notice"
+	case LogLevelWarning:
+		return "warning"
+	case LogLevelError:
+		return "error"
+	case LogLevelCritical:
+		return "critical"
+	case LogLevelAlert:
+		return "alert"
+	case LogLevelEmergency:
+		return "emergency"
+	default:
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+}
+
 func (j JSONRPCError) Error() string {
 	return fmt.Sprintf("request error, code: %d, message: %s, data %v", j.Code, j.Message, j.Data)
 }
+
+func (m MessageError) Error() string {
+	return fmt.Sprintf("message %q rejected: %s", m.ID, m.Err.Error())
+}
+
+// Unwrap returns the underlying JSONRPCError.
+func (m MessageError) Unwrap() error {
+	return m.Err
+}
+
+// Response returns the JSON-RPC error response that should be sent back to the peer.
+func (m MessageError) Response() JSONRPCMessage {
+	err := m.Err
+	return JSONRPCMessage{
+		JSONRPC: JSONRPCVersion,
+		ID:      m.ID,
+		Error:   &err,
+	}
+}
+
+func newUUID() string {
+	return uuid.New().String()
+}
+
+// validateJSONRPCVersion rejects a message whose jsonrpc field isn't JSONRPCVersion. A request is
+// rejected with a MessageError, so the peer is answered with an invalid request error, while other
+// messages can't be answered, and are rejected with a plain error.
+func validateJSONRPCVersion(msg JSONRPCMessage) error {
+	if msg.JSONRPC == JSONRPCVersion {
+		return nil
+	}
+
+	err := fmt.Errorf("unsupported jsonrpc version %q", msg.JSONRPC)
+	if msg.Method == "" || msg.ID == "" {
+		return err
+	}
+	return MessageError{
+		ID: msg.ID,
+		Err: JSONRPCError{
+			Code:    jsonRPCInvalidRequestCode,
+			Message: errMsgInvalidRequest,
+			Data:    map[string]any{"error": err.Error()},
+		},
+	}
+}
+
+func newParseError(err error) MessageError {
+	return MessageError{
+		Err: JSONRPCError{
+			Code:    jsonRPCParseErrorCode,
+			Message: errMsgInvalidJSON,
+			Data:    map[string]any{"error": err.Error()},
+		},
+	}
+}
+
+// schemaLocks serializes the validations of each schema. jsonschema resolves the $refs of a schema on
+// its first validation, and caches the resolved subschemas in the schema itself, so the same schema
+// can't be validated concurrently, as the schemas of tools are. Different schemas are validated
+// concurrently.
+var schemaLocks = schemaLockTable{locks: make(map[*jsonschema.Schema]*schemaLock)}
+
+// schemaLockTable holds a lock for every schema being validated. A lock is removed once no validation
+// holds or awaits it, so the table doesn't keep the schemas of tools that are gone.
+type schemaLockTable struct {
+	lock  sync.Mutex
+	locks map[*jsonschema.Schema]*schemaLock
+}
+
+type schemaLock struct {
+	sync.Mutex
+	// refs is the number of validations holding or awaiting the lock, guarded by the table's lock.
+	refs int
+}
+
+// validateSchema validates data against schema, resolving the local $refs of the schema, such as
+// #/$defs/name, against the schema itself.
+func validateSchema(ctx context.Context, schema *jsonschema.Schema, data []byte) ([]jsonschema.KeyError, error) {
+	unlock := schemaLocks.acquire(schema)
+	defer unlock()
+
+	return schema.ValidateBytes(ctx, data)
+}
+
+// acquire locks the lock of the schema, and returns the function unlocking it.
+func (t *schemaLockTable) acquire(schema *jsonschema.Schema) func() {
+	t.lock.Lock()
+	l, ok := t.locks[schema]
+	if !ok {
+		l = new(schemaLock)
+		t.locks[schema] = l
+	}
+	l.refs++
+	t.lock.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(t.locks, schema)
+		}
+	}
+}
+
+// validateToolInput validates the arguments of a call of the tool with the given name against its
+// input schema.
+func validateToolInput(ctx context.Context, toolName string, schema *jsonschema.Schema, args map[string]any) error {
+	if args == nil {
+		args = map[string]any{}
+	}
+	argsBs, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("failed to marshal arguments of tool %s: %w", toolName, err)
+	}
+	keyErrs, err := validateSchema(ctx, schema, argsBs)
+	if err != nil {
+		return fmt.Errorf("failed to validate arguments of tool %s: %w", toolName, err)
+	}
+	if len(keyErrs) > 0 {
+		return fmt.Errorf("arguments of tool %s don't match its input schema: %w", toolName, keyErrs[0])
+	}
+
+	return nil
+}
+
+// validateToolOutput validates the structured content returned by the tool with the given name
+// against its output schema.
+func validateToolOutput(ctx context.Context, toolName string, schema *jsonschema.Schema, content json.RawMessage) error {
+	keyErrs, err := validateSchema(ctx, schema, content)
+	if err != nil {
+		return fmt.Errorf("failed to validate structured content of tool %s: %w", toolName, err)
+	}
+	if len(keyErrs) > 0 {
+		return fmt.Errorf("structured content of tool %s doesn't match its output schema: %w", toolName, keyErrs[0])
+	}
+
+	return nil
+}
+
+// schemaRequired returns the names listed by the required keyword of the schema, read from the
+// encoded schema as clients see it.
+func schemaRequired(schema *jsonschema.Schema) []string {
+	bs, err := json.Marshal(schema)
+	if err != nil {
+		return nil
+	}
+	var keywords struct {
+		Required []string `json:"required"`
+	}
+	if err := json.Unmarshal(bs, &keywords); err != nil {
+		return nil
+	}
+	return keywords.Required
+}
+
+// applySchemaDefaults sets the arguments missing from args to the default values the schema
+// declares for its properties, either directly or in the subschemas they refer to with a $ref into
+// the $defs of the schema. The args are copied rather than modified.
+func applySchemaDefaults(schema *jsonschema.Schema, args map[string]any) map[string]any {
+	props, ok := schema.JSONProp("properties").(*jsonschema.Properties)
+	if !ok || props == nil {
+		return args
+	}
+
+	var merged map[string]any
+	for name, prop := range *props {
+		if _, ok := args[name]; ok || prop == nil {
+			continue
+		}
+		value, ok := schemaDefault(resolveSchemaRef(schema, prop))
+		if !ok {
+			continue
+		}
+		if merged == nil {
+			merged = make(map[string]any, len(args)+1)
+			for k, v := range args {
+				merged[k] = v
+			}
+		}
+		merged[name] = value
+	}
+	if merged == nil {
+		return args
+	}
+	return merged
+}
+
+// resolveSchemaRef returns the subschema of root that schema refers to with a $ref into the $defs of
+// root, such as #/$defs/unit, following the $refs of the subschemas in turn. It returns schema itself
+// if it has no such reference, or if the reference can't be resolved.
+func resolveSchemaRef(root, schema *jsonschema.Schema) *jsonschema.Schema {
+	defs, ok := root.JSONProp("$defs").(*jsonschema.Defs)
+	if !ok || defs == nil {
+		return schema
+	}
+
+	// A chain of $refs is at most as long as the number of subschemas, unless it's a cycle.
+	resolved := schema
+	for range len(*defs) {
+		name, ok := schemaDefsRef(resolved)
+		if !ok {
+			return resolved
+		}
+		def := (*defs)[name]
+		if def == nil {
+			return schema
+		}
+		resolved = def
+	}
+
+	return schema
+}
+
+// schemaDefsRef returns the name of the subschema of $defs the $ref of schema refers to, if it
+// refers to one. The reference is read from the encoded keyword, as jsonschema keeps it unexported.
+func schemaDefsRef(schema *jsonschema.Schema) (string, bool) {
+	ref := schema.JSONProp("$ref")
+	if ref == nil {
+		return "", false
+	}
+	bs, err := json.Marshal(ref)
+	if err != nil {
+		return "", false
+	}
+	var reference string
+	if err := json.Unmarshal(bs, &reference); err != nil {
+		return "", false
+	}
+	name, ok := strings.CutPrefix(reference, "#/$defs/")
+	if !ok || strings.Contains(name, "/") {
+		return "", false
+	}
+
+	return jsonPointerUnescaper.Replace(name), true
+}
+
+var jsonPointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// schemaDefaultKeyword is the default keyword of JSON schemas, registered with jsonschema in place of
+// its own, which keeps the value unexported and drops it when the schema is encoded. It keeps the raw
+// JSON of the value instead, so the schemas of tools reach clients with their defaults, and
+// applySchemaDefaults reads them from it.
+type schemaDefaultKeyword struct {
+	value json.RawMessage
+}
+
+func init() {
+	// The keywords of jsonschema are loaded on the first schema decoded, replacing the ones
+	// registered before, so they're loaded first.
+	if !jsonschema.IsRegistryLoaded() {
+		jsonschema.LoadDraft2019_09()
+	}
+	jsonschema.RegisterKeyword("default", newSchemaDefaultKeyword)
+}
+
+func newSchemaDefaultKeyword() jsonschema.Keyword {
+	return new(schemaDefaultKeyword)
+}
+
+// ValidateKeyword implements the jsonschema.Keyword interface. The default keyword is an annotation,
+// so any data is valid.
+func (d *schemaDefaultKeyword) ValidateKeyword(context.Context, *jsonschema.ValidationState, any) {}
+
+// Register implements the jsonschema.Keyword interface.
+func (d *schemaDefaultKeyword) Register(string, *jsonschema.SchemaRegistry) {}
+
+// Resolve implements the jsonschema.Keyword interface. The value of the keyword isn't a schema.
+func (d *schemaDefaultKeyword) Resolve(jsonpointer.Pointer, string) *jsonschema.Schema {
+	return nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (d *schemaDefaultKeyword) UnmarshalJSON(data []byte) error {
+	if !json.Valid(data) {
+		return fmt.Errorf("invalid default value: %s", data)
+	}
+	d.value = bytes.Clone(data)
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (d schemaDefaultKeyword) MarshalJSON() ([]byte, error) {
+	if d.value == nil {
+		return []byte("null"), nil
+	}
+	return d.value, nil
+}
+
+// schemaDefault returns the value of the default keyword of the schema, if it has one, decoded from
+// its raw JSON, so every call returns a value of its own. Schemas decoded before this package was
+// initialized hold the default keyword of jsonschema, whose value can't be read, and are treated as
+// having none.
+func schemaDefault(schema *jsonschema.Schema) (any, bool) {
+	d, ok := schema.JSONProp("default").(*schemaDefaultKeyword)
+	if !ok || d == nil || d.value == nil {
+		return nil, false
+	}
+	var value any
+	if err := json.Unmarshal(d.value, &value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
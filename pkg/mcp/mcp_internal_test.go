@@ -0,0 +1,41 @@
+package mcp
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/qri-io/jsonschema"
+)
+
+func TestValidateSchemaConcurrently(t *testing.T) {
+	schemas := []*jsonschema.Schema{
+		jsonschema.Must(`{"type":"object","$defs":{"city":{"type":"string"}},` +
+			`"properties":{"city":{"$ref":"#/$defs/city"}},"required":["city"]}`),
+		jsonschema.Must(`{"type":"object","properties":{"count":{"type":"integer"}}}`),
+	}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			schema := schemas[i%len(schemas)]
+			keyErrs, err := validateSchema(context.Background(), schema, []byte(`{"city":"Oslo","count":1}`))
+			if err != nil {
+				t.Errorf("failed to validate: %v", err)
+			}
+			if len(keyErrs) > 0 {
+				t.Errorf("expected valid data, got %v", keyErrs)
+			}
+		}()
+	}
+	wg.Wait()
+
+	schemaLocks.lock.Lock()
+	defer schemaLocks.lock.Unlock()
+	if n := len(schemaLocks.locks); n != 0 {
+		t.Errorf("expected the locks to be released, got %d", n)
+	}
+}
@@ -1,14 +1,21 @@
 package mcp_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+	"github.com/qri-io/jsonschema"
 )
 
 func TestInitialize(t *testing.T) {
@@ -19,6 +26,8 @@ func TestInitialize(t *testing.T) {
 		clientOptions     []mcp.ClientOption
 		serverRequirement mcp.ServerRequirement
 		wantErr           bool
+
+		wantServerExperimental map[string]any
 	}
 
 	testCases := []testCase{
@@ -68,6 +77,39 @@ func TestInitialize(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:   "success with experimental capabilities",
+			server: &mockServer{},
+			serverOptions: []mcp.ServerOption{
+				mcp.WithServerExperimentalCapability("vendor.feature", map[string]any{"enabled": true}),
+			},
+			clientOptions: []mcp.ClientOption{
+				mcp.WithClientExperimentalCapability("vendor.feature", map[string]any{"enabled": true}),
+			},
+			wantErr:                false,
+			wantServerExperimental: map[string]any{"vendor.feature": map[string]any{"enabled": true}},
+		},
+		{
+			name: "success with overridden client capabilities",
+			server: &mockServer{
+				requireSamplingClient: true,
+			},
+			clientOptions: []mcp.ClientOption{
+				mcp.WithClientCapabilities(mcp.ClientCapabilities{Sampling: &mcp.SamplingCapability{}}),
+			},
+			wantErr: false,
+		},
+		{
+			name: "fail suppressed client capabilities",
+			server: &mockServer{
+				requireSamplingClient: true,
+			},
+			clientOptions: []mcp.ClientOption{
+				mcp.WithSamplingHandler(mockSamplingHandler{}),
+				mcp.WithClientCapabilities(mcp.ClientCapabilities{}),
+			},
+			wantErr: true,
+		},
 		{
 			name: "fail insufficient client capabilities",
 			server: &mockServer{
@@ -138,12 +180,23 @@ func TestInitialize(t *testing.T) {
 					if err == nil {
 						t.Errorf("expected error, got nil")
 					}
+					if version := cli.ProtocolVersion(); version != "" {
+						t.Errorf("expected no protocol version after a failed initialization, got %q", version)
+					}
 					return
 				}
 				if err != nil {
 					t.Errorf("unexpected error: %v", err)
 					return
 				}
+				if version := cli.ProtocolVersion(); version != "2024-11-05" {
+					t.Errorf("expected protocol version 2024-11-05, got %q", version)
+				}
+
+				experimental := cli.ServerCapabilities().Experimental
+				if !reflect.DeepEqual(experimental, tc.wantServerExperimental) {
+					t.Errorf("expected experimental capabilities %v, got %v", tc.wantServerExperimental, experimental)
+				}
 			})
 		}
 	}
@@ -159,7 +212,7 @@ func TestPrompt(t *testing.T) {
 		{
 			name: "list",
 			testFunc: func(t *testing.T, cli *mcp.Client, mockPs *mockPromptServer) {
-				_, err := cli.ListPrompts(context.Background(), mcp.ListPromptsParams{
+				res, err := cli.ListPrompts(context.Background(), mcp.ListPromptsParams{
 					Cursor: "cursor",
 				})
 				if err != nil {
@@ -170,12 +223,21 @@ func TestPrompt(t *testing.T) {
 				if mockPs.listParams.Cursor != "cursor" {
 					t.Errorf("expected cursor cursor, got %s", mockPs.listParams.Cursor)
 				}
+				if len(res.Prompts) != 1 || len(res.Prompts[0].Arguments) != 1 {
+					t.Fatalf("expected 1 prompt with 1 argument, got %+v", res.Prompts)
+				}
+				if choices := res.Prompts[0].Arguments[0].Choices; len(choices) != 3 {
+					t.Errorf("expected 3 choices, got %v", choices)
+				}
+				if title := res.Prompts[0].Title; title != "Test Prompt" {
+					t.Errorf("expected title Test Prompt, got %q", title)
+				}
 			},
 		},
 		{
 			name: "get",
 			testFunc: func(t *testing.T, cli *mcp.Client, mockPs *mockPromptServer) {
-				_, err := cli.GetPrompt(context.Background(), mcp.GetPromptParams{
+				res, err := cli.GetPrompt(context.Background(), mcp.GetPromptParams{
 					Name: "test-prompt",
 				})
 				if err != nil {
@@ -186,16 +248,29 @@ func TestPrompt(t *testing.T) {
 				if mockPs.getParams.Name != "test-prompt" {
 					t.Errorf("expected prompt name test-prompt, got %s", mockPs.getParams.Name)
 				}
+				if len(res.Messages) != 2 {
+					t.Fatalf("expected 2 messages, got %d", len(res.Messages))
+				}
+				if got := res.Messages[0].FirstContent().Text; got != "instruction" {
+					t.Errorf("expected single content message with text instruction, got %s", got)
+				}
+				if content := res.Messages[1].Content; len(content) != 2 || content[1].Type != mcp.ContentTypeImage {
+					t.Errorf("expected text and image contents, got %+v", content)
+				}
 			},
 		},
 		{
 			name: "completes",
 			testFunc: func(t *testing.T, cli *mcp.Client, mockPs *mockPromptServer) {
-				_, err := cli.CompletesPrompt(context.Background(), mcp.CompletesCompletionParams{
+				res, err := cli.CompletesPrompt(context.Background(), mcp.CompletesCompletionParams{
 					Ref: mcp.CompletionRef{
 						Type: mcp.CompletionRefPrompt,
 						Name: "test-prompt",
 					},
+					Argument: mcp.CompletionArgument{
+						Name:  "style",
+						Value: "f",
+					},
 				})
 				if err != nil {
 					t.Errorf("unexpected error: %v", err)
@@ -205,6 +280,10 @@ func TestPrompt(t *testing.T) {
 				if mockPs.completesParams.Ref.Name != "test-prompt" {
 					t.Errorf("expected prompt name test-prompt, got %s", mockPs.completesParams.Ref.Name)
 				}
+				want := []string{"formal", "friendly"}
+				if !reflect.DeepEqual(res.Completion.Values, want) {
+					t.Errorf("expected completions %v, got %v", want, res.Completion.Values)
+				}
 			},
 		},
 	}
@@ -269,7 +348,7 @@ func TestResource(t *testing.T) {
 		{
 			name: "list",
 			testFunc: func(t *testing.T, cli *mcp.Client, mockRs *mockResourceServer) {
-				_, err := cli.ListResources(context.Background(), mcp.ListResourcesParams{
+				res, err := cli.ListResources(context.Background(), mcp.ListResourcesParams{
 					Cursor: "cursor",
 				})
 				if err != nil {
@@ -280,6 +359,19 @@ func TestResource(t *testing.T) {
 				if mockRs.listParams.Cursor != "cursor" {
 					t.Errorf("expected cursor cursor, got %s", mockRs.listParams.Cursor)
 				}
+				if len(res.Resources) != 2 {
+					t.Fatalf("expected 2 resources, got %d", len(res.Resources))
+				}
+				if size := res.Resources[0].Size; size == nil || *size != 1024 {
+					t.Errorf("expected size 1024, got %v", size)
+				}
+				if size := res.Resources[1].Size; size != nil {
+					t.Errorf("expected no size, got %d", *size)
+				}
+				if res.Resources[0].Title != "Test Resource" || res.Resources[1].Title != "" {
+					t.Errorf("expected titles Test Resource and none, got %q and %q",
+						res.Resources[0].Title, res.Resources[1].Title)
+				}
 			},
 		},
 		{
@@ -319,7 +411,7 @@ func TestResource(t *testing.T) {
 		{
 			name: "completes",
 			testFunc: func(t *testing.T, cli *mcp.Client, mockRs *mockResourceServer) {
-				_, err := cli.CompletesResourceTemplate(context.Background(), mcp.CompletesCompletionParams{
+				res, err := cli.CompletesResourceTemplate(context.Background(), mcp.CompletesCompletionParams{
 					Ref: mcp.CompletionRef{
 						Type: mcp.CompletionRefResource,
 						Name: "test-resource",
@@ -333,6 +425,9 @@ func TestResource(t *testing.T) {
 				if mockRs.completesTemplateParams.Ref.Name != "test-resource" {
 					t.Errorf("expected resource name test-resource, got %s", mockRs.completesTemplateParams.Ref.Name)
 				}
+				if total := res.Completion.Total; total == nil || *total != 250 {
+					t.Errorf("expected a total of 250 completions, got %v", total)
+				}
 			},
 		},
 		{
@@ -354,7 +449,16 @@ func TestResource(t *testing.T) {
 		{
 			name: "unsubscribe",
 			testFunc: func(t *testing.T, cli *mcp.Client, mockRs *mockResourceServer) {
-				err := cli.UnsubscribeResource(context.Background(), mcp.UnsubscribeResourceParams{
+				// Only the unsubscription from a subscribed resource reaches the resource server.
+				err := cli.SubscribeResource(context.Background(), mcp.SubscribeResourceParams{
+					URI: "test://resource",
+				})
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+
+				err = cli.UnsubscribeResource(context.Background(), mcp.UnsubscribeResourceParams{
 					URI: "test://resource",
 				})
 				if err != nil {
@@ -419,6 +523,95 @@ func TestResource(t *testing.T) {
 	}
 }
 
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func TestResourceStream(t *testing.T) {
+	// Several chunks, with a partial one at the end.
+	content := bytes.Repeat([]byte("0123456789abcdef"), 10_000)
+
+	type testCase struct {
+		name         string
+		server       mcp.ResourceServer
+		writer       func(*bytes.Buffer) io.Writer
+		wantContent  []byte
+		wantErr      bool
+		wantStreamed bool
+	}
+
+	testCases := []testCase{
+		{
+			name: "streamed",
+			server: &mockStreamableResourceServer{
+				mockBlobResourceServer: mockBlobResourceServer{&mockResourceServer{}, content},
+			},
+			wantContent:  content,
+			wantStreamed: true,
+		},
+		{
+			// Without streaming, the whole resource is a single message, which must fit in a StdIO line.
+			name:        "fallback",
+			server:      mockBlobResourceServer{&mockResourceServer{}, content[:1000]},
+			wantContent: content[:1000],
+		},
+		{
+			name: "write error",
+			server: &mockStreamableResourceServer{
+				mockBlobResourceServer: mockBlobResourceServer{&mockResourceServer{}, content},
+			},
+			writer:       func(*bytes.Buffer) io.Writer { return failingWriter{} },
+			wantErr:      true,
+			wantStreamed: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			serverTransport, clientTransport := setupStdIO()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithResourceServer(tc.server))
+
+			cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport,
+				mcp.ServerRequirement{ResourceServer: true})
+			defer cli.Close()
+
+			if err := cli.Connect(); err != nil {
+				t.Fatalf("failed to connect: %v", err)
+			}
+
+			var buf bytes.Buffer
+			var w io.Writer = &buf
+			if tc.writer != nil {
+				w = tc.writer(&buf)
+			}
+
+			resource, err := cli.ReadResourceStream(ctx, mcp.ReadResourceParams{URI: "test://big"}, w)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if resource.URI != "test://big" {
+					t.Errorf("expected resource test://big, got %s", resource.URI)
+				}
+				if !bytes.Equal(buf.Bytes(), tc.wantContent) {
+					t.Errorf("expected %d bytes of content, got %d", len(tc.wantContent), buf.Len())
+				}
+			}
+
+			if srv, ok := tc.server.(*mockStreamableResourceServer); ok && srv.streamed != tc.wantStreamed {
+				t.Errorf("expected streamed %v, got %v", tc.wantStreamed, srv.streamed)
+			}
+		})
+	}
+}
+
 func TestTool(t *testing.T) {
 	type testCase struct {
 		name     string
@@ -429,7 +622,7 @@ func TestTool(t *testing.T) {
 		{
 			name: "list",
 			testFunc: func(t *testing.T, cli *mcp.Client, mockTs *mockToolServer) {
-				_, err := cli.ListTools(context.Background(), mcp.ListToolsParams{
+				res, err := cli.ListTools(context.Background(), mcp.ListToolsParams{
 					Cursor: "cursor",
 				})
 				if err != nil {
@@ -440,13 +633,20 @@ func TestTool(t *testing.T) {
 				if mockTs.listParams.Cursor != "cursor" {
 					t.Errorf("expected cursor cursor, got %s", mockTs.listParams.Cursor)
 				}
+				if len(res.Tools) != 1 || res.Tools[0].Title != "Test Tool" {
+					t.Errorf("expected a tool titled Test Tool, got %+v", res.Tools)
+				}
 			},
 		},
 		{
 			name: "call",
 			testFunc: func(t *testing.T, cli *mcp.Client, mockTs *mockToolServer) {
-				_, err := cli.CallTool(context.Background(), mcp.CallToolParams{
+				res, err := cli.CallTool(context.Background(), mcp.CallToolParams{
 					Name: "test-tool",
+					Meta: mcp.ParamsMeta{
+						ProgressToken: "token",
+						Extra:         map[string]any{"traceId": "trace"},
+					},
 				})
 				if err != nil {
 					t.Errorf("unexpected error: %v", err)
@@ -456,6 +656,21 @@ func TestTool(t *testing.T) {
 				if mockTs.callParams.Name != "test-tool" {
 					t.Errorf("expected tool name test-tool, got %s", mockTs.callParams.Name)
 				}
+				if mockTs.callParams.Meta.ProgressToken != "token" {
+					t.Errorf("expected progress token token, got %s", mockTs.callParams.Meta.ProgressToken)
+				}
+				if got := mockTs.callParams.Meta.Extra["traceId"]; got != "trace" {
+					t.Errorf("expected meta traceId trace, got %v", got)
+				}
+				if got := res.Meta["traceId"]; got != "trace" {
+					t.Errorf("expected result meta traceId trace, got %v", got)
+				}
+				if mockTs.callMethod != mcp.MethodToolsCall {
+					t.Errorf("expected request method %s, got %s", mcp.MethodToolsCall, mockTs.callMethod)
+				}
+				if mockTs.callID == "" {
+					t.Error("expected request ID in context")
+				}
 			},
 		},
 	}
@@ -510,6 +725,335 @@ func TestTool(t *testing.T) {
 	}
 }
 
+func TestLogLevel(t *testing.T) {
+	levels := []mcp.LogLevel{
+		mcp.LogLevelDebug, mcp.LogLevelInfo, mcp.LogLevelNotice, mcp.LogLevelWarning,
+		mcp.LogLevelError, mcp.LogLevelCritical, mcp.LogLevelAlert, mcp.LogLevelEmergency,
+	}
+	for _, level := range levels {
+		if got := mcp.LogLevelFromSlog(level.ToSlog()); got != level {
+			t.Errorf("expected %s to round trip through slog, got %s", level, got)
+		}
+		parsed, err := mcp.ParseLogLevel(level.String())
+		if err != nil || parsed != level {
+			t.Errorf("expected to parse %s, got %s, err %v", level, parsed, err)
+		}
+	}
+
+	// The levels slog lacks are placed between and above the slog levels.
+	if got := mcp.LogLevelNotice.ToSlog(); got <= slog.LevelInfo || got >= slog.LevelWarn {
+		t.Errorf("expected notice between slog info and warn, got %s", got)
+	}
+	if got := mcp.LogLevelEmergency.ToSlog(); got <= mcp.LogLevelAlert.ToSlog() {
+		t.Errorf("expected emergency above alert, got %s", got)
+	}
+
+	fromSlog := map[slog.Level]mcp.LogLevel{
+		slog.LevelDebug - 4:   mcp.LogLevelDebug,
+		slog.LevelInfo:        mcp.LogLevelInfo,
+		slog.LevelInfo + 1:    mcp.LogLevelInfo,
+		slog.LevelWarn - 1:    mcp.LogLevelNotice,
+		slog.LevelWarn:        mcp.LogLevelWarning,
+		slog.LevelError:       mcp.LogLevelError,
+		slog.LevelError + 5:   mcp.LogLevelCritical,
+		slog.LevelError + 100: mcp.LogLevelEmergency,
+	}
+	for level, want := range fromSlog {
+		if got := mcp.LogLevelFromSlog(level); got != want {
+			t.Errorf("expected slog level %s to map to %s, got %s", level, want, got)
+		}
+	}
+
+	for name, want := range map[string]mcp.LogLevel{"WARN": mcp.LogLevelWarning, "emerg": mcp.LogLevelEmergency} {
+		if got, err := mcp.ParseLogLevel(name); err != nil || got != want {
+			t.Errorf("expected %q to parse as %s, got %s, err %v", name, want, got, err)
+		}
+	}
+	if _, err := mcp.ParseLogLevel("verbose"); err == nil {
+		t.Error("expected error parsing an unknown level")
+	}
+}
+
+func TestContentType(t *testing.T) {
+	for _, contentType := range []mcp.ContentType{mcp.ContentTypeText, mcp.ContentTypeImage,
+		mcp.ContentTypeResource, mcp.ContentTypeResourceLink} {
+		if !contentType.Valid() {
+			t.Errorf("expected %s to be valid", contentType)
+		}
+	}
+
+	var content mcp.Content
+	if err := json.Unmarshal([]byte(`{"type":"image","data":"aGk=","mimeType":"image/png"}`), &content); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if content.Type != mcp.ContentTypeImage || content.Data != "aGk=" || content.MimeType != "image/png" {
+		t.Errorf("expected image content, got %+v", content)
+	}
+
+	var result mcp.CallToolResult
+	err := json.Unmarshal([]byte(`{"content":[{"type":"text","text":"ok"},{"type":"video","data":"..."}]}`), &result)
+	if err == nil || !strings.Contains(err.Error(), `invalid content type "video"`) {
+		t.Errorf("expected invalid content type error, got %v", err)
+	}
+	var msg mcp.PromptMessage
+	if err := json.Unmarshal([]byte(`{"role":"user","content":{"text":"untyped"}}`), &msg); err == nil {
+		t.Error("expected error for a content without type")
+	}
+}
+
+func TestMessageErrorNullID(t *testing.T) {
+	msgErr := mcp.MessageError{Err: mcp.JSONRPCError{Code: -32700, Message: "Parse error"}}
+	data, err := json.Marshal(msgErr.Response())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(data), `"id":null`) {
+		t.Errorf("expected a null ID, got %s", data)
+	}
+
+	var msg mcp.JSONRPCMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if msg.ID != "" || msg.Error == nil || msg.Error.Code != -32700 {
+		t.Errorf("expected parse error without ID, got %+v", msg)
+	}
+
+	data, err = json.Marshal(mcp.JSONRPCMessage{JSONRPC: mcp.JSONRPCVersion, Method: "notifications/initialized"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(string(data), `"id"`) {
+		t.Errorf("expected no ID in a notification, got %s", data)
+	}
+}
+
+func TestPromptMessageEmptyContent(t *testing.T) {
+	data, err := json.Marshal(mcp.PromptMessage{Role: mcp.PromptRoleUser})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `{"role":"user","content":[]}`; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+}
+
+func TestRequestMeta(t *testing.T) {
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "trace"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			meta, ok := mcp.MetaFromContext(ctx)
+			if !ok {
+				return mcp.CallToolResult{}, errors.New("no request in context")
+			}
+			extra, err := json.Marshal(meta.Extra)
+			return mcp.CallToolResult{StructuredContent: extra}, err
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), mcp.WithToolServer(registry))
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ToolServer: true,
+	})
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	if _, ok := mcp.MetaFromContext(ctx); ok {
+		t.Error("expected no metadata in a context that isn't a request's")
+	}
+
+	metaCtx := mcp.ContextWithRequestMeta(ctx, map[string]any{"traceId": "abc", "hint": "from context"})
+	result, err := cli.CallTool(metaCtx, mcp.CallToolParams{
+		Name: "trace",
+		Meta: mcp.ParamsMeta{Extra: map[string]any{"hint": "from params"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to call tool: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(result.StructuredContent, &got); err != nil {
+		t.Fatalf("failed to unmarshal metadata: %v", err)
+	}
+	want := map[string]any{"traceId": "abc", "hint": "from params"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the handler to see metadata %v, got %v", want, got)
+	}
+}
+
+func TestJSONNumberArguments(t *testing.T) {
+	// 2^53 + 1, the smallest positive integer a float64 can't hold.
+	const big int64 = 9007199254740993
+
+	var msg mcp.JSONRPCMessage
+	if err := json.Unmarshal([]byte(`{"jsonrpc":"2.0","id":9007199254740993,"method":"ping"}`), &msg); err != nil {
+		t.Fatalf("failed to unmarshal message: %v", err)
+	}
+	if msg.ID != "9007199254740993" {
+		t.Errorf("expected the numeric ID to be kept exactly, got %s", msg.ID)
+	}
+
+	serverTransport, clientTransport := setupStdIO()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "lookup"},
+		func(_ context.Context, params mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			id, ok := params.Arguments["id"].(json.Number)
+			if !ok {
+				return mcp.CallToolResult{}, fmt.Errorf("expected id as json.Number, got %T", params.Arguments["id"])
+			}
+			structured, err := json.Marshal(map[string]any{"id": id})
+			return mcp.CallToolResult{StructuredContent: structured}, err
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+	go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error),
+		mcp.WithToolServer(registry), mcp.WithJSONNumberArguments(true))
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+		ToolServer: true,
+	})
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	type record struct {
+		ID int64 `json:"id"`
+	}
+	got, err := mcp.CallToolTyped[record](ctx, cli, "lookup", record{ID: big})
+	if err != nil {
+		t.Fatalf("failed to call tool: %v", err)
+	}
+	if got.ID != big {
+		t.Errorf("expected the argument to round-trip as %d, got %d", big, got.ID)
+	}
+}
+
+func TestToolDryRun(t *testing.T) {
+	var deleted []string
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{
+		Name:        "delete",
+		InputSchema: jsonschema.Must(`{"type":"object","properties":{"path":{"type":"string"}},"required":["path"]}`),
+	}, func(ctx context.Context, params mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+		path, _ := params.Arguments["path"].(string)
+		if mcp.IsDryRun(ctx) {
+			return mcp.CallToolResult{Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: "would delete " + path}}}, nil
+		}
+		deleted = append(deleted, path)
+		return mcp.CallToolResult{Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: "deleted " + path}}}, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	connect := func(t *testing.T, options ...mcp.ServerOption) *mcp.Client {
+		serverTransport, clientTransport := setupStdIO()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		options = append(options, mcp.WithToolServer(registry), mcp.WithToolInputValidation(true))
+		go mcp.Serve(ctx, mockServer{}, serverTransport, make(chan error), options...)
+
+		cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, clientTransport, mcp.ServerRequirement{
+			ToolServer: true,
+		})
+		t.Cleanup(cli.Close)
+		if err := cli.Connect(); err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		return cli
+	}
+
+	t.Run("supported", func(t *testing.T) {
+		cli := connect(t, mcp.WithToolDryRun(true))
+
+		params := mcp.CallToolParams{Name: "delete", Arguments: map[string]any{"path": "/tmp/a"}}
+		result, err := cli.CallToolDryRun(context.Background(), params)
+		if err != nil {
+			t.Fatalf("failed to dry run tool: %v", err)
+		}
+		if result.Content[0].Text != "would delete /tmp/a" || len(deleted) != 0 {
+			t.Errorf("expected the dry run to delete nothing, got %q and deleted %v", result.Content[0].Text, deleted)
+		}
+
+		invalid := mcp.CallToolParams{Name: "delete", Arguments: map[string]any{"path": 1}}
+		if _, err := cli.CallToolDryRun(context.Background(), invalid); err == nil {
+			t.Error("expected error dry running a tool with invalid arguments")
+		}
+
+		if _, err := cli.CallTool(context.Background(), params); err != nil {
+			t.Fatalf("failed to call tool: %v", err)
+		}
+		if len(deleted) != 1 || deleted[0] != "/tmp/a" {
+			t.Errorf("expected the call to delete /tmp/a, deleted %v", deleted)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		deleted = nil
+		cli := connect(t)
+
+		params := mcp.CallToolParams{Name: "delete", Arguments: map[string]any{"path": "/tmp/a"}}
+		if _, err := cli.CallToolDryRun(context.Background(), params); err == nil {
+			t.Error("expected error dry running a tool on a server without dry runs")
+		}
+
+		// A dry run requested by hand is rejected rather than run for real.
+		params.Meta.Extra = map[string]any{"dryRun": true}
+		if _, err := cli.CallTool(context.Background(), params); err == nil {
+			t.Error("expected error for a dry run on a server without dry runs")
+		}
+		if len(deleted) != 0 {
+			t.Errorf("expected nothing to be deleted, deleted %v", deleted)
+		}
+	})
+}
+
+func TestMime(t *testing.T) {
+	mediaType, params := mcp.ParseMime("Text/HTML; Charset=UTF-8")
+	if mediaType != "text/html" || params["charset"] != "UTF-8" {
+		t.Errorf("expected text/html with charset UTF-8, got %s %v", mediaType, params)
+	}
+	if mediaType, params := mcp.ParseMime("not a mime type"); mediaType != "" || params != nil {
+		t.Errorf("expected no media type, got %s %v", mediaType, params)
+	}
+
+	tests := map[string]bool{
+		"text/plain":                          true,
+		"application/json":                    true,
+		"application/vnd.api+json":            true,
+		"image/svg+xml":                       true,
+		"application/octet-stream":            false,
+		"image/png":                           false,
+		"":                                    false,
+		"text/plain; charset=utf-8":           true,
+		"text/plain; charset=ISO-8859-1":      false,
+		"application/x-custom; charset=utf-8": true,
+	}
+	for mimeType, want := range tests {
+		if got := mcp.IsTextMime(mimeType); got != want {
+			t.Errorf("expected IsTextMime(%q) to be %t, got %t", mimeType, want, got)
+		}
+	}
+}
+
 func setupSSE() (mcp.SSEServer, *mcp.SSEClient, *httptest.Server) {
 	srv := mcp.NewSSEServer()
 
@@ -540,3 +1084,6 @@ func setupStdIO() (mcp.StdIO, mcp.StdIO) {
 
 	return srvIO, cliIO
 }
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
@@ -0,0 +1,68 @@
+package mcp
+
+import (
+	"mime"
+	"strings"
+)
+
+// textMimeSuffixes are the structured syntax suffixes of media types whose content is text, like
+// application/ld+json.
+var textMimeSuffixes = []string{"+json", "+xml", "+yaml"}
+
+// textMimeTypes are the media types outside of text/* whose content is text.
+var textMimeTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/yaml":       true,
+	"application/x-yaml":     true,
+	"application/javascript": true,
+	"application/ecmascript": true,
+	"application/x-sh":       true,
+	"application/sql":        true,
+	"application/graphql":    true,
+	"application/toml":       true,
+	"image/svg+xml":          true,
+}
+
+// ParseMime parses the MimeType of a resource or content, such as "text/plain; charset=utf-8", into
+// its lowercased media type and its parameters, keyed by lowercased name. It returns an empty media
+// type and nil parameters if mimeType isn't a valid MIME type. Malformed parameters are ignored.
+func ParseMime(mimeType string) (string, map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	if err != nil && mediaType == "" {
+		return "", nil
+	}
+	if err != nil {
+		params = nil
+	}
+	return mediaType, params
+}
+
+// IsTextMime reports whether the content of the given MIME type is text, which is carried in the
+// Text of a resource, rather than base64-encoded in its Blob. Text holds UTF-8, so content with a
+// charset parameter other than UTF-8 or US-ASCII isn't text, whatever its media type, as it can't
+// be carried without conversion. Content with a UTF-8 charset parameter is text.
+func IsTextMime(mimeType string) bool {
+	mediaType, params := ParseMime(mimeType)
+	if mediaType == "" {
+		return false
+	}
+	if charset, ok := params["charset"]; ok {
+		switch strings.ToLower(charset) {
+		case "utf-8", "utf8", "us-ascii", "ascii":
+			return true
+		default:
+			return false
+		}
+	}
+
+	if strings.HasPrefix(mediaType, "text/") || textMimeTypes[mediaType] {
+		return true
+	}
+	for _, suffix := range textMimeSuffixes {
+		if strings.HasSuffix(mediaType, suffix) {
+			return true
+		}
+	}
+	return false
+}
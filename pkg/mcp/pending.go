@@ -0,0 +1,147 @@
+package mcp
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// pendingRequests tracks the outgoing requests that are still awaiting a response from the
+// other party. It's used by both the client and the server sessions.
+//
+// Every request is registered with a deadline. A background sweeper completes the requests that
+// are still pending after their deadline with errRequestTimeout and removes them, so a response
+// that never arrives doesn't leave a waiter or a map entry behind.
+type pendingRequests struct {
+	timeout time.Duration
+	clock   Clock
+
+	lock     sync.Mutex
+	requests map[string]pendingRequest
+}
+
+type pendingRequest struct {
+	deadline time.Time
+	results  chan pendingResult
+}
+
+type pendingResult struct {
+	msg JSONRPCMessage
+	err error
+}
+
+const maxPendingSweepInterval = time.Second
+
+var errRequestTimeout = errors.New("request timeout")
+
+func newPendingRequests(timeout time.Duration, clock Clock) *pendingRequests {
+	return &pendingRequests{
+		timeout:  timeout,
+		clock:    clock,
+		requests: make(map[string]pendingRequest),
+	}
+}
+
+// add registers a request with the given ID, and returns the channel that receives either its
+// response or a timeout error. The channel receives exactly one result.
+func (p *pendingRequests) add(id string) <-chan pendingResult {
+	return p.addWithTimeout(id, p.timeout)
+}
+
+// addWithTimeout is like add, but times the request out after the given timeout instead of the
+// timeout of p.
+func (p *pendingRequests) addWithTimeout(id string, timeout time.Duration) <-chan pendingResult {
+	// The channel is buffered, so resolving a request never blocks on a waiter that gave up.
+	results := make(chan pendingResult, 1)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.requests[id] = pendingRequest{
+		deadline: p.clock.Now().Add(timeout),
+		results:  results,
+	}
+
+	return results
+}
+
+// resolve delivers the response to the request with the given ID. It reports false if there is
+// no such pending request, e.g. because it already timed out.
+func (p *pendingRequests) resolve(id string, msg JSONRPCMessage) bool {
+	req, ok := p.take(id)
+	if !ok {
+		return false
+	}
+	req.results <- pendingResult{msg: msg}
+	return true
+}
+
+// remove discards the request with the given ID without delivering anything, for waiters that
+// stopped waiting on their own.
+func (p *pendingRequests) remove(id string) {
+	p.take(id)
+}
+
+// sweep completes every request whose deadline is before now with errRequestTimeout.
+func (p *pendingRequests) sweep(now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for id, req := range p.requests {
+		if req.deadline.After(now) {
+			continue
+		}
+		delete(p.requests, id)
+		req.results <- pendingResult{err: errRequestTimeout}
+	}
+}
+
+// sweepUntil runs sweep periodically until done is closed. It's meant to be run in a goroutine.
+func (p *pendingRequests) sweepUntil(done <-chan struct{}) {
+	interval := min(p.timeout, maxPendingSweepInterval)
+	ticker := p.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C():
+			// The ticks of a ticker that fell behind are dropped, so the time of the tick may be
+			// long gone.
+			p.sweep(p.clock.Now())
+		}
+	}
+}
+
+// extend pushes the deadline of the request with the given ID back by the timeout, for requests
+// whose response is known to be in progress, like streamed resource reads.
+func (p *pendingRequests) extend(id string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	req, ok := p.requests[id]
+	if !ok {
+		return
+	}
+	req.deadline = p.clock.Now().Add(p.timeout)
+	p.requests[id] = req
+}
+
+func (p *pendingRequests) count() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.requests)
+}
+
+func (p *pendingRequests) take(id string) (pendingRequest, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	req, ok := p.requests[id]
+	if ok {
+		delete(p.requests, id)
+	}
+	return req, ok
+}
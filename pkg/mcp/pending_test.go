@@ -0,0 +1,155 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// recordingTransport is a ServerTransport that only sends messages, recording them on sent.
+type recordingTransport struct {
+	ServerTransport
+
+	sent chan JSONRPCMessage
+}
+
+func TestPendingRequestsSweep(t *testing.T) {
+	p := newPendingRequests(time.Minute, realClock{})
+
+	unanswered := p.add("unanswered")
+	answered := p.add("answered")
+
+	if !p.resolve("answered", JSONRPCMessage{ID: "answered"}) {
+		t.Fatal("expected answered request to be resolved")
+	}
+	if res := <-answered; res.err != nil || res.msg.ID != "answered" {
+		t.Errorf("expected response to answered request, got %+v", res)
+	}
+
+	p.sweep(time.Now())
+	if p.count() != 1 {
+		t.Fatalf("expected request to be pending before its deadline, got %d pending", p.count())
+	}
+
+	p.sweep(time.Now().Add(2 * time.Minute))
+	if p.count() != 0 {
+		t.Errorf("expected no pending requests after the deadline, got %d", p.count())
+	}
+
+	select {
+	case res := <-unanswered:
+		if !errors.Is(res.err, errRequestTimeout) {
+			t.Errorf("expected timeout error, got %v", res.err)
+		}
+	default:
+		t.Fatal("expected timeout to be delivered to the waiter")
+	}
+
+	if p.resolve("unanswered", JSONRPCMessage{ID: "unanswered"}) {
+		t.Error("expected late response to be dropped")
+	}
+}
+
+func TestSessionRequestCleanup(t *testing.T) {
+	tests := []struct {
+		name string
+		// finish ends the request sent to the client, by answering it or cancelling it.
+		finish  func(s *session, req JSONRPCMessage, cancel context.CancelFunc)
+		wantErr bool
+	}{
+		{
+			name: "answered",
+			finish: func(s *session, req JSONRPCMessage, _ context.CancelFunc) {
+				s.handleResult(JSONRPCMessage{JSONRPC: JSONRPCVersion, ID: req.ID, Result: []byte(`{}`)})
+			},
+		},
+		{
+			name: "answered with error",
+			finish: func(s *session, req JSONRPCMessage, _ context.CancelFunc) {
+				s.handleResult(JSONRPCMessage{JSONRPC: JSONRPCVersion, ID: req.ID, Error: &JSONRPCError{
+					Code:    jsonRPCInternalErrorCode,
+					Message: errMsgInternalError,
+				}})
+			},
+		},
+		{
+			name: "cancelled",
+			finish: func(_ *session, _ JSONRPCMessage, cancel context.CancelFunc) {
+				cancel()
+			},
+			wantErr: true,
+		},
+		{
+			name:    "timed out",
+			finish:  func(*session, JSONRPCMessage, context.CancelFunc) {},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sessCtx, sessCancel := context.WithCancel(context.Background())
+			defer sessCancel()
+
+			transport := recordingTransport{sent: make(chan JSONRPCMessage, 10)}
+			s := &session{
+				ctx:                sessCtx,
+				cancel:             sessCancel,
+				transport:          transport,
+				writeTimeout:       time.Second,
+				methodTimeouts:     map[string]time.Duration{MethodSamplingCreateMessage: 50 * time.Millisecond},
+				requestIDGenerator: newUUID,
+				clock:              realClock{},
+				serverRequests:     newPendingRequests(time.Minute, realClock{}),
+				errs:               &errorReporter{errs: make(chan error, 10)},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			errs := make(chan error, 1)
+			go func() {
+				_, err := s.sendRequest(ctx, JSONRPCMessage{
+					JSONRPC: JSONRPCVersion,
+					Method:  MethodSamplingCreateMessage,
+				})
+				errs <- err
+			}()
+
+			req := <-transport.sent
+			if s.serverRequests.count() != 1 {
+				t.Fatalf("expected the request to be pending, got %d pending", s.serverRequests.count())
+			}
+			test.finish(s, req, cancel)
+
+			select {
+			case err := <-errs:
+				if (err != nil) != test.wantErr {
+					t.Errorf("expected error %t, got %v", test.wantErr, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("timeout waiting for the request to end")
+			}
+			if n := s.serverRequests.count(); n != 0 {
+				t.Errorf("expected no pending requests, got %d", n)
+			}
+			if !test.wantErr {
+				return
+			}
+			select {
+			case msg := <-transport.sent:
+				if msg.Method != methodNotificationsCancelled {
+					t.Errorf("expected a %s notification, got %+v", methodNotificationsCancelled, msg)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("timeout waiting for the cancellation notification")
+			}
+		})
+	}
+}
+
+func (t recordingTransport) Send(_ context.Context, msg SessionMsg) error {
+	t.sent <- msg.Msg
+	return nil
+}
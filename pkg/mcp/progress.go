@@ -0,0 +1,305 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// CounterProgress reports the progress of an operation as a count out of a total, such as "n of
+// total files processed", so handlers don't deal with ProgressParams and channels themselves. A
+// total of zero means it's unknown.
+//
+// Progress is clamped to the total, if known, and never goes backwards, as clients expect it to
+// increase with each report: an update that doesn't increase it isn't reported. The reports of an
+// unknown total have no Total, so clients show indeterminate progress.
+//
+// CounterProgress is safe for concurrent use.
+type CounterProgress struct {
+	lock     sync.Mutex
+	token    MustString
+	total    float64
+	progress float64
+	reported bool
+
+	// send reports the progress, on reports or to the session of the request it was created for.
+	send    func(ProgressParams)
+	reports chan ProgressParams
+}
+
+// progressStream queues the progress notifications the client sends for a request of the server, for
+// requestWithProgress to deliver. Queuing never blocks, so a slow reader of the progress never holds
+// up the other messages of the session.
+type progressStream struct {
+	lock     sync.Mutex
+	progress []ProgressParams
+	// ready is signalled when progress is queued.
+	ready chan struct{}
+}
+
+var _ ProgressReporter = (*CounterProgress)(nil)
+
+// NewCounterProgress creates a CounterProgress for the operation with the given progress token, out
+// of total, whose reports are received from ProgressReports. It can be passed to WithProgressReporter
+// by a server tracking a single operation, or have its reports forwarded elsewhere.
+//
+// The channel of ProgressReports only holds the latest report: a report that wasn't received before
+// the next one is superseded by it, so a slow reader never holds up the operation.
+//
+// To report the progress of the request being handled, use RequestProgress instead.
+func NewCounterProgress(token string, total float64) *CounterProgress {
+	c := &CounterProgress{
+		token:   MustString(token),
+		total:   total,
+		reports: make(chan ProgressParams, 1),
+	}
+	c.send = c.enqueue
+	return c
+}
+
+// RequestProgress returns a CounterProgress out of total for the client request being handled with
+// ctx, like the one passed to the methods of the server interfaces. Its reports are sent straight
+// to the client, with the progress token of the request, without a ProgressReporter. It reports
+// false if ctx wasn't created for handling a client request, or the request has no progress token.
+func RequestProgress(ctx context.Context, total float64) (*CounterProgress, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok || info.meta.ProgressToken == "" {
+		return nil, false
+	}
+
+	return &CounterProgress{
+		token: info.meta.ProgressToken,
+		total: total,
+		send: func(params ProgressParams) {
+			info.session.sendNotification(methodNotificationsProgress, params)
+		},
+	}, true
+}
+
+// ProgressReports implements ProgressReporter interface. It returns nil for a CounterProgress
+// created with RequestProgress, whose reports are sent to the client directly.
+func (c *CounterProgress) ProgressReports() <-chan ProgressParams {
+	return c.reports
+}
+
+// Inc increases the progress by one.
+func (c *CounterProgress) Inc() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.set(c.progress + 1)
+}
+
+// Set sets the progress to n.
+func (c *CounterProgress) Set(n float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.set(n)
+}
+
+func (c *CounterProgress) set(n float64) {
+	if c.total > 0 {
+		n = min(n, c.total)
+	}
+	if c.reported && n <= c.progress {
+		return
+	}
+	c.progress = n
+	c.reported = true
+
+	params := ProgressParams{
+		ProgressToken: c.token,
+		Progress:      c.progress,
+	}
+	if c.total > 0 {
+		total := c.total
+		params.Total = &total
+	}
+	c.send(params)
+}
+
+// enqueue puts the report on the reports channel, superseding the report that's still there.
+func (c *CounterProgress) enqueue(params ProgressParams) {
+	for {
+		select {
+		case c.reports <- params:
+			return
+		default:
+		}
+		select {
+		case <-c.reports:
+		default:
+		}
+	}
+}
+
+// RequestClientProgress sends msg as a request to the client whose request is being handled with
+// ctx, like the one passed to the methods of the server interfaces, and sends the progress the client
+// reports for it on progress, for handlers relaying the progress of a long request, such as sampling,
+// to their own client or user. All the progress is sent before RequestClientProgress returns, which
+// closes progress.
+//
+// The request carries a progress token of its own, in the _meta of its params, replacing any given
+// one, so the params of msg must be a JSON object, or empty. The client reports progress with
+// progress notifications with that token. Like with RequestClient, the request is abandoned when ctx
+// is done.
+//
+// An error is returned if ctx wasn't created for handling a client request, or the request fails.
+func RequestClientProgress(
+	ctx context.Context,
+	msg JSONRPCMessage,
+	progress chan<- ProgressParams,
+) (JSONRPCMessage, error) {
+	defer close(progress)
+
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok {
+		return JSONRPCMessage{}, errors.New("context wasn't created for handling a client request")
+	}
+
+	return info.session.requestWithProgress(ctx, msg, func(params ProgressParams) error {
+		select {
+		case progress <- params:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// requestWithProgress sends msg as a request to the client with a new progress token, and calls
+// report with the progress the client reports for it, in order, until the response arrives. The
+// client sends the progress before the response, so report is called with all of it.
+func (s *session) requestWithProgress(
+	ctx context.Context,
+	msg JSONRPCMessage,
+	report func(ProgressParams) error,
+) (JSONRPCMessage, error) {
+	token := MustString(newUUID())
+	params, err := withProgressToken(msg.Params, token)
+	if err != nil {
+		return JSONRPCMessage{}, err
+	}
+	msg.Params = params
+
+	stream := &progressStream{ready: make(chan struct{}, 1)}
+	s.progressStreams.Store(token, stream)
+	defer s.progressStreams.Delete(token)
+
+	type response struct {
+		msg JSONRPCMessage
+		err error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		msg, err := s.sendRequest(ctx, msg)
+		responses <- response{msg: msg, err: err}
+	}()
+
+	for {
+		select {
+		case <-stream.ready:
+			if err := stream.deliver(report); err != nil {
+				return JSONRPCMessage{}, err
+			}
+		case res := <-responses:
+			if res.err != nil {
+				return JSONRPCMessage{}, res.err
+			}
+			if err := stream.deliver(report); err != nil {
+				return JSONRPCMessage{}, err
+			}
+			return res.msg, nil
+		}
+	}
+}
+
+// withProgressToken sets the progress token in the _meta of params, keeping the rest of them.
+func withProgressToken(params json.RawMessage, token MustString) (json.RawMessage, error) {
+	return editParamsMeta(params, func(meta map[string]json.RawMessage) error {
+		var err error
+		if meta["progressToken"], err = json.Marshal(token); err != nil {
+			return fmt.Errorf("failed to marshal progress token: %w", err)
+		}
+		return nil
+	})
+}
+
+// editParamsMeta decodes the _meta of params, lets edit change its fields, and encodes params back,
+// keeping the rest of them.
+func editParamsMeta(params json.RawMessage, edit func(meta map[string]json.RawMessage) error) (json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &fields); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal params: %w", err)
+		}
+		if fields == nil {
+			fields = make(map[string]json.RawMessage)
+		}
+	}
+
+	meta := make(map[string]json.RawMessage)
+	if raw, ok := fields["_meta"]; ok {
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal params meta: %w", err)
+		}
+		if meta == nil {
+			meta = make(map[string]json.RawMessage)
+		}
+	}
+
+	if err := edit(meta); err != nil {
+		return nil, err
+	}
+	var err error
+	if fields["_meta"], err = json.Marshal(meta); err != nil {
+		return nil, fmt.Errorf("failed to marshal params meta: %w", err)
+	}
+
+	paramsBs, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal params: %w", err)
+	}
+	return paramsBs, nil
+}
+
+// handleNotificationsProgress queues the progress carried by a progress notification of the client,
+// for the request of the server it reports on. Other progress notifications are ignored.
+func (s *session) handleNotificationsProgress(params ProgressParams) {
+	st, ok := s.progressStreams.Load(params.ProgressToken)
+	if !ok {
+		return
+	}
+	stream, _ := st.(*progressStream)
+	stream.push(params)
+}
+
+func (st *progressStream) push(params ProgressParams) {
+	st.lock.Lock()
+	st.progress = append(st.progress, params)
+	st.lock.Unlock()
+
+	select {
+	case st.ready <- struct{}{}:
+	default:
+	}
+}
+
+// deliver calls report with the queued progress, until it fails.
+func (st *progressStream) deliver(report func(ProgressParams) error) error {
+	st.lock.Lock()
+	pending := st.progress
+	st.progress = nil
+	st.lock.Unlock()
+
+	for _, params := range pending {
+		if err := report(params); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
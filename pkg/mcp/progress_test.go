@@ -0,0 +1,114 @@
+package mcp_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+)
+
+func TestCounterProgress(t *testing.T) {
+	counter := mcp.NewCounterProgress("op", 3)
+
+	counter.Inc()
+	params := <-counter.ProgressReports()
+	if params.ProgressToken != "op" || params.Progress != 1 || params.Total == nil || *params.Total != 3 {
+		t.Errorf("expected progress 1 of 3 for op, got %+v", params)
+	}
+
+	// An unreceived report is superseded by the next one.
+	counter.Inc()
+	counter.Set(10)
+	if params := <-counter.ProgressReports(); params.Progress != 3 {
+		t.Errorf("expected progress clamped to 3, got %v", params.Progress)
+	}
+
+	// Progress that doesn't increase isn't reported.
+	counter.Inc()
+	counter.Set(2)
+	select {
+	case params := <-counter.ProgressReports():
+		t.Errorf("expected no report, got %+v", params)
+	default:
+	}
+}
+
+func TestIndeterminateProgress(t *testing.T) {
+	counter := mcp.NewCounterProgress("op", 0)
+
+	counter.Inc()
+	counter.Set(5)
+	params := <-counter.ProgressReports()
+	if params.Progress != 5 || params.Total != nil {
+		t.Errorf("expected progress 5 without total, got %+v", params)
+	}
+
+	bs, err := json.Marshal(params)
+	if err != nil {
+		t.Fatalf("failed to marshal progress: %v", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(bs, &fields); err != nil {
+		t.Fatalf("failed to unmarshal progress: %v", err)
+	}
+	if _, ok := fields["total"]; ok {
+		t.Errorf("expected total to be omitted, got %s", bs)
+	}
+
+	if err := json.Unmarshal([]byte(`{"progressToken":"op","value":1,"total":4}`), &params); err != nil {
+		t.Fatalf("failed to unmarshal progress: %v", err)
+	}
+	if params.Total == nil || *params.Total != 4 {
+		t.Errorf("expected total 4, got %+v", params)
+	}
+}
+
+func TestRequestProgress(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "count"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			progress, ok := mcp.RequestProgress(ctx, 2)
+			if !ok {
+				return mcp.CallToolResult{IsError: true}, nil
+			}
+			progress.Inc()
+			progress.Inc()
+			return mcp.CallToolResult{}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call",`+
+		`"params":{"name":"count","_meta":{"progressToken":"op"}}}`)
+
+	for _, want := range []float64{1, 2} {
+		msg := cli.receive(t)
+		if msg.Method != "notifications/progress" {
+			t.Fatalf("expected progress notification, got %+v", msg)
+		}
+		var params mcp.ProgressParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			t.Fatalf("failed to unmarshal progress: %v", err)
+		}
+		if params.ProgressToken != "op" || params.Progress != want || params.Total == nil || *params.Total != 2 {
+			t.Errorf("expected progress %v of 2 for op, got %+v", want, params)
+		}
+	}
+
+	msg := cli.receive(t)
+	if msg.ID != "call" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID call, got %+v", msg)
+	}
+	var result mcp.CallToolResult
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if result.IsError {
+		t.Error("expected the request to have progress tracking")
+	}
+}
@@ -0,0 +1,112 @@
+package mcp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TemplatedPromptOption represents the options for a TemplatedPrompt.
+type TemplatedPromptOption func(*TemplatedPrompt)
+
+// PromptMessageTemplate is a message of a TemplatedPrompt. Its text holds {{arg}} placeholders,
+// which are replaced by the values of the arguments with the same name.
+type PromptMessageTemplate struct {
+	Role PromptRole
+	Text string
+}
+
+// TemplatedPrompt is a prompt whose messages are built by interpolating the arguments of a
+// prompts/get request into text templates, so PromptServer implementations don't need to build
+// the messages by hand. It's meant to be used by calling GetPrompt from the GetPrompt method of the
+// server, and listing Prompt from its ListPrompts method.
+//
+// A placeholder is an argument name of letters, digits and underscores, between double braces,
+// optionally surrounded by spaces, such as {{topic}} or {{ topic }}. Text that doesn't form a
+// placeholder is kept as is. A placeholder whose argument isn't given, or is empty, is undefined:
+// GetPrompt returns an error for it, unless WithBlankUndefinedArgs is set.
+//
+// TemplatedPrompt is safe for concurrent use.
+type TemplatedPrompt struct {
+	prompt         Prompt
+	messages       []PromptMessageTemplate
+	blankUndefined bool
+}
+
+var promptPlaceholderRegexp = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// NewTemplatedPrompt creates a TemplatedPrompt for the prompt, built from the given messages.
+func NewTemplatedPrompt(
+	prompt Prompt,
+	messages []PromptMessageTemplate,
+	options ...TemplatedPromptOption,
+) *TemplatedPrompt {
+	p := &TemplatedPrompt{
+		prompt:   prompt,
+		messages: messages,
+	}
+	for _, opt := range options {
+		opt(p)
+	}
+
+	return p
+}
+
+// WithBlankUndefinedArgs sets undefined placeholders to be replaced by an empty string, instead of
+// GetPrompt returning an error for them.
+func WithBlankUndefinedArgs() TemplatedPromptOption {
+	return func(p *TemplatedPrompt) {
+		p.blankUndefined = true
+	}
+}
+
+// Prompt returns the prompt, for listing it.
+func (p *TemplatedPrompt) Prompt() Prompt {
+	return p.prompt
+}
+
+// GetPrompt builds the messages of the prompt from the arguments of params. It returns an error if a
+// required argument of the prompt is missing, or, unless WithBlankUndefinedArgs is set, if a
+// placeholder is undefined.
+func (p *TemplatedPrompt) GetPrompt(params GetPromptParams) (GetPromptResult, error) {
+	for _, arg := range p.prompt.Arguments {
+		if arg.Required && params.Arguments[arg.Name] == "" {
+			return GetPromptResult{}, fmt.Errorf("argument %q of prompt %q is required", arg.Name, p.prompt.Name)
+		}
+	}
+
+	messages := make([]PromptMessage, 0, len(p.messages))
+	for _, message := range p.messages {
+		text, err := p.interpolate(message.Text, params.Arguments)
+		if err != nil {
+			return GetPromptResult{}, err
+		}
+		messages = append(messages, PromptMessage{
+			Role:    message.Role,
+			Content: []Content{{Type: ContentTypeText, Text: text}},
+		})
+	}
+
+	return GetPromptResult{
+		Description: p.prompt.Description,
+		Messages:    messages,
+	}, nil
+}
+
+// interpolate replaces the placeholders of text by the values of args.
+func (p *TemplatedPrompt) interpolate(text string, args map[string]string) (string, error) {
+	var undefined string
+	result := promptPlaceholderRegexp.ReplaceAllStringFunc(text, func(placeholder string) string {
+		name := strings.TrimSpace(placeholder[2 : len(placeholder)-2])
+		value := args[name]
+		if value == "" && !p.blankUndefined && undefined == "" {
+			undefined = name
+		}
+		return value
+	})
+	if undefined != "" {
+		return "", fmt.Errorf("placeholder %q of prompt %q is undefined", undefined, p.prompt.Name)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package mcp_test
+
+import (
+	"testing"
+
+	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+)
+
+func TestTemplatedPrompt(t *testing.T) {
+	prompt := mcp.Prompt{
+		Name:        "review",
+		Description: "Reviews code",
+		Arguments: []mcp.PromptArgument{
+			{Name: "language", Required: true},
+			{Name: "focus"},
+		},
+	}
+	messages := []mcp.PromptMessageTemplate{
+		{Role: mcp.PromptRoleUser, Text: "Review this {{language}} code, focusing on {{ focus }}."},
+		{Role: mcp.PromptRoleAssistant, Text: "Paste the {{language}} code, {{not a placeholder}}."},
+	}
+
+	result, err := mcp.NewTemplatedPrompt(prompt, messages).GetPrompt(mcp.GetPromptParams{
+		Name:      "review",
+		Arguments: map[string]string{"language": "Go", "focus": "error handling"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Description != "Reviews code" || len(result.Messages) != 2 {
+		t.Fatalf("expected the description and 2 messages, got %+v", result)
+	}
+	want := []string{
+		"Review this Go code, focusing on error handling.",
+		"Paste the Go code, {{not a placeholder}}.",
+	}
+	for i, message := range result.Messages {
+		if message.Role != messages[i].Role || len(message.Content) != 1 || message.Content[0].Text != want[i] {
+			t.Errorf("expected message %d to be %q from %s, got %+v", i, want[i], messages[i].Role, message)
+		}
+	}
+
+	args := map[string]string{"language": "Go"}
+	if _, err := mcp.NewTemplatedPrompt(prompt, messages).GetPrompt(mcp.GetPromptParams{Arguments: args}); err == nil {
+		t.Error("expected error for an undefined placeholder")
+	}
+	result, err = mcp.NewTemplatedPrompt(prompt, messages, mcp.WithBlankUndefinedArgs()).
+		GetPrompt(mcp.GetPromptParams{Arguments: args})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if text := result.Messages[0].Content[0].Text; text != "Review this Go code, focusing on ." {
+		t.Errorf("expected the undefined placeholder to be blank, got %q", text)
+	}
+
+	_, err = mcp.NewTemplatedPrompt(prompt, messages, mcp.WithBlankUndefinedArgs()).GetPrompt(mcp.GetPromptParams{})
+	if err == nil {
+		t.Error("expected error for a missing required argument")
+	}
+}
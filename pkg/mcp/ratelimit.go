@@ -0,0 +1,99 @@
+package mcp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter decides whether the server handles a message from a client. It's consulted for every
+// request and notification the server receives, before the message is dispatched to its handler.
+//
+// Allow receives the context of the session the message belongs to, which is cancelled when the
+// session ends, the session ID, and the method of the message. When Allow returns false, a request
+// is answered with a rate limit error and a notification is dropped.
+// Pings, cancellations and the initialized notification aren't rate limited, and Allow isn't
+// called for them.
+//
+// Implementations must be safe for concurrent use, as Allow is called for all sessions.
+type RateLimiter interface {
+	Allow(ctx context.Context, session, method string) bool
+}
+
+// TokenBucketLimiter is a RateLimiter that keeps a token bucket per session and method. Each bucket
+// starts full and refills continuously at the configured rate, up to its burst size, and every
+// allowed message takes one token from it. The buckets of a session are discarded when the session
+// ends.
+type TokenBucketLimiter struct {
+	rate  float64
+	burst float64
+	clock Clock
+
+	lock     sync.Mutex
+	sessions map[string]map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// TokenBucketLimiterOption is a function that configures a TokenBucketLimiter.
+type TokenBucketLimiterOption func(*TokenBucketLimiter)
+
+// NewTokenBucketLimiter creates a TokenBucketLimiter that allows, per session and method, bursts of
+// up to burst messages, and rate messages per second on average.
+func NewTokenBucketLimiter(rate float64, burst int, options ...TokenBucketLimiterOption) *TokenBucketLimiter {
+	l := &TokenBucketLimiter{
+		rate:     rate,
+		burst:    float64(burst),
+		clock:    realClock{},
+		sessions: make(map[string]map[string]*tokenBucket),
+	}
+	for _, opt := range options {
+		opt(l)
+	}
+	return l
+}
+
+// WithTokenBucketClock sets the clock the buckets of the limiter refill on, so tests can control
+// time with a fake clock. By default, the real clock is used.
+func WithTokenBucketClock(clock Clock) TokenBucketLimiterOption {
+	return func(l *TokenBucketLimiter) {
+		l.clock = clock
+	}
+}
+
+// Allow implements RateLimiter interface.
+func (l *TokenBucketLimiter) Allow(ctx context.Context, session, method string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	buckets, ok := l.sessions[session]
+	if !ok {
+		buckets = make(map[string]*tokenBucket)
+		l.sessions[session] = buckets
+		context.AfterFunc(ctx, func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+
+			delete(l.sessions, session)
+		})
+	}
+
+	now := l.clock.Now()
+	bucket, ok := buckets[method]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		buckets[method] = bucket
+	}
+
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+
+	return true
+}
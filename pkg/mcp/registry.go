@@ -0,0 +1,213 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/qri-io/jsonschema"
+)
+
+// ToolHandler executes a single tool registered in a ToolRegistry.
+type ToolHandler func(
+	ctx context.Context,
+	params CallToolParams,
+	requestClient RequestClientFunc,
+) (CallToolResult, error)
+
+// ToolEntry pairs a tool with the handler that executes it, for bulk registration with
+// ToolRegistry.AddAll.
+type ToolEntry struct {
+	Tool    Tool
+	Handler ToolHandler
+}
+
+// ToolRegistry is a ToolServer that routes tool calls to handlers registered per tool name, so a
+// server with many tools doesn't need to dispatch tools/call by hand. Tool names are unique within
+// a registry, and registering a name twice is an error rather than silently shadowing the first
+// tool.
+//
+// ListTools returns every registered tool, in registration order. CallTool returns an error for
+// a tool that isn't registered, and for a successful result without structured content from a tool
+// that declares an OutputSchema, unless the call is a dry run, see IsDryRun. For such a tool, when
+// the result has no content, the structured content is also added as a JSON text content, for
+// clients that don't support structured results.
+//
+// ToolRegistry is safe for concurrent use.
+type ToolRegistry struct {
+	lock          sync.RWMutex
+	tools         []Tool
+	handlers      map[string]ToolHandler
+	outputSchemas map[string]*jsonschema.Schema
+}
+
+// NewToolRegistry creates an empty ToolRegistry.
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		handlers:      make(map[string]ToolHandler),
+		outputSchemas: make(map[string]*jsonschema.Schema),
+	}
+}
+
+// Add registers the tool with its handler. It returns an error if the tool has no name, or a tool
+// with the same name is already registered.
+func (r *ToolRegistry) Add(tool Tool, handler ToolHandler) error {
+	return r.AddAll([]ToolEntry{{Tool: tool, Handler: handler}})
+}
+
+// AddAll registers all the given tools. The registration is atomic: if any entry has no name, or
+// a name is duplicated within entries or with an already registered tool, an error is returned and
+// none of the entries are registered.
+func (r *ToolRegistry) AddAll(entries []ToolEntry) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	names := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		name := entry.Tool.Name
+		if name == "" {
+			return fmt.Errorf("tool name is required")
+		}
+		if _, ok := r.handlers[name]; ok {
+			return fmt.Errorf("tool %q is already registered", name)
+		}
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("tool %q is registered more than once", name)
+		}
+		names[name] = struct{}{}
+	}
+
+	for _, entry := range entries {
+		r.tools = append(r.tools, entry.Tool)
+		r.handlers[entry.Tool.Name] = entry.Handler
+		if entry.Tool.OutputSchema != nil {
+			r.outputSchemas[entry.Tool.Name] = entry.Tool.OutputSchema
+		}
+	}
+
+	return nil
+}
+
+// ListTools implements ToolServer interface.
+func (r *ToolRegistry) ListTools(context.Context, ListToolsParams, RequestClientFunc) (ListToolsResult, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	tools := make([]Tool, len(r.tools))
+	copy(tools, r.tools)
+
+	return ListToolsResult{Tools: tools}, nil
+}
+
+// CallTool implements ToolServer interface.
+func (r *ToolRegistry) CallTool(
+	ctx context.Context,
+	params CallToolParams,
+	requestClient RequestClientFunc,
+) (CallToolResult, error) {
+	r.lock.RLock()
+	handler, ok := r.handlers[params.Name]
+	outputSchema := r.outputSchemas[params.Name]
+	r.lock.RUnlock()
+
+	if !ok {
+		return CallToolResult{}, fmt.Errorf("tool not found: %s", params.Name)
+	}
+
+	result, err := handler(ctx, params, requestClient)
+	if err != nil || outputSchema == nil || result.IsError || IsDryRun(ctx) {
+		return result, err
+	}
+
+	if result.StructuredContent == nil {
+		return CallToolResult{}, fmt.Errorf("tool %s declares an output schema but returned no structured content",
+			params.Name)
+	}
+	if len(result.Content) == 0 {
+		result.Content = []Content{{Type: ContentTypeText, Text: string(result.StructuredContent)}}
+	}
+
+	return result, nil
+}
+
+// combinedToolServer is the ToolServer returned by CombineToolServers.
+type combinedToolServer struct {
+	servers []ToolServer
+}
+
+// CombineToolServers merges the given tool servers into a single ToolServer, for servers whose tools
+// are provided by several modules, each with its own ToolServer or ToolRegistry.
+//
+// ListTools returns the tools of every server, in the order the servers are given, in a single page,
+// following the pagination of each server. CallTool routes the call to the server that lists the
+// tool, and returns an error for a tool that no server lists. Both return an error if more than one
+// server lists a tool with the same name. The servers are listed on every call, so tools added to a
+// server after combining it are listed and routed too.
+func CombineToolServers(servers ...ToolServer) ToolServer {
+	return combinedToolServer{servers: servers}
+}
+
+// ListTools implements ToolServer interface.
+func (c combinedToolServer) ListTools(
+	ctx context.Context,
+	params ListToolsParams,
+	requestClient RequestClientFunc,
+) (ListToolsResult, error) {
+	tools, _, err := c.listTools(ctx, params.Meta, requestClient)
+	if err != nil {
+		return ListToolsResult{}, err
+	}
+
+	return ListToolsResult{Tools: tools}, nil
+}
+
+// CallTool implements ToolServer interface.
+func (c combinedToolServer) CallTool(
+	ctx context.Context,
+	params CallToolParams,
+	requestClient RequestClientFunc,
+) (CallToolResult, error) {
+	_, owners, err := c.listTools(ctx, ParamsMeta{}, requestClient)
+	if err != nil {
+		return CallToolResult{}, err
+	}
+
+	server, ok := owners[params.Name]
+	if !ok {
+		return CallToolResult{}, fmt.Errorf("tool not found: %s", params.Name)
+	}
+
+	return server.CallTool(ctx, params, requestClient)
+}
+
+// listTools lists the tools of all the servers, along with the server that lists each tool.
+func (c combinedToolServer) listTools(
+	ctx context.Context,
+	meta ParamsMeta,
+	requestClient RequestClientFunc,
+) ([]Tool, map[string]ToolServer, error) {
+	var tools []Tool
+	owners := make(map[string]ToolServer)
+	for i, server := range c.servers {
+		params := ListToolsParams{Meta: meta}
+		for {
+			result, err := server.ListTools(ctx, params, requestClient)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to list tools of server %d: %w", i, err)
+			}
+			for _, tool := range result.Tools {
+				if _, ok := owners[tool.Name]; ok {
+					return nil, nil, fmt.Errorf("tool %q is listed by more than one server", tool.Name)
+				}
+				owners[tool.Name] = server
+				tools = append(tools, tool)
+			}
+			if result.NextCursor == "" {
+				break
+			}
+			params.Cursor = result.NextCursor
+		}
+	}
+
+	return tools, owners, nil
+}
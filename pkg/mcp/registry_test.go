@@ -0,0 +1,182 @@
+package mcp_test
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+	"github.com/qri-io/jsonschema"
+)
+
+type pagedToolServer struct {
+	pages [][]mcp.Tool
+}
+
+func TestToolRegistry(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+
+	handler := func(text string) mcp.ToolHandler {
+		return func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			return mcp.CallToolResult{
+				Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: text}},
+			}, nil
+		}
+	}
+
+	err := registry.AddAll([]mcp.ToolEntry{
+		{Tool: mcp.Tool{Name: "echo"}, Handler: handler("echo")},
+		{Tool: mcp.Tool{Name: "add"}, Handler: handler("add")},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := registry.Add(mcp.Tool{Name: "echo"}, handler("other echo")); err == nil {
+		t.Error("expected error registering a duplicate tool")
+	}
+
+	err = registry.AddAll([]mcp.ToolEntry{
+		{Tool: mcp.Tool{Name: "sub"}, Handler: handler("sub")},
+		{Tool: mcp.Tool{Name: "sub"}, Handler: handler("sub")},
+	})
+	if err == nil {
+		t.Error("expected error registering duplicates within the same batch")
+	}
+
+	list, err := registry.ListTools(context.Background(), mcp.ListToolsParams{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Tools) != 2 || list.Tools[0].Name != "echo" || list.Tools[1].Name != "add" {
+		t.Errorf("expected tools echo and add, got %+v", list.Tools)
+	}
+
+	res, err := registry.CallTool(context.Background(), mcp.CallToolParams{Name: "add"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Content[0].Text != "add" {
+		t.Errorf("expected call to be routed to add, got %s", res.Content[0].Text)
+	}
+
+	if _, err := registry.CallTool(context.Background(), mcp.CallToolParams{Name: "sub"}, nil); err == nil {
+		t.Error("expected error calling an unregistered tool")
+	}
+}
+
+func TestToolRegistryOutputSchema(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+
+	schema := jsonschema.Must(`{"type":"object","required":["total"]}`)
+	structured := func(content json.RawMessage) mcp.ToolHandler {
+		return func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			return mcp.CallToolResult{StructuredContent: content}, nil
+		}
+	}
+	err := registry.AddAll([]mcp.ToolEntry{
+		{Tool: mcp.Tool{Name: "sum", OutputSchema: schema}, Handler: structured(json.RawMessage(`{"total":3}`))},
+		{Tool: mcp.Tool{Name: "broken", OutputSchema: schema}, Handler: structured(nil)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res, err := registry.CallTool(context.Background(), mcp.CallToolParams{Name: "sum"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Content) != 1 || res.Content[0].Text != `{"total":3}` {
+		t.Errorf("expected structured content as text content, got %+v", res.Content)
+	}
+
+	if _, err := registry.CallTool(context.Background(), mcp.CallToolParams{Name: "broken"}, nil); err == nil {
+		t.Error("expected error for a result without structured content")
+	}
+}
+
+func TestCombineToolServers(t *testing.T) {
+	handler := func(text string) mcp.ToolHandler {
+		return func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			return mcp.CallToolResult{
+				Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: text}},
+			}, nil
+		}
+	}
+
+	math := mcp.NewToolRegistry()
+	if err := math.Add(mcp.Tool{Name: "add"}, handler("math add")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	text := mcp.NewToolRegistry()
+	if err := text.Add(mcp.Tool{Name: "echo"}, handler("text echo")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	paged := pagedToolServer{pages: [][]mcp.Tool{{{Name: "first"}}, {{Name: "second"}}}}
+
+	combined := mcp.CombineToolServers(math, text, paged)
+
+	list, err := combined.ListTools(context.Background(), mcp.ListToolsParams{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, tool := range list.Tools {
+		names = append(names, tool.Name)
+	}
+	if strings.Join(names, ",") != "add,echo,first,second" || list.NextCursor != "" {
+		t.Errorf("expected tools add, echo, first and second in a single page, got %v", names)
+	}
+
+	for name, want := range map[string]string{"add": "math add", "echo": "text echo", "second": "paged second"} {
+		res, err := combined.CallTool(context.Background(), mcp.CallToolParams{Name: name}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error calling %s: %v", name, err)
+		}
+		if res.Content[0].Text != want {
+			t.Errorf("expected call to %s to be routed to %q, got %q", name, want, res.Content[0].Text)
+		}
+	}
+
+	if _, err := combined.CallTool(context.Background(), mcp.CallToolParams{Name: "sub"}, nil); err == nil {
+		t.Error("expected error calling a tool no server lists")
+	}
+
+	if err := text.Add(mcp.Tool{Name: "add"}, handler("text add")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := combined.ListTools(context.Background(), mcp.ListToolsParams{}, nil); err == nil {
+		t.Error("expected error listing a tool served by more than one server")
+	}
+	if _, err := combined.CallTool(context.Background(), mcp.CallToolParams{Name: "echo"}, nil); err == nil {
+		t.Error("expected error calling a tool while tool names collide")
+	}
+}
+
+func (s pagedToolServer) ListTools(
+	_ context.Context,
+	params mcp.ListToolsParams,
+	_ mcp.RequestClientFunc,
+) (mcp.ListToolsResult, error) {
+	page := 0
+	if params.Cursor != "" {
+		page, _ = strconv.Atoi(params.Cursor)
+	}
+	result := mcp.ListToolsResult{Tools: s.pages[page]}
+	if page+1 < len(s.pages) {
+		result.NextCursor = strconv.Itoa(page + 1)
+	}
+	return result, nil
+}
+
+func (s pagedToolServer) CallTool(
+	_ context.Context,
+	params mcp.CallToolParams,
+	_ mcp.RequestClientFunc,
+) (mcp.CallToolResult, error) {
+	return mcp.CallToolResult{
+		Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: "paged " + params.Name}},
+	}, nil
+}
@@ -0,0 +1,116 @@
+package mcp
+
+import (
+	"container/list"
+	"sync"
+)
+
+// resourceCache is a least recently used cache of the results of resource reads, keyed by resource
+// URI. It only holds the resources the client is subscribed to, as those are the only ones the
+// server notifies the client about when they change.
+type resourceCache struct {
+	lock sync.Mutex
+	size int
+
+	entries *list.List               // of *resourceCacheEntry, most recently used first
+	byURI   map[string]*list.Element // map[uri]element of entries
+	// subscribed maps the URI of each subscribed resource to its generation, which is bumped
+	// whenever the resource changes, so a read that raced with a change isn't cached.
+	subscribed map[string]uint64
+}
+
+type resourceCacheEntry struct {
+	uri    string
+	result ReadResourceResult
+}
+
+func newResourceCache(size int) *resourceCache {
+	return &resourceCache{
+		size:       size,
+		entries:    list.New(),
+		byURI:      make(map[string]*list.Element),
+		subscribed: make(map[string]uint64),
+	}
+}
+
+// get returns the cached result of reading the resource with the given URI. If there's none, it
+// returns the generation the result of reading the resource must be stored with, and whether the
+// resource can be cached at all.
+func (r *resourceCache) get(uri string) (ReadResourceResult, uint64, bool, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if elem, ok := r.byURI[uri]; ok {
+		r.entries.MoveToFront(elem)
+		entry, _ := elem.Value.(*resourceCacheEntry)
+		result := entry.result
+		result.Contents = append([]Resource(nil), result.Contents...)
+		return result, 0, true, true
+	}
+
+	gen, ok := r.subscribed[uri]
+	return ReadResourceResult{}, gen, false, ok
+}
+
+// put caches the result of reading the resource with the given URI, unless the resource changed or
+// was unsubscribed from since the read started, at generation gen.
+func (r *resourceCache) put(uri string, gen uint64, result ReadResourceResult) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if current, ok := r.subscribed[uri]; !ok || current != gen {
+		return
+	}
+	if elem, ok := r.byURI[uri]; ok {
+		r.entries.Remove(elem)
+	}
+	r.byURI[uri] = r.entries.PushFront(&resourceCacheEntry{uri: uri, result: result})
+
+	for r.entries.Len() > r.size {
+		oldest := r.entries.Back()
+		entry, _ := oldest.Value.(*resourceCacheEntry)
+		r.entries.Remove(oldest)
+		delete(r.byURI, entry.uri)
+	}
+}
+
+func (r *resourceCache) subscribe(uri string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.subscribed[uri]; !ok {
+		r.subscribed[uri] = 0
+	}
+}
+
+func (r *resourceCache) unsubscribe(uri string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.subscribed, uri)
+	r.evict(uri)
+}
+
+// invalidate drops the cached result of the resource with the given URI, as it changed. If the new
+// contents of the resource are known, they're cached instead, as long as the resource is subscribed.
+func (r *resourceCache) invalidate(uri string, contents []Resource) {
+	r.lock.Lock()
+	gen, ok := r.subscribed[uri]
+	if ok {
+		gen++
+		r.subscribed[uri] = gen
+	}
+	r.evict(uri)
+	r.lock.Unlock()
+
+	if ok && contents != nil {
+		r.put(uri, gen, ReadResourceResult{Contents: contents})
+	}
+}
+
+func (r *resourceCache) evict(uri string) {
+	if elem, ok := r.byURI[uri]; ok {
+		r.entries.Remove(elem)
+		delete(r.byURI, uri)
+	}
+}
@@ -0,0 +1,97 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// StreamSampling sends a sampling request with params to the client whose request is being handled
+// with ctx, like the one passed to the methods of the server interfaces, and sends the text of the
+// response on deltas as the client generates it, for tools relaying the response as it's typed. The
+// pieces sent on deltas form the text of the returned result once concatenated, and are all sent
+// before StreamSampling returns, which closes deltas.
+//
+// The request carries a progress token of its own, replacing the one of params. Clients whose
+// sampling handler is a StreamingSamplingHandler send the pieces as progress notifications with
+// that token, see ProgressParams.Delta, while other clients only send the result, in which case
+// nothing is sent on deltas. Like with RequestClient, the request is abandoned when ctx is done.
+//
+// An error is returned if ctx wasn't created for handling a client request, the request fails, or
+// the client answers it with an error.
+func StreamSampling(ctx context.Context, params SamplingParams, deltas chan<- string) (SamplingResult, error) {
+	defer close(deltas)
+
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok {
+		return SamplingResult{}, errors.New("context wasn't created for handling a client request")
+	}
+
+	return info.session.streamSampling(ctx, params, deltas)
+}
+
+func (s *session) streamSampling(
+	ctx context.Context,
+	params SamplingParams,
+	deltas chan<- string,
+) (SamplingResult, error) {
+	if err := params.ModelPreferences.Validate(); err != nil {
+		return SamplingResult{}, fmt.Errorf("invalid model preferences: %w", err)
+	}
+
+	paramsBs, err := json.Marshal(params)
+	if err != nil {
+		return SamplingResult{}, fmt.Errorf("failed to marshal params: %w", err)
+	}
+
+	res, err := s.requestWithProgress(ctx, JSONRPCMessage{
+		JSONRPC: JSONRPCVersion,
+		Method:  MethodSamplingCreateMessage,
+		Params:  paramsBs,
+	}, func(progress ProgressParams) error {
+		if progress.Delta == "" {
+			return nil
+		}
+		select {
+		case deltas <- progress.Delta:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil {
+		return SamplingResult{}, err
+	}
+	if res.Error != nil {
+		return SamplingResult{}, fmt.Errorf("result error: %w", res.Error)
+	}
+
+	var result SamplingResult
+	if err := json.Unmarshal(res.Result, &result); err != nil {
+		return SamplingResult{}, fmt.Errorf("failed to unmarshal sampling result: %w", err)
+	}
+	return result, nil
+}
+
+// validateSamplingParams checks the model preferences of the params of a sampling/createMessage
+// request, see SamplingModelPreferences.Validate.
+func validateSamplingParams(params json.RawMessage) error {
+	if len(params) == 0 {
+		return nil
+	}
+	var sampling struct {
+		ModelPreferences *SamplingModelPreferences `json:"modelPreferences"`
+	}
+	if err := json.Unmarshal(params, &sampling); err != nil {
+		return fmt.Errorf("failed to unmarshal sampling params: %w", err)
+	}
+	if sampling.ModelPreferences == nil {
+		return nil
+	}
+	if err := sampling.ModelPreferences.Validate(); err != nil {
+		return fmt.Errorf("invalid model preferences: %w", err)
+	}
+
+	return nil
+}
@@ -5,13 +5,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"runtime/debug"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 // Server represents the main MCP server interface that users will implement.
+//
+// RequireRootsListClient and RequireSamplingClient declare the client capabilities the server
+// depends on. The initialize request of a client that doesn't advertise them is answered with an
+// invalid params error, failing the handshake, and the client may retry with other capabilities.
 type Server interface {
 	Info() Info
 	RequireRootsListClient() bool
@@ -21,6 +30,39 @@ type Server interface {
 // ServerOption represents the options for the server.
 type ServerOption func(*server)
 
+// NotificationOverflowPolicy decides what happens to a notification for a session whose notification
+// buffer is full, see WithNotificationBuffer.
+type NotificationOverflowPolicy int
+
+// WriteTimeoutPolicy decides what happens to a session when sending a message to it takes longer
+// than the write timeout, see WithWriteTimeoutPolicy.
+type WriteTimeoutPolicy int
+
+// SessionInfo describes a session of the server, for the hooks set with WithSessionLifecycleHook.
+// ProtocolVersion, ClientInfo and ClientCapabilities are empty until the session is initialized.
+type SessionInfo struct {
+	ID                 string
+	ProtocolVersion    string
+	ClientInfo         Info
+	ClientCapabilities ClientCapabilities
+}
+
+// RequestError is an error reported on the errors channel of Serve while handling a client request,
+// such as a failure to send its response. CorrelationID identifies the request, as returned by
+// CorrelationID for the context of its handler, so the error can be matched with the other log
+// entries of the request.
+type RequestError struct {
+	CorrelationID string
+	Err           error
+}
+
+// sessionLifecycle holds the hooks set with WithSessionLifecycleHook.
+type sessionLifecycle struct {
+	onConnect     func(SessionInfo)
+	onInitialized func(SessionInfo)
+	onDisconnect  func(SessionInfo)
+}
+
 type server struct {
 	capabilities               ServerCapabilities
 	info                       Info
@@ -30,6 +72,9 @@ type server struct {
 	sessions   *sync.Map // map[sessionID]*serverSession
 	progresses *sync.Map // map[progressToken]sessionID
 
+	// handlers is a map of method to the messageHandler of its messages, see dispatchMsg.
+	handlers map[string]messageHandler
+
 	promptServer      PromptServer
 	promptListUpdater PromptListUpdater
 
@@ -43,14 +88,51 @@ type server struct {
 	rootsListWatcher RootsListWatcher
 
 	logHandler       LogHandler
+	localLogSink     *slog.Logger
+	localLogs        chan LogParams
 	progressReporter ProgressReporter
 
 	writeTimeout time.Duration
 	readTimeout  time.Duration
 	pingInterval time.Duration
 
+	methodTimeouts       map[string]time.Duration
+	defaultMethodTimeout time.Duration
+
+	requestIDGenerator func() string
+	clock              Clock
+
+	experimentalCapabilities   map[string]any
+	rateLimiter                RateLimiter
+	droppedNotificationHandler func(method, reason string)
+	notificationBuffer         int
+	notificationOverflow       NotificationOverflowPolicy
+	pingHandler                func(ctx context.Context) (json.RawMessage, error)
+	validateToolInput          bool
+	validateToolOutput         bool
+	writeTimeoutPolicy         WriteTimeoutPolicy
+	applySchemaDefaults        bool
+	jsonNumberArguments        bool
+	maxArgumentKeys            int
+	maxArgumentDepth           int
+	requireDeclaredArgs        bool
+	schemaDialect              string
+	resultInterceptor          func(ctx context.Context, method string, result any) (any, error)
+	sendListsOnInitialized     bool
+	resourceCompression        bool
+	resourceCompressionMinSize int
+	toolDryRun                 bool
+	inlineResourceUpdates      bool
+	fanoutConcurrency          int
+	broadcaster                *Broadcaster
+	lifecycle                  sessionLifecycle
+
+	// toolsListGen counts the changes of the tool list reported by the ToolListUpdater, invalidating
+	// the tools cached by the sessions.
+	toolsListGen *atomic.Uint64
+
 	sessionStopChan chan string
-	errsChan        chan error
+	errs            *errorReporter
 	closeChan       chan struct{}
 }
 
@@ -64,38 +146,142 @@ type session struct {
 	readTimeout  time.Duration
 	pingInterval time.Duration
 
+	methodTimeouts       map[string]time.Duration
+	defaultMethodTimeout time.Duration
+
+	requestIDGenerator         func() string
+	clock                      Clock
+	droppedNotificationHandler func(method, reason string)
+	pingHandler                func(ctx context.Context) (json.RawMessage, error)
+	validateToolInput          bool
+	validateToolOutput         bool
+	writeTimeoutPolicy         WriteTimeoutPolicy
+	applySchemaDefaults        bool
+	requireDeclaredArgs        bool
+	schemaDialect              string
+	resultInterceptor          func(ctx context.Context, method string, result any) (any, error)
+	sendListsOnInitialized     bool
+	resourceCompression        bool
+	resourceCompressionMinSize int
+	toolDryRun                 bool
+	lifecycle                  sessionLifecycle
+	// inlineResourceServer reads the updated resources whose contents are sent inline in their
+	// update notifications, if WithInlineResourceUpdates is set, and is nil otherwise.
+	inlineResourceServer ResourceServer
+
+	// tools caches the tools listed by the ToolServer for the session, keyed by name, as of the
+	// toolsGen change of the tool list, see findTool.
+	toolsLock    sync.Mutex
+	tools        map[string]Tool
+	toolsGen     uint64
+	toolsListGen *atomic.Uint64
+
 	// clientRequests is a map of requestID to request, used for cancelling requests
 	clientRequests sync.Map
-	// serverRequests is a map of requestID to chan JSONRPCMessage, used for mapping the result to the original request
-	serverRequests      sync.Map
+	// progressRequests maps each progress token to the IDs of the running requests sharing it, so
+	// cancelling one of them cancels the whole operation
+	progressLock     sync.Mutex
+	progressRequests map[MustString]map[MustString]struct{}
+	// serverRequests tracks the requests sent to the client, used for mapping the result to the original request
+	serverRequests      *pendingRequests
 	subscribedResources sync.Map // map[uri]struct{}
+	// progressStreams maps the progress token of each request sent to the client whose progress is
+	// tracked, with RequestClientProgress or StreamSampling, to its stream
+	progressStreams sync.Map // map[MustString]*progressStream
 
 	promptsListChan        chan struct{}
 	resourcesListChan      chan struct{}
 	resourcesSubscribeChan chan string
 	toolsListChan          chan struct{}
-	logChan                chan LogParams
+	logChan                chan []LogParams
 	progressChan           chan ProgressParams
-	errsChan               chan error
+	errs                   *errorReporter
 	stopChan               chan<- string
 
-	initLock    sync.RWMutex
-	initialized bool
+	initLock sync.RWMutex
+	// initializeHandled reports whether the client's initialize request was answered successfully,
+	// initialized whether the client then sent the initialized notification.
+	initializeHandled bool
+	initialized       bool
+	// serverCapabilities and clientCapabilities are the capabilities exchanged by the initialize
+	// request, once it was answered successfully.
+	serverCapabilities ServerCapabilities
+	clientCapabilities ClientCapabilities
+	// clientInfo is the info the client sent in the initialize request, and protocolVersion the
+	// version of the protocol agreed on, once it was answered successfully.
+	clientInfo      Info
+	protocolVersion string
+}
+
+// errorReporter delivers errors to the errors channel of Serve without blocking, until the server
+// stops and closes the channel. Sessions may still be finishing their work by then, so their
+// errors are discarded instead of being sent on the closed channel.
+type errorReporter struct {
+	lock   sync.RWMutex
+	closed bool
+	errs   chan error
 }
 
 type request struct {
-	ctx    context.Context
-	cancel context.CancelFunc
+	ctx           context.Context
+	cancel        context.CancelFunc
+	progressToken MustString
+}
+
+type requestInfo struct {
+	method  string
+	id      MustString
+	meta    ParamsMeta
+	session *session
 }
 
+type requestInfoKey struct{}
+
+// CorrelationIDKey is the key under which the correlation ID of a request is logged, in the message
+// of a RequestError. Handlers and middleware logging with the ID returned by CorrelationID should use
+// it too, so all the log entries of a request can be found with a single search.
+const CorrelationIDKey = "correlationID"
+
+const (
+	// NotificationOverflowBlock makes the server wait for room in the buffer of a session, which
+	// stalls the delivery of the notification to the other sessions until then. This is the default.
+	NotificationOverflowBlock NotificationOverflowPolicy = iota
+	// NotificationOverflowDropOldest discards the oldest buffered notification of a session to make
+	// room for the new one, so a slow session never stalls the other sessions.
+	NotificationOverflowDropOldest
+)
+
+const (
+	// WriteTimeoutDropMessage discards the message that timed out, and keeps the session open. It
+	// suits sessions whose client is merely slow, for example to consume log notifications, but the
+	// client never learns about the dropped message, and may wait forever for a dropped response.
+	// This is the default.
+	WriteTimeoutDropMessage WriteTimeoutPolicy = iota
+	// WriteTimeoutCloseSession ends the session whose message timed out, so the client notices the
+	// loss instead of carrying on with an incomplete view of the session.
+	WriteTimeoutCloseSession
+)
+
 var (
 	defaultServerWriteTimeout = 30 * time.Second
 	defaultServerReadTimeout  = 30 * time.Second
 
-	errInvalidJSON     = errors.New("invalid json")
-	errSessionNotFound = errors.New("session not found")
+	// localLogSinkBuffer is the number of log messages buffered for the local log sink.
+	localLogSinkBuffer = 100
+
+	errInvalidJSON = errors.New("invalid json")
 )
 
+// ErrSessionNotFound is returned for operations on a session that doesn't exist, or has ended, such as
+// sending a message to it, or rebinding it with SSEServer.RebindSession.
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrSessionBroken is wrapped by the errors a transport returns from Send when the session can't be
+// written to anymore, such as after its connection broke. The server closes and evicts a session
+// whose send fails with it, or with ErrSessionNotFound, and keeps the session after any other error,
+// such as a message that failed to be marshaled, or an event stream that can still be resumed.
+var ErrSessionBroken = errors.New("session broken")
+
 // Serve starts a Model Context Protocol (MCP) server and manages its lifecycle. It handles
 // client connections, protocol messages, and server capabilities according to the MCP specification.
 //
@@ -107,6 +293,15 @@ var (
 // Serve blocks until the provided context is cancelled, at which point it performs
 // a graceful shutdown by closing all active sessions and cleaning up resources.
 //
+// When a client cancels one of its requests, the context passed to the handler of the request is
+// cancelled, along with the contexts of the other running requests of the client that share its
+// progress token, as they're part of the same operation.
+//
+// Serve panics if the Info of the server has an empty name, as clients identify servers by name,
+// for example in their logs and configuration, or if the options contradict each other, such as a
+// list updater without the server of the list. ValidateServer reports the same problems as an error,
+// to check a configuration without panicking.
+//
 // Example usage:
 //
 //	srv := &MyMCPServer{} // implements Server interface
@@ -135,6 +330,203 @@ func Serve(
 	s.stop()
 }
 
+// ValidateServer checks the server and the options meant to be passed to Serve for contradictions,
+// which would confuse clients at runtime, such as an updater of a list without the server of the
+// list, whose changes would be announced for a list the clients can't fetch. It returns an error
+// listing every problem found, or nil if there's none. Serve panics with the same problems, so
+// servers built from configuration can check it first, and report the problems as they see fit.
+func ValidateServer(srv Server, options ...ServerOption) error {
+	var s server
+	for _, opt := range options {
+		opt(&s)
+	}
+
+	return s.validate(srv)
+}
+
+// validate reports the contradictions of the configuration of s with srv.
+func (s server) validate(srv Server) error {
+	var errs []error
+	if srv.Info().Name == "" {
+		errs = append(errs, errors.New("the Info of the server has an empty name"))
+	}
+
+	needs := []struct {
+		set, required bool
+		option, with  string
+	}{
+		{s.promptListUpdater != nil, s.promptServer != nil, "WithPromptListUpdater", "WithPromptServer"},
+		{s.resourceListUpdater != nil, s.resourceServer != nil, "WithResourceListUpdater", "WithResourceServer"},
+		{s.resourceSubscribedUpdater != nil, s.resourceServer != nil, "WithResourceSubscribedUpdater", "WithResourceServer"},
+		{s.toolListUpdater != nil, s.toolServer != nil, "WithToolListUpdater", "WithToolServer"},
+		{s.localLogSink != nil, s.logHandler != nil, "WithLocalLogSink", "WithLogHandler"},
+		{s.resourceCompression, s.resourceServer != nil, "WithResourceCompression", "WithResourceServer"},
+		{s.toolDryRun, s.toolServer != nil, "WithToolDryRun", "WithToolServer"},
+		{
+			s.maxArgumentKeys != 0 || s.maxArgumentDepth != 0, s.toolServer != nil,
+			"WithToolArgumentLimits", "WithToolServer",
+		},
+		{
+			s.inlineResourceUpdates, s.resourceSubscribedUpdater != nil,
+			"WithInlineResourceUpdates", "WithResourceSubscribedUpdater",
+		},
+	}
+	for _, need := range needs {
+		if need.set && !need.required {
+			errs = append(errs, fmt.Errorf("%s is set without %s", need.option, need.with))
+		}
+	}
+
+	type durationOption struct {
+		option string
+		d      time.Duration
+	}
+	durations := []durationOption{
+		{"WithServerWriteTimeout", s.writeTimeout},
+		{"WithServerReadTimeout", s.readTimeout},
+		{"WithServerPingInterval", s.pingInterval},
+		{"WithDefaultMethodTimeout", s.defaultMethodTimeout},
+	}
+	for _, method := range slices.Sorted(maps.Keys(s.methodTimeouts)) {
+		durations = append(durations, durationOption{"WithMethodTimeout for " + method, s.methodTimeouts[method]})
+	}
+	for _, duration := range durations {
+		if duration.d < 0 {
+			errs = append(errs, fmt.Errorf("%s is negative: %s", duration.option, duration.d))
+		}
+	}
+	if s.notificationBuffer < 0 {
+		errs = append(errs, fmt.Errorf("WithNotificationBuffer is negative: %d", s.notificationBuffer))
+	}
+	if s.resourceCompressionMinSize < 0 {
+		errs = append(errs, fmt.Errorf("WithResourceCompression is negative: %d", s.resourceCompressionMinSize))
+	}
+	if s.maxArgumentKeys < 0 || s.maxArgumentDepth < 0 {
+		errs = append(errs, fmt.Errorf("WithToolArgumentLimits is negative: %d keys, depth %d",
+			s.maxArgumentKeys, s.maxArgumentDepth))
+	}
+
+	return errors.Join(errs...)
+}
+
+// RequestInfo returns the method and the ID of the client request being handled, from the context
+// passed to the methods of the server interfaces, such as ToolServer.CallTool. It reports false if
+// ctx wasn't created for handling a client request.
+func RequestInfo(ctx context.Context) (string, MustString, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok {
+		return "", "", false
+	}
+	return info.method, info.id, true
+}
+
+// CorrelationID returns the correlation ID of the client request being handled with ctx, like the
+// one passed to the methods of the server interfaces. The ID is made of the session ID and the
+// request ID, so it's unique across the sessions of the server, and it's the one carried by the
+// RequestErrors reported while handling the request. It reports false if ctx wasn't created for
+// handling a client request.
+func CorrelationID(ctx context.Context) (string, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok {
+		return "", false
+	}
+	return correlationID(info.session.id, info.id), true
+}
+
+// RequestClient returns a RequestClientFunc that sends requests to the client whose request is being
+// handled with ctx, like the one passed to the methods of the server interfaces, but bound to ctx
+// itself. Handlers can derive a context with a deadline and use the returned function to keep a
+// request to the client, such as sampling, from outliving that deadline: when ctx is done before the
+// client responds, the request is abandoned, the client is notified of the cancellation, and
+// ctx.Err() is returned. It reports false if ctx wasn't created for handling a client request.
+func RequestClient(ctx context.Context) (RequestClientFunc, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok {
+		return nil, false
+	}
+	return info.session.requestClient(ctx), true
+}
+
+// CapabilitiesFromContext returns the capabilities the server and the client exchanged when the
+// session was initialized, from the context passed to the methods of the server interfaces, such
+// as ToolServer.CallTool. Handlers can check them before relying on an optional feature of the
+// client, such as sampling, which a client that doesn't advertise it would never answer. It reports
+// false if ctx wasn't created for handling a client request.
+//
+// The returned capabilities are copies, which handlers may modify without affecting the session or
+// any other session.
+func CapabilitiesFromContext(ctx context.Context) (ServerCapabilities, ClientCapabilities, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok {
+		return ServerCapabilities{}, ClientCapabilities{}, false
+	}
+
+	info.session.initLock.RLock()
+	defer info.session.initLock.RUnlock()
+
+	return info.session.serverCapabilities.clone(), info.session.clientCapabilities.clone(), true
+}
+
+// ClientInfoFromContext returns the name and version the client sent when the session was
+// initialized, from the context passed to the methods of the server interfaces, such as
+// ToolServer.CallTool, so handlers can tell which client they serve, for logging or to work around
+// the quirks of a client. It reports false if ctx wasn't created for handling a client request.
+func ClientInfoFromContext(ctx context.Context) (Info, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok {
+		return Info{}, false
+	}
+
+	info.session.initLock.RLock()
+	defer info.session.initLock.RUnlock()
+
+	return info.session.clientInfo, true
+}
+
+// ProtocolVersionFromContext returns the version of the protocol the server and the client agreed on
+// when the session was initialized, from the context passed to the methods of the server interfaces,
+// such as ToolServer.CallTool, so handlers can tell whether the client supports the features of a
+// newer version. The version is empty until the initialize request is answered. It reports false if
+// ctx wasn't created for handling a client request.
+func ProtocolVersionFromContext(ctx context.Context) (string, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok {
+		return "", false
+	}
+
+	info.session.initLock.RLock()
+	defer info.session.initLock.RUnlock()
+
+	return info.session.protocolVersion, true
+}
+
+// MetaFromContext returns the metadata the client attached to its request in the _meta field of the
+// params, such as trace IDs or hints, from the context passed to the methods of the server
+// interfaces, such as ToolServer.CallTool, so handlers and the helpers they call can read it without
+// being handed the params. Clients of this package attach it with ContextWithRequestMeta, or with the
+// Meta of the params. It reports false if ctx wasn't created for handling a client request.
+//
+// The returned metadata is a copy, whose Extra handlers may modify without affecting the request.
+func MetaFromContext(ctx context.Context) (ParamsMeta, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok {
+		return ParamsMeta{}, false
+	}
+	return ParamsMeta{
+		ProgressToken: info.meta.ProgressToken,
+		Extra:         maps.Clone(info.meta.Extra),
+	}, true
+}
+
+// IsDryRun reports whether the tool call being handled with ctx, like the one passed to
+// ToolServer.CallTool, is a dry run, see WithToolDryRun. A tool that supports dry runs checks it
+// before any side effect, and returns instead the result it would have, such as a description of
+// what it would do. It reports false if ctx wasn't created for handling a tool call.
+func IsDryRun(ctx context.Context) bool {
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	return ok && info.method == MethodToolsCall && info.meta.Extra[metaDryRun] == true
+}
+
 // WithPromptServer sets the prompt server for the server.
 func WithPromptServer(srv PromptServer) ServerOption {
 	return func(s *server) {
@@ -191,13 +583,30 @@ func WithRootsListWatcher(watcher RootsListWatcher) ServerOption {
 	}
 }
 
-// WithLogHandler sets the log handler for the server.
+// WithLogHandler sets the log handler for the server. If the handler implements BatchLogHandler,
+// its batches of log messages are sent too.
 func WithLogHandler(handler LogHandler) ServerOption {
 	return func(s *server) {
 		s.logHandler = handler
 	}
 }
 
+// WithLocalLogSink sets a logger that receives the log messages of the log handler set with
+// WithLogHandler, in addition to the clients, so the logs sent to clients can be kept locally too.
+// The MCP log levels are converted with LogLevel.ToSlog, the logger name is set as the "logger"
+// attribute, and the details are added as attributes.
+//
+// The messages are written to the sink in the background, so a slow sink never holds up the
+// delivery of the messages to the clients. Messages that arrive while the sink is too far behind
+// are dropped from the sink. The sink has no effect without a log handler.
+//
+// To write the messages to an io.Writer, use a logger such as slog.New(slog.NewTextHandler(w, nil)).
+func WithLocalLogSink(logger *slog.Logger) ServerOption {
+	return func(s *server) {
+		s.localLogSink = logger
+	}
+}
+
 // WithProgressReporter sets the progress reporter for the server.
 func WithProgressReporter(reporter ProgressReporter) ServerOption {
 	return func(s *server) {
@@ -212,6 +621,33 @@ func WithServerWriteTimeout(timeout time.Duration) ServerOption {
 	}
 }
 
+// WithMethodTimeout sets the time allowed for handling the requests with the given method, such as
+// MethodToolsCall, overriding the default set with WithDefaultMethodTimeout. Setting the same method
+// twice overrides the previous timeout, and a zero timeout exempts the requests of the client with
+// the method from the default.
+//
+// For the requests of the client, the timeout bounds the context passed to the handler, which is
+// cancelled once it elapses, failing the request. For the requests the server sends to the client,
+// such as MethodSamplingCreateMessage, it's how long the server waits for the response, instead of
+// the read timeout set with WithServerReadTimeout, before cancelling the request.
+func WithMethodTimeout(method string, timeout time.Duration) ServerOption {
+	return func(s *server) {
+		if s.methodTimeouts == nil {
+			s.methodTimeouts = make(map[string]time.Duration)
+		}
+		s.methodTimeouts[method] = timeout
+	}
+}
+
+// WithDefaultMethodTimeout sets the time allowed for handling the requests of the client whose method
+// has no timeout set with WithMethodTimeout. By default, they're not timed out. The requests the
+// server sends to the client without a timeout of their own are timed out with the read timeout.
+func WithDefaultMethodTimeout(timeout time.Duration) ServerOption {
+	return func(s *server) {
+		s.defaultMethodTimeout = timeout
+	}
+}
+
 // WithServerReadTimeout sets the read timeout for the server.
 func WithServerReadTimeout(timeout time.Duration) ServerOption {
 	return func(s *server) {
@@ -227,6 +663,284 @@ func WithServerPingInterval(interval time.Duration) ServerOption {
 	}
 }
 
+// WithServerRequestIDGenerator sets the function used to generate the IDs of requests the server
+// sends to clients, such as pings and sampling requests. The generated IDs must be unique within
+// a session. By default, random UUIDs are used.
+func WithServerRequestIDGenerator(generator func() string) ServerOption {
+	return func(s *server) {
+		s.requestIDGenerator = generator
+	}
+}
+
+// WithClock sets the clock the server measures its ping interval, read and write timeouts, and
+// method timeouts with, so tests can control time with a fake clock. By default, the real clock
+// is used.
+func WithClock(clock Clock) ServerOption {
+	return func(s *server) {
+		s.clock = clock
+	}
+}
+
+// WithServerExperimentalCapability advertises a non-standard capability with the given name and
+// value in the experimental field of the server capabilities. Setting the same name twice
+// overrides the previous value.
+func WithServerExperimentalCapability(name string, value any) ServerOption {
+	return func(s *server) {
+		if s.experimentalCapabilities == nil {
+			s.experimentalCapabilities = make(map[string]any)
+		}
+		s.experimentalCapabilities[name] = value
+	}
+}
+
+// WithRateLimiter sets the rate limiter consulted for every request and notification received by
+// the server. Requests denied by the limiter are answered with a rate limit error, and denied
+// notifications are dropped. By default, messages aren't rate limited.
+//
+// Pings, cancellations and the initialized notification bypass the limiter, so a client flooding
+// the server with calls can still cancel them, and its session can still be checked for liveness.
+func WithRateLimiter(limiter RateLimiter) ServerOption {
+	return func(s *server) {
+		s.rateLimiter = limiter
+	}
+}
+
+// WithDroppedNotificationHandler sets the function called whenever a notification for a client is
+// discarded instead of being delivered, with the method of the notification and the reason it was
+// dropped. Notifications are dropped when the session they're meant for has ended, when a progress
+// report's token doesn't belong to any session, or when sending them fails. By default, dropped
+// notifications are only reported as errors when sending them fails.
+func WithDroppedNotificationHandler(handler func(method, reason string)) ServerOption {
+	return func(s *server) {
+		s.droppedNotificationHandler = handler
+	}
+}
+
+// WithNotificationBuffer sets the number of notifications buffered per session and kind of
+// notification while they're waiting to be sent to the client. By default, notifications aren't
+// buffered, so a client that is slow to receive them holds up the delivery to every other client.
+func WithNotificationBuffer(size int) ServerOption {
+	return func(s *server) {
+		s.notificationBuffer = size
+	}
+}
+
+// WithNotificationOverflowPolicy sets what happens to a notification when the notification buffer
+// of a session is full. Notifications discarded by NotificationOverflowDropOldest are reported to the
+// handler set with WithDroppedNotificationHandler. The policy should be combined with a buffer
+// set with WithNotificationBuffer, otherwise a notification is dropped whenever the session is busy
+// sending the previous one. By default, NotificationOverflowBlock is used.
+func WithNotificationOverflowPolicy(policy NotificationOverflowPolicy) ServerOption {
+	return func(s *server) {
+		s.notificationOverflow = policy
+	}
+}
+
+// WithPingHandler sets the function that produces the result of the ping requests sent by clients,
+// for example to piggyback health or load information on them. The returned result must be a JSON
+// object. The _meta of the ping, if any, is available with MetaFromContext. The context is cancelled
+// when the client cancels the ping, or the session ends. If the handler returns an error, the ping is
+// answered with an internal error. By default, pings are answered with an empty object, holding the
+// _meta of the ping, if any.
+func WithPingHandler(handler func(ctx context.Context) (json.RawMessage, error)) ServerOption {
+	return func(s *server) {
+		s.pingHandler = handler
+	}
+}
+
+// WithToolInputValidation sets whether the server validates the arguments of tool calls against the
+// InputSchema of the called tool, before the call reaches the ToolServer, answering the calls with
+// invalid arguments with an invalid params error. The local $refs of the schema, such as those into
+// its $defs, are resolved against the schema itself. The arguments are validated after the defaults
+// applied with WithApplySchemaDefaults, and the tool is looked up as with WithToolOutputValidation,
+// with a single lookup serving all these options. By default, arguments aren't validated.
+func WithToolInputValidation(validate bool) ServerOption {
+	return func(s *server) {
+		s.validateToolInput = validate
+	}
+}
+
+// WithToolDryRun sets whether the server supports dry-run tool calls, for clients that check the
+// arguments of a call before running it, such as UIs confirming destructive tools, see
+// Client.CallToolDryRun. A call is a dry run when the _meta of its params sets dryRun to true. The
+// arguments of a dry run go through the same checks as those of any call, such as those of
+// WithToolInputValidation, and an invalid call fails the same way. A valid one reaches the
+// ToolServer, whose tools must check IsDryRun before any side effect, so this option is only meant
+// for servers whose tools all do. The results of dry runs aren't validated against output schemas.
+// The server advertises the support in the experimental capabilities. Without it, dry runs are
+// answered with an invalid params error, rather than run for real. By default, dry runs aren't
+// supported.
+func WithToolDryRun(enabled bool) ServerOption {
+	return func(s *server) {
+		s.toolDryRun = enabled
+	}
+}
+
+// WithToolOutputValidation sets whether the server validates the results of tool calls against the
+// OutputSchema of the called tool, before sending them to the client. The tool is looked up with
+// ListTools of the ToolServer, following its pagination. The listing is cached by each session, until
+// the ToolListUpdater set with WithToolListUpdater reports that the tool list changed.
+// A successful result of a tool that declares an output schema must carry structured content
+// matching it, otherwise the call is answered with an internal error. Results with IsError set
+// aren't validated. By default, results are sent as returned by the ToolServer.
+func WithToolOutputValidation(validate bool) ServerOption {
+	return func(s *server) {
+		s.validateToolOutput = validate
+	}
+}
+
+// WithWriteTimeoutPolicy sets what happens when a result, error or notification can't be sent to a
+// session within the write timeout set with WithServerWriteTimeout. In either case, the timeout
+// is reported on the errors channel. Requests sent to the client through a RequestClientFunc are
+// not affected, as their failure is reported to the caller. By default, WriteTimeoutDropMessage is
+// used.
+func WithWriteTimeoutPolicy(policy WriteTimeoutPolicy) ServerOption {
+	return func(s *server) {
+		s.writeTimeoutPolicy = policy
+	}
+}
+
+// WithRequireDeclaredArgs sets whether the server rejects the prompts/get and tools/call requests
+// that miss an argument the prompt or tool declares as required, before they reach the PromptServer
+// or ToolServer, with an invalid params error whose data lists the missing arguments. The required
+// arguments of a prompt are those marked Required, and those of a tool are the ones listed by the
+// required keyword of its InputSchema, checked after the defaults applied with
+// WithApplySchemaDefaults. The prompt or tool is looked up with ListPrompts or ListTools, and calls
+// of prompts or tools that aren't listed are passed through. By default, arguments aren't checked.
+func WithRequireDeclaredArgs(require bool) ServerOption {
+	return func(s *server) {
+		s.requireDeclaredArgs = require
+	}
+}
+
+// WithSessionLifecycleHook sets the functions called when a session connects, when its client
+// completes the initialization by sending the initialized notification, and when it disconnects,
+// for metrics, auditing or cleaning up the state kept per session. Any of them may be nil.
+//
+// The hooks are called in their own goroutines, so they never hold up the server, and a panic in a
+// hook is recovered and reported on the errors channel of Serve. As a result, the hooks of a session
+// may run concurrently, and out of order if the session is short-lived.
+func WithSessionLifecycleHook(onConnect, onInitialized, onDisconnect func(SessionInfo)) ServerOption {
+	return func(s *server) {
+		s.lifecycle = sessionLifecycle{
+			onConnect:     onConnect,
+			onInitialized: onInitialized,
+			onDisconnect:  onDisconnect,
+		}
+	}
+}
+
+// WithJSONNumberArguments sets whether the server decodes the numbers in the arguments of tool calls
+// as json.Number, instead of float64, so integers beyond the precision of a float64, such as int64
+// IDs, reach the ToolServer with their exact value. The handlers then convert them with the methods
+// of json.Number, such as Int64. By default, numbers are decoded as float64, as encoding/json does.
+func WithJSONNumberArguments(enabled bool) ServerOption {
+	return func(s *server) {
+		s.jsonNumberArguments = enabled
+	}
+}
+
+// WithToolArgumentLimits sets the maximum number of keys, counted across all their nested objects, and
+// the maximum nesting depth of the arguments of tool calls, so a client can't exhaust the memory of
+// the server with arguments made of millions of keys or of deeply nested objects. The arguments
+// object itself is at depth 1. The limits are checked by scanning the params of a call before its
+// arguments are decoded, and a call exceeding them is answered with an invalid params error. A zero
+// limit means no limit, which is the default for both.
+func WithToolArgumentLimits(maxKeys, maxDepth int) ServerOption {
+	return func(s *server) {
+		s.maxArgumentKeys = maxKeys
+		s.maxArgumentDepth = maxDepth
+	}
+}
+
+// WithApplySchemaDefaults sets whether the server fills in the arguments of tool calls with the
+// default values the InputSchema of the called tool declares for its top-level properties, before
+// the call reaches the ToolServer. Only the arguments the caller omitted are filled in. The tool is
+// looked up with ListTools of the ToolServer, as with WithToolOutputValidation, and a single lookup
+// serves both options. By default, arguments are passed as sent by the client.
+func WithApplySchemaDefaults(apply bool) ServerOption {
+	return func(s *server) {
+		s.applySchemaDefaults = apply
+	}
+}
+
+// WithSchemaDialect sets the JSON Schema dialect declared, with the $schema keyword, by the
+// InputSchema and OutputSchema of the tools listed by the server, for clients that need the dialect
+// to validate the schemas correctly, such as SchemaDialectDraft07 or SchemaDialect202012. Schemas
+// that already declare their dialect are listed unchanged. By default, the schemas are listed as
+// they are, so a schema without the $schema keyword leaves the dialect up to the client.
+func WithSchemaDialect(dialect string) ServerOption {
+	return func(s *server) {
+		s.schemaDialect = dialect
+	}
+}
+
+// WithResultInterceptor sets a function that's called with the result of every request handled by
+// the server, before the result is sent to the client, such as to redact or filter the content of
+// tool results. It's given the context and the method of the request, and returns the result that's
+// sent instead, which may be the given result itself. Results are of the types returned by the
+// handlers, such as CallToolResult or ReadResourceResult, and are nil for the methods without any,
+// such as ping. If the interceptor fails, the client receives an internal error instead of the
+// result. By default, the results are sent as the handlers return them.
+func WithResultInterceptor(
+	interceptor func(ctx context.Context, method string, result any) (any, error),
+) ServerOption {
+	return func(s *server) {
+		s.resultInterceptor = interceptor
+	}
+}
+
+// WithSendListsOnInitialized sets whether the server sends the list changed notifications of the
+// prompts, resources and tools it serves to every session once the session is initialized, that is,
+// once the client sent the initialized notification. This prompts the clients to fetch the lists
+// right away, so a client that connected after a list changed doesn't show stale data until the
+// next change. The notifications are queued like the others, see WithNotificationBuffer. By
+// default, they aren't sent.
+func WithSendListsOnInitialized(send bool) ServerOption {
+	return func(s *server) {
+		s.sendListsOnInitialized = send
+	}
+}
+
+// WithResourceCompression makes the server compress with gzip the results of resources/read
+// requests of at least minSize bytes, once marshaled, for the clients that support it, see
+// WithClientResourceCompression. It's meant for transports without compression of their own, such as
+// StdIO, where reading large resources otherwise costs bandwidth and latency. The server advertises
+// the support in the experimental capabilities, and only compresses the results of the clients that
+// advertise it too. A result that compression doesn't make smaller is sent as is. By default, results
+// aren't compressed.
+func WithResourceCompression(minSize int) ServerOption {
+	return func(s *server) {
+		s.resourceCompression = true
+		s.resourceCompressionMinSize = minSize
+	}
+}
+
+// WithInlineResourceUpdates sets whether the server sends the contents of an updated resource in
+// its notifications/resources/updated notification, next to its URI, so clients can skip reading it
+// again. The contents are read with ReadResource of the ResourceServer for each subscribed session,
+// and only sent inline up to 16KiB once encoded, above which the notification only carries the URI,
+// as it does when the read fails. Clients of this package use the inline contents to refresh their
+// resource cache, see WithResourceCache. By default, update notifications only carry the URI.
+func WithInlineResourceUpdates(inline bool) ServerOption {
+	return func(s *server) {
+		s.inlineResourceUpdates = inline
+	}
+}
+
+// WithFanoutConcurrency sets how many sessions a notification meant for all sessions, such as a list
+// change or a log message, is delivered to at once. Delivering to a session waits for the session
+// to take the notification, see WithNotificationBuffer, so with a concurrency of one a slow session
+// holds up the delivery to the sessions after it. With a higher concurrency, up to n-1 slow sessions
+// don't hold up the rest, although the next notification is still only delivered once all sessions
+// took the current one, which keeps the notifications of each session in order. By default,
+// notifications are delivered to one session at a time.
+func WithFanoutConcurrency(n int) ServerOption {
+	return func(s *server) {
+		s.fanoutConcurrency = n
+	}
+}
+
 func newServer(srv Server, transport ServerTransport, errsChan chan error, options ...ServerOption) server {
 	s := server{
 		info:            srv.Info(),
@@ -234,63 +948,114 @@ func newServer(srv Server, transport ServerTransport, errsChan chan error, optio
 		sessions:        new(sync.Map),
 		progresses:      new(sync.Map),
 		sessionStopChan: make(chan string),
-		errsChan:        errsChan,
+		errs:            &errorReporter{errs: errsChan},
+		toolsListGen:    new(atomic.Uint64),
 		closeChan:       make(chan struct{}),
 	}
 	for _, opt := range options {
 		opt(&s)
 	}
+	if err := s.validate(srv); err != nil {
+		panic(fmt.Sprintf("mcp: invalid server configuration: %v", err))
+	}
+
+	s.setDefaults()
+	if s.logHandler != nil && s.localLogSink != nil {
+		s.localLogs = make(chan LogParams, localLogSinkBuffer)
+	}
+
+	s.capabilities = s.serverCapabilities()
+	s.requiredClientCapabilities = s.clientRequirements(srv)
+	s.handlers = s.messageHandlers()
+
+	return s
+}
 
+// setDefaults sets the options left unset to their defaults.
+func (s *server) setDefaults() {
 	if s.writeTimeout == 0 {
 		s.writeTimeout = defaultServerWriteTimeout
 	}
 	if s.readTimeout == 0 {
 		s.readTimeout = defaultServerReadTimeout
 	}
+	if s.requestIDGenerator == nil {
+		s.requestIDGenerator = newUUID
+	}
+	if s.clock == nil {
+		s.clock = realClock{}
+	}
+}
 
-	s.capabilities = ServerCapabilities{}
+// serverCapabilities returns the capabilities the server advertises, following its options.
+func (s server) serverCapabilities() ServerCapabilities {
+	var caps ServerCapabilities
 
 	if s.promptServer != nil {
-		s.capabilities.Prompts = &PromptsCapability{}
+		caps.Prompts = &PromptsCapability{}
 		if s.promptListUpdater != nil {
-			s.capabilities.Prompts.ListChanged = true
+			caps.Prompts.ListChanged = true
 		}
 	}
 	if s.resourceServer != nil {
-		s.capabilities.Resources = &ResourcesCapability{}
+		caps.Resources = &ResourcesCapability{}
 		if s.resourceListUpdater != nil {
-			s.capabilities.Resources.ListChanged = true
+			caps.Resources.ListChanged = true
 		}
 		if s.resourceSubscribedUpdater != nil {
-			s.capabilities.Resources.Subscribe = true
+			caps.Resources.Subscribe = true
 		}
 	}
 	if s.toolServer != nil {
-		s.capabilities.Tools = &ToolsCapability{}
+		caps.Tools = &ToolsCapability{}
 		if s.toolListUpdater != nil {
-			s.capabilities.Tools.ListChanged = true
+			caps.Tools.ListChanged = true
 		}
 	}
 	if s.logHandler != nil {
-		s.capabilities.Logging = &LoggingCapability{}
+		caps.Logging = &LoggingCapability{}
+	}
+	caps.Experimental = maps.Clone(s.experimentalCapabilities)
+	if _, ok := s.resourceServer.(StreamableResourceServer); ok {
+		if caps.Experimental == nil {
+			caps.Experimental = make(map[string]any)
+		}
+		caps.Experimental[experimentalResourceStreaming] = map[string]any{}
+	}
+	if s.resourceCompression && s.resourceServer != nil {
+		if caps.Experimental == nil {
+			caps.Experimental = make(map[string]any)
+		}
+		caps.Experimental[experimentalResourceCompression] = map[string]any{}
 	}
+	if s.toolDryRun && s.toolServer != nil {
+		if caps.Experimental == nil {
+			caps.Experimental = make(map[string]any)
+		}
+		caps.Experimental[experimentalToolDryRun] = map[string]any{}
+	}
+
+	return caps
+}
 
-	s.requiredClientCapabilities = ClientCapabilities{}
+// clientRequirements returns the capabilities srv requires from clients, following the options.
+func (s server) clientRequirements(srv Server) ClientCapabilities {
+	var reqs ClientCapabilities
 
 	if srv.RequireRootsListClient() {
-		s.requiredClientCapabilities.Roots = &RootsCapability{}
+		reqs.Roots = &RootsCapability{}
 		if s.rootsListWatcher != nil {
-			s.requiredClientCapabilities.Roots = &RootsCapability{
+			reqs.Roots = &RootsCapability{
 				ListChanged: true,
 			}
 		}
 	}
 
 	if srv.RequireSamplingClient() {
-		s.requiredClientCapabilities.Sampling = &SamplingCapability{}
+		reqs.Sampling = &SamplingCapability{}
 	}
 
-	return s
+	return reqs
 }
 
 func (s server) start() {
@@ -306,10 +1071,16 @@ func (s server) start() {
 	if s.toolListUpdater != nil {
 		go s.listenToolsList()
 	}
+	if s.broadcaster != nil {
+		s.broadcaster.attach(s.sessions)
+	}
 
 	if s.logHandler != nil {
 		go s.listenLog()
 	}
+	if s.localLogs != nil {
+		go s.writeLocalLogs()
+	}
 	if s.progressReporter != nil {
 		go s.listenProgress()
 	}
@@ -326,15 +1097,38 @@ func (s server) listenSessions() {
 		case <-s.closeChan:
 			return
 		case id := <-s.sessionStopChan:
-			s.sessions.Delete(id)
+			if ss, ok := s.sessions.LoadAndDelete(id); ok {
+				sess, _ := ss.(*session)
+				sess.runLifecycleHook(s.lifecycle.onDisconnect)
+			}
+			if closer, ok := s.transport.(SessionCloser); ok {
+				closer.CloseSession(id)
+			}
 		case ctx := <-ctxs:
 			s.startSession(ctx.Ctx, ctx.ID)
 		case msg := <-msgs:
+			// Both channels may be ready at the same time, so make sure the session of
+			// this message is registered before handling it.
+			s.startPendingSessions(ctxs)
 			msg.Errs <- s.handleMsg(msg.SessionID, msg.Msg)
 		}
 	}
 }
 
+func (s server) startPendingSessions(ctxs <-chan SessionCtx) {
+	for {
+		select {
+		case ctx, ok := <-ctxs:
+			if !ok {
+				return
+			}
+			s.startSession(ctx.Ctx, ctx.ID)
+		default:
+			return
+		}
+	}
+}
+
 func (s server) listenPromptsList() {
 	lists := s.promptListUpdater.PromptListUpdates()
 
@@ -345,11 +1139,8 @@ func (s server) listenPromptsList() {
 		case <-lists:
 		}
 
-		s.sessions.Range(func(_, value any) bool {
-			sess, _ := value.(*session)
-			sess.promptsListChan <- struct{}{}
-			return true
-		})
+		fanOutNotification(s, methodNotificationsPromptsListChanged, struct{}{},
+			func(sess *session) chan struct{} { return sess.promptsListChan })
 	}
 }
 
@@ -363,11 +1154,8 @@ func (s server) listenResourcesList() {
 		case <-lists:
 		}
 
-		s.sessions.Range(func(_, value any) bool {
-			sess, _ := value.(*session)
-			sess.resourcesListChan <- struct{}{}
-			return true
-		})
+		fanOutNotification(s, methodNotificationsResourcesListChanged, struct{}{},
+			func(sess *session) chan struct{} { return sess.resourcesListChan })
 	}
 }
 
@@ -382,11 +1170,8 @@ func (s server) listenResourcesSubscribe() {
 		case uri = <-subscribes:
 		}
 
-		s.sessions.Range(func(_, value any) bool {
-			sess, _ := value.(*session)
-			sess.resourcesSubscribeChan <- uri
-			return true
-		})
+		fanOutNotification(s, methodNotificationsResourcesUpdated, uri,
+			func(sess *session) chan string { return sess.resourcesSubscribeChan })
 	}
 }
 
@@ -400,30 +1185,62 @@ func (s server) listenToolsList() {
 		case <-lists:
 		}
 
-		s.sessions.Range(func(_, value any) bool {
-			sess, _ := value.(*session)
-			sess.toolsListChan <- struct{}{}
-			return true
-		})
+		s.toolsListGen.Add(1)
+		fanOutNotification(s, methodNotificationsToolsListChanged, struct{}{},
+			func(sess *session) chan struct{} { return sess.toolsListChan })
 	}
 }
 
 func (s server) listenLog() {
 	logs := s.logHandler.LogStreams()
-	var params LogParams
+	var batches <-chan []LogParams
+	if handler, ok := s.logHandler.(BatchLogHandler); ok {
+		batches = handler.LogBatches()
+	}
 
 	for {
+		var batch []LogParams
 		select {
 		case <-s.closeChan:
 			return
-		case params = <-logs:
+		case params := <-logs:
+			batch = []LogParams{params}
+		case batch = <-batches:
+		}
+		if len(batch) == 0 {
+			continue
 		}
 
-		s.sessions.Range(func(_, value any) bool {
-			sess, _ := value.(*session)
-			sess.logChan <- params
-			return true
-		})
+		if s.localLogs != nil {
+			for _, params := range batch {
+				select {
+				case s.localLogs <- params:
+				default:
+				}
+			}
+		}
+
+		fanOutNotification(s, methodNotificationsMessage, batch,
+			func(sess *session) chan []LogParams { return sess.logChan })
+	}
+}
+
+// writeLocalLogs writes the log messages teed off by listenLog to the local log sink.
+func (s server) writeLocalLogs() {
+	for {
+		var params LogParams
+		select {
+		case <-s.closeChan:
+			return
+		case params = <-s.localLogs:
+		}
+
+		args := make([]any, 0, 2+2*len(params.Data.Details))
+		args = append(args, "logger", params.Logger)
+		for _, key := range slices.Sorted(maps.Keys(params.Data.Details)) {
+			args = append(args, key, params.Data.Details[key])
+		}
+		s.localLogSink.Log(context.Background(), params.Level.ToSlog(), params.Data.Message, args...)
 	}
 }
 
@@ -440,306 +1257,214 @@ func (s server) listenProgress() {
 
 		sessID, ok := s.progresses.Load(params.ProgressToken)
 		if !ok {
+			s.dropNotification(methodNotificationsProgress, "no session for progress token")
 			continue
 		}
 		ss, ok := s.sessions.Load(sessID)
 		if !ok {
+			s.dropNotification(methodNotificationsProgress, "session closed")
 			continue
 		}
 		sess, _ := ss.(*session)
-		sess.progressChan <- params
+		deliverNotification(s, sess, sess.progressChan, params, methodNotificationsProgress)
 	}
 }
 
-func (s server) startSession(ctx context.Context, sessID string) {
-	sCtx, sCancel := context.WithCancel(ctx)
-
-	sess := &session{
-		id:                     sessID,
-		ctx:                    sCtx,
-		cancel:                 sCancel,
-		transport:              s.transport,
-		writeTimeout:           s.writeTimeout,
-		readTimeout:            s.readTimeout,
-		pingInterval:           s.pingInterval,
-		promptsListChan:        make(chan struct{}),
-		resourcesListChan:      make(chan struct{}),
-		resourcesSubscribeChan: make(chan string),
-		toolsListChan:          make(chan struct{}),
-		logChan:                make(chan LogParams),
-		progressChan:           make(chan ProgressParams),
-		errsChan:               s.errsChan,
-		stopChan:               s.sessionStopChan,
-	}
-
-	s.sessions.Store(sessID, sess)
-	go sess.listen()
-	if s.pingInterval > 0 {
-		go sess.pings()
-	}
-}
-
-func (s server) handleMsg(sessionID string, msg JSONRPCMessage) error {
-	if msg.JSONRPC != JSONRPCVersion {
-		return errInvalidJSON
-	}
-
-	ss, ok := s.sessions.Load(sessionID)
-	if !ok {
-		return errSessionNotFound
-	}
-	sess, _ := ss.(*session)
-
-	// We musn't wait for the below handler to finish, as it might be blocking
-	// the client's request, and since these handlers might 'call' the client back,
-	// that would cause a deadlock. So, in each handlers below, once the params
-	// is proven to be valid, we launch a goroutine to continue the processing.
-
-	// Handle basic protocol messages
-	if err := s.handleBasicMessages(sess, msg); err != nil {
-		return err
-	}
-
-	// Handle prompt-related messages
-	if err := s.handlePromptMessages(sess, msg); err != nil {
-		return err
-	}
-
-	// Handle resource-related messages
-	if err := s.handleResourceMessages(sess, msg); err != nil {
-		return err
-	}
-
-	// Handle tool-related messages
-	if err := s.handleToolMessages(sess, msg); err != nil {
-		return err
-	}
-
-	// Handle completion messages
-	if err := s.handleCompletionMessages(sess, msg); err != nil {
-		return err
-	}
-
-	// Handle notification messages
-	if err := s.handleNotificationMessages(sess, msg); err != nil {
-		return err
-	}
-
-	// Handle result messages
-	s.handleResultMessages(sess, msg)
-
-	// Handle logging messages
-	if err := s.handleLoggingMessages(sess, msg); err != nil {
-		return err
+// fanOutNotification delivers value to the notification channel picked by notifs of every session,
+// to up to fanoutConcurrency sessions at once. It returns once all sessions were delivered to.
+func fanOutNotification[T any](s server, method string, value T, notifs func(*session) chan T) {
+	if s.fanoutConcurrency <= 1 {
+		s.sessions.Range(func(_, v any) bool {
+			sess, _ := v.(*session)
+			deliverNotification(s, sess, notifs(sess), value, method)
+			return true
+		})
+		return
 	}
 
-	return nil
-}
-
-func (s server) handleBasicMessages(sess *session, msg JSONRPCMessage) error {
-	switch msg.Method {
-	case methodPing:
-		go sess.handlePing(msg.ID)
-		return nil
-	case methodInitialize:
-		var params initializeParams
-		if err := json.Unmarshal(msg.Params, &params); err != nil {
-			return errInvalidJSON
-		}
-		go sess.handleInitialize(msg.ID, params, s.capabilities,
-			s.requiredClientCapabilities, s.info)
-		return nil
-	}
-	return nil
+	workers := make(chan struct{}, s.fanoutConcurrency)
+	var wg sync.WaitGroup
+	s.sessions.Range(func(_, v any) bool {
+		sess, _ := v.(*session)
+		workers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			deliverNotification(s, sess, notifs(sess), value, method)
+		}()
+		return true
+	})
+	wg.Wait()
 }
 
-func (s server) handlePromptMessages(sess *session, msg JSONRPCMessage) error {
-	if s.promptServer == nil {
-		return nil
-	}
-
-	switch msg.Method {
-	case MethodPromptsList:
-		var params ListPromptsParams
-		if err := json.Unmarshal(msg.Params, &params); err != nil {
-			return errInvalidJSON
-		}
-		if params.Meta.ProgressToken != "" {
-			s.progresses.Store(params.Meta.ProgressToken, sess.id)
-		}
-		go sess.handlePromptsList(msg.ID, params, s.promptServer)
-		return nil
-	case MethodPromptsGet:
-		var params GetPromptParams
-		if err := json.Unmarshal(msg.Params, &params); err != nil {
-			return errInvalidJSON
+// deliverNotification queues value on the notification channel of the session, following the
+// overflow policy of the server when the channel is full.
+func deliverNotification[T any](s server, sess *session, notifs chan T, value T, method string) {
+	if s.notificationOverflow == NotificationOverflowDropOldest {
+		if sess.ctx.Err() != nil {
+			s.dropNotification(method, "session closed")
+			return
 		}
-		if params.Meta.ProgressToken != "" {
-			s.progresses.Store(params.Meta.ProgressToken, sess.id)
+		for {
+			select {
+			case notifs <- value:
+				return
+			default:
+			}
+			if cap(notifs) == 0 {
+				s.dropNotification(method, "session busy")
+				return
+			}
+			select {
+			case <-notifs:
+				s.dropNotification(method, "notification buffer full")
+			default:
+			}
 		}
-		go sess.handlePromptsGet(msg.ID, params, s.promptServer)
-		return nil
 	}
-	return nil
-}
 
-func (s server) handleResourceMessages(sess *session, msg JSONRPCMessage) error {
-	if s.resourceServer == nil {
-		return nil
+	select {
+	case notifs <- value:
+	case <-sess.ctx.Done():
+		s.dropNotification(method, "session closed")
 	}
+}
 
-	switch msg.Method {
-	case MethodResourcesList:
-		var params ListResourcesParams
-		if err := json.Unmarshal(msg.Params, &params); err != nil {
-			return errInvalidJSON
-		}
-		if params.Meta.ProgressToken != "" {
-			s.progresses.Store(params.Meta.ProgressToken, sess.id)
-		}
-		go sess.handleResourcesList(msg.ID, params, s.resourceServer)
-		return nil
-	case MethodResourcesRead:
-		var params ReadResourceParams
-		if err := json.Unmarshal(msg.Params, &params); err != nil {
-			return errInvalidJSON
-		}
-		if params.Meta.ProgressToken != "" {
-			s.progresses.Store(params.Meta.ProgressToken, sess.id)
-		}
-		go sess.handleResourcesRead(msg.ID, params, s.resourceServer)
-		return nil
-	case MethodResourcesTemplatesList:
-		var params ListResourceTemplatesParams
-		if err := json.Unmarshal(msg.Params, &params); err != nil {
-			return errInvalidJSON
-		}
-		if params.Meta.ProgressToken != "" {
-			s.progresses.Store(params.Meta.ProgressToken, sess.id)
-		}
-		go sess.handleResourcesListTemplates(msg.ID, params, s.resourceServer)
-		return nil
-	case MethodResourcesSubscribe:
-		var params SubscribeResourceParams
-		if err := json.Unmarshal(msg.Params, &params); err != nil {
-			return errInvalidJSON
-		}
-		go sess.handleResourcesSubscribe(msg.ID, params, s.resourceServer)
-		return nil
-	case MethodResourcesUnsubscribe:
-		var params UnsubscribeResourceParams
-		if err := json.Unmarshal(msg.Params, &params); err != nil {
-			return errInvalidJSON
-		}
-		go sess.handleResourcesUnsubscribe(msg.ID, params, s.resourceServer)
-		return nil
+func (s server) dropNotification(method, reason string) {
+	if s.droppedNotificationHandler != nil {
+		s.droppedNotificationHandler(method, reason)
 	}
-	return nil
 }
 
-func (s server) handleToolMessages(sess *session, msg JSONRPCMessage) error {
-	if s.toolServer == nil {
-		return nil
-	}
+func (s server) startSession(ctx context.Context, sessID string) {
+	sCtx, sCancel := context.WithCancel(ctx)
 
-	switch msg.Method {
-	case MethodToolsList:
-		var params ListToolsParams
-		if err := json.Unmarshal(msg.Params, &params); err != nil {
-			return errInvalidJSON
-		}
-		if params.Meta.ProgressToken != "" {
-			s.progresses.Store(params.Meta.ProgressToken, sess.id)
-		}
-		go sess.handleToolsList(msg.ID, params, s.toolServer)
-		return nil
-	case MethodToolsCall:
-		var params CallToolParams
-		if err := json.Unmarshal(msg.Params, &params); err != nil {
-			return errInvalidJSON
-		}
-		if params.Meta.ProgressToken != "" {
-			s.progresses.Store(params.Meta.ProgressToken, sess.id)
-		}
-		go sess.handleToolsCall(msg.ID, params, s.toolServer)
-		return nil
+	sess := &session{
+		id:                         sessID,
+		ctx:                        sCtx,
+		cancel:                     sCancel,
+		transport:                  s.transport,
+		writeTimeout:               s.writeTimeout,
+		readTimeout:                s.readTimeout,
+		methodTimeouts:             s.methodTimeouts,
+		defaultMethodTimeout:       s.defaultMethodTimeout,
+		pingInterval:               s.pingInterval,
+		requestIDGenerator:         s.requestIDGenerator,
+		clock:                      s.clock,
+		droppedNotificationHandler: s.droppedNotificationHandler,
+		pingHandler:                s.pingHandler,
+		validateToolInput:          s.validateToolInput,
+		validateToolOutput:         s.validateToolOutput,
+		writeTimeoutPolicy:         s.writeTimeoutPolicy,
+		applySchemaDefaults:        s.applySchemaDefaults,
+		requireDeclaredArgs:        s.requireDeclaredArgs,
+		schemaDialect:              s.schemaDialect,
+		resultInterceptor:          s.resultInterceptor,
+		sendListsOnInitialized:     s.sendListsOnInitialized,
+		resourceCompression:        s.resourceCompression,
+		resourceCompressionMinSize: s.resourceCompressionMinSize,
+		toolDryRun:                 s.toolDryRun,
+		lifecycle:                  s.lifecycle,
+		serverRequests:             newPendingRequests(s.readTimeout, s.clock),
+		promptsListChan:            make(chan struct{}, s.notificationBuffer),
+		resourcesListChan:          make(chan struct{}, s.notificationBuffer),
+		resourcesSubscribeChan:     make(chan string, s.notificationBuffer),
+		toolsListChan:              make(chan struct{}, s.notificationBuffer),
+		logChan:                    make(chan []LogParams, s.notificationBuffer),
+		progressChan:               make(chan ProgressParams, s.notificationBuffer),
+		errs:                       s.errs,
+		toolsListGen:               s.toolsListGen,
+		stopChan:                   s.sessionStopChan,
+	}
+	if s.inlineResourceUpdates {
+		sess.inlineResourceServer = s.resourceServer
 	}
-	return nil
-}
 
-func (s server) handleCompletionMessages(sess *session, msg JSONRPCMessage) error {
-	if msg.Method != MethodCompletionComplete {
-		return nil
+	s.sessions.Store(sessID, sess)
+	sess.runLifecycleHook(s.lifecycle.onConnect)
+	go sess.listen()
+	go sess.serverRequests.sweepUntil(sCtx.Done())
+	if s.pingInterval > 0 {
+		go sess.pings()
 	}
+}
 
-	var params CompletesCompletionParams
-	if err := json.Unmarshal(msg.Params, &params); err != nil {
-		return errInvalidJSON
+func (s server) handleMsg(sessionID string, msg JSONRPCMessage) error {
+	if err := validateJSONRPCVersion(msg); err != nil {
+		return err
 	}
 
-	switch params.Ref.Type {
-	case CompletionRefPrompt:
-		go sess.handleCompletePrompt(msg.ID, params, s.promptServer)
-		return nil
-	case CompletionRefResource:
-		go sess.handleCompleteResource(msg.ID, params, s.resourceServer)
-		return nil
+	ss, ok := s.sessions.Load(sessionID)
+	if !ok {
+		return ErrSessionNotFound
 	}
-	return nil
-}
+	sess, _ := ss.(*session)
 
-func (s server) handleNotificationMessages(sess *session, msg JSONRPCMessage) error {
-	switch msg.Method {
-	case methodNotificationsInitialized:
-		go sess.handleNotificationsInitialized()
-	case methodNotificationsCancelled:
-		var params notificationsCancelledParams
-		if err := json.Unmarshal(msg.Params, &params); err != nil {
-			return errInvalidJSON
+	if msg.Method != "" && !isControlMethod(msg.Method) && s.rateLimiter != nil &&
+		!s.rateLimiter.Allow(sess.ctx, sessionID, msg.Method) {
+		if msg.ID == "" {
+			sess.logError(fmt.Errorf("rate limit exceeded, dropped notification %s", msg.Method))
+			return nil
 		}
-		go sess.handleNotificationsCancelled(params)
-	case methodNotificationsRootsListChanged:
-		if s.rootsListWatcher != nil {
-			s.rootsListWatcher.OnRootsListChanged()
+		return MessageError{
+			ID: msg.ID,
+			Err: JSONRPCError{
+				Code:    jsonRPCRateLimitedCode,
+				Message: errMsgRateLimited,
+				Data:    map[string]any{"method": msg.Method},
+			},
 		}
 	}
 
-	return nil
-}
-
-func (s server) handleResultMessages(sess *session, msg JSONRPCMessage) {
-	if msg.Method != "" {
-		return
+	err := s.dispatchMsg(sess, msg)
+	if !errors.Is(err, errInvalidJSON) {
+		return err
 	}
 
-	go sess.handleResult(msg)
-}
-
-func (s server) handleLoggingMessages(sess *session, msg JSONRPCMessage) error {
-	if s.logHandler == nil {
+	// Malformed params only invalidate this message, the session itself is still usable.
+	if msg.ID == "" {
+		// Notifications must not be answered, so there is nothing to report back.
+		sess.logError(fmt.Errorf("invalid params for notification %s: %w", msg.Method, err))
 		return nil
 	}
-
-	if msg.Method != MethodLoggingSetLevel {
-		return nil
+	return MessageError{
+		ID: msg.ID,
+		Err: JSONRPCError{
+			Code:    jsonRPCInvalidParamsCode,
+			Message: errMsgInvalidJSON,
+			Data:    map[string]any{"method": msg.Method},
+		},
 	}
+}
 
-	var params LogParams
-	if err := json.Unmarshal(msg.Params, &params); err != nil {
-		return errInvalidJSON
+// isControlMethod reports whether the method controls the session rather than asks for work, so its
+// messages are handled however busy the session is.
+func isControlMethod(method string) bool {
+	switch method {
+	case methodPing, methodNotificationsCancelled, methodNotificationsInitialized:
+		return true
+	default:
+		return false
 	}
-	go sess.handleLoggingSetLevel(msg.ID, params, s.logHandler)
-
-	return nil
 }
 
 func (s server) stop() {
+	if s.broadcaster != nil {
+		s.broadcaster.detach()
+	}
+	closer, canClose := s.transport.(SessionCloser)
 	s.sessions.Range(func(_, value any) bool {
 		sess, _ := value.(*session)
 		sess.cancel()
+		if canClose {
+			closer.CloseSession(sess.id)
+		}
 		return true
 	})
-	close(s.errsChan)
+	s.errs.close()
 	close(s.closeChan)
 	s.transport.Close()
 }
@@ -760,33 +1485,84 @@ func (s *session) listen() {
 				continue
 			}
 			s.sendNotification(methodNotificationsResourcesUpdated, notificationsResourcesUpdatedParams{
-				URI: uri,
+				URI:      uri,
+				Contents: s.inlineResourceContents(uri),
 			})
 		case <-s.toolsListChan:
 			s.sendNotification(methodNotificationsToolsListChanged, nil)
-		case params := <-s.logChan:
-			s.sendNotification(methodNotificationsMessage, params)
+		case batch := <-s.logChan:
+			for _, params := range batch {
+				s.sendNotification(methodNotificationsMessage, params)
+			}
 		case params := <-s.progressChan:
 			s.sendNotification(methodNotificationsProgress, params)
 		}
 	}
 }
 
+// inlineResourceContents returns the contents of the updated resource with the given URI, to be sent
+// inline in its update notification, if WithInlineResourceUpdates is set. It returns nil, for the
+// notification to only carry the URI, if the contents are larger than inlineResourceUpdateMaxSize or
+// the resource can't be read.
+func (s *session) inlineResourceContents(uri string) []Resource {
+	if s.inlineResourceServer == nil {
+		return nil
+	}
+
+	// The read holds up the notifications of the session, so it's bounded like their writes.
+	ctx, cancel := contextWithTimeout(s.ctx, s.clock, s.writeTimeout)
+	defer cancel()
+
+	result, err := s.inlineResourceServer.ReadResource(ctx, ReadResourceParams{URI: uri}, s.requestClient(ctx))
+	if err != nil {
+		s.logError(fmt.Errorf("failed to read updated resource %s: %w", uri, err))
+		return nil
+	}
+	contentsBs, err := json.Marshal(result.Contents)
+	if err != nil || len(contentsBs) > inlineResourceUpdateMaxSize {
+		return nil
+	}
+
+	return result.Contents
+}
+
 func (s *session) pings() {
-	pingTicker := time.NewTicker(s.pingInterval)
+	pingTicker := s.clock.NewTicker(s.pingInterval)
+	defer pingTicker.Stop()
 
 	for {
 		select {
 		case <-s.ctx.Done():
 			return
-		case <-pingTicker.C:
+		case <-pingTicker.C():
 			s.ping()
 		}
 	}
 }
 
-func (s *session) handlePing(msgID MustString) {
-	s.sendResult(msgID, nil)
+func (s *session) handlePing(msgID MustString, meta ParamsMeta, rawMeta json.RawMessage) {
+	defer s.recoverPanic(msgID)
+
+	ctx, cancel := s.requestContext(msgID, methodPing, meta)
+	defer cancel()
+
+	if s.pingHandler == nil {
+		s.sendResult(msgID, pingResult{Meta: rawMeta})
+		return
+	}
+
+	result, err := s.pingHandler(ctx)
+	if err != nil {
+		nErr := fmt.Errorf("failed to handle ping: %w", err)
+		s.sendError(msgID, JSONRPCError{
+			Code:    jsonRPCInternalErrorCode,
+			Message: errMsgInternalError,
+			Data:    map[string]any{"error": nErr},
+		})
+		return
+	}
+
+	s.sendResult(msgID, result)
 }
 
 func (s *session) handleInitialize(
@@ -796,9 +1572,12 @@ func (s *session) handleInitialize(
 	requiredClientCap ClientCapabilities,
 	serverInfo Info,
 ) {
+	_, cancel := s.requestContext(msgID, methodInitialize, ParamsMeta{})
+	defer cancel()
+
 	if params.ProtocolVersion != protocolVersion {
 		nErr := fmt.Errorf("protocol version mismatch: %s != %s", params.ProtocolVersion, protocolVersion)
-		s.logError(nErr)
+		s.logRequestError(msgID, nErr)
 		s.sendError(msgID, JSONRPCError{
 			Code:    jsonRPCInvalidParamsCode,
 			Message: errMsgUnsupportedProtocolVersion,
@@ -810,7 +1589,7 @@ func (s *session) handleInitialize(
 	if requiredClientCap.Roots != nil {
 		if params.Capabilities.Roots == nil {
 			nErr := fmt.Errorf("insufficient client capabilities: missing required capability 'roots'")
-			s.logError(nErr)
+			s.logRequestError(msgID, nErr)
 			s.sendError(msgID, JSONRPCError{
 				Code:    jsonRPCInvalidParamsCode,
 				Message: errMsgInsufficientClientCapabilities,
@@ -821,7 +1600,7 @@ func (s *session) handleInitialize(
 		if requiredClientCap.Roots.ListChanged {
 			if !params.Capabilities.Roots.ListChanged {
 				nErr := fmt.Errorf("insufficient client capabilities: missing required capability 'roots.listChanged'")
-				s.logError(nErr)
+				s.logRequestError(msgID, nErr)
 				s.sendError(msgID, JSONRPCError{
 					Code:    jsonRPCInvalidParamsCode,
 					Message: errMsgInsufficientClientCapabilities,
@@ -835,7 +1614,7 @@ func (s *session) handleInitialize(
 	if requiredClientCap.Sampling != nil {
 		if params.Capabilities.Sampling == nil {
 			nErr := fmt.Errorf("insufficient client capabilities: missing required capability 'sampling'")
-			s.logError(nErr)
+			s.logRequestError(msgID, nErr)
 			s.sendError(msgID, JSONRPCError{
 				Code:    jsonRPCInvalidParamsCode,
 				Message: errMsgInsufficientClientCapabilities,
@@ -845,6 +1624,17 @@ func (s *session) handleInitialize(
 		}
 	}
 
+	if !s.markInitializeHandled(protocolVersion, serverCap, params.Capabilities, params.ClientInfo) {
+		nErr := fmt.Errorf("session is already initialized")
+		s.logRequestError(msgID, nErr)
+		s.sendError(msgID, JSONRPCError{
+			Code:    jsonRPCInvalidRequestCode,
+			Message: errMsgAlreadyInitialized,
+			Data:    map[string]any{"error": nErr},
+		})
+		return
+	}
+
 	s.sendResult(msgID, initializeResult{
 		ProtocolVersion: protocolVersion,
 		Capabilities:    serverCap,
@@ -857,19 +1647,16 @@ func (s *session) handlePromptsList(
 	params ListPromptsParams,
 	server PromptServer,
 ) {
+	defer s.recoverPanic(msgID)
+
 	if !s.isInitialized() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(s.ctx)
+	ctx, cancel := s.requestContext(msgID, MethodPromptsList, params.Meta)
 	defer cancel()
 
-	s.clientRequests.Store(msgID, &request{
-		ctx:    ctx,
-		cancel: cancel,
-	})
-
-	ps, err := server.ListPrompts(ctx, params, s.sendRequest)
+	ps, err := server.ListPrompts(ctx, params, s.requestClient(ctx))
 	if err != nil {
 		nErr := fmt.Errorf("failed to list prompts: %w", err)
 		s.sendError(msgID, JSONRPCError{
@@ -888,19 +1675,41 @@ func (s *session) handlePromptsGet(
 	params GetPromptParams,
 	server PromptServer,
 ) {
+	defer s.recoverPanic(msgID)
+
 	if !s.isInitialized() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(s.ctx)
+	ctx, cancel := s.requestContext(msgID, MethodPromptsGet, params.Meta)
 	defer cancel()
 
-	s.clientRequests.Store(msgID, &request{
-		ctx:    ctx,
-		cancel: cancel,
-	})
+	if s.requireDeclaredArgs {
+		prompt, err := s.findPrompt(ctx, params.Name, server)
+		if err != nil {
+			nErr := fmt.Errorf("failed to get prompt: %w", err)
+			s.sendError(msgID, JSONRPCError{
+				Code:    jsonRPCInternalErrorCode,
+				Message: errMsgInternalError,
+				Data:    map[string]any{"error": nErr},
+			})
+			return
+		}
+		if prompt != nil {
+			var missing []string
+			for _, arg := range prompt.Arguments {
+				if _, ok := params.Arguments[arg.Name]; arg.Required && !ok {
+					missing = append(missing, arg.Name)
+				}
+			}
+			if len(missing) > 0 {
+				s.sendMissingArgsError(msgID, "prompt", params.Name, missing)
+				return
+			}
+		}
+	}
 
-	p, err := server.GetPrompt(ctx, params, s.sendRequest)
+	p, err := server.GetPrompt(ctx, params, s.requestClient(ctx))
 	if err != nil {
 		nErr := fmt.Errorf("failed to get prompt: %w", err)
 		s.sendError(msgID, JSONRPCError{
@@ -919,19 +1728,16 @@ func (s *session) handleCompletePrompt(
 	params CompletesCompletionParams,
 	server PromptServer,
 ) {
+	defer s.recoverPanic(msgID)
+
 	if !s.isInitialized() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(s.ctx)
+	ctx, cancel := s.requestContext(msgID, MethodCompletionComplete, params.Meta)
 	defer cancel()
 
-	s.clientRequests.Store(msgID, &request{
-		ctx:    ctx,
-		cancel: cancel,
-	})
-
-	result, err := server.CompletesPrompt(ctx, params, s.sendRequest)
+	result, err := server.CompletesPrompt(ctx, params, s.requestClient(ctx))
 	if err != nil {
 		nErr := fmt.Errorf("failed to complete prompt: %w", err)
 		s.sendError(msgID, JSONRPCError{
@@ -950,19 +1756,16 @@ func (s *session) handleResourcesList(
 	params ListResourcesParams,
 	server ResourceServer,
 ) {
+	defer s.recoverPanic(msgID)
+
 	if !s.isInitialized() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(s.ctx)
+	ctx, cancel := s.requestContext(msgID, MethodResourcesList, params.Meta)
 	defer cancel()
 
-	s.clientRequests.Store(msgID, &request{
-		ctx:    ctx,
-		cancel: cancel,
-	})
-
-	rs, err := server.ListResources(ctx, params, s.sendRequest)
+	rs, err := server.ListResources(ctx, params, s.requestClient(ctx))
 	if err != nil {
 		nErr := fmt.Errorf("failed to list resources: %w", err)
 		s.sendError(msgID, JSONRPCError{
@@ -981,19 +1784,21 @@ func (s *session) handleResourcesRead(
 	params ReadResourceParams,
 	server ResourceServer,
 ) {
+	defer s.recoverPanic(msgID)
+
 	if !s.isInitialized() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(s.ctx)
+	ctx, cancel := s.requestContext(msgID, MethodResourcesRead, params.Meta)
 	defer cancel()
 
-	s.clientRequests.Store(msgID, &request{
-		ctx:    ctx,
-		cancel: cancel,
-	})
+	if streamer, ok := server.(StreamableResourceServer); ok && params.Meta.Extra[metaStream] == true {
+		s.handleResourcesReadStream(ctx, msgID, params, streamer)
+		return
+	}
 
-	r, err := server.ReadResource(ctx, params, s.sendRequest)
+	r, err := server.ReadResource(ctx, params, s.requestClient(ctx))
 	if err != nil {
 		nErr := fmt.Errorf("failed to read resource: %w", err)
 		s.sendError(msgID, JSONRPCError{
@@ -1007,24 +1812,94 @@ func (s *session) handleResourcesRead(
 	s.sendResult(msgID, r)
 }
 
+func (s *session) handleResourcesReadStream(
+	ctx context.Context,
+	msgID MustString,
+	params ReadResourceParams,
+	server StreamableResourceServer,
+) {
+	stream, err := server.ReadResourceStream(ctx, params, s.requestClient(ctx))
+	if err != nil {
+		nErr := fmt.Errorf("failed to read resource stream: %w", err)
+		s.sendError(msgID, JSONRPCError{
+			Code:    jsonRPCInternalErrorCode,
+			Message: errMsgInternalError,
+			Data:    map[string]any{"error": nErr},
+		})
+		return
+	}
+	defer stream.Content.Close()
+
+	buf := make([]byte, resourceStreamChunkSize)
+	for {
+		n, rErr := io.ReadFull(stream.Content, buf)
+		if n > 0 {
+			if err := s.sendResourceChunk(ctx, msgID, buf[:n]); err != nil {
+				if ctx.Err() == nil {
+					s.logRequestError(msgID, fmt.Errorf("failed to send resource chunk: %w", err))
+				}
+				return
+			}
+		}
+		if errors.Is(rErr, io.EOF) || errors.Is(rErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if rErr != nil {
+			nErr := fmt.Errorf("failed to read resource stream: %w", rErr)
+			s.sendError(msgID, JSONRPCError{
+				Code:    jsonRPCInternalErrorCode,
+				Message: errMsgInternalError,
+				Data:    map[string]any{"error": nErr},
+			})
+			return
+		}
+	}
+
+	resource := stream.Resource
+	resource.Text = ""
+	resource.Blob = ""
+	s.sendResult(msgID, ReadResourceResult{Contents: []Resource{resource}})
+}
+
+// sendResourceChunk sends a chunk of a streamed resource read, waiting until the transport accepted
+// it, so the stream is read only as fast as the client receives it.
+func (s *session) sendResourceChunk(ctx context.Context, msgID MustString, data []byte) error {
+	paramsBs, err := json.Marshal(notificationsResourcesChunkParams{
+		RequestID: string(msgID),
+		Data:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal params: %w", err)
+	}
+
+	sCtx, sCancel := contextWithTimeout(ctx, s.clock, s.writeTimeout)
+	defer sCancel()
+
+	return s.transport.Send(sCtx, SessionMsg{
+		SessionID: s.id,
+		Msg: JSONRPCMessage{
+			JSONRPC: JSONRPCVersion,
+			Method:  methodNotificationsResourcesChunk,
+			Params:  paramsBs,
+		},
+	})
+}
+
 func (s *session) handleResourcesListTemplates(
 	msgID MustString,
 	params ListResourceTemplatesParams,
 	server ResourceServer,
 ) {
+	defer s.recoverPanic(msgID)
+
 	if !s.isInitialized() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(s.ctx)
+	ctx, cancel := s.requestContext(msgID, MethodResourcesTemplatesList, params.Meta)
 	defer cancel()
 
-	s.clientRequests.Store(msgID, &request{
-		ctx:    ctx,
-		cancel: cancel,
-	})
-
-	ts, err := server.ListResourceTemplates(ctx, params, s.sendRequest)
+	ts, err := server.ListResourceTemplates(ctx, params, s.requestClient(ctx))
 	if err != nil {
 		nErr := fmt.Errorf("failed to list resource templates: %w", err)
 		s.sendError(msgID, JSONRPCError{
@@ -1043,20 +1918,20 @@ func (s *session) handleResourcesSubscribe(
 	params SubscribeResourceParams,
 	server ResourceServer,
 ) {
+	defer s.recoverPanic(msgID)
+
 	if !s.isInitialized() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(s.ctx)
+	_, cancel := s.requestContext(msgID, MethodResourcesSubscribe, params.Meta)
 	defer cancel()
 
-	s.clientRequests.Store(msgID, &request{
-		ctx:    ctx,
-		cancel: cancel,
-	})
-
-	server.SubscribeResource(params)
-	s.subscribedResources.Store(params.URI, struct{}{})
+	// The session is notified once per update however many times it subscribed, so the resource
+	// server only learns of the first subscription.
+	if _, subscribed := s.subscribedResources.LoadOrStore(params.URI, struct{}{}); !subscribed {
+		server.SubscribeResource(params)
+	}
 
 	s.sendResult(msgID, nil)
 }
@@ -1066,42 +1941,65 @@ func (s *session) handleResourcesUnsubscribe(
 	params UnsubscribeResourceParams,
 	server ResourceServer,
 ) {
+	defer s.recoverPanic(msgID)
+
 	if !s.isInitialized() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(s.ctx)
+	_, cancel := s.requestContext(msgID, MethodResourcesUnsubscribe, params.Meta)
 	defer cancel()
 
-	s.clientRequests.Store(msgID, &request{
-		ctx:    ctx,
-		cancel: cancel,
-	})
-
-	server.UnsubscribeResource(params)
-	s.subscribedResources.Delete(params.URI)
+	// Like subscriptions, only the unsubscription from a resource the session is subscribed to is
+	// relayed to the resource server.
+	if _, subscribed := s.subscribedResources.LoadAndDelete(params.URI); subscribed {
+		server.UnsubscribeResource(params)
+	}
 
 	s.sendResult(msgID, nil)
 }
 
+func (s *session) handleResourcesSubscriptions(msgID MustString) {
+	defer s.recoverPanic(msgID)
+
+	if !s.isInitialized() {
+		return
+	}
+
+	_, cancel := s.requestContext(msgID, MethodResourcesSubscriptions, ParamsMeta{})
+	defer cancel()
+
+	s.sendResult(msgID, resourcesSubscriptionsResult{URIs: s.subscriptions()})
+}
+
+// subscriptions returns the URIs of the resources the session is subscribed to, sorted.
+func (s *session) subscriptions() []string {
+	uris := []string{}
+	s.subscribedResources.Range(func(uri, _ any) bool {
+		u, _ := uri.(string)
+		uris = append(uris, u)
+		return true
+	})
+	slices.Sort(uris)
+
+	return uris
+}
+
 func (s *session) handleCompleteResource(
 	msgID MustString,
 	params CompletesCompletionParams,
 	server ResourceServer,
 ) {
+	defer s.recoverPanic(msgID)
+
 	if !s.isInitialized() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(s.ctx)
+	ctx, cancel := s.requestContext(msgID, MethodCompletionComplete, params.Meta)
 	defer cancel()
 
-	s.clientRequests.Store(msgID, &request{
-		ctx:    ctx,
-		cancel: cancel,
-	})
-
-	result, err := server.CompletesResourceTemplate(ctx, params, s.sendRequest)
+	result, err := server.CompletesResourceTemplate(ctx, params, s.requestClient(ctx))
 	if err != nil {
 		nErr := fmt.Errorf("failed to complete resource template: %w", err)
 		s.sendError(msgID, JSONRPCError{
@@ -1120,19 +2018,16 @@ func (s *session) handleToolsList(
 	params ListToolsParams,
 	server ToolServer,
 ) {
+	defer s.recoverPanic(msgID)
+
 	if !s.isInitialized() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(s.ctx)
+	ctx, cancel := s.requestContext(msgID, MethodToolsList, params.Meta)
 	defer cancel()
 
-	s.clientRequests.Store(msgID, &request{
-		ctx:    ctx,
-		cancel: cancel,
-	})
-
-	ts, err := server.ListTools(ctx, params, s.sendRequest)
+	ts, err := server.ListTools(ctx, params, s.requestClient(ctx))
 	if err != nil {
 		nErr := fmt.Errorf("failed to list tools: %w", err)
 		s.sendError(msgID, JSONRPCError{
@@ -1143,69 +2038,537 @@ func (s *session) handleToolsList(
 		return
 	}
 
-	s.sendResult(msgID, ts)
+	if s.schemaDialect == "" {
+		s.sendResult(msgID, ts)
+		return
+	}
+	result, err := declareSchemaDialect(ts, s.schemaDialect)
+	if err != nil {
+		nErr := fmt.Errorf("failed to declare schema dialect: %w", err)
+		s.sendError(msgID, JSONRPCError{
+			Code:    jsonRPCInternalErrorCode,
+			Message: errMsgInternalError,
+			Data:    map[string]any{"error": nErr},
+		})
+		return
+	}
+	s.sendResult(msgID, result)
+}
+
+// declareSchemaDialect encodes the result, with the $schema keyword set to dialect in the input and
+// output schemas of the tools that don't declare their dialect. Schemas that aren't objects, like
+// the boolean schemas, are left as they are.
+func declareSchemaDialect(result ListToolsResult, dialect string) (json.RawMessage, error) {
+	resultBs, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal result: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(resultBs, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal result: %w", err)
+	}
+	var tools []map[string]json.RawMessage
+	if err := json.Unmarshal(fields["tools"], &tools); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tools: %w", err)
+	}
+	dialectBs, err := json.Marshal(dialect)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal dialect: %w", err)
+	}
+
+	for _, tool := range tools {
+		for _, key := range []string{"inputSchema", "outputSchema"} {
+			var schema map[string]json.RawMessage
+			if err := json.Unmarshal(tool[key], &schema); err != nil || schema == nil {
+				continue
+			}
+			if _, ok := schema["$schema"]; ok {
+				continue
+			}
+			schema["$schema"] = dialectBs
+			if tool[key], err = json.Marshal(schema); err != nil {
+				return nil, fmt.Errorf("failed to marshal schema: %w", err)
+			}
+		}
+	}
+
+	if fields["tools"], err = json.Marshal(tools); err != nil {
+		return nil, fmt.Errorf("failed to marshal tools: %w", err)
+	}
+	return json.Marshal(fields)
 }
 
 func (s *session) handleToolsCall(msgID MustString, params CallToolParams, server ToolServer) {
+	defer s.recoverPanic(msgID)
+
 	if !s.isInitialized() {
 		return
 	}
 
-	ctx, cancel := context.WithCancel(s.ctx)
+	ctx, cancel := s.requestContext(msgID, MethodToolsCall, params.Meta)
 	defer cancel()
 
-	s.clientRequests.Store(msgID, &request{
-		ctx:    ctx,
-		cancel: cancel,
-	})
-
-	result, err := server.CallTool(ctx, params, s.sendRequest)
-	if err != nil {
-		nErr := fmt.Errorf("failed to call tool: %w", err)
+	dryRun := params.Meta.Extra[metaDryRun] == true
+	if dryRun && !s.toolDryRun {
 		s.sendError(msgID, JSONRPCError{
-			Code:    jsonRPCInternalErrorCode,
-			Message: errMsgInternalError,
-			Data:    map[string]any{"error": nErr},
+			Code:    jsonRPCInvalidParamsCode,
+			Message: errMsgInvalidParams,
+			Data:    map[string]any{"error": fmt.Errorf("failed to call tool %s: dry runs aren't supported", params.Name)},
 		})
 		return
 	}
 
+	var tool *Tool
+	if s.applySchemaDefaults || s.validateToolOutput || s.requireDeclaredArgs || s.validateToolInput {
+		var err error
+		tool, err = s.findTool(ctx, params.Name, server)
+		if err != nil {
+			nErr := fmt.Errorf("failed to call tool: %w", err)
+			s.sendError(msgID, JSONRPCError{
+				Code:    jsonRPCInternalErrorCode,
+				Message: errMsgInternalError,
+				Data:    map[string]any{"error": nErr},
+			})
+			return
+		}
+	}
+	if s.applySchemaDefaults && tool != nil && tool.InputSchema != nil {
+		params.Arguments = applySchemaDefaults(tool.InputSchema, params.Arguments)
+	}
+	if s.requireDeclaredArgs && tool != nil && tool.InputSchema != nil {
+		var missing []string
+		for _, name := range schemaRequired(tool.InputSchema) {
+			if _, ok := params.Arguments[name]; !ok {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			s.sendMissingArgsError(msgID, "tool", params.Name, missing)
+			return
+		}
+	}
+	if s.validateToolInput && tool != nil && tool.InputSchema != nil {
+		if err := validateToolInput(ctx, params.Name, tool.InputSchema, params.Arguments); err != nil {
+			s.sendError(msgID, JSONRPCError{
+				Code:    jsonRPCInvalidParamsCode,
+				Message: errMsgInvalidParams,
+				Data:    map[string]any{"error": err},
+			})
+			return
+		}
+	}
+
+	// A tool error is a failed call, answered with a JSON-RPC error, while a result with IsError
+	// set is a tool that ran and failed, answered like any other result.
+	result, err := s.callTool(ctx, params, server)
+	if err != nil {
+		s.sendError(msgID, toolCallError(err))
+		return
+	}
+	if s.validateToolOutput && !result.IsError && !dryRun && tool != nil {
+		if err := checkToolOutput(ctx, *tool, result); err != nil {
+			nErr := fmt.Errorf("failed to call tool: %w", err)
+			s.sendError(msgID, JSONRPCError{
+				Code:    jsonRPCInternalErrorCode,
+				Message: errMsgInternalError,
+				Data:    map[string]any{"error": nErr},
+			})
+			return
+		}
+	}
+
 	s.sendResult(msgID, result)
 }
 
-func (s *session) handleNotificationsInitialized() {
+// toolCallError returns the JSON-RPC error answering a tool call that failed with err: the
+// JSONRPCError err is or wraps, if any, and an internal error otherwise.
+func toolCallError(err error) JSONRPCError {
+	var jErr JSONRPCError
+	if errors.As(err, &jErr) {
+		return jErr
+	}
+	var jErrPtr *JSONRPCError
+	if errors.As(err, &jErrPtr) && jErrPtr != nil {
+		return *jErrPtr
+	}
+
+	return JSONRPCError{
+		Code:    jsonRPCInternalErrorCode,
+		Message: errMsgInternalError,
+		Data:    map[string]any{"error": fmt.Errorf("failed to call tool: %w", err)},
+	}
+}
+
+// callTool calls the tool with CallToolStream if server is a StreamingToolServer and the call has a
+// progress token, sending each content item to the client as it's produced, and with CallTool
+// otherwise.
+func (s *session) callTool(ctx context.Context, params CallToolParams, server ToolServer) (CallToolResult, error) {
+	streamer, ok := server.(StreamingToolServer)
+	if !ok || params.Meta.ProgressToken == "" {
+		return server.CallTool(ctx, params, s.requestClient(ctx))
+	}
+
+	contents, err := streamer.CallToolStream(ctx, params, s.requestClient(ctx))
+	if err != nil {
+		return CallToolResult{}, err
+	}
+
+	result := CallToolResult{Content: []Content{}}
+	for {
+		select {
+		case <-ctx.Done():
+			return CallToolResult{}, ctx.Err()
+		case content, ok := <-contents:
+			if !ok {
+				return result, nil
+			}
+			result.Content = append(result.Content, content)
+			s.sendNotification(methodNotificationsProgress, ProgressParams{
+				ProgressToken: params.Meta.ProgressToken,
+				Progress:      float64(len(result.Content)),
+				Content:       []Content{content},
+			})
+		}
+	}
+}
+
+// findPrompt looks up the prompt with the given name in the listing of server, following its
+// pagination. It returns nil if server doesn't list the prompt.
+func (s *session) findPrompt(ctx context.Context, name string, server PromptServer) (*Prompt, error) {
+	params := ListPromptsParams{}
+	for {
+		ps, err := server.ListPrompts(ctx, params, s.requestClient(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list prompts: %w", err)
+		}
+		for _, prompt := range ps.Prompts {
+			if prompt.Name == name {
+				return &prompt, nil
+			}
+		}
+		if ps.NextCursor == "" {
+			return nil, nil
+		}
+		params.Cursor = ps.NextCursor
+	}
+}
+
+// sendMissingArgsError answers the request for the prompt or tool with the given name with an
+// invalid params error listing the required arguments it's missing.
+func (s *session) sendMissingArgsError(msgID MustString, kind, name string, missing []string) {
+	s.sendError(msgID, JSONRPCError{
+		Code:    jsonRPCInvalidParamsCode,
+		Message: errMsgInvalidParams,
+		Data: map[string]any{
+			"error":   fmt.Errorf("%s %s is missing required arguments: %s", kind, name, strings.Join(missing, ", ")),
+			"missing": missing,
+		},
+	})
+}
+
+// findTool looks up the tool with the given name in the listing of server, following its pagination.
+// It returns nil if server doesn't list the tool. The listing is cached by the session until the
+// ToolListUpdater reports that the tool list changed, so tool calls don't list the tools every time.
+func (s *session) findTool(ctx context.Context, name string, server ToolServer) (*Tool, error) {
+	tools, err := s.listTools(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	tool, ok := tools[name]
+	if !ok {
+		return nil, nil
+	}
+	return &tool, nil
+}
+
+// listTools returns the tools listed by server, keyed by name, from the cache of the session if the
+// tool list didn't change since they were cached.
+func (s *session) listTools(ctx context.Context, server ToolServer) (map[string]Tool, error) {
+	// The generation is read before listing, so a change during the listing invalidates it.
+	gen := s.toolsListGen.Load()
+
+	s.toolsLock.Lock()
+	defer s.toolsLock.Unlock()
+
+	if s.tools != nil && s.toolsGen == gen {
+		return s.tools, nil
+	}
+
+	tools := make(map[string]Tool)
+	params := ListToolsParams{}
+	for {
+		ts, err := server.ListTools(ctx, params, s.requestClient(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tools: %w", err)
+		}
+		for _, tool := range ts.Tools {
+			if _, ok := tools[tool.Name]; !ok {
+				tools[tool.Name] = tool
+			}
+		}
+		if ts.NextCursor == "" {
+			break
+		}
+		params.Cursor = ts.NextCursor
+	}
+	s.tools, s.toolsGen = tools, gen
+
+	return tools, nil
+}
+
+// checkToolOutput validates the result of the tool against the output schema the tool declares.
+func checkToolOutput(ctx context.Context, tool Tool, result CallToolResult) error {
+	if tool.OutputSchema == nil {
+		return nil
+	}
+	if result.StructuredContent == nil {
+		return fmt.Errorf("tool %s declares an output schema but returned no structured content", tool.Name)
+	}
+	return validateToolOutput(ctx, tool.Name, tool.OutputSchema, result.StructuredContent)
+}
+
+// handlerTimeout returns the time allowed for handling a request of the client with the given
+// method, zero if it has none.
+func (s *session) handlerTimeout(method string) time.Duration {
+	if timeout, ok := s.methodTimeouts[method]; ok {
+		return timeout
+	}
+	return s.defaultMethodTimeout
+}
+
+// requestContext creates the context for handling the client request with the given ID, and
+// registers it so the request can be cancelled by the client, along with the other requests sharing
+// its progress token, if any. The context carries the metadata of the request, see MetaFromContext.
+// The returned function cancels the context and unregisters the request.
+func (s *session) requestContext(
+	msgID MustString,
+	method string,
+	meta ParamsMeta,
+) (context.Context, context.CancelFunc) {
+	progressToken := meta.ProgressToken
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout := s.handlerTimeout(method); timeout > 0 {
+		ctx, cancel = contextWithTimeout(s.ctx, s.clock, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(s.ctx)
+	}
+	ctx = context.WithValue(ctx, requestInfoKey{}, requestInfo{
+		method:  method,
+		id:      msgID,
+		meta:    meta,
+		session: s,
+	})
+
+	req := &request{
+		ctx:           ctx,
+		cancel:        cancel,
+		progressToken: progressToken,
+	}
+	s.clientRequests.Store(msgID, req)
+	if progressToken != "" {
+		s.progressLock.Lock()
+		if s.progressRequests == nil {
+			s.progressRequests = make(map[MustString]map[MustString]struct{})
+		}
+		if s.progressRequests[progressToken] == nil {
+			s.progressRequests[progressToken] = make(map[MustString]struct{})
+		}
+		s.progressRequests[progressToken][msgID] = struct{}{}
+		s.progressLock.Unlock()
+	}
+
+	return ctx, func() {
+		cancel()
+		s.clientRequests.CompareAndDelete(msgID, req)
+		if progressToken == "" {
+			return
+		}
+		s.progressLock.Lock()
+		defer s.progressLock.Unlock()
+
+		delete(s.progressRequests[progressToken], msgID)
+		if len(s.progressRequests[progressToken]) == 0 {
+			delete(s.progressRequests, progressToken)
+		}
+	}
+}
+
+// markInitializeHandled records that the initialize request of the session was handled, with the
+// protocol version agreed on, the capabilities it exchanged and the info of the client, and reports
+// whether it wasn't already.
+func (s *session) markInitializeHandled(
+	version string,
+	serverCap ServerCapabilities,
+	clientCap ClientCapabilities,
+	clientInfo Info,
+) bool {
 	s.initLock.Lock()
 	defer s.initLock.Unlock()
 
+	if s.initializeHandled {
+		return false
+	}
+	s.initializeHandled = true
+	s.protocolVersion = version
+	s.serverCapabilities = serverCap
+	s.clientCapabilities = clientCap
+	s.clientInfo = clientInfo
+
+	return true
+}
+
+// markInitialized marks the session as initialized, on the initialized notification of the client.
+// It reports false if the session was already initialized.
+func (s *session) markInitialized() bool {
+	s.initLock.Lock()
+	defer s.initLock.Unlock()
+
+	wasInitialized := s.initialized
 	s.initialized = true
+
+	return !wasInitialized
+}
+
+func (s *session) handleNotificationsInitialized() {
+	s.runLifecycleHook(s.lifecycle.onInitialized)
+	if s.sendListsOnInitialized {
+		s.queueListsChanged()
+	}
+}
+
+// queueListsChanged queues the list changed notifications of the lists served by the server, until
+// the session is done.
+func (s *session) queueListsChanged() {
+	s.initLock.RLock()
+	serverCap := s.serverCapabilities
+	s.initLock.RUnlock()
+
+	lists := []struct {
+		served bool
+		ch     chan struct{}
+	}{
+		{served: serverCap.Prompts != nil, ch: s.promptsListChan},
+		{served: serverCap.Resources != nil, ch: s.resourcesListChan},
+		{served: serverCap.Tools != nil, ch: s.toolsListChan},
+	}
+	for _, list := range lists {
+		if !list.served {
+			continue
+		}
+		select {
+		case list.ch <- struct{}{}:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// info returns the SessionInfo of the session.
+func (s *session) info() SessionInfo {
+	s.initLock.RLock()
+	defer s.initLock.RUnlock()
+
+	return SessionInfo{
+		ID:                 s.id,
+		ProtocolVersion:    s.protocolVersion,
+		ClientInfo:         s.clientInfo,
+		ClientCapabilities: s.clientCapabilities.clone(),
+	}
+}
+
+// runLifecycleHook calls the hook, if set, with the info of the session in a new goroutine, so a
+// slow hook never holds up the session or the server. A panicking hook is reported on the errors
+// channel of Serve.
+func (s *session) runLifecycleHook(hook func(SessionInfo)) {
+	if hook == nil {
+		return
+	}
+
+	info := s.info()
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				s.logError(fmt.Errorf("session lifecycle hook of session %s panicked: %v\n%s",
+					s.id, r, debug.Stack()))
+			}
+		}()
+		hook(info)
+	}()
 }
 
 func (s *session) handleNotificationsCancelled(params notificationsCancelledParams) {
-	r, ok := s.clientRequests.Load(params.RequestID)
+	r, ok := s.clientRequests.Load(MustString(params.RequestID))
 	if !ok {
 		return
 	}
-	req, _ := r.(request)
+	req, _ := r.(*request)
 
 	s.logError(fmt.Errorf("cancelled request %s: %s", params.RequestID, params.Reason))
 	req.cancel()
+
+	if req.progressToken == "" {
+		return
+	}
+	s.progressLock.Lock()
+	siblings := make([]MustString, 0, len(s.progressRequests[req.progressToken]))
+	for id := range s.progressRequests[req.progressToken] {
+		siblings = append(siblings, id)
+	}
+	s.progressLock.Unlock()
+
+	for _, id := range siblings {
+		if r, ok := s.clientRequests.Load(id); ok {
+			sibling, _ := r.(*request)
+			sibling.cancel()
+		}
+	}
 }
 
-func (s *session) handleResult(msg JSONRPCMessage) {
-	reqID := string(msg.ID)
-	rc, ok := s.serverRequests.Load(reqID)
-	if !ok {
+func (s *session) handleNotificationsRootsListChanged(receiver RootsListReceiver) {
+	defer s.recoverPanic("")
+
+	resMsg, err := s.sendRequest(s.ctx, JSONRPCMessage{
+		JSONRPC: JSONRPCVersion,
+		Method:  MethodRootsList,
+	})
+	if err != nil {
+		s.logError(fmt.Errorf("failed to request roots list: %w", err))
 		return
 	}
-	resChan, _ := rc.(chan JSONRPCMessage)
-	resChan <- msg
+	if resMsg.Error != nil {
+		s.logError(fmt.Errorf("error response: %w", resMsg.Error))
+		return
+	}
+
+	var roots RootList
+	if err := json.Unmarshal(resMsg.Result, &roots); err != nil {
+		s.logError(fmt.Errorf("failed to unmarshal roots list: %w", err))
+		return
+	}
+
+	receiver.OnRootsListUpdated(s.id, roots)
+}
+
+// handleResult delivers the response to the request of the server it answers. A response to a
+// request that isn't pending, because it already timed out or was answered, or was never sent, is
+// reported and dropped, and the session carries on.
+func (s *session) handleResult(msg JSONRPCMessage) {
+	if !s.serverRequests.resolve(string(msg.ID), msg) {
+		s.logError(fmt.Errorf("dropped response to unknown request %q", msg.ID))
+	}
 }
 
 func (s *session) handleLoggingSetLevel(msgID MustString, params LogParams, handler LogHandler) {
+	defer s.recoverPanic(msgID)
+
 	if !s.isInitialized() {
 		return
 	}
 
+	_, cancel := s.requestContext(msgID, MethodLoggingSetLevel, ParamsMeta{})
+	defer cancel()
+
 	handler.SetLogLevel(params.Level)
 
 	s.sendResult(msgID, nil)
@@ -1218,20 +2581,24 @@ func (s *session) isInitialized() bool {
 	return s.initialized
 }
 
-func (s *session) registerRequest() (string, chan JSONRPCMessage) {
-	reqID := uuid.New().String()
-	resChan := make(chan JSONRPCMessage)
-	s.serverRequests.Store(reqID, resChan)
-	return reqID, resChan
+func (s *session) registerRequest(method string) (string, <-chan pendingResult) {
+	reqID := s.requestIDGenerator()
+	if timeout := s.methodTimeouts[method]; timeout > 0 {
+		return reqID, s.serverRequests.addWithTimeout(reqID, timeout)
+	}
+	return reqID, s.serverRequests.add(reqID)
 }
 
 func (s *session) ping() {
-	resMsg, err := s.sendRequest(JSONRPCMessage{
+	resMsg, err := s.sendRequest(s.ctx, JSONRPCMessage{
 		JSONRPC: JSONRPCVersion,
-		ID:      MustString(uuid.New().String()),
 		Method:  methodPing,
 		Params:  nil,
 	})
+	if s.ctx.Err() != nil {
+		// The session is closing, so there is no one left to report to.
+		return
+	}
 	if err != nil {
 		s.logError(fmt.Errorf("failed to send ping: %w", err))
 		return
@@ -1246,6 +2613,7 @@ func (s *session) sendNotification(method string, params any) {
 	paramsBs, err := json.Marshal(params)
 	if err != nil {
 		s.logError(fmt.Errorf("failed to marshal params: %w", err))
+		s.dropNotification(method, err.Error())
 		return
 	}
 
@@ -1255,7 +2623,7 @@ func (s *session) sendNotification(method string, params any) {
 		Params:  paramsBs,
 	}
 
-	sCtx, sCancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	sCtx, sCancel := contextWithTimeout(s.ctx, s.clock, s.writeTimeout)
 	defer sCancel()
 
 	if err := s.transport.Send(sCtx, SessionMsg{
@@ -1263,16 +2631,37 @@ func (s *session) sendNotification(method string, params any) {
 		Msg:       notif,
 	}); err != nil {
 		s.logError(fmt.Errorf("failed to send notification: %w", err))
+		s.dropNotification(method, err.Error())
+		s.handleWriteTimeout(sCtx)
+		s.handleWriteFailure(sCtx, err)
 		return
 	}
 }
 
+func (s *session) dropNotification(method, reason string) {
+	if s.droppedNotificationHandler != nil {
+		s.droppedNotificationHandler(method, reason)
+	}
+}
+
 func (s *session) sendResult(id MustString, result any) {
+	result, err := s.interceptResult(id, result)
+	if err != nil {
+		nErr := fmt.Errorf("failed to intercept result: %w", err)
+		s.sendError(id, JSONRPCError{
+			Code:    jsonRPCInternalErrorCode,
+			Message: errMsgInternalError,
+			Data:    map[string]any{"error": nErr},
+		})
+		return
+	}
+
 	resBs, err := json.Marshal(result)
 	if err != nil {
-		s.logError(fmt.Errorf("failed to marshal result: %w", err))
+		s.logRequestError(id, fmt.Errorf("failed to marshal result: %w", err))
 		return
 	}
+	resBs = s.compressResult(id, resBs)
 
 	msg := JSONRPCMessage{
 		JSONRPC: JSONRPCVersion,
@@ -1280,15 +2669,46 @@ func (s *session) sendResult(id MustString, result any) {
 		Result:  resBs,
 	}
 
-	sCtx, sCancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	sCtx, sCancel := contextWithTimeout(s.ctx, s.clock, s.writeTimeout)
 	defer sCancel()
 
 	if err := s.transport.Send(sCtx, SessionMsg{
 		SessionID: s.id,
 		Msg:       msg,
 	}); err != nil {
-		s.logError(fmt.Errorf("failed to send result: %w", err))
+		s.logRequestError(id, fmt.Errorf("failed to send result: %w", err))
+		s.handleWriteTimeout(sCtx)
+	}
+}
+
+// interceptResult passes the result of the request with the given ID through the result interceptor,
+// if there's one.
+func (s *session) interceptResult(id MustString, result any) (any, error) {
+	if s.resultInterceptor == nil {
+		return result, nil
 	}
+	ctx, info, ok := s.lookupRequest(id)
+	if !ok {
+		return result, nil
+	}
+
+	return s.resultInterceptor(ctx, info.method, result)
+}
+
+// lookupRequest returns the context of the running client request with the given ID, and the info
+// it holds. It reports false if there's no such request.
+func (s *session) lookupRequest(id MustString) (context.Context, requestInfo, bool) {
+	r, ok := s.clientRequests.Load(id)
+	if !ok {
+		return nil, requestInfo{}, false
+	}
+	req, ok := r.(*request)
+	if !ok {
+		return nil, requestInfo{}, false
+	}
+	info, _ := req.ctx.Value(requestInfoKey{}).(requestInfo)
+
+	return req.ctx, info, true
 }
 
 func (s *session) sendError(id MustString, err JSONRPCError) {
@@ -1298,51 +2718,183 @@ func (s *session) sendError(id MustString, err JSONRPCError) {
 		Error:   &err,
 	}
 
-	sCtx, sCancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	sCtx, sCancel := contextWithTimeout(s.ctx, s.clock, s.writeTimeout)
 	defer sCancel()
 
 	if err := s.transport.Send(sCtx, SessionMsg{
 		SessionID: s.id,
 		Msg:       msg,
 	}); err != nil {
-		s.logError(fmt.Errorf("failed to send error: %w", err))
+		s.logRequestError(id, fmt.Errorf("failed to send error: %w", err))
+		s.handleWriteTimeout(sCtx)
 	}
 }
 
-func (s *session) sendRequest(msg JSONRPCMessage) (JSONRPCMessage, error) {
-	reqID, resChan := s.registerRequest()
+// handleWriteTimeout applies the write timeout policy after a failed send, if the send failed
+// because writeCtx timed out while the session was still open.
+func (s *session) handleWriteTimeout(writeCtx context.Context) {
+	if !errors.Is(writeCtx.Err(), context.DeadlineExceeded) || s.ctx.Err() != nil {
+		return
+	}
+	if s.writeTimeoutPolicy == WriteTimeoutCloseSession {
+		s.logError(fmt.Errorf("closing session %s after write timeout", s.id))
+		s.cancel()
+	}
+}
+
+// handleWriteFailure ends the session after a send failed with err, if the transport reported that
+// the session can't be written to anymore, with ErrSessionBroken or ErrSessionNotFound, such as after
+// a broken pipe. The session is then evicted from the server, like any ended session, so the
+// notifications fanned out to all the sessions stop being queued for it, while the other sessions keep
+// receiving them. Other failures, and sends that timed out, which follow the write timeout policy,
+// leave the session open.
+func (s *session) handleWriteFailure(writeCtx context.Context, err error) {
+	if writeCtx.Err() != nil || s.ctx.Err() != nil {
+		return
+	}
+	if !errors.Is(err, ErrSessionBroken) && !errors.Is(err, ErrSessionNotFound) {
+		return
+	}
+	s.logError(fmt.Errorf("closing session %s after failed write: %w", s.id, err))
+	s.cancel()
+}
+
+// requestClient returns a RequestClientFunc bound to ctx, so the requests sent through it are
+// cancelled along with the client request that is being handled.
+func (s *session) requestClient(ctx context.Context) RequestClientFunc {
+	return func(msg JSONRPCMessage) (JSONRPCMessage, error) {
+		return s.sendRequest(ctx, msg)
+	}
+}
+
+// sendRequest sends msg to the client as a request, such as a sampling/createMessage request, and
+// waits for its response. The request is removed from serverRequests however it ends: answered,
+// failed to be sent, cancelled through ctx or timed out, so no entry outlives its caller.
+func (s *session) sendRequest(ctx context.Context, msg JSONRPCMessage) (JSONRPCMessage, error) {
+	if msg.Method == MethodSamplingCreateMessage {
+		if err := validateSamplingParams(msg.Params); err != nil {
+			return JSONRPCMessage{}, err
+		}
+	}
+
+	reqID, results := s.registerRequest(msg.Method)
 	msg.ID = MustString(reqID)
+	// The request is part of the handling of a client request, if ctx was created for one.
+	_, clientReqID, _ := RequestInfo(ctx)
+
+	if timeout := s.methodTimeouts[msg.Method]; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = contextWithTimeout(ctx, s.clock, timeout)
+		defer cancel()
+	}
 
-	sCtx, sCancel := context.WithTimeout(s.ctx, s.writeTimeout)
+	sCtx, sCancel := contextWithTimeout(ctx, s.clock, s.writeTimeout)
 	defer sCancel()
 
 	if err := s.transport.Send(sCtx, SessionMsg{
 		SessionID: s.id,
 		Msg:       msg,
 	}); err != nil {
-		s.logError(fmt.Errorf("failed to send request: %w", err))
+		s.serverRequests.remove(reqID)
+		s.logRequestError(clientReqID, fmt.Errorf("failed to send request: %w", err))
 		return JSONRPCMessage{}, err
 	}
 
-	ticker := time.NewTicker(s.readTimeout)
-
-	var resMsg JSONRPCMessage
+	var res pendingResult
 
 	select {
-	case <-ticker.C:
-		s.logError(fmt.Errorf("request timeout"))
-		return JSONRPCMessage{}, fmt.Errorf("request timeout")
-	case <-sCtx.Done():
-		return JSONRPCMessage{}, sCtx.Err()
-	case resMsg = <-resChan:
+	case <-ctx.Done():
+		s.serverRequests.remove(reqID)
+		err := ctx.Err()
+		// Let the client know it can stop working on the request, unless the whole session is gone.
+		if s.ctx.Err() == nil {
+			s.sendNotification(methodNotificationsCancelled, notificationsCancelledParams{
+				RequestID: reqID,
+				Reason:    serverCancelledReason,
+			})
+		}
+		return JSONRPCMessage{}, err
+	case res = <-results:
+	}
+
+	if res.err != nil {
+		s.logRequestError(clientReqID, fmt.Errorf("request %s: %w", reqID, res.err))
+		return JSONRPCMessage{}, res.err
 	}
 
-	return resMsg, nil
+	return res.msg, nil
+}
+
+// recoverPanic recovers from a panic in the handler of the client message with the given ID, so a
+// buggy handler doesn't crash the server. The panic is reported with its stack trace on the errors
+// channel of Serve, and the request is answered with an internal error, which doesn't include the
+// stack trace. Notifications, with an empty ID, aren't answered.
+func (s *session) recoverPanic(msgID MustString) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	s.logRequestError(msgID, fmt.Errorf("handler of message %s panicked: %v\n%s", msgID, r, debug.Stack()))
+	if msgID == "" {
+		return
+	}
+	s.sendError(msgID, JSONRPCError{
+		Code:    jsonRPCInternalErrorCode,
+		Message: errMsgInternalError,
+		Data:    map[string]any{"error": fmt.Sprintf("handler panicked: %v", r)},
+	})
 }
 
 func (s *session) logError(err error) {
+	s.errs.report(err)
+}
+
+// logRequestError reports an error that happened while handling the client request with the given
+// ID as a RequestError. Errors unrelated to a request, with an empty ID, are reported as is.
+func (s *session) logRequestError(msgID MustString, err error) {
+	if msgID == "" {
+		s.logError(err)
+		return
+	}
+	s.logError(RequestError{
+		CorrelationID: correlationID(s.id, msgID),
+		Err:           err,
+	})
+}
+
+// correlationID returns the correlation ID of the request with the given ID of the given session.
+func correlationID(sessionID string, msgID MustString) string {
+	return sessionID + "/" + string(msgID)
+}
+
+// Error implements the error interface. The message starts with the correlation ID, logged under
+// CorrelationIDKey.
+func (e RequestError) Error() string {
+	return fmt.Sprintf("%s=%s: %v", CorrelationIDKey, e.CorrelationID, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e RequestError) Unwrap() error {
+	return e.Err
+}
+
+func (r *errorReporter) report(err error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.closed {
+		return
+	}
 	select {
-	case s.errsChan <- err:
+	case r.errs <- err:
 	default:
 	}
 }
+
+func (r *errorReporter) close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.closed = true
+	close(r.errs)
+}
@@ -1,9 +1,25 @@
 package mcp_test
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
 
 	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+	"github.com/MegaGrindStone/go-mcp/pkg/mcptest"
+	"github.com/qri-io/jsonschema"
 )
 
 type mockServer struct {
@@ -19,6 +35,14 @@ type mockPromptServer struct {
 
 type mockPromptListUpdater struct{}
 
+var mockPrompt = mcp.Prompt{
+	Name:  "test-prompt",
+	Title: "Test Prompt",
+	Arguments: []mcp.PromptArgument{
+		{Name: "style", Choices: []string{"casual", "formal", "friendly"}},
+	},
+}
+
 type mockResourceServer struct {
 	listParams              mcp.ListResourcesParams
 	readParams              mcp.ReadResourceParams
@@ -28,6 +52,18 @@ type mockResourceServer struct {
 	unsubscribeParams       mcp.UnsubscribeResourceParams
 }
 
+// mockBlobResourceServer serves content as the blob of every resource.
+type mockBlobResourceServer struct {
+	*mockResourceServer
+	content []byte
+}
+
+// mockStreamableResourceServer streams content as every resource.
+type mockStreamableResourceServer struct {
+	mockBlobResourceServer
+	streamed bool
+}
+
 type mockResourceListUpdater struct{}
 
 type mockResourceSubscribedUpdater struct{}
@@ -35,18 +71,2185 @@ type mockResourceSubscribedUpdater struct{}
 type mockToolServer struct {
 	listParams mcp.ListToolsParams
 	callParams mcp.CallToolParams
+	callMethod string
+	callID     mcp.MustString
 }
 
 type mockToolListUpdater struct{}
 
+// mockSamplingToolServer requests a sample from the client on every tool call.
+type mockSamplingToolServer struct{}
+
+// mockDeadlineToolServer requests a sample from the client on every tool call, giving up after
+// a short deadline.
+type mockDeadlineToolServer struct {
+	mockSamplingToolServer
+}
+
+// stalledTransport serves a single session whose sends never complete, like a client that stopped
+// reading. It reports the ID of every message it's asked to send, and the sessions it's asked
+// to close.
+type stalledTransport struct {
+	sessions chan mcp.SessionCtx
+	messages chan mcp.SessionMsgWithErrs
+	sends    chan mcp.MustString
+	closed   chan string
+}
+
+// fanoutTransport serves a fast and a slow session. The sends to the slow session never complete,
+// like a client that stopped reading, while the methods of the messages sent to the fast session are
+// reported.
+type fanoutTransport struct {
+	sessions chan mcp.SessionCtx
+	messages chan mcp.SessionMsgWithErrs
+	fast     chan string
+}
+
+// brokenTransport is a fanoutTransport whose sends to the broken session fail right away, like the
+// writes to a client whose pipe is broken, and whose sends to the flaky session fail without
+// breaking it.
+type brokenTransport struct {
+	fanoutTransport
+}
+
+// mockPromptListChanges is a PromptListUpdater reporting the changes sent on it.
+type mockPromptListChanges chan struct{}
+
+// mockUnnamedServer is a server whose info has no name.
+type mockUnnamedServer struct {
+	mockServer
+}
+
+// mockSubscriptionCountingServer counts the subscriptions and unsubscriptions it's told about.
+type mockSubscriptionCountingServer struct {
+	*mockResourceServer
+	subscribes   *atomic.Int32
+	unsubscribes *atomic.Int32
+}
+
+// mockSaturatedLimiter denies every message once saturated is set.
+type mockSaturatedLimiter struct {
+	saturated *atomic.Bool
+}
+
+// mockArgumentsToolServer returns the arguments of every tool call as the structured content of the
+// result.
+type mockArgumentsToolServer struct{}
+
+// mockCountingToolServer is a mockArgumentsToolServer counting the listings of its tools.
+type mockCountingToolServer struct {
+	mockArgumentsToolServer
+	lists *atomic.Int32
+}
+
+// mockStructuredToolServer lists its tools over two pages, and returns the structured content of
+// the called tool.
+type mockStructuredToolServer struct {
+	contents map[string]string
+}
+
 type mockLogHandler struct{}
 
+// mockBatchLogHandler emits the logs and the batches of logs sent on its channels.
+type mockBatchLogHandler struct {
+	logs    chan mcp.LogParams
+	batches chan []mcp.LogParams
+}
+
 type mockRootsListWatcher struct{}
 
+// mockRootsListReceiver sends every root list it receives to roots.
+type mockRootsListReceiver struct {
+	roots chan mcp.RootList
+}
+
+type mockLogStream struct {
+	logs chan mcp.LogParams
+}
+
+type mockStreamingToolServer struct{}
+
+// mockRequiredArgsPromptServer serves a prompt with a required and an optional argument.
+type mockRequiredArgsPromptServer struct{}
+
+// lineWriter sends every write to the channel, blocking until it's received.
+type lineWriter chan string
+
+type mockProgressReporter struct {
+	reports chan mcp.ProgressParams
+}
+
+// rawClient talks to a server over StdIO with hand-written JSON-RPC frames, for tests that
+// need to send messages the Client would never produce.
+type rawClient struct {
+	writer io.Writer
+	msgs   chan mcp.JSONRPCMessage
+	// errs holds the errors the server reported, the oldest ones once full.
+	errs chan error
+}
+
+func TestServerInvalidMessage(t *testing.T) {
+	cli := setupRawClient(t, mockServer{})
+
+	cli.send(t, `this is not json`)
+	cli.send(t, `{"jsonrpc":"2.0","id":"1","method":"ping"}`)
+
+	msg := cli.receive(t)
+	if msg.Error == nil {
+		t.Fatalf("expected parse error response, got %+v", msg)
+	}
+	if msg.Error.Code != -32700 {
+		t.Errorf("expected error code -32700, got %d", msg.Error.Code)
+	}
+
+	msg = cli.receive(t)
+	if msg.Error != nil {
+		t.Fatalf("unexpected error response: %v", msg.Error)
+	}
+	if msg.ID != "1" {
+		t.Errorf("expected ping response with ID 1, got %s", msg.ID)
+	}
+}
+
+func TestServerInvalidParams(t *testing.T) {
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(&mockToolServer{}))
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"1","method":"tools/call","params":"not an object"}`)
+	cli.send(t, `{"jsonrpc":"2.0","id":"2","method":"ping"}`)
+
+	msg := cli.receive(t)
+	if msg.Error == nil {
+		t.Fatalf("expected invalid params response, got %+v", msg)
+	}
+	if msg.ID != "1" {
+		t.Errorf("expected error response with ID 1, got %s", msg.ID)
+	}
+	if msg.Error.Code != -32602 {
+		t.Errorf("expected error code -32602, got %d", msg.Error.Code)
+	}
+
+	msg = cli.receive(t)
+	if msg.ID != "2" || msg.Error != nil {
+		t.Errorf("expected ping response with ID 2, got %+v", msg)
+	}
+}
+
+func TestServerPingParams(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     string
+		wantResult string
+	}{
+		{
+			name:       "meta",
+			params:     `{"_meta":{"correlationId":"abc","seq":1}}`,
+			wantResult: `{"_meta":{"correlationId":"abc","seq":1}}`,
+		},
+		{name: "empty", params: `{}`, wantResult: `{}`},
+		{name: "meta not an object", params: `{"_meta":"abc"}`, wantResult: `{}`},
+		{name: "params not an object", params: `[1,2]`, wantResult: `{}`},
+	}
+
+	cli := setupRawClient(t, mockServer{})
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id := fmt.Sprint(i)
+			cli.send(t, `{"jsonrpc":"2.0","id":"`+id+`","method":"ping","params":`+test.params+`}`)
+
+			msg := cli.receive(t)
+			if msg.ID != mcp.MustString(id) || msg.Error != nil {
+				t.Fatalf("expected ping response with ID %s, got %+v", id, msg)
+			}
+			if string(msg.Result) != test.wantResult {
+				t.Errorf("expected result %s, got %s", test.wantResult, msg.Result)
+			}
+		})
+	}
+
+	cli = setupRawClient(t, mockServer{}, mcp.WithPingHandler(func(ctx context.Context) (json.RawMessage, error) {
+		meta, _ := mcp.MetaFromContext(ctx)
+		return json.Marshal(map[string]any{"seen": meta.Extra["correlationId"]})
+	}))
+	cli.send(t, `{"jsonrpc":"2.0","id":"1","method":"ping","params":{"_meta":{"correlationId":"abc"}}}`)
+	msg := cli.receive(t)
+	if msg.Error != nil || string(msg.Result) != `{"seen":"abc"}` {
+		t.Errorf("expected the ping handler to see the meta of the ping, got %+v", msg)
+	}
+}
+
+func TestServerToolArgumentLimits(t *testing.T) {
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(&mockToolServer{}), mcp.WithToolArgumentLimits(100, 10))
+	cli.initialize(t)
+
+	keys := make([]string, 101)
+	for i := range keys {
+		keys[i] = fmt.Sprintf(`"k%d":%d`, i, i)
+	}
+	tests := []struct {
+		name      string
+		arguments string
+		wantError bool
+	}{
+		{
+			name:      "deeply nested",
+			arguments: strings.Repeat(`{"a":`, 50) + "1" + strings.Repeat("}", 50),
+			wantError: true,
+		},
+		{
+			name:      "nested in arrays",
+			arguments: `{"a":` + strings.Repeat("[", 20) + strings.Repeat("]", 20) + "}",
+			wantError: true,
+		},
+		{
+			name:      "too many keys",
+			arguments: "{" + strings.Join(keys, ",") + "}",
+			wantError: true,
+		},
+		{
+			name:      "within limits",
+			arguments: `{"a":{"b":[1,{"c":"d"}]},` + strings.Join(keys[:96], ",") + "}",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id := fmt.Sprint(i)
+			cli.send(t, `{"jsonrpc":"2.0","id":"`+id+`","method":"tools/call","params":{"name":"test-tool",`+
+				`"arguments":`+test.arguments+`}}`)
+
+			msg := cli.receive(t)
+			if msg.ID != mcp.MustString(id) {
+				t.Fatalf("expected response with ID %s, got %+v", id, msg)
+			}
+			if test.wantError {
+				if msg.Error == nil || msg.Error.Code != -32602 {
+					t.Errorf("expected invalid params error, got %+v", msg)
+				}
+				return
+			}
+			if msg.Error != nil {
+				t.Errorf("unexpected error: %v", msg.Error)
+			}
+		})
+	}
+}
+
+func TestServerRequestIDGenerator(t *testing.T) {
+	var counter int
+	gen := func() string {
+		counter++
+		return fmt.Sprintf("req-%d", counter)
+	}
+	cli := setupRawClient(t, mockServer{},
+		mcp.WithServerPingInterval(50*time.Millisecond),
+		mcp.WithServerRequestIDGenerator(gen),
+	)
+
+	msg := cli.receive(t)
+	if msg.Method != "ping" {
+		t.Fatalf("expected ping request, got %+v", msg)
+	}
+	if msg.ID != "req-1" {
+		t.Errorf("expected ping request with ID req-1, got %s", msg.ID)
+	}
+}
+
+func TestServerCancelOutboundRequest(t *testing.T) {
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(mockSamplingToolServer{}))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"sample"}}`)
+
+	msg := cli.receive(t)
+	if msg.Method != mcp.MethodSamplingCreateMessage {
+		t.Fatalf("expected sampling request, got %+v", msg)
+	}
+	samplingID := msg.ID
+
+	cli.send(t, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"call"}}`)
+
+	msg = cli.receive(t)
+	if msg.Method != "notifications/cancelled" {
+		t.Fatalf("expected cancelled notification, got %+v", msg)
+	}
+	var params struct {
+		RequestID string `json:"requestId"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		t.Fatalf("failed to unmarshal params: %v", err)
+	}
+	if params.RequestID != string(samplingID) {
+		t.Errorf("expected cancellation of request %s, got %s", samplingID, params.RequestID)
+	}
+}
+
+func TestServerRateLimiter(t *testing.T) {
+	cli := setupRawClient(t, mockServer{}, mcp.WithRateLimiter(mcp.NewTokenBucketLimiter(0.001, 1)))
+	// The first initialize request takes the only token of its bucket.
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"2","method":"initialize","params":{"protocolVersion":"2024-11-05",`+
+		`"capabilities":{},"clientInfo":{"name":"raw-client","version":"1.0"}}}`)
+	msg := cli.receive(t)
+	if msg.ID != "2" || msg.Error == nil {
+		t.Fatalf("expected rate limit error with ID 2, got %+v", msg)
+	}
+	if msg.Error.Code != -32000 {
+		t.Errorf("expected error code -32000, got %d", msg.Error.Code)
+	}
+}
+
+func TestTokenBucketLimiterClock(t *testing.T) {
+	clock := mcptest.NewFakeClock(time.Now())
+	limiter := mcp.NewTokenBucketLimiter(1, 1, mcp.WithTokenBucketClock(clock))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if !limiter.Allow(ctx, "session", mcp.MethodToolsCall) {
+		t.Fatal("expected the first call to be allowed")
+	}
+	if limiter.Allow(ctx, "session", mcp.MethodToolsCall) {
+		t.Fatal("expected the second call to be limited")
+	}
+
+	// The bucket refills on the clock of the limiter.
+	clock.Advance(time.Second)
+	if !limiter.Allow(ctx, "session", mcp.MethodToolsCall) {
+		t.Error("expected a call to be allowed after the bucket refilled")
+	}
+}
+
+func TestServerRateLimiterControlMessages(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "slow"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			close(started)
+			<-ctx.Done()
+			close(cancelled)
+			return mcp.CallToolResult{}, ctx.Err()
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	limiter := mockSaturatedLimiter{saturated: new(atomic.Bool)}
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry), mcp.WithRateLimiter(limiter))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"slow"}}`)
+	<-started
+
+	// The limiter now denies every message, yet the call can be cancelled and the session pinged.
+	limiter.saturated.Store(true)
+	cli.send(t, `{"jsonrpc":"2.0","id":"denied","method":"tools/call","params":{"name":"slow"}}`)
+	if msg := cli.receive(t); msg.ID != "denied" || msg.Error == nil || msg.Error.Code != -32000 {
+		t.Fatalf("expected rate limit error with ID denied, got %+v", msg)
+	}
+
+	cli.send(t, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"call"}}`)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the call to be cancelled")
+	}
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"ping","method":"ping"}`)
+	for {
+		msg := cli.receive(t)
+		if msg.ID == "call" {
+			continue
+		}
+		if msg.ID != "ping" || msg.Error != nil {
+			t.Fatalf("expected ping response, got %+v", msg)
+		}
+		break
+	}
+}
+
+func TestServerCancelProgressToken(t *testing.T) {
+	started := make(chan struct{}, 3)
+	cancelled := make(chan string, 3)
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "wait"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			started <- struct{}{}
+			<-ctx.Done()
+			_, id, _ := mcp.RequestInfo(ctx)
+			cancelled <- string(id)
+			return mcp.CallToolResult{}, ctx.Err()
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry))
+	cli.initialize(t)
+
+	call := func(id, token string) {
+		cli.send(t, `{"jsonrpc":"2.0","id":"`+id+`","method":"tools/call",`+
+			`"params":{"name":"wait","_meta":{"progressToken":"`+token+`"}}}`)
+	}
+	call("first", "operation")
+	call("second", "operation")
+	call("other", "other-operation")
+	for range 3 {
+		<-started
+	}
+
+	cli.send(t, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":"first"}}`)
+
+	got := make(map[string]bool)
+	for range 2 {
+		select {
+		case id := <-cancelled:
+			got[id] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expected both requests sharing the progress token to be cancelled, got %v", got)
+		}
+	}
+	if !got["first"] || !got["second"] {
+		t.Errorf("expected requests first and second to be cancelled, got %v", got)
+	}
+
+	select {
+	case id := <-cancelled:
+		t.Errorf("expected request with another progress token to keep running, got %s cancelled", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestServerDuplicateSubscription(t *testing.T) {
+	srv := mockSubscriptionCountingServer{
+		mockResourceServer: &mockResourceServer{},
+		subscribes:         new(atomic.Int32),
+		unsubscribes:       new(atomic.Int32),
+	}
+	updater := mcptest.NewManualResourceUpdater()
+	cli := setupRawClient(t, mockServer{}, mcp.WithResourceServer(srv), mcp.WithResourceSubscribedUpdater(updater))
+	cli.initialize(t)
+
+	for _, id := range []string{"first", "second"} {
+		cli.send(t, `{"jsonrpc":"2.0","id":"`+id+`","method":"resources/subscribe","params":{"uri":"test://resource"}}`)
+		if msg := cli.receive(t); msg.ID != mcp.MustString(id) || msg.Error != nil {
+			t.Fatalf("expected successful response with ID %s, got %+v", id, msg)
+		}
+	}
+	if n := srv.subscribes.Load(); n != 1 {
+		t.Errorf("expected the resource server to be told of 1 subscription, got %d", n)
+	}
+
+	updater.TriggerUpdate("test://resource")
+	if msg := cli.receive(t); msg.Method != "notifications/resources/updated" {
+		t.Fatalf("expected resource updated notification, got %+v", msg)
+	}
+
+	// A duplicate notification would arrive before the ping response.
+	cli.send(t, `{"jsonrpc":"2.0","id":"ping","method":"ping"}`)
+	if msg := cli.receive(t); msg.ID != "ping" {
+		t.Fatalf("expected a single notification followed by the ping response, got %+v", msg)
+	}
+}
+
+func TestServerDuplicateUnsubscription(t *testing.T) {
+	srv := mockSubscriptionCountingServer{
+		mockResourceServer: &mockResourceServer{},
+		subscribes:         new(atomic.Int32),
+		unsubscribes:       new(atomic.Int32),
+	}
+	cli := setupRawClient(t, mockServer{}, mcp.WithResourceServer(srv))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"subscribe","method":"resources/subscribe","params":{"uri":"test://resource"}}`)
+	if msg := cli.receive(t); msg.ID != "subscribe" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID subscribe, got %+v", msg)
+	}
+
+	// Unsubscribing again, or from a resource the session never subscribed to, succeeds without
+	// telling the resource server.
+	for _, req := range []struct{ id, uri string }{
+		{"first", "test://resource"},
+		{"second", "test://resource"},
+		{"other", "test://other"},
+	} {
+		cli.send(t, `{"jsonrpc":"2.0","id":"`+req.id+`","method":"resources/unsubscribe","params":{"uri":"`+
+			req.uri+`"}}`)
+		if msg := cli.receive(t); msg.ID != mcp.MustString(req.id) || msg.Error != nil {
+			t.Fatalf("expected successful response with ID %s, got %+v", req.id, msg)
+		}
+	}
+	if n := srv.unsubscribes.Load(); n != 1 {
+		t.Errorf("expected the resource server to be told of 1 unsubscription, got %d", n)
+	}
+}
+
+func TestServerDroppedNotification(t *testing.T) {
+	reporter := mockProgressReporter{reports: make(chan mcp.ProgressParams)}
+	type drop struct {
+		method string
+		reason string
+	}
+	drops := make(chan drop, 1)
+	cli := setupRawClient(t, mockServer{},
+		mcp.WithProgressReporter(reporter),
+		mcp.WithDroppedNotificationHandler(func(method, reason string) {
+			drops <- drop{method: method, reason: reason}
+		}),
+	)
+	cli.initialize(t)
+
+	reporter.reports <- mcp.ProgressParams{ProgressToken: "unknown", Progress: 1}
+
+	select {
+	case d := <-drops:
+		if d.method != "notifications/progress" {
+			t.Errorf("expected dropped progress notification, got %s", d.method)
+		}
+		if d.reason == "" {
+			t.Error("expected a reason for the dropped notification")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for dropped notification")
+	}
+}
+
+func TestServerRootsListReceiver(t *testing.T) {
+	receiver := mockRootsListReceiver{roots: make(chan mcp.RootList, 1)}
+	cli := setupRawClient(t, mockServer{}, mcp.WithRootsListWatcher(receiver))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","method":"notifications/roots/list_changed"}`)
+
+	msg := cli.receive(t)
+	if msg.Method != mcp.MethodRootsList {
+		t.Fatalf("expected roots list request, got %+v", msg)
+	}
+	cli.send(t, fmt.Sprintf(`{"jsonrpc":"2.0","id":%q,"result":{"roots":[{"uri":"file:///a","name":"a"}]}}`, msg.ID))
+
+	select {
+	case roots := <-receiver.roots:
+		if len(roots.Roots) != 1 || roots.Roots[0].URI != "file:///a" {
+			t.Errorf("expected root file:///a, got %+v", roots.Roots)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for roots list")
+	}
+}
+
+func TestServerNotificationBuffer(t *testing.T) {
+	stream := mockLogStream{logs: make(chan mcp.LogParams)}
+	drops := make(chan string, 100)
+	cli := setupRawClient(t, mockServer{},
+		mcp.WithLogHandler(stream),
+		mcp.WithNotificationBuffer(5),
+		mcp.WithNotificationOverflowPolicy(mcp.NotificationOverflowDropOldest),
+		mcp.WithDroppedNotificationHandler(func(method, _ string) {
+			drops <- method
+		}),
+	)
+	cli.initialize(t)
+
+	// The raw client stops reading its messages here, so its pipe fills up and every write blocks.
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for i := range 50 {
+			stream.logs <- mcp.LogParams{Level: mcp.LogLevelInfo, Data: mcp.LogData{Message: fmt.Sprintf("log %d", i)}}
+		}
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("fan-out of logs was blocked by a slow client")
+	}
+
+	select {
+	case method := <-drops:
+		if method != "notifications/message" {
+			t.Errorf("expected dropped log notification, got %s", method)
+		}
+	default:
+		t.Error("expected logs to be dropped once the buffer is full")
+	}
+}
+
+func TestServerStreamingToolServer(t *testing.T) {
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(mockStreamingToolServer{}))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"stream","method":"tools/call",`+
+		`"params":{"name":"tail","_meta":{"progressToken":"tail"}}}`)
+	for i, want := range []string{"line 1", "line 2"} {
+		msg := cli.receive(t)
+		if msg.Method != "notifications/progress" {
+			t.Fatalf("expected progress notification, got %+v", msg)
+		}
+		var params mcp.ProgressParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			t.Fatalf("failed to unmarshal progress: %v", err)
+		}
+		if params.Progress != float64(i+1) || len(params.Content) != 1 || params.Content[0].Text != want {
+			t.Errorf("expected progress %d with %q, got %+v", i+1, want, params)
+		}
+	}
+
+	msg := cli.receive(t)
+	if msg.ID != "stream" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID stream, got %+v", msg)
+	}
+	var result mcp.CallToolResult
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if len(result.Content) != 2 || result.Content[0].Text != "line 1" || result.Content[1].Text != "line 2" {
+		t.Errorf("expected the aggregated lines, got %+v", result.Content)
+	}
+
+	// Without a progress token, the call is served by CallTool.
+	cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"tail"}}`)
+	msg = cli.receive(t)
+	if msg.ID != "call" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID call, got %+v", msg)
+	}
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if len(result.Content) != 1 || result.Content[0].Text != "all lines" {
+		t.Errorf("expected the content of CallTool, got %+v", result.Content)
+	}
+}
+
+func TestServerLocalLogSink(t *testing.T) {
+	stream := mockLogStream{logs: make(chan mcp.LogParams)}
+	sink := make(lineWriter)
+	cli := setupRawClient(t, mockServer{},
+		mcp.WithLogHandler(stream),
+		mcp.WithLocalLogSink(slog.New(slog.NewTextHandler(sink, nil))),
+	)
+	cli.initialize(t)
+
+	// The sink isn't read from until both logs reached the client, so it can't hold up the delivery.
+	for i := range 2 {
+		stream.logs <- mcp.LogParams{
+			Level:  mcp.LogLevelWarning,
+			Logger: "db",
+			Data:   mcp.LogData{Message: fmt.Sprintf("log %d", i), Details: map[string]any{"table": "users"}},
+		}
+		if msg := cli.receive(t); msg.Method != "notifications/message" {
+			t.Fatalf("expected log notification, got %+v", msg)
+		}
+	}
+
+	for i := range 2 {
+		select {
+		case line := <-sink:
+			for _, want := range []string{"level=WARN", fmt.Sprintf(`msg="log %d"`, i), "logger=db", "table=users"} {
+				if !strings.Contains(line, want) {
+					t.Errorf("expected %s in local log %q", want, line)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for local log %d", i)
+		}
+	}
+}
+
+func TestServerPingHandler(t *testing.T) {
+	cli := setupRawClient(t, mockServer{}, mcp.WithPingHandler(func(context.Context) (json.RawMessage, error) {
+		return json.RawMessage(`{"load":0.5}`), nil
+	}))
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"1","method":"ping"}`)
+
+	msg := cli.receive(t)
+	if msg.ID != "1" || msg.Error != nil {
+		t.Fatalf("expected ping response with ID 1, got %+v", msg)
+	}
+	var result struct {
+		Load float64 `json:"load"`
+	}
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if result.Load != 0.5 {
+		t.Errorf("expected load 0.5, got %v", result.Load)
+	}
+}
+
+func TestServerRequiredClientCapabilities(t *testing.T) {
+	cli := setupRawClient(t, mockServer{requireSamplingClient: true})
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"init","method":"initialize","params":{"protocolVersion":"2024-11-05",`+
+		`"capabilities":{"roots":{}},"clientInfo":{"name":"raw-client","version":"1.0"}}}`)
+
+	msg := cli.receive(t)
+	if msg.ID != "init" || msg.Error == nil {
+		t.Fatalf("expected error response with ID init, got %+v", msg)
+	}
+	if msg.Error.Code != -32602 {
+		t.Errorf("expected error code -32602, got %d", msg.Error.Code)
+	}
+	if msg.Error.Message != "Insufficient client capabilities" {
+		t.Errorf("expected insufficient client capabilities error, got %q", msg.Error.Message)
+	}
+
+	// The failed handshake doesn't count as initialization, so the client can retry.
+	cli.send(t, `{"jsonrpc":"2.0","id":"retry","method":"initialize","params":{"protocolVersion":"2024-11-05",`+
+		`"capabilities":{"sampling":{}},"clientInfo":{"name":"raw-client","version":"1.0"}}}`)
+
+	msg = cli.receive(t)
+	if msg.ID != "retry" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID retry, got %+v", msg)
+	}
+}
+
+func TestServerCapabilitiesFromContext(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "caps"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			serverCap, clientCap, ok := mcp.CapabilitiesFromContext(ctx)
+			if !ok {
+				return mcp.CallToolResult{}, errors.New("no capabilities in context")
+			}
+			text := fmt.Sprintf("tools=%t sampling=%t roots=%t",
+				serverCap.Tools != nil, clientCap.Sampling != nil, clientCap.Roots != nil)
+			return mcp.CallToolResult{Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: text}}}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	if _, _, ok := mcp.CapabilitiesFromContext(context.Background()); ok {
+		t.Error("expected no capabilities outside of a request")
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry))
+	cli.send(t, `{"jsonrpc":"2.0","id":"init","method":"initialize","params":{"protocolVersion":"2024-11-05",`+
+		`"capabilities":{"sampling":{}},"clientInfo":{"name":"raw-client","version":"1.0"}}}`)
+	if msg := cli.receive(t); msg.ID != "init" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID init, got %+v", msg)
+	}
+	cli.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	cli.send(t, `{"jsonrpc":"2.0","id":"ping","method":"ping"}`)
+	if msg := cli.receive(t); msg.ID != "ping" {
+		t.Fatalf("expected ping response, got %+v", msg)
+	}
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"caps"}}`)
+	msg := cli.receive(t)
+	if msg.ID != "call" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID call, got %+v", msg)
+	}
+	var result mcp.CallToolResult
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if want := "tools=true sampling=true roots=false"; result.Content[0].Text != want {
+		t.Errorf("expected %q, got %q", want, result.Content[0].Text)
+	}
+}
+
+func TestServerClientInfoFromContext(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "whoami"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			info, ok := mcp.ClientInfoFromContext(ctx)
+			if !ok {
+				return mcp.CallToolResult{}, errors.New("no client info in context")
+			}
+			version, ok := mcp.ProtocolVersionFromContext(ctx)
+			if !ok {
+				return mcp.CallToolResult{}, errors.New("no protocol version in context")
+			}
+			text := fmt.Sprintf("%s %s, protocol %s", info.Name, info.Version, version)
+			return mcp.CallToolResult{Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: text}}}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	if _, ok := mcp.ClientInfoFromContext(context.Background()); ok {
+		t.Error("expected no client info outside of a request")
+	}
+	if _, ok := mcp.ProtocolVersionFromContext(context.Background()); ok {
+		t.Error("expected no protocol version outside of a request")
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"whoami"}}`)
+	msg := cli.receive(t)
+	if msg.ID != "call" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID call, got %+v", msg)
+	}
+	var result mcp.CallToolResult
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if want := "raw-client 1.0, protocol 2024-11-05"; result.Content[0].Text != want {
+		t.Errorf("expected %q, got %q", want, result.Content[0].Text)
+	}
+}
+
+func TestServerConcurrentInitialize(t *testing.T) {
+	// The tool toggles the capabilities it's handed, while other sessions are initializing.
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "toggle"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			serverCap, _, ok := mcp.CapabilitiesFromContext(ctx)
+			if !ok {
+				return mcp.CallToolResult{}, errors.New("no capabilities in context")
+			}
+			serverCap.Tools.ListChanged = !serverCap.Tools.ListChanged
+			serverCap.Experimental["toggle"] = false
+			return mcp.CallToolResult{}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	srv, _, httpSrv := setupSSE()
+	defer httpSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error),
+		mcp.WithToolServer(registry), mcp.WithServerExperimentalCapability("toggle", true))
+
+	const sessions = 10
+	errs := make(chan error, sessions)
+	for range sessions {
+		go func() {
+			transport := mcp.NewSSEClient(httpSrv.URL+"/sse", httpSrv.Client())
+			cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, transport,
+				mcp.ServerRequirement{ToolServer: true})
+			defer cli.Close()
+
+			if err := cli.Connect(); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := cli.CallTool(ctx, mcp.CallToolParams{Name: "toggle"}); err != nil {
+				errs <- err
+				return
+			}
+			capabilities := cli.ServerCapabilities()
+			if capabilities.Tools.ListChanged || capabilities.Experimental["toggle"] != true {
+				errs <- fmt.Errorf("expected the initial capabilities, got %+v", capabilities)
+				return
+			}
+			errs <- nil
+		}()
+	}
+
+	for range sessions {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestServerBroadcast(t *testing.T) {
+	broadcaster := mcp.NewBroadcaster()
+	if err := broadcaster.Broadcast(context.Background(), "notifications/banner", nil); err == nil {
+		t.Error("expected error broadcasting without a running server")
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithBroadcaster(broadcaster))
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"init","method":"initialize","params":{"protocolVersion":"2024-11-05",`+
+		`"capabilities":{},"clientInfo":{"name":"raw-client","version":"1.0"}}}`)
+	if msg := cli.receive(t); msg.ID != "init" || msg.Error != nil {
+		t.Fatalf("expected initialize response, got %+v", msg)
+	}
+
+	// The client is mid-handshake, so it's skipped.
+	if err := broadcaster.Broadcast(context.Background(), "notifications/banner", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cli.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	cli.send(t, `{"jsonrpc":"2.0","id":"ping","method":"ping"}`)
+	if msg := cli.receive(t); msg.ID != "ping" {
+		t.Fatalf("expected ping response, got %+v", msg)
+	}
+
+	params := map[string]string{"message": "maintenance at noon"}
+	if err := broadcaster.Broadcast(context.Background(), "notifications/banner", params); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	msg := cli.receive(t)
+	if msg.Method != "notifications/banner" {
+		t.Fatalf("expected banner notification, got %+v", msg)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(msg.Params, &got); err != nil {
+		t.Fatalf("failed to unmarshal params: %v", err)
+	}
+	if got["message"] != "maintenance at noon" {
+		t.Errorf("expected banner message, got %v", got)
+	}
+}
+
+func TestServerInvalidJSONRPCVersion(t *testing.T) {
+	cli := setupRawClient(t, mockServer{})
+
+	cli.send(t, `{"jsonrpc":"1.0","id":"old","method":"ping"}`)
+	cli.send(t, `{"id":"missing","method":"ping"}`)
+	// Notifications can't be answered, so this one is only dropped.
+	cli.send(t, `{"jsonrpc":"1.0","method":"notifications/initialized"}`)
+	cli.send(t, `{"jsonrpc":"2.0","id":"valid","method":"ping"}`)
+
+	for _, id := range []mcp.MustString{"old", "missing"} {
+		msg := cli.receive(t)
+		if msg.ID != id || msg.Error == nil {
+			t.Fatalf("expected error response with ID %s, got %+v", id, msg)
+		}
+		if msg.Error.Code != -32600 {
+			t.Errorf("expected error code -32600, got %d", msg.Error.Code)
+		}
+	}
+
+	if msg := cli.receive(t); msg.ID != "valid" || msg.Error != nil {
+		t.Fatalf("expected ping response with ID valid, got %+v", msg)
+	}
+}
+
+func TestServerUnknownResponse(t *testing.T) {
+	cli := setupRawClient(t, mockServer{})
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"unknown","result":{}}`)
+	cli.send(t, `{"jsonrpc":"2.0","id":"ping","method":"ping"}`)
+	if msg := cli.receive(t); msg.ID != "ping" || msg.Error != nil {
+		t.Fatalf("expected the session to survive, got %+v", msg)
+	}
+}
+
+func TestServerDuplicateInitialize(t *testing.T) {
+	cli := setupRawClient(t, mockServer{})
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"again","method":"initialize","params":{"protocolVersion":"2024-11-05",`+
+		`"capabilities":{},"clientInfo":{"name":"raw-client","version":"1.0"}}}`)
+
+	msg := cli.receive(t)
+	if msg.ID != "again" || msg.Error == nil {
+		t.Fatalf("expected error response with ID again, got %+v", msg)
+	}
+	if msg.Error.Code != -32600 {
+		t.Errorf("expected error code -32600, got %d", msg.Error.Code)
+	}
+}
+
+func TestServerToolOutputValidation(t *testing.T) {
+	toolServer := mockStructuredToolServer{contents: map[string]string{
+		"plain":   `{"anything":true}`,
+		"valid":   `{"total":3}`,
+		"invalid": `{"total":"three"}`,
+	}}
+
+	type testCase struct {
+		name      string
+		validate  bool
+		wantError bool
+	}
+
+	testCases := []testCase{
+		{name: "plain", validate: true},
+		{name: "valid", validate: true},
+		{name: "invalid", validate: true, wantError: true},
+		{name: "missing", validate: true, wantError: true},
+		{name: "invalid", validate: false},
+		{name: "missing", validate: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s validate %t", tc.name, tc.validate), func(t *testing.T) {
+			cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(toolServer),
+				mcp.WithToolOutputValidation(tc.validate))
+			cli.initialize(t)
+
+			cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"`+tc.name+`"}}`)
+
+			msg := cli.receive(t)
+			if msg.ID != "call" {
+				t.Fatalf("expected response with ID call, got %+v", msg)
+			}
+			if tc.wantError != (msg.Error != nil) {
+				t.Errorf("expected error %t, got %+v", tc.wantError, msg.Error)
+			}
+		})
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(toolServer))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"list","method":"tools/list","params":{"cursor":"next"}}`)
+
+	msg := cli.receive(t)
+	var result mcp.ListToolsResult
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if len(result.Tools) == 0 || result.Tools[0].OutputSchema == nil {
+		t.Fatalf("expected tools with output schema, got %s", msg.Result)
+	}
+	keyErrs, err := result.Tools[0].OutputSchema.ValidateBytes(context.Background(), []byte(`{}`))
+	if err != nil {
+		t.Fatalf("failed to validate: %v", err)
+	}
+	if len(keyErrs) == 0 {
+		t.Error("expected decoded output schema to require total")
+	}
+}
+
+func TestServerWriteTimeoutPolicy(t *testing.T) {
+	type testCase struct {
+		name        string
+		policy      mcp.WriteTimeoutPolicy
+		wantClosing bool
+	}
+
+	testCases := []testCase{
+		{name: "drop message", policy: mcp.WriteTimeoutDropMessage},
+		{name: "close session", policy: mcp.WriteTimeoutCloseSession, wantClosing: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transport := stalledTransport{
+				sessions: make(chan mcp.SessionCtx, 1),
+				messages: make(chan mcp.SessionMsgWithErrs),
+				sends:    make(chan mcp.MustString, 10),
+				closed:   make(chan string, 1),
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go mcp.Serve(ctx, mockServer{}, transport, make(chan error),
+				mcp.WithServerWriteTimeout(20*time.Millisecond),
+				mcp.WithWriteTimeoutPolicy(tc.policy))
+
+			transport.sessions <- mcp.SessionCtx{Ctx: ctx, ID: "stalled"}
+			transport.ping(t, "1")
+
+			select {
+			case id := <-transport.closed:
+				if !tc.wantClosing {
+					t.Fatalf("expected session to stay open, got %s closed", id)
+				}
+				return
+			case <-time.After(200 * time.Millisecond):
+				if tc.wantClosing {
+					t.Fatal("expected session to be closed after the write timeout")
+				}
+			}
+
+			// The session is still served after the dropped response.
+			transport.ping(t, "2")
+		})
+	}
+}
+
+func TestServerApplySchemaDefaults(t *testing.T) {
+	type testCase struct {
+		name  string
+		apply bool
+		want  map[string]any
+	}
+
+	testCases := []testCase{
+		{name: "applied", apply: true, want: map[string]any{"city": "Oslo", "days": 7.0, "unit": "celsius"}},
+		{name: "disabled", apply: false, want: map[string]any{"city": "Oslo", "days": 7.0}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(mockArgumentsToolServer{}),
+				mcp.WithApplySchemaDefaults(tc.apply))
+			cli.initialize(t)
+
+			cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call",`+
+				`"params":{"name":"forecast","arguments":{"city":"Oslo","days":7}}}`)
+
+			msg := cli.receive(t)
+			if msg.ID != "call" || msg.Error != nil {
+				t.Fatalf("expected successful response with ID call, got %+v", msg)
+			}
+			var result mcp.CallToolResult
+			if err := json.Unmarshal(msg.Result, &result); err != nil {
+				t.Fatalf("failed to unmarshal result: %v", err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(result.StructuredContent, &got); err != nil {
+				t.Fatalf("failed to unmarshal arguments: %v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("expected arguments %v, got %v", tc.want, got)
+			}
+			for k, v := range tc.want {
+				if got[k] != v {
+					t.Errorf("expected argument %s to be %v, got %v", k, v, got[k])
+				}
+			}
+
+			// The defaults reach the clients listing the tools.
+			cli.send(t, `{"jsonrpc":"2.0","id":"list","method":"tools/list","params":{}}`)
+			msg = cli.receive(t)
+			var tools struct {
+				Tools []struct {
+					InputSchema struct {
+						Properties map[string]struct {
+							Default any `json:"default"`
+						} `json:"properties"`
+					} `json:"inputSchema"`
+				} `json:"tools"`
+			}
+			if err := json.Unmarshal(msg.Result, &tools); err != nil {
+				t.Fatalf("failed to unmarshal tools: %v", err)
+			}
+			if len(tools.Tools) != 1 || tools.Tools[0].InputSchema.Properties["unit"].Default != "celsius" {
+				t.Errorf("expected the listed schema to declare the default unit, got %s", msg.Result)
+			}
+		})
+	}
+}
+
+func TestServerToolListCache(t *testing.T) {
+	tools := mockCountingToolServer{lists: new(atomic.Int32)}
+	updater := mockManualToolListUpdater{updates: make(chan struct{})}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(tools), mcp.WithToolListUpdater(updater),
+		mcp.WithApplySchemaDefaults(true))
+	cli.initialize(t)
+
+	call := func(t *testing.T, id string) {
+		t.Helper()
+
+		cli.send(t, `{"jsonrpc":"2.0","id":"`+id+`","method":"tools/call",`+
+			`"params":{"name":"forecast","arguments":{"city":"Oslo"}}}`)
+		if msg := cli.receive(t); msg.ID != mcp.MustString(id) || msg.Error != nil {
+			t.Fatalf("expected successful response with ID %s, got %+v", id, msg)
+		}
+	}
+
+	call(t, "first")
+	call(t, "second")
+	if lists := tools.lists.Load(); lists != 1 {
+		t.Errorf("expected the tools to be listed once, got %d listings", lists)
+	}
+
+	updater.updates <- struct{}{}
+	if msg := cli.receive(t); msg.Method != "notifications/tools/list_changed" {
+		t.Fatalf("expected tools list changed notification, got %+v", msg)
+	}
+	call(t, "changed")
+	if lists := tools.lists.Load(); lists != 2 {
+		t.Errorf("expected the tools to be listed again once changed, got %d listings", lists)
+	}
+}
+
+func TestServerRequireDeclaredArgs(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{
+		Name: "forecast",
+		InputSchema: jsonschema.Must(`{"type":"object","properties":{"city":{"type":"string"},` +
+			`"days":{"type":"integer"}},"required":["city","days"]}`),
+	}, func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+		return mcp.CallToolResult{}, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithRequireDeclaredArgs(true),
+		mcp.WithToolServer(registry), mcp.WithPromptServer(mockRequiredArgsPromptServer{}))
+	cli.initialize(t)
+
+	expectMissing := func(t *testing.T, id string, want []string) {
+		t.Helper()
+
+		msg := cli.receive(t)
+		if msg.ID != mcp.MustString(id) || msg.Error == nil || msg.Error.Code != -32602 {
+			t.Fatalf("expected invalid params error with ID %s, got %+v", id, msg)
+		}
+		data, _ := json.Marshal(msg.Error.Data)
+		var got struct {
+			Missing []string `json:"missing"`
+		}
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("failed to unmarshal error data: %v", err)
+		}
+		if fmt.Sprint(got.Missing) != fmt.Sprint(want) {
+			t.Errorf("expected missing arguments %v, got %v", want, got.Missing)
+		}
+	}
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"tool","method":"tools/call",`+
+		`"params":{"name":"forecast","arguments":{"city":"Oslo"}}}`)
+	expectMissing(t, "tool", []string{"days"})
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"prompt","method":"prompts/get",`+
+		`"params":{"name":"greeting","arguments":{"tone":"warm"}}}`)
+	expectMissing(t, "prompt", []string{"name"})
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"complete","method":"tools/call",`+
+		`"params":{"name":"forecast","arguments":{"city":"Oslo","days":3}}}`)
+	if msg := cli.receive(t); msg.ID != "complete" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID complete, got %+v", msg)
+	}
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"optional","method":"prompts/get",`+
+		`"params":{"name":"greeting","arguments":{"name":"Ada"}}}`)
+	if msg := cli.receive(t); msg.ID != "optional" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID optional, got %+v", msg)
+	}
+}
+
+func TestServerToolInputValidation(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{
+		Name: "forecast",
+		InputSchema: jsonschema.Must(`{"type":"object",` +
+			`"$defs":{"unit":{"type":"string","default":"celsius"},` +
+			`"location":{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]},` +
+			`"origin":{"$ref":"#/$defs/location"}},` +
+			`"properties":{"location":{"$ref":"#/$defs/origin"},"unit":{"$ref":"#/$defs/unit"}},` +
+			`"required":["location"]}`),
+	}, func(_ context.Context, params mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+		args, err := json.Marshal(params.Arguments)
+		return mcp.CallToolResult{StructuredContent: args}, err
+	})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry),
+		mcp.WithToolInputValidation(true), mcp.WithApplySchemaDefaults(true))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"valid","method":"tools/call",`+
+		`"params":{"name":"forecast","arguments":{"location":{"city":"Oslo"}}}}`)
+	msg := cli.receive(t)
+	if msg.ID != "valid" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID valid, got %+v", msg)
+	}
+	var result mcp.CallToolResult
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	var args map[string]any
+	if err := json.Unmarshal(result.StructuredContent, &args); err != nil {
+		t.Fatalf("failed to unmarshal arguments: %v", err)
+	}
+	if args["unit"] != "celsius" {
+		t.Errorf("expected the default of the referenced unit to be applied, got %v", args)
+	}
+
+	invalid := map[string]string{
+		"missing": `{"unit":"kelvin"}`,
+		"type":    `{"location":{"city":7}}`,
+		"nested":  `{"location":{}}`,
+	}
+	for id, arguments := range invalid {
+		cli.send(t, `{"jsonrpc":"2.0","id":"`+id+`","method":"tools/call",`+
+			`"params":{"name":"forecast","arguments":`+arguments+`}}`)
+		msg := cli.receive(t)
+		if msg.ID != mcp.MustString(id) || msg.Error == nil || msg.Error.Code != -32602 {
+			t.Errorf("expected invalid params error for arguments %s, got %+v", arguments, msg)
+		}
+	}
+}
+
+func TestServerHandlerPanic(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "crash"},
+		func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			panic("tool is broken")
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"crash"}}`)
+	msg := cli.receive(t)
+	if msg.ID != "call" || msg.Error == nil || msg.Error.Code != -32603 {
+		t.Fatalf("expected internal error response with ID call, got %+v", msg)
+	}
+
+	// The session survives the panic.
+	cli.send(t, `{"jsonrpc":"2.0","id":"ping","method":"ping"}`)
+	msg = cli.receive(t)
+	if msg.ID != "ping" || msg.Error != nil {
+		t.Fatalf("expected successful ping response, got %+v", msg)
+	}
+}
+
+func TestServerToolErrors(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	tools := map[string]mcp.ToolHandler{
+		"refused": func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			return mcp.CallToolResult{
+				Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: "the API refused the request"}},
+				IsError: true,
+			}, nil
+		},
+		"broken": func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			return mcp.CallToolResult{IsError: true}, errors.New("database is down")
+		},
+		"invalid": func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			return mcp.CallToolResult{}, fmt.Errorf("bad query: %w", mcp.JSONRPCError{
+				Code:    -32602,
+				Message: "Invalid params",
+			})
+		},
+	}
+	for name, handler := range tools {
+		if err := registry.Add(mcp.Tool{Name: name}, handler); err != nil {
+			t.Fatalf("failed to add tool: %v", err)
+		}
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"refused","method":"tools/call","params":{"name":"refused"}}`)
+	msg := cli.receive(t)
+	if msg.ID != "refused" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID refused, got %+v", msg)
+	}
+	var result mcp.CallToolResult
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if !result.IsError || len(result.Content) != 1 || result.Content[0].Text != "the API refused the request" {
+		t.Errorf("expected tool error result, got %+v", result)
+	}
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"broken","method":"tools/call","params":{"name":"broken"}}`)
+	msg = cli.receive(t)
+	if msg.ID != "broken" || msg.Error == nil || msg.Error.Code != -32603 || msg.Result != nil {
+		t.Fatalf("expected internal error response with ID broken and no result, got %+v", msg)
+	}
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"invalid","method":"tools/call","params":{"name":"invalid"}}`)
+	msg = cli.receive(t)
+	if msg.ID != "invalid" || msg.Error == nil || msg.Error.Code != -32602 {
+		t.Fatalf("expected invalid params error response with ID invalid, got %+v", msg)
+	}
+}
+
+func TestServerClock(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "sample"},
+		func(_ context.Context, _ mcp.CallToolParams, requestClient mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			_, err := requestClient(mcp.JSONRPCMessage{
+				JSONRPC: mcp.JSONRPCVersion,
+				Method:  mcp.MethodSamplingCreateMessage,
+			})
+			return mcp.CallToolResult{}, err
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+	contexts := make(chan context.Context, 1)
+	err = registry.Add(mcp.Tool{Name: "wait"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			contexts <- ctx
+			<-ctx.Done()
+			return mcp.CallToolResult{}, ctx.Err()
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	expectNoMessage := func(t *testing.T, cli *rawClient) {
+		t.Helper()
+
+		select {
+		case msg := <-cli.msgs:
+			t.Fatalf("expected no message before the timeout, got %+v", msg)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	t.Run("read timeout", func(t *testing.T) {
+		clock := mcptest.NewFakeClock(time.Now())
+		// The write timeout of the sampling request is out of the way, as its send may still be
+		// returning when the time moves.
+		cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry),
+			mcp.WithServerReadTimeout(time.Minute), mcp.WithServerWriteTimeout(time.Hour), mcp.WithClock(clock))
+		cli.initialize(t)
+
+		cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"sample"}}`)
+		if msg := cli.receive(t); msg.Method != mcp.MethodSamplingCreateMessage {
+			t.Fatalf("expected sampling request, got %+v", msg)
+		}
+
+		// The sweeper of the pending requests.
+		clock.BlockUntil(1)
+		clock.Advance(30 * time.Second)
+		expectNoMessage(t, cli)
+
+		clock.Advance(30 * time.Second)
+		if msg := cli.receive(t); msg.ID != "call" || msg.Error == nil {
+			t.Fatalf("expected error response with ID call, got %+v", msg)
+		}
+	})
+
+	t.Run("method timeout", func(t *testing.T) {
+		clock := mcptest.NewFakeClock(time.Now())
+		cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry),
+			mcp.WithMethodTimeout(mcp.MethodToolsCall, time.Minute), mcp.WithClock(clock))
+		cli.initialize(t)
+
+		cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"wait"}}`)
+		ctx := <-contexts
+		if deadline, ok := ctx.Deadline(); !ok || !deadline.Equal(clock.Now().Add(time.Minute)) {
+			t.Errorf("expected a deadline a minute from now on the clock, got %v", deadline)
+		}
+
+		clock.Advance(59 * time.Second)
+		expectNoMessage(t, cli)
+
+		clock.Advance(time.Second)
+		if msg := cli.receive(t); msg.ID != "call" || msg.Error == nil {
+			t.Fatalf("expected error response with ID call, got %+v", msg)
+		}
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			t.Errorf("expected the context to exceed its deadline, got %v", ctx.Err())
+		}
+	})
+}
+
+func TestServerCorrelationID(t *testing.T) {
+	correlationIDs := make(chan string, 1)
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "crash"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			id, _ := mcp.CorrelationID(ctx)
+			correlationIDs <- id
+			panic("tool is broken")
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"crash"}}`)
+	if msg := cli.receive(t); msg.ID != "call" || msg.Error == nil {
+		t.Fatalf("expected error response with ID call, got %+v", msg)
+	}
+
+	id := <-correlationIDs
+	if !strings.HasSuffix(id, "/call") {
+		t.Errorf("expected correlation ID of request call, got %q", id)
+	}
+
+	for {
+		select {
+		case err := <-cli.errs:
+			var reqErr mcp.RequestError
+			if !errors.As(err, &reqErr) {
+				continue
+			}
+			if reqErr.CorrelationID != id {
+				t.Errorf("expected correlation ID %q, got %q", id, reqErr.CorrelationID)
+			}
+			if !strings.HasPrefix(err.Error(), mcp.CorrelationIDKey+"="+id+": ") {
+				t.Errorf("expected error message prefixed with the correlation ID, got %q", err)
+			}
+			return
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for the request error")
+		}
+	}
+}
+
+func TestServerUnnamed(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("expected Serve to panic for a server without a name")
+		}
+		if msg := fmt.Sprint(r); !strings.Contains(msg, "the Info of the server has an empty name") {
+			t.Errorf("expected the panic to report the empty name, got %q", msg)
+		}
+	}()
+
+	srvReader, _ := io.Pipe()
+	_, srvWriter := io.Pipe()
+	mcp.Serve(context.Background(), mockUnnamedServer{}, mcp.NewStdIO(srvReader, srvWriter), make(chan error))
+}
+
+func TestServerSchemaDialect(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.AddAll([]mcp.ToolEntry{
+		{Tool: mcp.Tool{
+			Name:         "plain",
+			InputSchema:  jsonschema.Must(`{"type":"object"}`),
+			OutputSchema: jsonschema.Must(`{"type":"object"}`),
+		}},
+		{Tool: mcp.Tool{
+			Name:        "declared",
+			InputSchema: jsonschema.Must(`{"$schema":"` + mcp.SchemaDialectDraft07 + `","type":"object"}`),
+		}},
+	})
+	if err != nil {
+		t.Fatalf("failed to add tools: %v", err)
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry),
+		mcp.WithSchemaDialect(mcp.SchemaDialect202012))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"list","method":"tools/list","params":{}}`)
+	msg := cli.receive(t)
+	if msg.ID != "list" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID list, got %+v", msg)
+	}
+
+	var result struct {
+		Tools []struct {
+			InputSchema  map[string]any `json:"inputSchema"`
+			OutputSchema map[string]any `json:"outputSchema"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if len(result.Tools) != 2 {
+		t.Fatalf("expected 2 tools, got %d", len(result.Tools))
+	}
+
+	plain, declared := result.Tools[0], result.Tools[1]
+	if plain.InputSchema["$schema"] != mcp.SchemaDialect202012 ||
+		plain.OutputSchema["$schema"] != mcp.SchemaDialect202012 {
+		t.Errorf("expected the dialect to be declared, got %v and %v", plain.InputSchema, plain.OutputSchema)
+	}
+	if declared.InputSchema["$schema"] != mcp.SchemaDialectDraft07 {
+		t.Errorf("expected the declared dialect to be kept, got %v", declared.InputSchema)
+	}
+}
+
+func TestServerResultInterceptor(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "leak"},
+		func(context.Context, mcp.CallToolParams, mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			return mcp.CallToolResult{
+				Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: "the password is hunter2"}},
+			}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	var lock sync.Mutex
+	var methods []string
+	interceptor := func(_ context.Context, method string, result any) (any, error) {
+		lock.Lock()
+		methods = append(methods, method)
+		lock.Unlock()
+
+		switch r := result.(type) {
+		case mcp.CallToolResult:
+			for i, content := range r.Content {
+				r.Content[i].Text = strings.ReplaceAll(content.Text, "hunter2", "[REDACTED]")
+			}
+			return r, nil
+		case mcp.ListToolsResult:
+			return nil, errors.New("listing is forbidden")
+		}
+		return result, nil
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry), mcp.WithResultInterceptor(interceptor))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"leak"}}`)
+	msg := cli.receive(t)
+	if msg.ID != "call" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID call, got %+v", msg)
+	}
+	var result mcp.CallToolResult
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if len(result.Content) != 1 || result.Content[0].Text != "the password is [REDACTED]" {
+		t.Errorf("expected redacted result, got %+v", result)
+	}
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"list","method":"tools/list","params":{}}`)
+	msg = cli.receive(t)
+	if msg.ID != "list" || msg.Error == nil || msg.Error.Code != -32603 || msg.Result != nil {
+		t.Fatalf("expected internal error response with ID list and no result, got %+v", msg)
+	}
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"ping","method":"ping"}`)
+	if msg := cli.receive(t); msg.ID != "ping" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID ping, got %+v", msg)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	// The initialization ends with a ping, to wait until the session is initialized.
+	want := []string{"initialize", "ping", "tools/call", "tools/list", "ping"}
+	if !slices.Equal(methods, want) {
+		t.Errorf("expected intercepted methods %v, got %v", want, methods)
+	}
+}
+
+func TestServerFanoutConcurrency(t *testing.T) {
+	transport := fanoutTransport{
+		sessions: make(chan mcp.SessionCtx),
+		messages: make(chan mcp.SessionMsgWithErrs),
+		fast:     make(chan string, 10),
+	}
+	changes := make(mockPromptListChanges)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, transport, make(chan error),
+		mcp.WithPromptServer(&mockPromptServer{}), mcp.WithPromptListUpdater(changes), mcp.WithFanoutConcurrency(2))
+
+	transport.sessions <- mcp.SessionCtx{Ctx: ctx, ID: "slow"}
+	transport.sessions <- mcp.SessionCtx{Ctx: ctx, ID: "fast"}
+	// Messages are handled after the sessions are started, so both are running once it's handled.
+	errs := make(chan error)
+	transport.messages <- mcp.SessionMsgWithErrs{
+		SessionID: "fast",
+		Msg:       mcp.JSONRPCMessage{JSONRPC: mcp.JSONRPCVersion, Method: "notifications/initialized"},
+		Errs:      errs,
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("failed to handle message: %v", err)
+	}
+
+	// The slow session gets stuck sending the first change, so it can't take the second one, which
+	// must not hold up the delivery to the fast session.
+	changes <- struct{}{}
+	changes <- struct{}{}
+
+	for i := range 2 {
+		select {
+		case method := <-transport.fast:
+			if method != "notifications/prompts/list_changed" {
+				t.Errorf("expected prompts list changed notification, got %s", method)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected notification %d to reach the fast session promptly", i+1)
+		}
+	}
+}
+
+func TestServerEvictBrokenSession(t *testing.T) {
+	transport := brokenTransport{fanoutTransport{
+		sessions: make(chan mcp.SessionCtx),
+		messages: make(chan mcp.SessionMsgWithErrs),
+		fast:     make(chan string, 10),
+	}}
+	changes := make(mockPromptListChanges)
+	disconnected := make(chan string, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, transport, make(chan error, 10),
+		mcp.WithPromptServer(&mockPromptServer{}), mcp.WithPromptListUpdater(changes),
+		mcp.WithSessionLifecycleHook(nil, nil, func(info mcp.SessionInfo) { disconnected <- info.ID }))
+
+	transport.sessions <- mcp.SessionCtx{Ctx: ctx, ID: "broken"}
+	transport.sessions <- mcp.SessionCtx{Ctx: ctx, ID: "flaky"}
+	transport.sessions <- mcp.SessionCtx{Ctx: ctx, ID: "fast"}
+	// Messages are handled after the sessions are started, so both are running once it's handled.
+	errs := make(chan error)
+	transport.messages <- mcp.SessionMsgWithErrs{
+		SessionID: "fast",
+		Msg:       mcp.JSONRPCMessage{JSONRPC: mcp.JSONRPCVersion, Method: "notifications/initialized"},
+		Errs:      errs,
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("failed to handle message: %v", err)
+	}
+
+	expectNotification := func(t *testing.T) {
+		t.Helper()
+
+		select {
+		case method := <-transport.fast:
+			if method != "notifications/prompts/list_changed" {
+				t.Errorf("expected prompts list changed notification, got %s", method)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the notification to reach the fast session")
+		}
+	}
+
+	changes <- struct{}{}
+	expectNotification(t)
+	select {
+	case id := <-disconnected:
+		if id != "broken" {
+			t.Errorf("expected the broken session to be evicted, got %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the broken session to be evicted")
+	}
+
+	changes <- struct{}{}
+	expectNotification(t)
+	select {
+	case id := <-disconnected:
+		t.Errorf("expected no other session to be evicted, got %s", id)
+	default:
+	}
+}
+
+func TestServerMethodTimeout(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "deadline"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			text := "none"
+			if deadline, ok := ctx.Deadline(); ok {
+				text = time.Until(deadline).Round(time.Minute).String()
+			}
+			return mcp.CallToolResult{Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: text}}}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+	err = registry.Add(mcp.Tool{Name: "sample"},
+		func(ctx context.Context, _ mcp.CallToolParams, requestClient mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			_, err := requestClient(mcp.JSONRPCMessage{
+				JSONRPC: mcp.JSONRPCVersion,
+				Method:  mcp.MethodSamplingCreateMessage,
+			})
+			return mcp.CallToolResult{}, err
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	callDeadline := func(t *testing.T, cli *rawClient) string {
+		t.Helper()
+
+		cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"deadline"}}`)
+		msg := cli.receive(t)
+		if msg.ID != "call" || msg.Error != nil {
+			t.Fatalf("expected successful response with ID call, got %+v", msg)
+		}
+		var result mcp.CallToolResult
+		if err := json.Unmarshal(msg.Result, &result); err != nil {
+			t.Fatalf("failed to unmarshal result: %v", err)
+		}
+		return result.Content[0].Text
+	}
+
+	t.Run("tools/call", func(t *testing.T) {
+		cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry),
+			mcp.WithMethodTimeout(mcp.MethodToolsCall, 10*time.Minute),
+			mcp.WithDefaultMethodTimeout(time.Hour))
+		cli.initialize(t)
+
+		if got := callDeadline(t, cli); got != "10m0s" {
+			t.Errorf("expected the handler to have 10m left, got %s", got)
+		}
+	})
+
+	t.Run("default", func(t *testing.T) {
+		cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry),
+			mcp.WithMethodTimeout(mcp.MethodResourcesRead, time.Second),
+			mcp.WithDefaultMethodTimeout(time.Hour))
+		cli.initialize(t)
+
+		if got := callDeadline(t, cli); got != "1h0m0s" {
+			t.Errorf("expected the handler to have 1h left, got %s", got)
+		}
+	})
+
+	t.Run("exempt", func(t *testing.T) {
+		cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry),
+			mcp.WithMethodTimeout(mcp.MethodToolsCall, 0),
+			mcp.WithDefaultMethodTimeout(time.Hour))
+		cli.initialize(t)
+
+		if got := callDeadline(t, cli); got != "none" {
+			t.Errorf("expected the handler to have no deadline, got %s", got)
+		}
+	})
+
+	t.Run("sampling/createMessage", func(t *testing.T) {
+		cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry),
+			mcp.WithMethodTimeout(mcp.MethodSamplingCreateMessage, 50*time.Millisecond))
+		cli.initialize(t)
+
+		cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"sample"}}`)
+		msg := cli.receive(t)
+		if msg.Method != mcp.MethodSamplingCreateMessage {
+			t.Fatalf("expected sampling request, got %+v", msg)
+		}
+
+		// The sampling request is never answered, so it's cancelled once its timeout elapses.
+		if msg = cli.receive(t); msg.Method != "notifications/cancelled" {
+			t.Fatalf("expected cancelled notification, got %+v", msg)
+		}
+		if msg = cli.receive(t); msg.ID != "call" || msg.Error == nil {
+			t.Fatalf("expected error response for the tool call, got %+v", msg)
+		}
+	})
+}
+
+func TestServerClientProgress(t *testing.T) {
+	registry := mcp.NewToolRegistry()
+	err := registry.Add(mcp.Tool{Name: "relay"},
+		func(ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc) (mcp.CallToolResult, error) {
+			progress := make(chan mcp.ProgressParams)
+			var reports []string
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for params := range progress {
+					if params.Total == nil {
+						continue
+					}
+					reports = append(reports, fmt.Sprintf("%v/%v", params.Progress, *params.Total))
+				}
+			}()
+
+			res, err := mcp.RequestClientProgress(ctx, mcp.JSONRPCMessage{
+				JSONRPC: mcp.JSONRPCVersion,
+				Method:  mcp.MethodSamplingCreateMessage,
+				Params:  json.RawMessage(`{"messages":[],"maxTokens":10,"_meta":{"trace":"t1"}}`),
+			}, progress)
+			<-done
+			if err != nil {
+				return mcp.CallToolResult{}, err
+			}
+
+			text := fmt.Sprintf("%s %s", strings.Join(reports, ","), res.Result)
+			return mcp.CallToolResult{Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: text}}}, nil
+		})
+	if err != nil {
+		t.Fatalf("failed to add tool: %v", err)
+	}
+
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(registry))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"relay"}}`)
+	msg := cli.receive(t)
+	if msg.Method != mcp.MethodSamplingCreateMessage {
+		t.Fatalf("expected sampling request, got %+v", msg)
+	}
+	var params struct {
+		MaxTokens int `json:"maxTokens"`
+		Meta      struct {
+			ProgressToken string `json:"progressToken"`
+			Trace         string `json:"trace"`
+		} `json:"_meta"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		t.Fatalf("failed to unmarshal params: %v", err)
+	}
+	if params.Meta.ProgressToken == "" || params.Meta.Trace != "t1" || params.MaxTokens != 10 {
+		t.Fatalf("expected the params with a progress token, got %s", msg.Params)
+	}
+
+	for i := 1; i <= 2; i++ {
+		cli.send(t, fmt.Sprintf(`{"jsonrpc":"2.0","method":"notifications/progress",`+
+			`"params":{"progressToken":%q,"value":%d,"total":3}}`, params.Meta.ProgressToken, i))
+	}
+	// Progress for another request is ignored.
+	cli.send(t, `{"jsonrpc":"2.0","method":"notifications/progress","params":{"progressToken":"other","value":1}}`)
+	cli.send(t, fmt.Sprintf(`{"jsonrpc":"2.0","id":%q,"result":{"done":true}}`, msg.ID))
+
+	msg = cli.receive(t)
+	if msg.ID != "call" || msg.Error != nil {
+		t.Fatalf("expected successful response with ID call, got %+v", msg)
+	}
+	var result mcp.CallToolResult
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+	if want := `1/3,2/3 {"done":true}`; result.Content[0].Text != want {
+		t.Errorf("expected %q, got %q", want, result.Content[0].Text)
+	}
+}
+
+func TestServerInvalidOptions(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected Serve to panic for contradictory options")
+		}
+	}()
+
+	srvReader, _ := io.Pipe()
+	_, srvWriter := io.Pipe()
+	mcp.Serve(context.Background(), mockServer{}, mcp.NewStdIO(srvReader, srvWriter), make(chan error),
+		mcp.WithToolListUpdater(mockToolListUpdater{}))
+}
+
+func TestValidateServer(t *testing.T) {
+	if err := mcp.ValidateServer(mockServer{}, mcp.WithResourceServer(&mockResourceServer{}),
+		mcp.WithResourceSubscribedUpdater(mockResourceSubscribedUpdater{})); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err := mcp.ValidateServer(mockServer{},
+		mcp.WithResourceSubscribedUpdater(mockResourceSubscribedUpdater{}),
+		mcp.WithToolListUpdater(mockToolListUpdater{}),
+		mcp.WithMethodTimeout(mcp.MethodToolsCall, -time.Second),
+		mcp.WithNotificationBuffer(-1))
+	if err == nil {
+		t.Fatal("expected error for contradictory options")
+	}
+	for _, want := range []string{
+		"WithResourceSubscribedUpdater is set without WithResourceServer",
+		"WithToolListUpdater is set without WithToolServer",
+		"WithMethodTimeout for tools/call is negative: -1s",
+		"WithNotificationBuffer is negative: -1",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got %v", want, err)
+		}
+	}
+}
+
+func TestServerDiagnostics(t *testing.T) {
+	options := []mcp.ServerOption{
+		mcp.WithToolServer(&mockToolServer{}),
+		mcp.WithToolListUpdater(mockToolListUpdater{}),
+		mcp.WithLogHandler(mockLogHandler{}),
+		mcp.WithServerWriteTimeout(5 * time.Second),
+		mcp.WithMethodTimeout(mcp.MethodToolsCall, time.Minute),
+		mcp.WithServerExperimentalCapability("custom", true),
+	}
+
+	diagnostics := mcp.ServerDiagnostics(mockServer{}, options...)
+	if diagnostics.Info.Name != "test-server" {
+		t.Errorf("expected the info of the server, got %+v", diagnostics.Info)
+	}
+	caps := diagnostics.Capabilities
+	if caps.Prompts != nil || caps.Resources != nil || diagnostics.Completion {
+		t.Errorf("expected no prompts, resources or completion, got %+v", diagnostics)
+	}
+	if caps.Tools == nil || !caps.Tools.ListChanged || caps.Logging == nil {
+		t.Errorf("expected tools with list changes and logging, got %+v", caps)
+	}
+	if caps.Experimental["custom"] != true {
+		t.Errorf("expected the experimental capability, got %v", caps.Experimental)
+	}
+	if diagnostics.WriteTimeout != 5*time.Second || diagnostics.ReadTimeout <= 0 {
+		t.Errorf("expected the write timeout and the default read timeout, got %s and %s",
+			diagnostics.WriteTimeout, diagnostics.ReadTimeout)
+	}
+	if diagnostics.MethodTimeouts[mcp.MethodToolsCall] != time.Minute || diagnostics.DefaultMethodTimeout != 0 {
+		t.Errorf("expected a timeout for tools/call only, got %v and %s",
+			diagnostics.MethodTimeouts, diagnostics.DefaultMethodTimeout)
+	}
+
+	// Snapshots share no state, so a caller changing one doesn't affect the next.
+	diagnostics.Capabilities.Experimental["custom"] = false
+	diagnostics.MethodTimeouts[mcp.MethodToolsCall] = 0
+	again := mcp.ServerDiagnostics(mockServer{}, options...)
+	if again.Capabilities.Experimental["custom"] != true || again.MethodTimeouts[mcp.MethodToolsCall] != time.Minute {
+		t.Errorf("expected a fresh snapshot, got %+v", again)
+	}
+
+	if _, err := json.Marshal(again); err != nil {
+		t.Errorf("failed to marshal diagnostics: %v", err)
+	}
+}
+
+func TestServerSessionLifecycleHook(t *testing.T) {
+	transport := fanoutTransport{
+		sessions: make(chan mcp.SessionCtx),
+		messages: make(chan mcp.SessionMsgWithErrs),
+		fast:     make(chan string, 10),
+	}
+	events := make(chan string, 3)
+	hook := func(event string) func(mcp.SessionInfo) {
+		return func(info mcp.SessionInfo) {
+			events <- fmt.Sprintf("%s %s %s/%s", event, info.ID, info.ClientInfo.Name, info.ProtocolVersion)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, transport, make(chan error),
+		mcp.WithSessionLifecycleHook(hook("connect"), hook("initialized"), hook("disconnect")))
+
+	expectEvent := func(want string) {
+		t.Helper()
+
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("expected event %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for event %q", want)
+		}
+	}
+	send := func(msg mcp.JSONRPCMessage) {
+		t.Helper()
+
+		errs := make(chan error)
+		transport.messages <- mcp.SessionMsgWithErrs{SessionID: "fast", Msg: msg, Errs: errs}
+		if err := <-errs; err != nil {
+			t.Fatalf("failed to handle message: %v", err)
+		}
+	}
+
+	sessCtx, sessCancel := context.WithCancel(ctx)
+	transport.sessions <- mcp.SessionCtx{Ctx: sessCtx, ID: "fast"}
+	expectEvent("connect fast /")
+
+	send(mcp.JSONRPCMessage{
+		JSONRPC: mcp.JSONRPCVersion,
+		ID:      "init",
+		Method:  "initialize",
+		Params: json.RawMessage(`{"protocolVersion":"2024-11-05","capabilities":{},` +
+			`"clientInfo":{"name":"raw-client","version":"1.0"}}`),
+	})
+	<-transport.fast
+	send(mcp.JSONRPCMessage{JSONRPC: mcp.JSONRPCVersion, Method: "notifications/initialized"})
+	expectEvent("initialized fast raw-client/2024-11-05")
+
+	sessCancel()
+	expectEvent("disconnect fast raw-client/2024-11-05")
+}
+
+func TestServerSendListsOnInitialized(t *testing.T) {
+	cli := setupRawClient(t, mockServer{}, mcp.WithSendListsOnInitialized(true),
+		mcp.WithPromptServer(&mockPromptServer{}), mcp.WithToolServer(mcp.NewToolRegistry()))
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"init","method":"initialize","params":{"protocolVersion":"2024-11-05",`+
+		`"capabilities":{},"clientInfo":{"name":"raw-client","version":"1.0"}}}`)
+	if msg := cli.receive(t); msg.Error != nil {
+		t.Fatalf("failed to initialize: %v", msg.Error)
+	}
+	cli.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+
+	var methods []string
+	for range 2 {
+		methods = append(methods, cli.receive(t).Method)
+	}
+	slices.Sort(methods)
+	want := []string{"notifications/prompts/list_changed", "notifications/tools/list_changed"}
+	if !slices.Equal(methods, want) {
+		t.Errorf("expected notifications %v, got %v", want, methods)
+	}
+
+	// The notifications are only sent once.
+	cli.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	cli.send(t, `{"jsonrpc":"2.0","id":"ping","method":"ping"}`)
+	if msg := cli.receive(t); msg.ID != "ping" {
+		t.Errorf("expected ping response, got %+v", msg)
+	}
+}
+
+func TestServerLogBatches(t *testing.T) {
+	handler := mockBatchLogHandler{
+		logs:    make(chan mcp.LogParams),
+		batches: make(chan []mcp.LogParams),
+	}
+	cli := setupRawClient(t, mockServer{}, mcp.WithLogHandler(handler))
+	cli.initialize(t)
+
+	log := func(message string) mcp.LogParams {
+		return mcp.LogParams{Level: mcp.LogLevelInfo, Data: mcp.LogData{Message: message}}
+	}
+	handler.logs <- log("first")
+	handler.batches <- []mcp.LogParams{log("second"), log("third"), log("fourth")}
+	handler.batches <- nil
+	handler.logs <- log("fifth")
+
+	for _, want := range []string{"first", "second", "third", "fourth", "fifth"} {
+		msg := cli.receive(t)
+		if msg.Method != "notifications/message" {
+			t.Fatalf("expected log notification, got %+v", msg)
+		}
+		var params mcp.LogParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			t.Fatalf("failed to unmarshal log: %v", err)
+		}
+		if params.Data.Message != want {
+			t.Errorf("expected log %q, got %q", want, params.Data.Message)
+		}
+	}
+}
+
+func TestServerRequestDeadline(t *testing.T) {
+	cli := setupRawClient(t, mockServer{}, mcp.WithToolServer(mockDeadlineToolServer{}))
+	cli.initialize(t)
+
+	cli.send(t, `{"jsonrpc":"2.0","id":"call","method":"tools/call","params":{"name":"sample"}}`)
+
+	msg := cli.receive(t)
+	if msg.Method != mcp.MethodSamplingCreateMessage {
+		t.Fatalf("expected sampling request, got %+v", msg)
+	}
+	samplingID := msg.ID
+
+	// The sampling request is never answered, so the deadline of the tool must expire first.
+	msg = cli.receive(t)
+	if msg.Method != "notifications/cancelled" {
+		t.Fatalf("expected cancelled notification, got %+v", msg)
+	}
+	var params struct {
+		RequestID string `json:"requestId"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		t.Fatalf("failed to unmarshal params: %v", err)
+	}
+	if params.RequestID != string(samplingID) {
+		t.Errorf("expected cancellation of request %s, got %s", samplingID, params.RequestID)
+	}
+
+	msg = cli.receive(t)
+	if msg.ID != "call" || msg.Error == nil {
+		t.Fatalf("expected error response for the tool call, got %+v", msg)
+	}
+}
+
 func (m mockServer) Info() mcp.Info {
 	return mcp.Info{Name: "test-server", Version: "1.0"}
 }
 
+func (m mockSubscriptionCountingServer) SubscribeResource(params mcp.SubscribeResourceParams) {
+	m.subscribes.Add(1)
+	m.mockResourceServer.SubscribeResource(params)
+}
+
+func (m mockSubscriptionCountingServer) UnsubscribeResource(params mcp.UnsubscribeResourceParams) {
+	m.unsubscribes.Add(1)
+	m.mockResourceServer.UnsubscribeResource(params)
+}
+
+func (m mockSaturatedLimiter) Allow(context.Context, string, string) bool {
+	return !m.saturated.Load()
+}
+
+func (m mockUnnamedServer) Info() mcp.Info {
+	return mcp.Info{Version: "1.0"}
+}
+
 func (m mockServer) RequireRootsListClient() bool {
 	return m.requireRootsListClient
 }
@@ -61,7 +2264,7 @@ func (m *mockPromptServer) ListPrompts(
 	_ mcp.RequestClientFunc,
 ) (mcp.ListPromptResult, error) {
 	m.listParams = params
-	return mcp.ListPromptResult{}, nil
+	return mcp.ListPromptResult{Prompts: []mcp.Prompt{mockPrompt}}, nil
 }
 
 func (m *mockPromptServer) GetPrompt(
@@ -70,7 +2273,21 @@ func (m *mockPromptServer) GetPrompt(
 	_ mcp.RequestClientFunc,
 ) (mcp.GetPromptResult, error) {
 	m.getParams = params
-	return mcp.GetPromptResult{}, nil
+	return mcp.GetPromptResult{
+		Messages: []mcp.PromptMessage{
+			{
+				Role:    mcp.PromptRoleUser,
+				Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: "instruction"}},
+			},
+			{
+				Role: mcp.PromptRoleUser,
+				Content: []mcp.Content{
+					{Type: mcp.ContentTypeText, Text: "screenshot"},
+					{Type: mcp.ContentTypeImage, Data: "aW1hZ2U=", MimeType: "image/png"},
+				},
+			},
+		},
+	}, nil
 }
 
 func (m *mockPromptServer) CompletesPrompt(
@@ -79,7 +2296,8 @@ func (m *mockPromptServer) CompletesPrompt(
 	_ mcp.RequestClientFunc,
 ) (mcp.CompletionResult, error) {
 	m.completesParams = params
-	return mcp.CompletionResult{}, nil
+	result, _ := mockPrompt.CompleteArgument(params.Argument)
+	return result, nil
 }
 
 func (m mockPromptListUpdater) PromptListUpdates() <-chan struct{} {
@@ -92,7 +2310,13 @@ func (m *mockResourceServer) ListResources(
 	_ mcp.RequestClientFunc,
 ) (mcp.ListResourcesResult, error) {
 	m.listParams = params
-	return mcp.ListResourcesResult{}, nil
+	size := int64(1024)
+	return mcp.ListResourcesResult{
+		Resources: []mcp.Resource{
+			{URI: "test://resource", Title: "Test Resource", Size: &size},
+			{URI: "test://unsized"},
+		},
+	}, nil
 }
 
 func (m *mockResourceServer) ReadResource(
@@ -104,6 +2328,28 @@ func (m *mockResourceServer) ReadResource(
 	return mcp.ReadResourceResult{}, nil
 }
 
+func (m mockBlobResourceServer) ReadResource(
+	_ context.Context,
+	params mcp.ReadResourceParams,
+	_ mcp.RequestClientFunc,
+) (mcp.ReadResourceResult, error) {
+	return mcp.ReadResourceResult{
+		Contents: []mcp.Resource{{URI: params.URI, Blob: base64.StdEncoding.EncodeToString(m.content)}},
+	}, nil
+}
+
+func (m *mockStreamableResourceServer) ReadResourceStream(
+	_ context.Context,
+	params mcp.ReadResourceParams,
+	_ mcp.RequestClientFunc,
+) (mcp.ResourceStream, error) {
+	m.streamed = true
+	return mcp.ResourceStream{
+		Resource: mcp.Resource{URI: params.URI, MimeType: "application/octet-stream"},
+		Content:  io.NopCloser(bytes.NewReader(m.content)),
+	}, nil
+}
+
 func (m *mockResourceServer) ListResourceTemplates(
 	_ context.Context,
 	params mcp.ListResourceTemplatesParams,
@@ -119,7 +2365,12 @@ func (m *mockResourceServer) CompletesResourceTemplate(
 	_ mcp.RequestClientFunc,
 ) (mcp.CompletionResult, error) {
 	m.completesTemplateParams = params
-	return mcp.CompletionResult{}, nil
+	var result mcp.CompletionResult
+	result.Completion.Values = []string{"1", "2"}
+	result.Completion.HasMore = true
+	total := 250
+	result.Completion.Total = &total
+	return result, nil
 }
 
 func (m *mockResourceServer) SubscribeResource(params mcp.SubscribeResourceParams) {
@@ -144,16 +2395,199 @@ func (m *mockToolServer) ListTools(
 	_ mcp.RequestClientFunc,
 ) (mcp.ListToolsResult, error) {
 	m.listParams = params
-	return mcp.ListToolsResult{}, nil
+	return mcp.ListToolsResult{Tools: []mcp.Tool{{Name: "test-tool", Title: "Test Tool"}}}, nil
 }
 
 func (m *mockToolServer) CallTool(
-	_ context.Context,
+	ctx context.Context,
 	params mcp.CallToolParams,
 	_ mcp.RequestClientFunc,
 ) (mcp.CallToolResult, error) {
 	m.callParams = params
-	return mcp.CallToolResult{}, nil
+	m.callMethod, m.callID, _ = mcp.RequestInfo(ctx)
+	return mcp.CallToolResult{Meta: params.Meta.Extra}, nil
+}
+
+func (m mockSamplingToolServer) ListTools(
+	context.Context,
+	mcp.ListToolsParams,
+	mcp.RequestClientFunc,
+) (mcp.ListToolsResult, error) {
+	return mcp.ListToolsResult{}, nil
+}
+
+func (m mockSamplingToolServer) CallTool(
+	_ context.Context,
+	_ mcp.CallToolParams,
+	requestClient mcp.RequestClientFunc,
+) (mcp.CallToolResult, error) {
+	_, err := requestClient(mcp.JSONRPCMessage{
+		JSONRPC: mcp.JSONRPCVersion,
+		Method:  mcp.MethodSamplingCreateMessage,
+	})
+	return mcp.CallToolResult{}, err
+}
+
+func (m mockDeadlineToolServer) CallTool(
+	ctx context.Context,
+	_ mcp.CallToolParams,
+	_ mcp.RequestClientFunc,
+) (mcp.CallToolResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	requestClient, ok := mcp.RequestClient(ctx)
+	if !ok {
+		return mcp.CallToolResult{}, errors.New("no request client in context")
+	}
+	_, err := requestClient(mcp.JSONRPCMessage{
+		JSONRPC: mcp.JSONRPCVersion,
+		Method:  mcp.MethodSamplingCreateMessage,
+	})
+	return mcp.CallToolResult{}, err
+}
+
+func (m mockStructuredToolServer) ListTools(
+	_ context.Context,
+	params mcp.ListToolsParams,
+	_ mcp.RequestClientFunc,
+) (mcp.ListToolsResult, error) {
+	if params.Cursor == "" {
+		return mcp.ListToolsResult{Tools: []mcp.Tool{{Name: "plain"}}, NextCursor: "next"}, nil
+	}
+	schema := jsonschema.Must(`{"type":"object","properties":{"total":{"type":"integer"}},"required":["total"]}`)
+	return mcp.ListToolsResult{Tools: []mcp.Tool{
+		{Name: "valid", OutputSchema: schema},
+		{Name: "invalid", OutputSchema: schema},
+		{Name: "missing", OutputSchema: schema},
+	}}, nil
+}
+
+func (m mockStructuredToolServer) CallTool(
+	_ context.Context,
+	params mcp.CallToolParams,
+	_ mcp.RequestClientFunc,
+) (mcp.CallToolResult, error) {
+	content, ok := m.contents[params.Name]
+	if !ok {
+		return mcp.CallToolResult{}, nil
+	}
+	return mcp.CallToolResult{StructuredContent: json.RawMessage(content)}, nil
+}
+
+func (s stalledTransport) Send(ctx context.Context, msg mcp.SessionMsg) error {
+	s.sends <- msg.Msg.ID
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s stalledTransport) SessionMessages() <-chan mcp.SessionMsgWithErrs {
+	return s.messages
+}
+
+func (s stalledTransport) Sessions() <-chan mcp.SessionCtx {
+	return s.sessions
+}
+
+func (s stalledTransport) Close() {}
+
+func (s stalledTransport) CloseSession(sessionID string) {
+	s.closed <- sessionID
+}
+
+// ping sends a ping to the server, and waits for the server to attempt sending its response.
+func (s stalledTransport) ping(t *testing.T, id string) {
+	t.Helper()
+
+	errs := make(chan error)
+	s.messages <- mcp.SessionMsgWithErrs{
+		SessionID: "stalled",
+		Msg:       mcp.JSONRPCMessage{JSONRPC: mcp.JSONRPCVersion, ID: mcp.MustString(id), Method: "ping"},
+		Errs:      errs,
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("failed to handle ping: %v", err)
+	}
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case sent := <-s.sends:
+			if sent == mcp.MustString(id) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("expected response to ping %s to be sent", id)
+		}
+	}
+}
+
+func (f fanoutTransport) Send(ctx context.Context, msg mcp.SessionMsg) error {
+	if msg.SessionID == "slow" {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	f.fast <- msg.Msg.Method
+	return nil
+}
+
+func (b brokenTransport) Send(ctx context.Context, msg mcp.SessionMsg) error {
+	switch msg.SessionID {
+	case "broken":
+		return fmt.Errorf("broken pipe: %w", mcp.ErrSessionBroken)
+	case "flaky":
+		return errors.New("failed to marshal message")
+	}
+	return b.fanoutTransport.Send(ctx, msg)
+}
+
+func (f fanoutTransport) SessionMessages() <-chan mcp.SessionMsgWithErrs {
+	return f.messages
+}
+
+func (f fanoutTransport) Sessions() <-chan mcp.SessionCtx {
+	return f.sessions
+}
+
+func (f fanoutTransport) Close() {}
+
+func (m mockPromptListChanges) PromptListUpdates() <-chan struct{} {
+	return m
+}
+
+func (m mockArgumentsToolServer) ListTools(
+	context.Context,
+	mcp.ListToolsParams,
+	mcp.RequestClientFunc,
+) (mcp.ListToolsResult, error) {
+	return mcp.ListToolsResult{Tools: []mcp.Tool{{
+		Name: "forecast",
+		InputSchema: jsonschema.Must(`{"type":"object","properties":{` +
+			`"city":{"type":"string"},` +
+			`"unit":{"type":"string","default":"celsius"},` +
+			`"days":{"type":"integer","default":3}}}`),
+	}}}, nil
+}
+
+func (m mockArgumentsToolServer) CallTool(
+	_ context.Context,
+	params mcp.CallToolParams,
+	_ mcp.RequestClientFunc,
+) (mcp.CallToolResult, error) {
+	args, err := json.Marshal(params.Arguments)
+	if err != nil {
+		return mcp.CallToolResult{}, err
+	}
+	return mcp.CallToolResult{StructuredContent: args}, nil
+}
+
+func (m mockCountingToolServer) ListTools(
+	ctx context.Context,
+	params mcp.ListToolsParams,
+	requestClient mcp.RequestClientFunc,
+) (mcp.ListToolsResult, error) {
+	m.lists.Add(1)
+	return m.mockArgumentsToolServer.ListTools(ctx, params, requestClient)
 }
 
 func (m mockToolListUpdater) ToolListUpdates() <-chan struct{} {
@@ -167,5 +2601,164 @@ func (m mockLogHandler) LogStreams() <-chan mcp.LogParams {
 func (m mockLogHandler) SetLogLevel(mcp.LogLevel) {
 }
 
+func (m mockBatchLogHandler) LogStreams() <-chan mcp.LogParams {
+	return m.logs
+}
+
+func (m mockBatchLogHandler) LogBatches() <-chan []mcp.LogParams {
+	return m.batches
+}
+
+func (m mockBatchLogHandler) SetLogLevel(mcp.LogLevel) {
+}
+
 func (m mockRootsListWatcher) OnRootsListChanged() {
 }
+
+func (m mockRootsListReceiver) OnRootsListChanged() {
+}
+
+func (m mockRootsListReceiver) OnRootsListUpdated(_ string, roots mcp.RootList) {
+	m.roots <- roots
+}
+
+func (m mockLogStream) LogStreams() <-chan mcp.LogParams {
+	return m.logs
+}
+
+func (m mockLogStream) SetLogLevel(mcp.LogLevel) {
+}
+
+func (mockRequiredArgsPromptServer) ListPrompts(
+	context.Context, mcp.ListPromptsParams, mcp.RequestClientFunc,
+) (mcp.ListPromptResult, error) {
+	return mcp.ListPromptResult{Prompts: []mcp.Prompt{{
+		Name: "greeting",
+		Arguments: []mcp.PromptArgument{
+			{Name: "name", Required: true},
+			{Name: "tone"},
+		},
+	}}}, nil
+}
+
+func (mockRequiredArgsPromptServer) GetPrompt(
+	context.Context, mcp.GetPromptParams, mcp.RequestClientFunc,
+) (mcp.GetPromptResult, error) {
+	return mcp.GetPromptResult{}, nil
+}
+
+func (mockRequiredArgsPromptServer) CompletesPrompt(
+	context.Context, mcp.CompletesCompletionParams, mcp.RequestClientFunc,
+) (mcp.CompletionResult, error) {
+	return mcp.CompletionResult{}, nil
+}
+
+func (mockStreamingToolServer) ListTools(
+	context.Context, mcp.ListToolsParams, mcp.RequestClientFunc,
+) (mcp.ListToolsResult, error) {
+	return mcp.ListToolsResult{Tools: []mcp.Tool{{Name: "tail"}}}, nil
+}
+
+func (mockStreamingToolServer) CallTool(
+	context.Context, mcp.CallToolParams, mcp.RequestClientFunc,
+) (mcp.CallToolResult, error) {
+	return mcp.CallToolResult{Content: []mcp.Content{{Type: mcp.ContentTypeText, Text: "all lines"}}}, nil
+}
+
+func (mockStreamingToolServer) CallToolStream(
+	ctx context.Context, _ mcp.CallToolParams, _ mcp.RequestClientFunc,
+) (<-chan mcp.Content, error) {
+	contents := make(chan mcp.Content)
+	go func() {
+		defer close(contents)
+		for _, line := range []string{"line 1", "line 2"} {
+			select {
+			case contents <- mcp.Content{Type: mcp.ContentTypeText, Text: line}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return contents, nil
+}
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func (m mockProgressReporter) ProgressReports() <-chan mcp.ProgressParams {
+	return m.reports
+}
+
+func setupRawClient(t *testing.T, server mcp.Server, options ...mcp.ServerOption) *rawClient {
+	t.Helper()
+
+	srvReader, cliWriter := io.Pipe()
+	cliReader, srvWriter := io.Pipe()
+
+	srvIO := mcp.NewStdIO(srvReader, srvWriter)
+	go srvIO.Start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	errsChan := make(chan error, 10)
+	go mcp.Serve(ctx, server, srvIO, errsChan, options...)
+
+	cli := &rawClient{
+		writer: cliWriter,
+		msgs:   make(chan mcp.JSONRPCMessage, 10),
+		errs:   errsChan,
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(cliReader)
+		for scanner.Scan() {
+			var msg mcp.JSONRPCMessage
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				continue
+			}
+			cli.msgs <- msg
+		}
+	}()
+
+	return cli
+}
+
+func (c *rawClient) send(t *testing.T, msg string) {
+	t.Helper()
+
+	if _, err := io.WriteString(c.writer, msg+"\n"); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+}
+
+func (c *rawClient) receive(t *testing.T) mcp.JSONRPCMessage {
+	t.Helper()
+
+	select {
+	case msg := <-c.msgs:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting for message")
+	}
+	return mcp.JSONRPCMessage{}
+}
+
+// initialize performs the initialization handshake, and makes sure the server processed the
+// initialized notification before returning.
+func (c *rawClient) initialize(t *testing.T) {
+	t.Helper()
+
+	c.send(t, `{"jsonrpc":"2.0","id":"init","method":"initialize","params":{"protocolVersion":"2024-11-05",`+
+		`"capabilities":{},"clientInfo":{"name":"raw-client","version":"1.0"}}}`)
+	if msg := c.receive(t); msg.Error != nil {
+		t.Fatalf("failed to initialize: %v", msg.Error)
+	}
+	c.send(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	c.send(t, `{"jsonrpc":"2.0","id":"init-ping","method":"ping"}`)
+	if msg := c.receive(t); msg.ID != "init-ping" {
+		t.Fatalf("expected ping response, got %+v", msg)
+	}
+}
@@ -2,16 +2,23 @@ package mcp
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/tmaxmax/go-sse"
 )
 
@@ -22,7 +29,7 @@ import (
 // The server maintains active client connections and handles message routing through
 // channels while providing thread-safe operations using sync.Map for connection management.
 type SSEServer struct {
-	// writers is a map of sessionID to http.ResponseWriter
+	// writers is a map of sessionID to *sseSession
 	writers *sync.Map
 
 	sessionsChan chan SessionCtx
@@ -30,9 +37,83 @@ type SSEServer struct {
 	errsChan     chan error
 	closeChan    chan struct{}
 
+	sessionIDGenerator func() string
+	jsonPrefix         string
+	jsonIndent         string
+	compression        bool
+	replayBuffer       int
+	keepAlive          time.Duration
+	clock              Clock
+	codecs             []Codec
+
 	flushLock *sync.Mutex
 }
 
+// SSEServerOption is a function that configures an SSEServer.
+type SSEServerOption func(*SSEServer)
+
+// gzipResponseWriter compresses everything written to the wrapped http.ResponseWriter. Flush
+// flushes the compressed data through to the client, so events aren't held back by the compressor.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+
+	lock *sync.Mutex
+	gz   *gzip.Writer
+}
+
+// maxDecompressedMessageSize caps the size of a gzip-compressed message body once decompressed, so a
+// small compressed body can't exhaust the server's memory.
+const maxDecompressedMessageSize = 10 << 20
+
+// sseResumeWindow is how long a session with a replay buffer outlives its disconnected event
+// stream, waiting for the client to resume it.
+const sseResumeWindow = 30 * time.Second
+
+// sseCodecHeader names the codec a client asks for when it opens an event stream, and the server
+// echoes it in its response when it supports the codec. Otherwise, both peers use JSON.
+const sseCodecHeader = "Mcp-Codec"
+
+// sseClientResponseHeaderTimeout is how long the default HTTP client of an SSEClient waits for the
+// headers of a response.
+const sseClientResponseHeaderTimeout = 30 * time.Second
+
+type sseSession struct {
+	// lock guards the writer and the replay state, and serializes the writes of events.
+	lock *sync.Mutex
+	// writer is nil while the event stream is disconnected, waiting to be resumed.
+	writer io.Writer
+	// rebound is set while the writer was given to RebindSession, so no handler of HandleSSE waits on
+	// the session to end its event stream.
+	rebound bool
+	// replaced is closed when a resumed event stream takes over from the current one.
+	replaced chan struct{}
+	// codec encodes the messages sent on the event stream, as negotiated when the session started.
+	codec Codec
+	// resumeToken is a random secret that's part of the ID of every event of the session, so only
+	// a client that received an event of the session's stream can resume it, and not anyone who
+	// knows or guesses the session ID.
+	resumeToken string
+	// lastID is the ID of the last event sent on the session, and written the ID of the last event
+	// written to an event stream.
+	lastID  uint64
+	written uint64
+	// events holds the most recent events, oldest first, for replaying them to a resumed stream.
+	events []sseEvent
+	// stopExpiry stops the timer that ends the session if its event stream isn't resumed in time.
+	stopExpiry func()
+	expired    bool
+	cancel     context.CancelFunc
+
+	// done is closed to end the session's event stream.
+	done      chan struct{}
+	closeOnce *sync.Once
+}
+
+type sseEvent struct {
+	id   uint64
+	data []byte
+}
+
 // SSEClient implements a Server-Sent Events (SSE) client that manages server connections
 // and bidirectional message handling. It provides real-time communication through SSE for
 // server-to-client streaming and HTTP POST for client-to-server messages.
@@ -43,53 +124,203 @@ type SSEClient struct {
 	httpClient *http.Client
 	baseURL    string
 	messageURL string
+	headers    http.Header
+	headerFunc func(ctx context.Context) (http.Header, error)
+	// preferredCodec is the codec the client asks the server for, if any, and codec the one
+	// negotiated when the session started.
+	preferredCodec Codec
+	codec          Codec
 
 	messagesChan chan SessionMsgWithErrs
 	errsChan     chan error
 	closeChan    chan struct{}
 }
 
+// SSEClientOption is a function that configures an SSEClient.
+type SSEClientOption func(*SSEClient)
+
+// WithSessionIDGenerator sets the function used to generate the IDs of new SSE sessions.
+// The generated IDs must be unique across the server and safe to use in a URL query.
+// By default, random UUIDs are used.
+func WithSessionIDGenerator(generator func() string) SSEServerOption {
+	return func(s *SSEServer) {
+		s.sessionIDGenerator = generator
+	}
+}
+
+// WithSSEJSONIndent makes the SSE server indent the JSON messages it sends, as json.MarshalIndent
+// does with the given prefix and indent, which is handy for inspecting the event stream while
+// debugging. Each line of an indented message is written as a separate data field of the event, so
+// the framing stays valid. When both are empty, which is the default, messages are sent compact.
+//
+// It only affects SSEServer. Newline-delimited transports such as StdIO require a message per line,
+// so they always send compact JSON.
+func WithSSEJSONIndent(prefix, indent string) SSEServerOption {
+	return func(s *SSEServer) {
+		s.jsonPrefix = prefix
+		s.jsonIndent = indent
+	}
+}
+
+// WithCompression enables gzip compression of the event streams sent to clients that advertise
+// support for it with the Accept-Encoding header. Regardless of this option, the server accepts
+// message bodies compressed with gzip, as declared by their Content-Encoding header, and rejects
+// those exceeding 10 MiB once decompressed. Compression is disabled by default.
+func WithCompression(enabled bool) SSEServerOption {
+	return func(s *SSEServer) {
+		s.compression = enabled
+	}
+}
+
+// WithSSEReplayBuffer makes the server keep the last n messages sent on each session, so a client
+// whose event stream drops can resume it without missing messages. Every message is sent with an
+// event ID, and a client reconnecting to the SSE endpoint with the Last-Event-ID header set to
+// the last ID it received gets the session back, with the buffered messages that follow that ID
+// replayed. Messages older than the last n are lost. The event IDs carry a random token of the
+// session, so a session can't be resumed by anyone who only knows its ID.
+//
+// With a replay buffer, a session doesn't end when its event stream disconnects: messages are
+// buffered until the stream is resumed, and the session ends if that doesn't happen within 30
+// seconds. By default, there's no replay buffer, and a session ends with its event stream.
+func WithSSEReplayBuffer(n int) SSEServerOption {
+	return func(s *SSEServer) {
+		s.replayBuffer = n
+	}
+}
+
+// WithSSEClock sets the clock the server measures the keep-alive interval set with WithSSEKeepAlive,
+// and the resume window of the sessions with a replay buffer with, so tests can control time with a
+// fake clock. By default, the real clock is used.
+func WithSSEClock(clock Clock) SSEServerOption {
+	return func(s *SSEServer) {
+		s.clock = clock
+	}
+}
+
+// WithSSECodecs makes the server support the given codecs besides JSON, such as MsgpackCodec. A client
+// asking for one of them when it opens its event stream, as SSEClient does with WithSSEClientCodec,
+// gets the messages of its session encoded with it, and must post its messages encoded with it, with
+// its Content-Type. Clients asking for no codec, or an unsupported one, use JSON.
+//
+// Messages of codecs other than JSONCodec are sent in base64 in the data of their events, as they may
+// be binary, so MessagePack doesn't make event streams smaller or faster than JSON: its gains are
+// limited to the messages clients post.
+func WithSSECodecs(codecs ...Codec) SSEServerOption {
+	return func(s *SSEServer) {
+		s.codecs = codecs
+	}
+}
+
+// WithSSEKeepAlive makes the server send a keep-alive comment on every event stream at the given
+// interval, so proxies and load balancers don't close streams that are idle between messages.
+// Unlike the pings sent with WithPingInterval, comments aren't JSON-RPC messages: clients ignore
+// them, and they don't need a response. A zero interval, which is the default, disables them.
+func WithSSEKeepAlive(interval time.Duration) SSEServerOption {
+	return func(s *SSEServer) {
+		s.keepAlive = interval
+	}
+}
+
 // NewSSEServer creates and initializes a new SSE server instance with all necessary
 // channels for session management, message handling, and error reporting.
-func NewSSEServer() SSEServer {
-	return SSEServer{
-		writers:      new(sync.Map),
-		sessionsChan: make(chan SessionCtx, 1),
-		messagesChan: make(chan SessionMsgWithErrs),
-		errsChan:     make(chan error),
-		closeChan:    make(chan struct{}),
-		flushLock:    new(sync.Mutex),
+func NewSSEServer(options ...SSEServerOption) SSEServer {
+	s := SSEServer{
+		writers:            new(sync.Map),
+		sessionsChan:       make(chan SessionCtx, 1),
+		messagesChan:       make(chan SessionMsgWithErrs),
+		errsChan:           make(chan error),
+		closeChan:          make(chan struct{}),
+		sessionIDGenerator: newUUID,
+		clock:              realClock{},
+		flushLock:          new(sync.Mutex),
+	}
+	for _, opt := range options {
+		opt(&s)
 	}
+
+	return s
 }
 
 // NewSSEClient creates and initializes a new SSE client instance with the specified
-// base URL and HTTP client. If httpClient is nil, the default HTTP client will be used.
+// base URL and HTTP client. If httpClient is nil, and none is set with WithSSEClientHTTPClient, a
+// client that gives up on servers not sending the headers of a response in time is used.
 //
 // The baseURL parameter should point to the SSE endpoint of the server.
-func NewSSEClient(baseURL string, httpClient *http.Client) *SSEClient {
-	return &SSEClient{
+func NewSSEClient(baseURL string, httpClient *http.Client, options ...SSEClientOption) *SSEClient {
+	s := &SSEClient{
 		httpClient:   httpClient,
 		baseURL:      baseURL,
 		messagesChan: make(chan SessionMsgWithErrs),
 		errsChan:     make(chan error),
 		closeChan:    make(chan struct{}),
+		codec:        JSONCodec{},
+	}
+	for _, opt := range options {
+		opt(s)
+	}
+	if s.httpClient == nil {
+		s.httpClient = newDefaultSSEHTTPClient()
+	}
+
+	return s
+}
+
+// WithSSEClientCodec makes the client ask the server to encode the messages of its session with codec,
+// such as MsgpackCodec, instead of JSON. The codec is used in both directions if the server supports
+// it, see WithSSECodecs, and the client falls back to JSON otherwise.
+func WithSSEClientCodec(codec Codec) SSEClientOption {
+	return func(s *SSEClient) {
+		s.preferredCodec = codec
+	}
+}
+
+// WithSSEClientHTTPClient sets the HTTP client that sends the requests of the client, instead of the
+// one given to NewSSEClient, such as a client going through a corporate proxy, or authenticating
+// with mutual TLS. As the event stream stays open for the whole session, the client mustn't set a
+// Timeout, which would end the stream, but can bound the steps of a request with the timeouts of
+// its transport instead.
+func WithSSEClientHTTPClient(httpClient *http.Client) SSEClientOption {
+	return func(s *SSEClient) {
+		s.httpClient = httpClient
+	}
+}
+
+// WithSSEClientHeaders sets headers sent with every request of the client, both the one opening the
+// event stream and the ones delivering messages, such as the Authorization header of a server
+// requiring authentication. The headers are copied, so later changes to them aren't seen.
+func WithSSEClientHeaders(headers http.Header) SSEClientOption {
+	return func(s *SSEClient) {
+		s.headers = headers.Clone()
+	}
+}
+
+// WithSSEClientHeaderFunc sets a function called before every request of the client, whose headers
+// are sent with the request, for headers that change over time, such as short-lived access tokens.
+// It's called with the context of the request, and its headers replace those with the same name
+// set with WithSSEClientHeaders. If it fails, the request isn't sent, and the error is returned by
+// StartSession or Send. It must be safe for concurrent use.
+func WithSSEClientHeaderFunc(headerFunc func(ctx context.Context) (http.Header, error)) SSEClientOption {
+	return func(s *SSEClient) {
+		s.headerFunc = headerFunc
 	}
 }
 
 // Send delivers a message to a specific client session identified by the SessionMsg.
-// It marshals the message to JSON and writes it to the client's event stream.
+// It marshals the message with the codec of the session, JSON by default, and writes it to the
+// client's event stream.
 // The operation can be cancelled via the provided context.
 //
 // Returns an error if the session is not found, message marshaling fails,
-// or the write operation fails.
+// or the write operation fails. Without WithSSEReplayBuffer, the error of a failed write wraps
+// ErrSessionBroken, as the session ends with its event stream.
 func (s SSEServer) Send(ctx context.Context, msg SessionMsg) error {
-	w, ok := s.writers.Load(msg.SessionID)
+	ss, ok := s.writers.Load(msg.SessionID)
 	if !ok {
-		return fmt.Errorf("session not found")
+		return ErrSessionNotFound
 	}
-	wr, _ := w.(http.ResponseWriter)
+	sess, _ := ss.(*sseSession)
 
-	msgBs, err := json.Marshal(msg.Msg)
+	msgBs, err := s.marshalMessage(sess.codec, msg.Msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
@@ -97,19 +328,7 @@ func (s SSEServer) Send(ctx context.Context, msg SessionMsg) error {
 	errs := make(chan error)
 
 	go func() {
-		_, err = fmt.Fprintf(wr, "event: message\ndata: %s\n\n", msgBs)
-		if err != nil {
-			errs <- fmt.Errorf("failed to write message: %w", err)
-			return
-		}
-
-		s.flushLock.Lock()
-		f, fOk := wr.(http.Flusher)
-		if fOk {
-			f.Flush()
-		}
-		s.flushLock.Unlock()
-		errs <- nil
+		errs <- s.sendEvent(msg.SessionID, sess, msgBs)
 	}()
 
 	select {
@@ -147,6 +366,10 @@ func (s SSEServer) Errors() <-chan error {
 //
 // The messageBaseURL parameter specifies the base URL for client message endpoints.
 // Each client receives a unique message endpoint URL with their session ID.
+//
+// With WithSSEReplayBuffer, a request with the Last-Event-ID header resumes the session the
+// event belongs to, if it's still alive, instead of creating a new one. The messages following
+// the event are replayed, and no endpoint event is sent, as the client already knows it.
 func (s SSEServer) HandleSSE(messageBaseURL string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/event-stream")
@@ -155,45 +378,336 @@ func (s SSEServer) HandleSSE(messageBaseURL string) http.Handler {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		// Disable chunked encoding to avoid issues with SSE
 		w.Header().Set("Transfer-Encoding", "identity")
+		if s.compression && acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Add("Vary", "Accept-Encoding")
+			w = &gzipResponseWriter{
+				ResponseWriter: w,
+				lock:           new(sync.Mutex),
+				gz:             gzip.NewWriter(w),
+			}
+		}
 
-		sessID := uuid.New().String()
-		s.sessionsChan <- SessionCtx{
-			Ctx: r.Context(),
-			ID:  sessID,
+		codec := s.negotiateCodec(r)
+		if !isJSONCodec(codec) {
+			w.Header().Set(sseCodecHeader, codec.ContentType())
 		}
-		s.writers.Store(sessID, w)
 
-		url := fmt.Sprintf("%s?sessionID=%s", messageBaseURL, sessID)
-		_, err := fmt.Fprintf(w, "event: endpoint\ndata: %s\n\n", url)
-		if err != nil {
-			nErr := fmt.Errorf("failed to write SSE URL: %w", err)
-			http.Error(w, nErr.Error(), http.StatusInternalServerError)
-			s.logError(nErr)
-			return
+		sessID, sess, replaced, resumed := s.resumeSession(r.Header.Get("Last-Event-ID"), codec, w)
+		if !resumed {
+			sessID, sess, replaced = s.newSession(r, codec, w)
+			if !s.writeEndpoint(w, messageBaseURL, sessID) {
+				s.endSession(sessID, sess, w)
+				return
+			}
 		}
 
-		s.flushLock.Lock()
-		f, ok := w.(http.Flusher)
-		if ok {
-			f.Flush()
+		var keepAlive <-chan time.Time
+		if s.keepAlive > 0 {
+			ticker := s.clock.NewTicker(s.keepAlive)
+			defer ticker.Stop()
+			keepAlive = ticker.C()
 		}
-		s.flushLock.Unlock()
 
 		// Keep the connection open for new messages
-		select {
-		case <-r.Context().Done():
-		case <-s.closeChan:
+	wait:
+		for {
+			select {
+			case <-keepAlive:
+				s.writeKeepAlive(sess, w)
+			case <-r.Context().Done():
+				if s.replayBuffer > 0 {
+					s.detachSession(sessID, sess, replaced)
+					s.closeWriter(sessID, w)
+					return
+				}
+				break wait
+			case <-replaced:
+				s.closeWriter(sessID, w)
+				return
+			case <-s.closeChan:
+				break wait
+			case <-sess.done:
+				break wait
+			}
 		}
-		// Session would be removed by server when r.Context is done.
+		s.endSession(sessID, sess, w)
 	})
 }
 
+// newSession starts a session whose event stream is written to w.
+func (s SSEServer) newSession(
+	r *http.Request,
+	codec Codec,
+	w http.ResponseWriter,
+) (string, *sseSession, chan struct{}) {
+	ctx, cancel := r.Context(), context.CancelFunc(func() {})
+	if s.replayBuffer > 0 {
+		// The session must outlive the request, so its stream can be resumed.
+		ctx, cancel = context.WithCancel(context.WithoutCancel(r.Context()))
+	}
+
+	sessID := s.sessionIDGenerator()
+	s.sessionsChan <- SessionCtx{
+		Ctx: ctx,
+		ID:  sessID,
+	}
+	sess := &sseSession{
+		lock:        new(sync.Mutex),
+		writer:      w,
+		replaced:    make(chan struct{}),
+		codec:       codec,
+		resumeToken: newSSEResumeToken(),
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		closeOnce:   new(sync.Once),
+	}
+	s.writers.Store(sessID, sess)
+
+	return sessID, sess, sess.replaced
+}
+
+// writeEndpoint sends the endpoint event of the session, reporting whether it succeeded.
+func (s SSEServer) writeEndpoint(w http.ResponseWriter, messageBaseURL, sessID string) bool {
+	url := fmt.Sprintf("%s?sessionID=%s", messageBaseURL, sessID)
+	_, err := fmt.Fprintf(w, "event: endpoint\ndata: %s\n\n", url)
+	if err != nil {
+		nErr := fmt.Errorf("failed to write SSE URL: %w", err)
+		http.Error(w, nErr.Error(), http.StatusInternalServerError)
+		s.logError(nErr)
+		return false
+	}
+
+	s.flushLock.Lock()
+	f, ok := w.(http.Flusher)
+	if ok {
+		f.Flush()
+	}
+	s.flushLock.Unlock()
+
+	return true
+}
+
+// resumeSession attaches w as the event stream of the session the event with the given ID was sent
+// on, and replays the events that followed it. It reports false if there's no such session, the
+// resume token of the event ID isn't the session's, or the session uses another codec than codec.
+func (s SSEServer) resumeSession(
+	lastEventID string,
+	codec Codec,
+	w http.ResponseWriter,
+) (string, *sseSession, chan struct{}, bool) {
+	if s.replayBuffer == 0 || lastEventID == "" {
+		return "", nil, nil, false
+	}
+	sessID, token, lastID, ok := parseSSEEventID(lastEventID)
+	if !ok {
+		return "", nil, nil, false
+	}
+	ss, ok := s.writers.Load(sessID)
+	if !ok {
+		return "", nil, nil, false
+	}
+	sess, _ := ss.(*sseSession)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(sess.resumeToken)) != 1 {
+		return "", nil, nil, false
+	}
+	if sess.codec.ContentType() != codec.ContentType() {
+		return "", nil, nil, false
+	}
+
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+
+	if sess.expired {
+		return "", nil, nil, false
+	}
+	s.attachWriter(sessID, sess, w, lastID)
+
+	return sessID, sess, sess.replaced, true
+}
+
+// RebindSession makes w the event stream of the session with the given ID, in place of its current
+// one, for transports whose connection changes while the session persists, such as a client that
+// reconnects through a route other than HandleSSE. The session keeps its state, such as its resource
+// subscriptions and the requests in flight, and no new session is started.
+//
+// The writer is swapped under the lock that serializes the writes of the session, so every message
+// is written whole to one writer or the other. The messages that weren't written to the previous
+// event stream, such as the ones sent while it was disconnected, are written to w first. The handler
+// of the previous event stream, if any, returns as if the stream was resumed with Last-Event-ID. w is
+// written to until the session ends, or its event stream is resumed or rebound again, and it's never
+// closed by the server.
+//
+// It requires WithSSEReplayBuffer, as sessions otherwise end along with the request of their first
+// event stream. It returns an error wrapping ErrSessionNotFound if there's no session with the given
+// ID, or it has ended.
+func (s SSEServer) RebindSession(sessionID string, w io.Writer) error {
+	if s.replayBuffer == 0 {
+		return errors.New("rebinding sessions requires WithSSEReplayBuffer")
+	}
+	ss, ok := s.writers.Load(sessionID)
+	if !ok {
+		return fmt.Errorf("failed to rebind session %q: %w", sessionID, ErrSessionNotFound)
+	}
+	sess, _ := ss.(*sseSession)
+
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+
+	if sess.expired {
+		return fmt.Errorf("failed to rebind session %q: %w", sessionID, ErrSessionNotFound)
+	}
+	s.attachWriter(sessionID, sess, w, sess.written)
+	sess.rebound = true
+
+	return nil
+}
+
+// attachWriter makes w the event stream of the session, taking over from the current one, and
+// replays the buffered events that followed the one with ID lastID. The caller holds the lock of the
+// session.
+func (s SSEServer) attachWriter(sessID string, sess *sseSession, w io.Writer, lastID uint64) {
+	if sess.stopExpiry != nil {
+		sess.stopExpiry()
+		sess.stopExpiry = nil
+	}
+	// A stream the client gave up on may not have noticed the disconnection yet.
+	close(sess.replaced)
+	sess.replaced = make(chan struct{})
+	sess.writer = w
+	sess.rebound = false
+
+	for _, ev := range sess.events {
+		if ev.id <= lastID {
+			continue
+		}
+		if err := s.writeEvent(w, sseEventID(sessID, sess.resumeToken, ev.id), ev.data); err != nil {
+			s.logError(fmt.Errorf("failed to replay message: %w", err))
+			break
+		}
+		sess.written = ev.id
+	}
+}
+
+// detachSession keeps the session alive after its event stream, given by replaced, disconnected,
+// buffering the messages sent to it until the stream is resumed or the resume window passes.
+func (s SSEServer) detachSession(sessID string, sess *sseSession, replaced chan struct{}) {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+
+	if sess.replaced != replaced {
+		// The stream was already resumed.
+		return
+	}
+	sess.writer = nil
+	sess.stopExpiry = afterFunc(s.clock, sseResumeWindow, func() {
+		sess.lock.Lock()
+		if sess.writer != nil {
+			sess.lock.Unlock()
+			return
+		}
+		sess.expired = true
+		sess.lock.Unlock()
+
+		s.writers.Delete(sessID)
+		sess.cancel()
+	})
+}
+
+// endSession removes the session, and tears down its event stream w.
+func (s SSEServer) endSession(sessID string, sess *sseSession, w http.ResponseWriter) {
+	s.writers.Delete(sessID)
+
+	sess.lock.Lock()
+	sess.writer = nil
+	sess.expired = true
+	sess.lock.Unlock()
+
+	s.closeWriter(sessID, w)
+	sess.cancel()
+}
+
+// sendEvent writes the message data as the next event of the session, recording it in the replay
+// buffer. While the event stream is disconnected, the event is only buffered. A failed write breaks
+// the session, unless the event can be replayed to a resumed stream.
+func (s SSEServer) sendEvent(sessID string, sess *sseSession, data []byte) error {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+
+	sess.lastID++
+	if s.replayBuffer > 0 {
+		if len(sess.events) == s.replayBuffer {
+			sess.events = append(sess.events[:0], sess.events[1:]...)
+		}
+		sess.events = append(sess.events, sseEvent{id: sess.lastID, data: data})
+	}
+	if sess.writer == nil {
+		if sess.expired {
+			return ErrSessionNotFound
+		}
+		return nil
+	}
+
+	if err := s.writeEvent(sess.writer, sseEventID(sessID, sess.resumeToken, sess.lastID), data); err != nil {
+		if s.replayBuffer > 0 {
+			// The event is replayed once the client resumes the stream.
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrSessionBroken, err)
+	}
+	sess.written = sess.lastID
+
+	return nil
+}
+
+func (s SSEServer) writeEvent(w io.Writer, id string, data []byte) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: message\n%s\n", id, sseData(data))
+	if err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+
+	s.flushLock.Lock()
+	f, ok := w.(http.Flusher)
+	if ok {
+		f.Flush()
+	}
+	s.flushLock.Unlock()
+
+	return nil
+}
+
+// writeKeepAlive sends a keep-alive comment on w, unless it's no longer the event stream of the
+// session.
+func (s SSEServer) writeKeepAlive(sess *sseSession, w http.ResponseWriter) {
+	sess.lock.Lock()
+	defer sess.lock.Unlock()
+
+	if sess.writer != w {
+		return
+	}
+	if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+		s.logError(fmt.Errorf("failed to write keep-alive: %w", err))
+		return
+	}
+
+	s.flushLock.Lock()
+	f, ok := w.(http.Flusher)
+	if ok {
+		f.Flush()
+	}
+	s.flushLock.Unlock()
+}
+
 // HandleMessage returns an http.Handler that processes incoming messages from clients
 // via HTTP POST requests. It expects a session ID as a query parameter and the message
-// content as JSON in the request body.
+// content as JSON in the request body, or encoded with the codec the session negotiated, see
+// WithSSECodecs. Bodies whose Content-Type isn't the one of the codec of the session are rejected
+// with 415 Unsupported Media Type.
 //
 // Messages are validated and routed through the server's message channel system
-// for processing. Results are communicated back through the response.
+// for processing. Results are communicated back through the response. A body that can't be
+// decoded is answered with a JSON-RPC parse error on the event stream of the session, like any other
+// rejected message.
 func (s SSEServer) HandleMessage() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sessID := r.URL.Query().Get("sessionID")
@@ -204,16 +718,41 @@ func (s SSEServer) HandleMessage() http.Handler {
 			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
-		var msg JSONRPCMessage
+		// A session posts its messages with the codec it negotiated when it started.
+		codec := s.sessionCodec(sessID)
+		if mediaType := contentMediaType(r.Header.Get("Content-Type")); mediaType != codec.ContentType() {
+			nErr := fmt.Errorf("unsupported content type %q, the session uses %q", mediaType, codec.ContentType())
+			s.logError(nErr)
+			http.Error(w, nErr.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		body, err := messageBody(r)
+		if err != nil {
+			nErr := fmt.Errorf("failed to read message: %w", err)
+			s.logError(nErr)
+			http.Error(w, nErr.Error(), http.StatusBadRequest)
+			return
+		}
+		defer body.Close()
 
-		if err := decoder.Decode(&msg); err != nil {
-			nErr := fmt.Errorf("failed to decode message: %w", err)
+		data, err := io.ReadAll(body)
+		if err != nil {
+			nErr := fmt.Errorf("failed to read message: %w", err)
 			s.logError(nErr)
 			http.Error(w, nErr.Error(), http.StatusBadRequest)
 			return
 		}
 
+		var msg JSONRPCMessage
+		if err := codec.Unmarshal(data, &msg); err != nil {
+			s.logError(fmt.Errorf("failed to decode message: %w", err))
+			// Like any other rejected message, a malformed message is answered with a JSON-RPC error
+			// response on the event stream.
+			s.sendMessageError(r.Context(), sessID, newParseError(err))
+			return
+		}
+
 		errs := make(chan error)
 		s.messagesChan <- SessionMsgWithErrs{
 			SessionID: sessID,
@@ -224,29 +763,80 @@ func (s SSEServer) HandleMessage() http.Handler {
 		if err := <-errs; err != nil {
 			nErr := fmt.Errorf("failed to handle message: %w", err)
 			s.logError(nErr)
+
+			var msgErr MessageError
+			if errors.As(err, &msgErr) {
+				// The session is still healthy, so the rejection is delivered as a regular
+				// JSON-RPC error response on the event stream.
+				s.sendMessageError(r.Context(), sessID, msgErr)
+				return
+			}
+
 			http.Error(w, nErr.Error(), http.StatusBadRequest)
 			return
 		}
 	})
 }
 
+// sendMessageError sends the JSON-RPC error response of the rejected message to the session.
+func (s SSEServer) sendMessageError(ctx context.Context, sessID string, msgErr MessageError) {
+	if err := s.Send(ctx, SessionMsg{
+		SessionID: sessID,
+		Msg:       msgErr.Response(),
+	}); err != nil {
+		s.logError(fmt.Errorf("failed to send error response: %w", err))
+	}
+}
+
+// CloseSession ends the event stream of the given session, flushing any buffered data and
+// closing the response writer if it implements io.Closer. It implements SessionCloser
+// interface, and is called by the server when a session ends.
+func (s SSEServer) CloseSession(sessionID string) {
+	ss, ok := s.writers.Load(sessionID)
+	if !ok {
+		return
+	}
+	sess, _ := ss.(*sseSession)
+	sess.closeOnce.Do(func() {
+		close(sess.done)
+	})
+
+	// A disconnected or rebound session has no event stream waiting for done, so it's removed here.
+	sess.lock.Lock()
+	detached := sess.writer == nil || sess.rebound
+	if detached {
+		sess.writer = nil
+		sess.expired = true
+		if sess.stopExpiry != nil {
+			sess.stopExpiry()
+		}
+	}
+	sess.lock.Unlock()
+	if detached {
+		s.writers.Delete(sessionID)
+		sess.cancel()
+	}
+}
+
 // Close shuts down the SSE server by closing all internal channels.
 // This terminates all active connections and stops message processing.
 func (s SSEServer) Close() {
+	// closeChan goes first, so the handlers that are returning stop reporting errors.
+	close(s.closeChan)
 	close(s.sessionsChan)
 	close(s.messagesChan)
 	close(s.errsChan)
-	close(s.closeChan)
 }
 
 // Send delivers a message to the server using an HTTP POST request. The message
-// is marshaled to JSON and sent to the server's message endpoint. The operation
+// is marshaled with the codec negotiated by StartSession, JSON by default, and sent to the
+// server's message endpoint. The operation
 // can be cancelled via the provided context.
 //
 // Returns an error if message marshaling fails, the request cannot be created,
 // or the server returns a non-200 status code.
 func (s *SSEClient) Send(ctx context.Context, msg SessionMsg) error {
-	msgBs, err := json.Marshal(msg.Msg)
+	msgBs, err := s.codec.Marshal(msg.Msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
@@ -256,7 +846,10 @@ func (s *SSEClient) Send(ctx context.Context, msg SessionMsg) error {
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", s.codec.ContentType())
+	if err := s.setHeaders(req); err != nil {
+		return err
+	}
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
@@ -283,6 +876,12 @@ func (s *SSEClient) StartSession() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
+	if err := s.setHeaders(req); err != nil {
+		return "", err
+	}
+	if s.preferredCodec != nil {
+		req.Header.Set(sseCodecHeader, s.preferredCodec.ContentType())
+	}
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
@@ -294,6 +893,12 @@ func (s *SSEClient) StartSession() (string, error) {
 		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
+	// A server that doesn't support the codec, or doesn't know of codecs, leaves the header out.
+	s.codec = JSONCodec{}
+	if s.preferredCodec != nil && resp.Header.Get(sseCodecHeader) == s.preferredCodec.ContentType() {
+		s.codec = s.preferredCodec
+	}
+
 	session := make(chan sessionResponse)
 
 	go s.listenMessages(resp.Body, session)
@@ -330,6 +935,39 @@ type sessionResponse struct {
 	err       error
 }
 
+// setHeaders adds the headers set with WithSSEClientHeaders and WithSSEClientHeaderFunc to req.
+func (s *SSEClient) setHeaders(req *http.Request) error {
+	for name, values := range s.headers {
+		req.Header[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+	}
+	if s.headerFunc == nil {
+		return nil
+	}
+
+	headers, err := s.headerFunc(req.Context())
+	if err != nil {
+		return fmt.Errorf("failed to get request headers: %w", err)
+	}
+	for name, values := range headers {
+		req.Header[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+	}
+
+	return nil
+}
+
+// newDefaultSSEHTTPClient creates the HTTP client of an SSEClient that wasn't given one. It has no
+// Timeout, so the event stream isn't cut off, but its transport limits the wait for response headers.
+func newDefaultSSEHTTPClient() *http.Client {
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return &http.Client{}
+	}
+	transport := base.Clone()
+	transport.ResponseHeaderTimeout = sseClientResponseHeaderTimeout
+
+	return &http.Client{Transport: transport}
+}
+
 func (s *SSEClient) listenMessages(body io.ReadCloser, session chan<- sessionResponse) {
 	defer body.Close()
 	defer close(session)
@@ -382,7 +1020,7 @@ func (s *SSEClient) listenMessages(body io.ReadCloser, session chan<- sessionRes
 			}
 
 			var msg JSONRPCMessage
-			if err := json.Unmarshal([]byte(ev.Data), &msg); err != nil {
+			if err := decodeEventData(s.codec, ev.Data, &msg); err != nil {
 				s.logError(fmt.Errorf("failed to unmarshal message: %w", err))
 				continue
 			}
@@ -396,6 +1034,15 @@ func (s *SSEClient) listenMessages(body io.ReadCloser, session chan<- sessionRes
 
 			if err := <-errs; err != nil {
 				s.logError(fmt.Errorf("failed to handle message: %w", err))
+				var msgErr MessageError
+				if errors.As(err, &msgErr) {
+					if err := s.Send(context.Background(), SessionMsg{
+						SessionID: sessID,
+						Msg:       msgErr.Response(),
+					}); err != nil {
+						s.logError(fmt.Errorf("failed to send error response: %w", err))
+					}
+				}
 			}
 		default:
 			s.logError(fmt.Errorf("unhandled event type %q", ev.Type))
@@ -403,6 +1050,188 @@ func (s *SSEClient) listenMessages(body io.ReadCloser, session chan<- sessionRes
 	}
 }
 
+// closeWriter tears down the writer of a session whose handler is returning, as the writer
+// mustn't be used once the handler returns.
+func (s SSEServer) closeWriter(sessionID string, w http.ResponseWriter) {
+	s.flushLock.Lock()
+	defer s.flushLock.Unlock()
+
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	c, ok := w.(io.Closer)
+	if !ok {
+		return
+	}
+	err := c.Close()
+	select {
+	case <-s.closeChan:
+		// The errors channel is closed along with the server.
+	default:
+		if err != nil {
+			s.logError(fmt.Errorf("failed to close writer of session %s: %w", sessionID, err))
+		}
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.gz.Write(b)
+}
+
+func (w *gzipResponseWriter) Flush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.gz.Flush(); err != nil {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close writes the end of the compressed stream, and closes the wrapped writer if it's an
+// io.Closer.
+func (w *gzipResponseWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	err := w.gz.Close()
+	if c, ok := w.ResponseWriter.(io.Closer); ok {
+		err = errors.Join(err, c.Close())
+	}
+	return err
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc, _, _ = strings.Cut(enc, ";")
+		if strings.TrimSpace(enc) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// messageBody returns the body of a message request, decompressing it if needed. The decompressed
+// body is capped at maxDecompressedMessageSize, and reading past it fails.
+func messageBody(r *http.Request) (io.ReadCloser, error) {
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip":
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", r.Header.Get("Content-Encoding"))
+	}
+
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress body: %w", err)
+	}
+	return &limitedReadCloser{reader: gz, limit: maxDecompressedMessageSize}, nil
+}
+
+// limitedReadCloser reads at most limit bytes from reader, and fails if there are more.
+type limitedReadCloser struct {
+	reader io.ReadCloser
+	limit  int64
+	read   int64
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	// Reading a byte past the limit tells a body of exactly the limit from a larger one.
+	if rest := l.limit - l.read + 1; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := l.reader.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n - int(l.read-l.limit), fmt.Errorf("decompressed body exceeds %d bytes", l.limit)
+	}
+	return n, err
+}
+
+func (l *limitedReadCloser) Close() error {
+	return l.reader.Close()
+}
+
+// marshalMessage encodes the message with codec as the data of an event. JSON messages are indented
+// as set with WithSSEJSONIndent.
+func (s SSEServer) marshalMessage(codec Codec, msg JSONRPCMessage) ([]byte, error) {
+	if !isJSONCodec(codec) || (s.jsonPrefix == "" && s.jsonIndent == "") {
+		return encodeEventData(codec, msg)
+	}
+	return json.MarshalIndent(msg, s.jsonPrefix, s.jsonIndent)
+}
+
+// sessionCodec returns the codec the session negotiated when it started, or JSONCodec if there's no
+// such session.
+func (s SSEServer) sessionCodec(sessID string) Codec {
+	ss, ok := s.writers.Load(sessID)
+	if !ok {
+		return JSONCodec{}
+	}
+	sess, _ := ss.(*sseSession)
+	return sess.codec
+}
+
+// negotiateCodec returns the codec the client asks for when opening an event stream with r, if the
+// server supports it, or JSONCodec.
+func (s SSEServer) negotiateCodec(r *http.Request) Codec {
+	codec, ok := findCodec(s.codecs, r.Header.Get(sseCodecHeader))
+	if !ok {
+		return JSONCodec{}
+	}
+	return codec
+}
+
+// sseEventID formats the ID of the event with the given sequence number of a session. The session
+// ID is part of it, so the session can be found from the Last-Event-ID header of a reconnection,
+// along with the resume token of the session, which proves the client received the event.
+func sseEventID(sessID, token string, id uint64) string {
+	return sessID + ":" + token + ":" + strconv.FormatUint(id, 10)
+}
+
+func parseSSEEventID(eventID string) (string, string, uint64, bool) {
+	i := strings.LastIndexByte(eventID, ':')
+	if i < 0 {
+		return "", "", 0, false
+	}
+	id, err := strconv.ParseUint(eventID[i+1:], 10, 64)
+	if err != nil {
+		return "", "", 0, false
+	}
+	j := strings.LastIndexByte(eventID[:i], ':')
+	if j < 0 {
+		return "", "", 0, false
+	}
+	return eventID[:j], eventID[j+1 : i], id, true
+}
+
+// newSSEResumeToken returns a random token to authorize the resumption of a session's event stream.
+func newSSEResumeToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// Like uuid.New for the session IDs, a broken source of randomness is unrecoverable.
+		panic(fmt.Sprintf("mcp: failed to generate SSE resume token: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// sseData formats the payload as the data fields of an event, one field per line of the payload.
+func sseData(payload []byte) []byte {
+	var b bytes.Buffer
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		b.WriteString("data: ")
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
 func (s *SSEServer) logError(err error) {
 	select {
 	case s.errsChan <- err:
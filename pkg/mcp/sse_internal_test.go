@@ -0,0 +1,44 @@
+package mcp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestLimitedReadCloser(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		wantErr bool
+	}{
+		{name: "below limit", size: 99},
+		{name: "at limit", size: 100},
+		{name: "above limit", size: 101, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &limitedReadCloser{
+				reader: io.NopCloser(bytes.NewReader(bytes.Repeat([]byte("a"), tt.size))),
+				limit:  100,
+			}
+			bs, err := io.ReadAll(l)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error reading past the limit")
+				}
+				if len(bs) > 100 {
+					t.Errorf("expected at most 100 bytes, got %d", len(bs))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(bs) != tt.size {
+				t.Errorf("expected %d bytes, got %d", tt.size, len(bs))
+			}
+		})
+	}
+}
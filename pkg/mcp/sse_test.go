@@ -0,0 +1,760 @@
+package mcp_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+	"github.com/MegaGrindStone/go-mcp/pkg/mcptest"
+	"github.com/tmaxmax/go-sse"
+)
+
+func TestSSEServerJSONIndent(t *testing.T) {
+	srv := mcp.NewSSEServer(mcp.WithSSEJSONIndent("", "  "))
+
+	mux := http.NewServeMux()
+	httpSrv := httptest.NewServer(mux)
+	defer httpSrv.Close()
+
+	mux.Handle("/sse", srv.HandleSSE(httpSrv.URL+"/message"))
+	mux.Handle("/message", srv.HandleMessage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpSrv.URL+"/sse", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := httpSrv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	for ev, err := range sse.Read(resp.Body, nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+
+		switch ev.Type {
+		case "endpoint":
+			ping := strings.NewReader(`{"jsonrpc":"2.0","id":"1","method":"ping"}`)
+			pingResp, err := httpSrv.Client().Post(ev.Data, "application/json", ping)
+			if err != nil {
+				t.Fatalf("failed to send ping: %v", err)
+			}
+			pingResp.Body.Close()
+		case "message":
+			if !strings.Contains(ev.Data, "\n  \"jsonrpc\"") {
+				t.Errorf("expected indented message, got %q", ev.Data)
+			}
+
+			var msg mcp.JSONRPCMessage
+			if err := json.Unmarshal([]byte(ev.Data), &msg); err != nil {
+				t.Fatalf("failed to unmarshal message: %v", err)
+			}
+			if msg.ID != "1" {
+				t.Errorf("expected ping response with ID 1, got %s", msg.ID)
+			}
+			return
+		}
+	}
+
+	t.Fatal("event stream ended before the ping response")
+}
+
+func TestSSEServerKeepAlive(t *testing.T) {
+	clock := mcptest.NewFakeClock(time.Now())
+	srv := mcp.NewSSEServer(mcp.WithSSEKeepAlive(10*time.Millisecond), mcp.WithSSEClock(clock))
+
+	mux := http.NewServeMux()
+	httpSrv := httptest.NewServer(mux)
+	defer httpSrv.Close()
+
+	mux.Handle("/sse", srv.HandleSSE(httpSrv.URL+"/message"))
+	mux.Handle("/message", srv.HandleMessage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpSrv.URL+"/sse", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := httpSrv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body := bufio.NewReader(resp.Body)
+	readLine := func() string {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event stream: %v", err)
+		}
+		return strings.TrimSuffix(line, "\n")
+	}
+
+	var endpoint string
+	for line := readLine(); line != ""; line = readLine() {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			endpoint = data
+		}
+	}
+
+	// The keep-alive comment is sent once the interval passes on the clock of the server.
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Millisecond)
+	if line := readLine(); line != ": keepalive" {
+		t.Fatalf("expected keep-alive comment, got %q", line)
+	}
+	if line := readLine(); line != "" {
+		t.Fatalf("expected keep-alive comment to end, got %q", line)
+	}
+
+	ping := strings.NewReader(`{"jsonrpc":"2.0","id":"1","method":"ping"}`)
+	pingResp, err := httpSrv.Client().Post(endpoint, "application/json", ping)
+	if err != nil {
+		t.Fatalf("failed to send ping: %v", err)
+	}
+	pingResp.Body.Close()
+
+	for ev, err := range sse.Read(body, nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+
+		var msg mcp.JSONRPCMessage
+		if err := json.Unmarshal([]byte(ev.Data), &msg); err != nil {
+			t.Fatalf("failed to unmarshal message: %v", err)
+		}
+		if msg.ID != "1" {
+			t.Errorf("expected ping response with ID 1 as the first message, got %+v", msg)
+		}
+		return
+	}
+
+	t.Fatal("event stream ended before the ping response")
+}
+
+func TestSSEServerInvalidMessage(t *testing.T) {
+	srv := mcp.NewSSEServer()
+
+	mux := http.NewServeMux()
+	httpSrv := httptest.NewServer(mux)
+	defer httpSrv.Close()
+
+	mux.Handle("/sse", srv.HandleSSE(httpSrv.URL+"/message"))
+	mux.Handle("/message", srv.HandleMessage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpSrv.URL+"/sse", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := httpSrv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	for ev, err := range sse.Read(resp.Body, nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+
+		switch ev.Type {
+		case "endpoint":
+			postResp, err := httpSrv.Client().Post(ev.Data, "application/json", strings.NewReader("this is not json"))
+			if err != nil {
+				t.Fatalf("failed to send message: %v", err)
+			}
+			postResp.Body.Close()
+			if postResp.StatusCode != http.StatusOK {
+				t.Errorf("expected malformed JSON to be answered on the event stream, got status %d",
+					postResp.StatusCode)
+			}
+		case "message":
+			if !strings.Contains(ev.Data, `"id":null`) {
+				t.Errorf("expected the parse error response to have a null ID, got %s", ev.Data)
+			}
+			var msg mcp.JSONRPCMessage
+			if err := json.Unmarshal([]byte(ev.Data), &msg); err != nil {
+				t.Fatalf("failed to unmarshal message: %v", err)
+			}
+			if msg.Error == nil || msg.Error.Code != -32700 {
+				t.Errorf("expected parse error response, got %+v", msg)
+			}
+			return
+		}
+	}
+
+	t.Fatal("event stream ended before the parse error response")
+}
+
+func TestSSEClientHeaders(t *testing.T) {
+	srv := mcp.NewSSEServer()
+
+	var lock sync.Mutex
+	var requests []http.Header
+	record := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			requests = append(requests, r.Header.Clone())
+			lock.Unlock()
+			h.ServeHTTP(w, r)
+		})
+	}
+
+	mux := http.NewServeMux()
+	httpSrv := httptest.NewServer(mux)
+	defer httpSrv.Close()
+
+	mux.Handle("/sse", record(srv.HandleSSE(httpSrv.URL+"/message")))
+	mux.Handle("/message", record(srv.HandleMessage()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error))
+
+	var tokens atomic.Int64
+	transport := mcp.NewSSEClient(httpSrv.URL+"/sse", httpSrv.Client(),
+		mcp.WithSSEClientHeaders(http.Header{"X-Api-Key": {"secret"}}),
+		mcp.WithSSEClientHeaderFunc(func(context.Context) (http.Header, error) {
+			token := fmt.Sprintf("Bearer token-%d", tokens.Add(1))
+			return http.Header{"Authorization": {token}}, nil
+		}))
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, transport, mcp.ServerRequirement{})
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// The event stream, the initialize request, and the initialized notification.
+	if len(requests) < 3 {
+		t.Fatalf("expected at least 3 requests, got %d", len(requests))
+	}
+	seen := make(map[string]bool)
+	for i, header := range requests {
+		if header.Get("X-Api-Key") != "secret" {
+			t.Errorf("expected request %d to carry the static header, got %v", i, header)
+		}
+		token := header.Get("Authorization")
+		if !strings.HasPrefix(token, "Bearer token-") || seen[token] {
+			t.Errorf("expected request %d to carry a fresh token, got %q", i, token)
+		}
+		seen[token] = true
+	}
+}
+
+func TestSSEClientHeaderFuncError(t *testing.T) {
+	_, _, httpSrv := setupSSE()
+	defer httpSrv.Close()
+
+	transport := mcp.NewSSEClient(httpSrv.URL+"/sse", httpSrv.Client(),
+		mcp.WithSSEClientHeaderFunc(func(context.Context) (http.Header, error) {
+			return nil, errors.New("token expired")
+		}))
+	if _, err := transport.StartSession(); err == nil || !strings.Contains(err.Error(), "token expired") {
+		t.Errorf("expected the header function error, got %v", err)
+	}
+}
+
+// countingRoundTripper is an http.RoundTripper that counts the requests it sends with its base.
+type countingRoundTripper struct {
+	base     http.RoundTripper
+	requests atomic.Int32
+}
+
+func TestSSEClientHTTPClient(t *testing.T) {
+	srv, _, httpSrv := setupSSE()
+	defer httpSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error))
+
+	roundTripper := &countingRoundTripper{base: httpSrv.Client().Transport}
+	transport := mcp.NewSSEClient(httpSrv.URL+"/sse", nil,
+		mcp.WithSSEClientHTTPClient(&http.Client{Transport: roundTripper}))
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, transport, mcp.ServerRequirement{})
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	// The event stream, the initialize request, and the initialized notification.
+	if n := roundTripper.requests.Load(); n < 3 {
+		t.Errorf("expected at least 3 requests through the custom transport, got %d", n)
+	}
+}
+
+func TestSSEClientDefaultHTTPClient(t *testing.T) {
+	srv, _, httpSrv := setupSSE()
+	defer httpSrv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error))
+
+	cli := mcp.NewClient(mcp.Info{Name: "test-client", Version: "1.0"}, mcp.NewSSEClient(httpSrv.URL+"/sse", nil),
+		mcp.ServerRequirement{})
+	defer cli.Close()
+
+	if err := cli.Connect(); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+}
+
+func TestSSEServerCompression(t *testing.T) {
+	srv := mcp.NewSSEServer(mcp.WithCompression(true))
+
+	mux := http.NewServeMux()
+	httpSrv := httptest.NewServer(mux)
+	defer httpSrv.Close()
+
+	mux.Handle("/sse", srv.HandleSSE(httpSrv.URL+"/message"))
+	mux.Handle("/message", srv.HandleMessage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpSrv.URL+"/sse", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	// Setting the header explicitly stops the HTTP client from decompressing transparently.
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := httpSrv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if enc := resp.Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("expected gzip content encoding, got %q", enc)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to create gzip reader: %v", err)
+	}
+
+	postGzip := func(url string, body []byte) int {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			t.Fatalf("failed to compress body: %v", err)
+		}
+		w.Close()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &buf)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Encoding", "gzip")
+		res, err := httpSrv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("failed to send message: %v", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	for ev, err := range sse.Read(bufio.NewReader(gz), nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+
+		switch ev.Type {
+		case "endpoint":
+			// A compressed body that expands past the size cap is rejected.
+			bomb := append([]byte(`{"jsonrpc":"2.0","id":"0","method":"ping","params":"`),
+				bytes.Repeat([]byte("a"), 11<<20)...)
+			if status := postGzip(ev.Data, bomb); status != http.StatusBadRequest {
+				t.Errorf("expected oversized body to be rejected, got status %d", status)
+			}
+
+			if status := postGzip(ev.Data, []byte(`{"jsonrpc":"2.0","id":"1","method":"ping"}`)); status != http.StatusOK {
+				t.Fatalf("expected compressed ping to be accepted, got status %d", status)
+			}
+		case "message":
+			var msg mcp.JSONRPCMessage
+			if err := json.Unmarshal([]byte(ev.Data), &msg); err != nil {
+				t.Fatalf("failed to unmarshal message: %v", err)
+			}
+			if msg.ID != "1" {
+				t.Errorf("expected ping response with ID 1, got %s", msg.ID)
+			}
+			return
+		}
+	}
+
+	t.Fatal("event stream ended before the ping response")
+}
+
+func TestSSEServerReplay(t *testing.T) {
+	srv := mcp.NewSSEServer(mcp.WithSSEReplayBuffer(10))
+
+	mux := http.NewServeMux()
+	httpSrv := httptest.NewServer(mux)
+	defer httpSrv.Close()
+
+	mux.Handle("/sse", srv.HandleSSE(httpSrv.URL+"/message"))
+	mux.Handle("/message", srv.HandleMessage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error))
+
+	connect := func(ctx context.Context, lastEventID string) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpSrv.URL+"/sse", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := httpSrv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		return resp
+	}
+	ping := func(endpoint, id string) {
+		body := strings.NewReader(`{"jsonrpc":"2.0","id":"` + id + `","method":"ping"}`)
+		resp, err := httpSrv.Client().Post(endpoint, "application/json", body)
+		if err != nil {
+			t.Fatalf("failed to send ping: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	streamCtx, streamCancel := context.WithCancel(ctx)
+	resp := connect(streamCtx, "")
+
+	var endpoint, lastEventID string
+	for ev, err := range sse.Read(resp.Body, nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+		if ev.Type == "endpoint" {
+			endpoint = ev.Data
+			ping(endpoint, "1")
+			continue
+		}
+		lastEventID = ev.LastEventID
+		break
+	}
+	if lastEventID == "" {
+		t.Fatal("expected the message to have an event ID")
+	}
+
+	// The stream drops, and the response to the second ping is sent while it's disconnected.
+	streamCancel()
+	resp.Body.Close()
+	ping(endpoint, "2")
+
+	// An event ID forged from the session ID alone starts a new session instead.
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatalf("failed to parse endpoint: %v", err)
+	}
+	sessID := endpointURL.Query().Get("sessionID")
+	forgedCtx, forgedCancel := context.WithCancel(ctx)
+	forged := connect(forgedCtx, sessID+":0000:1")
+	for ev, err := range sse.Read(forged.Body, nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+		if ev.Type != "endpoint" || strings.Contains(ev.Data, sessID) {
+			t.Errorf("expected a new session for a forged event ID, got %q event %s", ev.Type, ev.Data)
+		}
+		break
+	}
+	forgedCancel()
+	forged.Body.Close()
+
+	resp = connect(ctx, lastEventID)
+	defer resp.Body.Close()
+
+	for ev, err := range sse.Read(resp.Body, nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+		if ev.Type != "message" {
+			t.Fatalf("expected only messages on the resumed stream, got %q event", ev.Type)
+		}
+
+		var msg mcp.JSONRPCMessage
+		if err := json.Unmarshal([]byte(ev.Data), &msg); err != nil {
+			t.Fatalf("failed to unmarshal message: %v", err)
+		}
+		if msg.ID != "2" {
+			t.Errorf("expected the ping response with ID 2 to be replayed, got %s", msg.ID)
+		}
+		return
+	}
+
+	t.Fatal("event stream ended before the replayed message")
+}
+
+func TestSSEServerResumeWindow(t *testing.T) {
+	clock := mcptest.NewFakeClock(time.Now())
+	srv := mcp.NewSSEServer(mcp.WithSSEReplayBuffer(10), mcp.WithSSEClock(clock))
+
+	mux := http.NewServeMux()
+	httpSrv := httptest.NewServer(mux)
+	defer httpSrv.Close()
+
+	mux.Handle("/sse", srv.HandleSSE(httpSrv.URL+"/message"))
+	mux.Handle("/message", srv.HandleMessage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	disconnected := make(chan string, 1)
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error),
+		mcp.WithSessionLifecycleHook(nil, nil, func(info mcp.SessionInfo) { disconnected <- info.ID }))
+
+	connect := func(ctx context.Context, lastEventID string) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpSrv.URL+"/sse", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := httpSrv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		return resp
+	}
+
+	streamCtx, streamCancel := context.WithCancel(ctx)
+	resp := connect(streamCtx, "")
+
+	var lastEventID string
+	for ev, err := range sse.Read(resp.Body, nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+		if ev.Type == "endpoint" {
+			ping := strings.NewReader(`{"jsonrpc":"2.0","id":"1","method":"ping"}`)
+			pingResp, err := httpSrv.Client().Post(ev.Data, "application/json", ping)
+			if err != nil {
+				t.Fatalf("failed to send ping: %v", err)
+			}
+			pingResp.Body.Close()
+			continue
+		}
+		lastEventID = ev.LastEventID
+		break
+	}
+	streamCancel()
+	resp.Body.Close()
+
+	// The session ends once the resume window passes on the clock of the server.
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Second)
+	select {
+	case <-disconnected:
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the session to end")
+	}
+
+	resp = connect(ctx, lastEventID)
+	defer resp.Body.Close()
+	for ev, err := range sse.Read(resp.Body, nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+		if ev.Type != "endpoint" {
+			t.Errorf("expected a new session after the resume window, got %q event", ev.Type)
+		}
+		return
+	}
+
+	t.Fatal("event stream ended before the endpoint event")
+}
+
+func TestSSEServerRebindSession(t *testing.T) {
+	srv := mcp.NewSSEServer(mcp.WithSSEReplayBuffer(10))
+
+	mux := http.NewServeMux()
+	httpSrv := httptest.NewServer(mux)
+	defer httpSrv.Close()
+
+	mux.Handle("/sse", srv.HandleSSE(httpSrv.URL+"/message"))
+	mux.Handle("/message", srv.HandleMessage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, httpSrv.URL+"/sse", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp, err := httpSrv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var endpoint string
+	for ev, err := range sse.Read(resp.Body, nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+		endpoint = ev.Data
+		break
+	}
+	_, sessID, ok := strings.Cut(endpoint, "sessionID=")
+	if !ok {
+		t.Fatalf("expected the endpoint to hold the session ID, got %q", endpoint)
+	}
+
+	if err := srv.RebindSession("unknown", io.Discard); !errors.Is(err, mcp.ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound for an unknown session, got %v", err)
+	}
+	if err := mcp.NewSSEServer().RebindSession(sessID, io.Discard); err == nil {
+		t.Error("expected error for a server without a replay buffer")
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	if err := srv.RebindSession(sessID, pw); err != nil {
+		t.Fatalf("failed to rebind session: %v", err)
+	}
+
+	// The previous event stream ends, while the session lives on with the new one.
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Fatalf("failed to read the previous event stream: %v", err)
+	}
+	body := strings.NewReader(`{"jsonrpc":"2.0","id":"1","method":"ping"}`)
+	pingResp, err := httpSrv.Client().Post(endpoint, "application/json", body)
+	if err != nil {
+		t.Fatalf("failed to send ping: %v", err)
+	}
+	pingResp.Body.Close()
+
+	for ev, err := range sse.Read(pr, nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+
+		var msg mcp.JSONRPCMessage
+		if err := json.Unmarshal([]byte(ev.Data), &msg); err != nil {
+			t.Fatalf("failed to unmarshal message: %v", err)
+		}
+		if msg.ID != "1" {
+			t.Errorf("expected the ping response with ID 1 on the rebound writer, got %s", msg.ID)
+		}
+		return
+	}
+
+	t.Fatal("rebound writer ended before the ping response")
+}
+
+// closeRecorder is an http.ResponseWriter that records whether it was closed.
+type closeRecorder struct {
+	http.ResponseWriter
+	closed chan struct{}
+}
+
+func TestSSEServerClosesWriter(t *testing.T) {
+	srv := mcp.NewSSEServer()
+
+	mux := http.NewServeMux()
+	httpSrv := httptest.NewServer(mux)
+	defer httpSrv.Close()
+
+	recorder := &closeRecorder{closed: make(chan struct{})}
+	handler := srv.HandleSSE(httpSrv.URL + "/message")
+	mux.Handle("/sse", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.ResponseWriter = w
+		handler.ServeHTTP(recorder, r)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go mcp.Serve(ctx, mockServer{}, srv, make(chan error))
+
+	resp, err := httpSrv.Client().Get(httpSrv.URL + "/sse")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	for ev, err := range sse.Read(resp.Body, nil) {
+		if err != nil {
+			t.Fatalf("failed to read events: %v", err)
+		}
+		if ev.Type == "endpoint" {
+			break
+		}
+	}
+
+	// Stopping the server ends every session.
+	cancel()
+
+	select {
+	case <-recorder.closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected writer to be closed when the session ends")
+	}
+}
+
+func (c *closeRecorder) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *closeRecorder) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func (c *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return c.base.RoundTrip(req)
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 )
@@ -52,6 +53,8 @@ func NewStdIO(reader io.Reader, writer io.Writer) StdIO {
 //
 // The processing loop continues until either the reader is exhausted or Close()
 // is called. Any unmarshaling or processing errors are sent to the error channel.
+// Lines that can't be parsed, and messages rejected with a MessageError, are answered
+// with a JSON-RPC error response without interrupting the loop.
 //
 // This method should typically be called in a separate goroutine as it blocks
 // until completion or shutdown.
@@ -72,6 +75,7 @@ func (s StdIO) Start() {
 		var msg JSONRPCMessage
 		if err := json.Unmarshal([]byte(line), &msg); err != nil {
 			s.logError(fmt.Errorf("failed to unmarshal message: %w", err))
+			s.sendMessageError(newParseError(err))
 			continue
 		}
 
@@ -84,6 +88,10 @@ func (s StdIO) Start() {
 
 		if err := <-errs; err != nil {
 			s.logError(fmt.Errorf("failed to handle message: %w", err))
+			var msgErr MessageError
+			if errors.As(err, &msgErr) {
+				s.sendMessageError(msgErr)
+			}
 		}
 	}
 
@@ -99,6 +107,7 @@ func (s StdIO) Start() {
 // is cancelled before the write completes, the operation is abandoned and ctx.Err() is returned.
 //
 // Returns an error if marshaling fails, the write operation fails, or the context is cancelled.
+// The error of a failed write wraps ErrSessionBroken, as the writer is the only way to the peer.
 func (s StdIO) Send(ctx context.Context, msg SessionMsg) error {
 	msgBs, err := json.Marshal(msg.Msg)
 	if err != nil {
@@ -111,7 +120,7 @@ func (s StdIO) Send(ctx context.Context, msg SessionMsg) error {
 	go func() {
 		_, err = s.writer.Write(msgBs)
 		if err != nil {
-			errs <- fmt.Errorf("failed to write message: %w", err)
+			errs <- fmt.Errorf("failed to write message: %w: %w", ErrSessionBroken, err)
 			return
 		}
 		errs <- nil
@@ -148,17 +157,14 @@ func (s StdIO) Close() {
 
 // Sessions returns a receive-only channel that provides the single session context
 // used by this transport. Since StdIO only supports a single session, this method
-// sends one SessionCtx with ID "1" and a background context.
-//
-// The returned channel should be consumed to prevent goroutine leaks.
+// returns a channel holding one SessionCtx with ID "1" and a background context.
 func (s StdIO) Sessions() <-chan SessionCtx {
-	sessions := make(chan SessionCtx)
-	go func() {
-		sessions <- SessionCtx{
-			Ctx: context.Background(),
-			ID:  "1",
-		}
-	}()
+	// The session is buffered, so it's already available by the time the first message is read.
+	sessions := make(chan SessionCtx, 1)
+	sessions <- SessionCtx{
+		Ctx: context.Background(),
+		ID:  "1",
+	}
 
 	return sessions
 }
@@ -182,6 +188,15 @@ func (s StdIO) Errors() <-chan error {
 	return s.errsChan
 }
 
+func (s StdIO) sendMessageError(msgErr MessageError) {
+	if err := s.Send(context.Background(), SessionMsg{
+		SessionID: "1",
+		Msg:       msgErr.Response(),
+	}); err != nil {
+		s.logError(fmt.Errorf("failed to send error response: %w", err))
+	}
+}
+
 func (s StdIO) logError(err error) {
 	select {
 	case s.errsChan <- err:
@@ -0,0 +1,296 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ResourceTemplateHandler reads a resource whose URI matched a registered template. The vars
+// parameter holds the values of the template variables extracted from the URI, keyed by
+// variable name.
+type ResourceTemplateHandler func(
+	ctx context.Context,
+	params ReadResourceParams,
+	vars map[string]string,
+	requestClient RequestClientFunc,
+) (ReadResourceResult, error)
+
+// TemplateRouter dispatches resources/read requests to handlers registered per URI template,
+// similar to how an HTTP router dispatches requests per path pattern. It's meant to be used by
+// ResourceServer implementations that serve templated resources, by calling ReadResource from
+// their own ReadResource method and Templates from ListResourceTemplates.
+//
+// Templates support the simple {var} expansion, where a variable matches a single URI segment
+// that doesn't contain '/', '?' or '#', and the reserved {+var} expansion, where a variable
+// matches any non-empty string. Simple variables are percent-decoded before they are passed to
+// the handler.
+//
+// When several templates match the same URI, the most specific one wins: the template with the
+// most literal characters, then the one with the fewest reserved variables, then the one that
+// was registered first.
+//
+// TemplateRouter is safe for concurrent use.
+type TemplateRouter struct {
+	lock   sync.RWMutex
+	routes []templateRoute
+}
+
+type templateRoute struct {
+	template ResourceTemplate
+	handler  ResourceTemplateHandler
+	pattern  *regexp.Regexp
+	vars     []templateVar
+
+	// order is the registration order of the route.
+	order    int
+	literals int
+	reserved int
+}
+
+type templateVar struct {
+	name     string
+	reserved bool
+}
+
+var templateVarNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// NewTemplateRouter creates an empty TemplateRouter.
+func NewTemplateRouter() *TemplateRouter {
+	return &TemplateRouter{}
+}
+
+// Handle registers the handler for the resources matching the given template. It returns an
+// error if the template's URITemplate is malformed, uses an unsupported expression, or is
+// already registered.
+func (r *TemplateRouter) Handle(template ResourceTemplate, handler ResourceTemplateHandler) error {
+	route, err := compileTemplateRoute(template.URITemplate)
+	if err != nil {
+		return fmt.Errorf("invalid URI template %q: %w", template.URITemplate, err)
+	}
+	route.template = template
+	route.handler = handler
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, rt := range r.routes {
+		if rt.template.URITemplate == template.URITemplate {
+			return fmt.Errorf("URI template %q is already registered", template.URITemplate)
+		}
+	}
+
+	route.order = len(r.routes)
+	r.routes = append(r.routes, route)
+	// The sort is stable, so templates that are equally specific keep their registration order.
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return r.routes[i].moreSpecificThan(r.routes[j])
+	})
+
+	return nil
+}
+
+// Templates returns the registered templates, in the order they were registered.
+func (r *TemplateRouter) Templates() []ResourceTemplate {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	templates := make([]ResourceTemplate, len(r.routes))
+	for _, rt := range r.routes {
+		templates[rt.order] = rt.template
+	}
+
+	return templates
+}
+
+// Match finds the most specific template matching the URI, and returns it with the values of
+// its variables. It reports false if no registered template matches.
+func (r *TemplateRouter) Match(uri string) (ResourceTemplate, map[string]string, bool) {
+	route, vars, ok := r.match(uri)
+	if !ok {
+		return ResourceTemplate{}, nil, false
+	}
+	return route.template, vars, true
+}
+
+// ReadResource calls the handler of the most specific template matching params.URI. It returns
+// an error if no registered template matches.
+func (r *TemplateRouter) ReadResource(
+	ctx context.Context,
+	params ReadResourceParams,
+	requestClient RequestClientFunc,
+) (ReadResourceResult, error) {
+	route, vars, ok := r.match(params.URI)
+	if !ok {
+		return ReadResourceResult{}, fmt.Errorf("no resource template matches URI %q", params.URI)
+	}
+	return route.handler(ctx, params, vars, requestClient)
+}
+
+func (r *TemplateRouter) match(uri string) (templateRoute, map[string]string, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, rt := range r.routes {
+		vars, ok := rt.match(uri)
+		if ok {
+			return rt, vars, true
+		}
+	}
+
+	return templateRoute{}, nil, false
+}
+
+// ExpandTemplate expands the URI template with the given variables, the reverse of matching a URI
+// against the template in TemplateRouter. It supports the same expressions: the values of simple
+// {var} variables are percent-encoded, so they form a single URI segment, and the values of reserved
+// {+var} variables are inserted as they are. It returns an error if the template is malformed, or a
+// variable of the template is missing from vars or empty. Variables the template doesn't use are
+// ignored.
+func ExpandTemplate(uriTemplate string, vars map[string]string) (string, error) {
+	parts, err := parseTemplate(uriTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid URI template %q: %w", uriTemplate, err)
+	}
+
+	var uri strings.Builder
+	for _, part := range parts {
+		if !part.isVar {
+			uri.WriteString(part.literal)
+			continue
+		}
+		value := vars[part.variable.name]
+		if value == "" {
+			return "", fmt.Errorf("variable %q of URI template %q is required", part.variable.name, uriTemplate)
+		}
+		if !part.variable.reserved {
+			value = url.PathEscape(value)
+		}
+		uri.WriteString(value)
+	}
+
+	return uri.String(), nil
+}
+
+// templatePart is either a literal of a URI template, or one of its variables.
+type templatePart struct {
+	literal  string
+	variable templateVar
+	isVar    bool
+}
+
+func parseTemplate(uriTemplate string) ([]templatePart, error) {
+	var parts []templatePart
+	seen := make(map[string]bool)
+
+	rest := uriTemplate
+	for rest != "" {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			parts = append(parts, templatePart{literal: rest})
+			break
+		}
+		if rest[start] == '}' {
+			return nil, fmt.Errorf("unexpected '}' at offset %d", len(uriTemplate)-len(rest)+start)
+		}
+
+		if start > 0 {
+			parts = append(parts, templatePart{literal: rest[:start]})
+		}
+		rest = rest[start+1:]
+
+		end := strings.IndexByte(rest, '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed expression")
+		}
+		expr := rest[:end]
+		rest = rest[end+1:]
+
+		v := templateVar{name: expr}
+		if strings.HasPrefix(expr, "+") {
+			v = templateVar{name: expr[1:], reserved: true}
+		}
+		if !templateVarNameRegexp.MatchString(v.name) {
+			return nil, fmt.Errorf("unsupported expression {%s}", expr)
+		}
+		if seen[v.name] {
+			return nil, fmt.Errorf("duplicate variable %q", v.name)
+		}
+		seen[v.name] = true
+
+		parts = append(parts, templatePart{variable: v, isVar: true})
+	}
+
+	return parts, nil
+}
+
+func compileTemplateRoute(uriTemplate string) (templateRoute, error) {
+	parts, err := parseTemplate(uriTemplate)
+	if err != nil {
+		return templateRoute{}, err
+	}
+
+	var (
+		route   templateRoute
+		pattern strings.Builder
+	)
+
+	pattern.WriteString("^")
+	for _, part := range parts {
+		switch {
+		case !part.isVar:
+			route.literals += len(part.literal)
+			pattern.WriteString(regexp.QuoteMeta(part.literal))
+		case part.variable.reserved:
+			route.reserved++
+			pattern.WriteString("(.+)")
+		default:
+			pattern.WriteString("([^/?#]+)")
+		}
+		if part.isVar {
+			route.vars = append(route.vars, part.variable)
+		}
+	}
+	pattern.WriteString("$")
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return templateRoute{}, fmt.Errorf("failed to compile pattern: %w", err)
+	}
+	route.pattern = re
+
+	return route, nil
+}
+
+func (rt templateRoute) match(uri string) (map[string]string, bool) {
+	matches := rt.pattern.FindStringSubmatch(uri)
+	if matches == nil {
+		return nil, false
+	}
+
+	vars := make(map[string]string, len(rt.vars))
+	for i, v := range rt.vars {
+		value := matches[i+1]
+		if !v.reserved {
+			unescaped, err := url.PathUnescape(value)
+			if err != nil {
+				return nil, false
+			}
+			value = unescaped
+		}
+		vars[v.name] = value
+	}
+
+	return vars, true
+}
+
+func (rt templateRoute) moreSpecificThan(other templateRoute) bool {
+	if rt.literals != other.literals {
+		return rt.literals > other.literals
+	}
+	return rt.reserved < other.reserved
+}
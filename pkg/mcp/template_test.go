@@ -0,0 +1,139 @@
+package mcp_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+)
+
+func TestTemplateRouter(t *testing.T) {
+	router := mcp.NewTemplateRouter()
+
+	handler := func(name string) mcp.ResourceTemplateHandler {
+		return func(
+			_ context.Context,
+			params mcp.ReadResourceParams,
+			vars map[string]string,
+			_ mcp.RequestClientFunc,
+		) (mcp.ReadResourceResult, error) {
+			return mcp.ReadResourceResult{
+				Contents: []mcp.Resource{{URI: params.URI, Name: name, Text: vars["id"] + vars["path"]}},
+			}, nil
+		}
+	}
+
+	templates := []string{
+		"test://files/{+path}",
+		"test://users/{id}",
+		"test://users/{id}/profile",
+		"test://users/admin",
+	}
+	for _, tmpl := range templates {
+		if err := router.Handle(mcp.ResourceTemplate{URITemplate: tmpl, Name: tmpl}, handler(tmpl)); err != nil {
+			t.Fatalf("failed to register %s: %v", tmpl, err)
+		}
+	}
+
+	type testCase struct {
+		uri          string
+		wantTemplate string
+		wantText     string
+	}
+
+	testCases := []testCase{
+		{uri: "test://users/42", wantTemplate: "test://users/{id}", wantText: "42"},
+		{uri: "test://users/a%20b", wantTemplate: "test://users/{id}", wantText: "a b"},
+		{uri: "test://users/42/profile", wantTemplate: "test://users/{id}/profile", wantText: "42"},
+		{uri: "test://users/admin", wantTemplate: "test://users/admin", wantText: ""},
+		{uri: "test://files/a/b/c.txt", wantTemplate: "test://files/{+path}", wantText: "a/b/c.txt"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.uri, func(t *testing.T) {
+			res, err := router.ReadResource(context.Background(), mcp.ReadResourceParams{URI: tc.uri}, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := res.Contents[0].Name; got != tc.wantTemplate {
+				t.Errorf("expected template %s, got %s", tc.wantTemplate, got)
+			}
+			if got := res.Contents[0].Text; got != tc.wantText {
+				t.Errorf("expected text %q, got %q", tc.wantText, got)
+			}
+		})
+	}
+
+	if _, err := router.ReadResource(context.Background(), mcp.ReadResourceParams{URI: "test://users/"}, nil); err == nil {
+		t.Error("expected error for URI matching no template")
+	}
+
+	registered := router.Templates()
+	for i, tmpl := range templates {
+		if registered[i].URITemplate != tmpl {
+			t.Errorf("expected template %d to be %s, got %s", i, tmpl, registered[i].URITemplate)
+		}
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	router := mcp.NewTemplateRouter()
+	handler := func(
+		_ context.Context,
+		_ mcp.ReadResourceParams,
+		_ map[string]string,
+		_ mcp.RequestClientFunc,
+	) (mcp.ReadResourceResult, error) {
+		return mcp.ReadResourceResult{}, nil
+	}
+	template := "test://users/{id}/files/{+path}"
+	if err := router.Handle(mcp.ResourceTemplate{URITemplate: template}, handler); err != nil {
+		t.Fatalf("failed to register %s: %v", template, err)
+	}
+
+	vars := map[string]string{"id": "a b/c", "path": "docs/report.txt", "unused": "x"}
+	uri, err := mcp.ExpandTemplate(template, vars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "test://users/a%20b%2Fc/files/docs/report.txt"; uri != want {
+		t.Errorf("expected URI %s, got %s", want, uri)
+	}
+
+	// The expanded URI matches the template, with the same variables.
+	_, matched, ok := router.Match(uri)
+	if !ok || matched["id"] != vars["id"] || matched["path"] != vars["path"] {
+		t.Errorf("expected the URI to match the template with the given variables, got %v", matched)
+	}
+
+	if _, err := mcp.ExpandTemplate(template, map[string]string{"id": "42"}); err == nil {
+		t.Error("expected error for a missing variable")
+	}
+	if _, err := mcp.ExpandTemplate("test://users/{id", map[string]string{"id": "42"}); err == nil {
+		t.Error("expected error for a malformed template")
+	}
+}
+
+func TestTemplateRouterInvalidTemplate(t *testing.T) {
+	router := mcp.NewTemplateRouter()
+
+	invalid := []string{
+		"test://users/{id",
+		"test://users/id}",
+		"test://users/{}",
+		"test://users/{?query}",
+		"test://users/{id}/{id}",
+	}
+	for _, tmpl := range invalid {
+		if err := router.Handle(mcp.ResourceTemplate{URITemplate: tmpl}, nil); err == nil {
+			t.Errorf("expected error for template %s", tmpl)
+		}
+	}
+
+	if err := router.Handle(mcp.ResourceTemplate{URITemplate: "test://users/{id}"}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := router.Handle(mcp.ResourceTemplate{URITemplate: "test://users/{id}"}, nil); err == nil {
+		t.Error("expected error for duplicate template")
+	}
+}
@@ -0,0 +1,170 @@
+package mcptest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
+)
+
+// FakeClock is an mcp.Clock whose time only moves when Advance is called, so tests of timeouts and
+// intervals, set with mcp.WithClock or mcp.WithClientClock, run instantly and deterministically
+// instead of waiting for them.
+//
+// Timers and tickers behave like those of the time package: their channels have a buffer of one,
+// and a ticker that falls behind drops ticks. As the server and the client create their timers in
+// goroutines of their own, tests should call BlockUntil before Advance, so the time doesn't move
+// before the timers they expect exist.
+//
+// The zero value isn't usable, use NewFakeClock to create one. FakeClock is safe for concurrent use.
+type FakeClock struct {
+	lock    sync.Mutex
+	changed *sync.Cond
+	now     time.Time
+	timers  map[*fakeTimer]struct{}
+}
+
+// fakeTimer is a timer, or the timer of a ticker when its period isn't zero, of a FakeClock.
+type fakeTimer struct {
+	clock  *FakeClock
+	c      chan time.Time
+	when   time.Time
+	period time.Duration
+}
+
+// fakeTicker is a ticker of a FakeClock.
+type fakeTicker struct {
+	*fakeTimer
+}
+
+var _ mcp.Clock = (*FakeClock)(nil)
+
+// NewFakeClock creates a FakeClock whose time starts at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{
+		now:    now,
+		timers: make(map[*fakeTimer]struct{}),
+	}
+	c.changed = sync.NewCond(&c.lock)
+	return c
+}
+
+// Now implements mcp.Clock interface.
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+// NewTimer implements mcp.Clock interface.
+func (c *FakeClock) NewTimer(d time.Duration) mcp.Timer {
+	return c.add(d, 0)
+}
+
+// NewTicker implements mcp.Clock interface. It panics if d isn't positive, like time.NewTicker.
+func (c *FakeClock) NewTicker(d time.Duration) mcp.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+	return fakeTicker{c.add(d, d)}
+}
+
+// Advance moves the time forward by d, firing the timers and tickers that expire by then, in the
+// order they expire.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	end := c.now.Add(d)
+	for {
+		next := c.nextExpiring(end)
+		if next == nil {
+			break
+		}
+		c.now = next.when
+		next.fire()
+	}
+	c.now = end
+}
+
+// BlockUntil blocks until at least n timers and tickers are running, that is, created and neither
+// stopped nor, for timers, expired.
+func (c *FakeClock) BlockUntil(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+}
+
+func (c *FakeClock) add(d, period time.Duration) *fakeTimer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &fakeTimer{
+		clock:  c,
+		c:      make(chan time.Time, 1),
+		when:   c.now.Add(d),
+		period: period,
+	}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers[t] = struct{}{}
+	c.changed.Broadcast()
+
+	return t
+}
+
+// nextExpiring returns the running timer that expires first, by end, or nil if there's none.
+func (c *FakeClock) nextExpiring(end time.Time) *fakeTimer {
+	var next *fakeTimer
+	for t := range c.timers {
+		if t.when.After(end) {
+			continue
+		}
+		if next == nil || t.when.Before(next.when) {
+			next = t
+		}
+	}
+	return next
+}
+
+// fire sends the time on the channel of the timer, unless a previous time is still there, and
+// schedules the next tick of a ticker. The lock of the clock must be held.
+func (t *fakeTimer) fire() {
+	select {
+	case t.c <- t.when:
+	default:
+	}
+	if t.period == 0 {
+		delete(t.clock.timers, t)
+		t.clock.changed.Broadcast()
+		return
+	}
+	t.when = t.when.Add(t.period)
+}
+
+// C implements mcp.Timer interface.
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop implements mcp.Timer interface.
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	_, ok := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	t.clock.changed.Broadcast()
+	return ok
+}
+
+// Stop implements mcp.Ticker interface.
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
@@ -0,0 +1,38 @@
+// Package mcptest provides utilities for testing MCP servers and clients built with the mcp package.
+package mcptest
+
+import "github.com/MegaGrindStone/go-mcp/pkg/mcp"
+
+// ManualResourceUpdater is an mcp.ResourceSubscribedUpdater whose updates are triggered explicitly,
+// so tests of resource subscriptions can drive them deterministically instead of relying on timing.
+//
+// The channel returned by ResourceSubscribedUpdates is unbuffered, and TriggerUpdate blocks until
+// the update is received from it. Once TriggerUpdate returns, the server has taken the update and
+// will notify the sessions subscribed to the resource, and updates are received in the order they
+// were triggered. Because of that, TriggerUpdate must only be called while a server using the
+// updater is running, otherwise it blocks forever.
+//
+// The zero value isn't usable, use NewManualResourceUpdater to create one.
+type ManualResourceUpdater struct {
+	updates chan string
+}
+
+var _ mcp.ResourceSubscribedUpdater = ManualResourceUpdater{}
+
+// NewManualResourceUpdater creates a ManualResourceUpdater.
+func NewManualResourceUpdater() ManualResourceUpdater {
+	return ManualResourceUpdater{
+		updates: make(chan string),
+	}
+}
+
+// ResourceSubscribedUpdates implements mcp.ResourceSubscribedUpdater interface.
+func (m ManualResourceUpdater) ResourceSubscribedUpdates() <-chan string {
+	return m.updates
+}
+
+// TriggerUpdate reports that the resource with the given URI changed, and returns once the update
+// has been received.
+func (m ManualResourceUpdater) TriggerUpdate(uri string) {
+	m.updates <- uri
+}
@@ -0,0 +1,82 @@
+package mcptest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MegaGrindStone/go-mcp/pkg/mcptest"
+)
+
+func TestManualResourceUpdater(t *testing.T) {
+	updater := mcptest.NewManualResourceUpdater()
+
+	triggered := make(chan struct{})
+	go func() {
+		defer close(triggered)
+		updater.TriggerUpdate("test://a")
+		updater.TriggerUpdate("test://b")
+	}()
+
+	select {
+	case <-triggered:
+		t.Fatal("expected TriggerUpdate to block until the update is received")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	updates := updater.ResourceSubscribedUpdates()
+	for _, want := range []string{"test://a", "test://b"} {
+		if got := <-updates; got != want {
+			t.Errorf("expected update %s, got %s", want, got)
+		}
+	}
+
+	select {
+	case <-triggered:
+	case <-time.After(time.Second):
+		t.Fatal("expected TriggerUpdate to return once the update is received")
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := mcptest.NewFakeClock(start)
+
+	timer := clock.NewTimer(time.Minute)
+	ticker := clock.NewTicker(20 * time.Second)
+	defer ticker.Stop()
+	stopped := clock.NewTimer(time.Second)
+	if !stopped.Stop() {
+		t.Error("expected Stop to stop the running timer")
+	}
+	clock.BlockUntil(2)
+
+	clock.Advance(30 * time.Second)
+	if now := clock.Now(); !now.Equal(start.Add(30 * time.Second)) {
+		t.Errorf("expected the time to advance by 30s, got %v", now)
+	}
+	select {
+	case <-timer.C():
+		t.Error("expected the timer not to fire before its time")
+	default:
+	}
+	if tick := <-ticker.C(); !tick.Equal(start.Add(20 * time.Second)) {
+		t.Errorf("expected a tick at 20s, got %v", tick)
+	}
+
+	clock.Advance(30 * time.Second)
+	if fired := <-timer.C(); !fired.Equal(start.Add(time.Minute)) {
+		t.Errorf("expected the timer to fire at 1m, got %v", fired)
+	}
+	// The tick at 60s was dropped, as the one at 40s wasn't received.
+	if tick := <-ticker.C(); !tick.Equal(start.Add(40 * time.Second)) {
+		t.Errorf("expected a tick at 40s, got %v", tick)
+	}
+	select {
+	case <-stopped.C():
+		t.Error("expected the stopped timer not to fire")
+	default:
+	}
+	if timer.Stop() {
+		t.Error("expected Stop to report the timer already fired")
+	}
+}
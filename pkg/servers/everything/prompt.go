@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"strconv"
-	"strings"
 
 	"github.com/MegaGrindStone/go-mcp/pkg/mcp"
 )
@@ -28,22 +27,19 @@ var promptList = mcp.ListPromptResult{
 					Name:        "temperature",
 					Description: "Temperature settings",
 					Required:    true,
+					Choices:     []string{"0", "0.5", "0.7", "1.0"},
 				},
 				{
 					Name:        "style",
 					Description: "Output style",
 					Required:    false,
+					Choices:     []string{"casual", "formal", "technical", "friendly"},
 				},
 			},
 		},
 	},
 }
 
-var promptCompletions = map[string][]string{
-	"temperature": {"0", "0.5", "0.7", "1.0"},
-	"style":       {"casual", "formal", "technical", "friendly"},
-}
-
 // ListPrompts implements mcp.PromptServer interface.
 func (s *Server) ListPrompts(
 	context.Context,
@@ -72,10 +68,10 @@ func (s *Server) GetPrompt(
 			Messages: []mcp.PromptMessage{
 				{
 					Role: mcp.PromptRoleUser,
-					Content: mcp.Content{
+					Content: []mcp.Content{{
 						Type: mcp.ContentTypeText,
 						Text: "This is a simple prompt without arguments.",
-					},
+					}},
 				},
 			},
 		}, nil
@@ -98,27 +94,27 @@ func (s *Server) GetPrompt(
 			Messages: []mcp.PromptMessage{
 				{
 					Role: mcp.PromptRoleUser,
-					Content: mcp.Content{
+					Content: []mcp.Content{{
 						Type: mcp.ContentTypeText,
 						Text: fmt.Sprintf("This is a complex prompt with arguments: temperature=%.2f, style=%s", temperature, style),
-					},
+					}},
 				},
 				{
 					Role: mcp.PromptRoleAssistant,
-					Content: mcp.Content{
+					Content: []mcp.Content{{
 						Type: mcp.ContentTypeText,
 						Text: `
 I understand. You've provided a complex prompt with temperature and style arguments. How would you like me to proceed?
             `,
-					},
+					}},
 				},
 				{
 					Role: mcp.PromptRoleUser,
-					Content: mcp.Content{
+					Content: []mcp.Content{{
 						Type:     mcp.ContentTypeImage,
 						Data:     mcpTinyImage,
 						MimeType: "image/png",
-					},
+					}},
 				},
 			},
 		}, nil
@@ -135,29 +131,13 @@ func (s *Server) CompletesPrompt(
 ) (mcp.CompletionResult, error) {
 	s.log(fmt.Sprintf("CompletesPrompt: %s", params.Ref.Name), mcp.LogLevelDebug)
 
-	completions, ok := promptCompletions[params.Argument.Name]
-	if !ok {
-		return mcp.CompletionResult{}, nil
-	}
-
-	var values []string
-	for _, c := range completions {
-		if params.Argument.Value == "" {
-			values = append(values, c)
+	for _, prompt := range promptList.Prompts {
+		if prompt.Name != params.Ref.Name {
 			continue
 		}
-		if strings.HasPrefix(c, params.Argument.Value) {
-			values = append(values, c)
-		}
+		result, _ := prompt.CompleteArgument(params.Argument)
+		return result, nil
 	}
 
-	return mcp.CompletionResult{
-		Completion: struct {
-			Values  []string `json:"values"`
-			HasMore bool     `json:"hasMore"`
-		}{
-			Values:  values,
-			HasMore: false,
-		},
-	}, nil
+	return mcp.CompletionResult{}, nil
 }
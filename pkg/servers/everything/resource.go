@@ -23,27 +23,33 @@ func genResources() []mcp.Resource {
 		uri := fmt.Sprintf("test://static/resource/%d", i+1)
 		name := fmt.Sprintf("Resource %d", i+1)
 		if i%2 == 0 {
-			resources = append(resources, mcp.Resource{
-				URI:      uri,
-				Name:     name,
-				MimeType: "text/plain",
-				Text:     fmt.Sprintf("Resource %d: This is a plain text resource", i+1),
-			})
+			resources = append(resources, resourceContent(uri, name, "text/plain",
+				fmt.Sprintf("Resource %d: This is a plain text resource", i+1)))
 		} else {
-			content := fmt.Sprintf("Resource %d: This is a base64 blob", i+1)
-			c64 := base64.StdEncoding.EncodeToString([]byte(content))
-			resources = append(resources, mcp.Resource{
-				URI:      uri,
-				Name:     name,
-				MimeType: "application/octet-stream",
-				Blob:     c64,
-			})
+			resources = append(resources, resourceContent(uri, name, "application/octet-stream",
+				fmt.Sprintf("Resource %d: This is a base64 blob", i+1)))
 		}
 	}
 
 	return resources
 }
 
+// resourceContent returns the resource with the given content, as text or base64-encoded blob,
+// depending on its MIME type.
+func resourceContent(uri, name, mimeType, content string) mcp.Resource {
+	resource := mcp.Resource{
+		URI:      uri,
+		Name:     name,
+		MimeType: mimeType,
+	}
+	if mcp.IsTextMime(mimeType) {
+		resource.Text = content
+	} else {
+		resource.Blob = base64.StdEncoding.EncodeToString([]byte(content))
+	}
+	return resource
+}
+
 // ListResources implements mcp.ResourceServer interface.
 func (s *Server) ListResources(
 	_ context.Context,
@@ -139,15 +145,9 @@ func (s *Server) CompletesResourceTemplate(
 		}
 	}
 
-	return mcp.CompletionResult{
-		Completion: struct {
-			Values  []string `json:"values"`
-			HasMore bool     `json:"hasMore"`
-		}{
-			Values:  values,
-			HasMore: false,
-		},
-	}, nil
+	var result mcp.CompletionResult
+	result.Completion.Values = values
+	return result, nil
 }
 
 // SubscribeResource implements mcp.ResourceServer interface.
@@ -187,7 +187,7 @@ func (s *Server) callLongRunningOperation(ctx context.Context, params mcp.CallTo
 		case s.progressChan <- mcp.ProgressParams{
 			ProgressToken: params.Meta.ProgressToken,
 			Progress:      float64(i + 1),
-			Total:         steps,
+			Total:         &steps,
 		}:
 		case <-ctx.Done():
 			return mcp.CallToolResult{}, ctx.Err()
@@ -248,9 +248,9 @@ func (s *Server) callSampleLLM(
 			},
 		},
 		ModelPreferences: mcp.SamplingModelPreferences{
-			CostPriority:         1,
-			SpeedPriority:        2,
-			IntelligencePriority: 3,
+			CostPriority:         0.2,
+			SpeedPriority:        0.5,
+			IntelligencePriority: 0.8,
 		},
 		SystemPrompts: "You are a helpful assistant.",
 		MaxTokens:     int(maxTokens),